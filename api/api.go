@@ -1,34 +1,68 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
-	"os"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
-)
 
-const envWWWHost = "MANAGEME_WWW_HOST"
+	"github.com/briansan/ManageMeServer/config"
+	"github.com/briansan/ManageMeServer/errors"
+)
 
-var wwwHost string
+// problemHandler responds to the errors of handlers with their problem,
+//   identified by the request ID and path
+func problemHandler(err error, c echo.Context) {
+	p := errors.ProblemOf(err)
+	p.Instance = c.Request().URL.Path
+	p.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	if p.Status >= http.StatusInternalServerError {
+		cause := p.Cause
+		if cause == nil {
+			cause = err
+		}
+		logger.Error("request failed", "requestID", p.RequestID, "err", cause)
+	}
 
-func init() {
-	wwwHost = os.Getenv(envWWWHost)
-	if len(wwwHost) == 0 {
-		logger.Info("env.MANAGEME_WWW_HOST not specified, defaulting to http://localhost:8889")
-		wwwHost = "http://localhost:8889"
+	if c.Response().Committed {
+		return
 	}
+	if c.Request().Method == echo.HEAD {
+		c.NoContent(p.Status)
+		return
+	}
+	body, _ := json.Marshal(p)
+	c.Blob(p.Status, errors.ProblemContentType, body)
 }
 
-func New() *echo.Echo {
+// New returns the api configured by cfg, creating its admin if missing.
+//   The store's session must be initialized
+func New(cfg *config.API) (*echo.Echo, error) {
+	if err := initSecret(cfg); err != nil {
+		return nil, err
+	}
+
 	e := echo.New()
-	e.Use(middleware.Logger())
-	e.Use(middleware.RemoveTrailingSlash())
-	e.Use(middleware.Recover())
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins:     []string{wwwHost},
+	e.HTTPErrorHandler = problemHandler
+	metrics := metricsMiddleware(e)
+	requestLogger := middleware.Logger()
+	recoverer := middleware.Recover()
+	cors := middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:     []string{cfg.WWWHost},
 		AllowCredentials: true,
-	}))
+	})
+	e.Pre(middleware.RequestID())
+	e.Pre(tracing(e))
+	e.Pre(caldav(metrics, requestLogger, recoverer, cors))
+	e.Use(metrics)
+	e.Use(requestLogger)
+	e.Use(middleware.RemoveTrailingSlash())
+	e.Use(recoverer)
+	e.Use(cors)
+
+	// probes of orchestrators
+	initHealth(e)
 
 	// setup /api
 	api := e.Group("/api")
@@ -45,7 +79,17 @@ func New() *echo.Echo {
 	initAuth(api)
 	initUsers(api)
 	initTasks(api)
+	initSummary(api)
+	initReports(api)
+	initCalendar(api)
+	initImport(api)
+	initBackup(api)
+	initFilters(api)
+	initWebhooks(api)
+	initEvents(api)
+	initGraphQL(api)
+	initOpenAPI(e, api)
 
 	// setup the rest
-	return e
+	return e, nil
 }
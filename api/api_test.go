@@ -2,20 +2,37 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
-
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/backup"
+	"github.com/briansan/ManageMeServer/config"
 	model "github.com/briansan/ManageMeServer/model/schema"
 	"github.com/briansan/ManageMeServer/model/store"
+	"github.com/briansan/ManageMeServer/rpc/pb"
 )
 
 type APITestSuite struct {
@@ -24,13 +41,14 @@ type APITestSuite struct {
 }
 
 func (suite *APITestSuite) SetupTest() {
-	os.Setenv("MANAGEME_MONGO_DATABASE", "test")
-	os.Setenv("MANAGEME_SECRET", "test_secret")
+	cfg, err := config.Load([]string{"-mode", "api", "-store.database", "test", "-api.secret", "test_secret"})
+	suite.Require().NoError(err)
 	os.Setenv("TESTING", "true")
 
-	store.InitMongoSession()
+	store.InitMongoSession(&cfg.Store)
 	store.Nuke()
-	suite.e = New()
+	suite.e, err = New(&cfg.API)
+	suite.Require().NoError(err)
 }
 
 func (suite *APITestSuite) Test001_NormalUsage() {
@@ -64,7 +82,7 @@ func (suite *APITestSuite) Test001_NormalUsage() {
 	suite.Equal(http.StatusCreated, code)
 	suite.Equal(username, secureUser.Username)
 	suite.Equal(email, secureUser.Email)
-	suite.Nil(user.PreferredHours)
+	suite.Nil(user.WorkingHours)
 
 	// save user id
 	uid := secureUser.ID.Hex()
@@ -87,28 +105,28 @@ func (suite *APITestSuite) Test001_NormalUsage() {
 	suite.Equal(http.StatusOK, code)
 	suite.Equal(username, secureUser.Username)
 	suite.Equal(email, secureUser.Email)
-	suite.Nil(user.PreferredHours)
+	suite.Nil(user.WorkingHours)
 
 	// 2b. GET /api/users/{username} (as user)
 	code, _ = suite.request("GET", "/api/users/"+username, jwtAuth, nil, secureUser)
 	suite.Equal(http.StatusOK, code)
 	suite.Equal(username, secureUser.Username)
 	suite.Equal(email, secureUser.Email)
-	suite.Nil(user.PreferredHours)
+	suite.Nil(user.WorkingHours)
 
 	// 2c. GET /api/users/{userID} (as admin)
 	code, _ = suite.request("GET", "/api/users/"+uid, jwtAuthString(adminSession), nil, secureUser)
 	suite.Equal(http.StatusOK, code)
 	suite.Equal(username, secureUser.Username)
 	suite.Equal(email, secureUser.Email)
-	suite.Nil(user.PreferredHours)
+	suite.Nil(user.WorkingHours)
 
 	// 2d. GET /api/users/{username} (as admin)
 	code, _ = suite.request("GET", "/api/users/"+username, jwtAuthString(adminSession), nil, secureUser)
 	suite.Equal(http.StatusOK, code)
 	suite.Equal(username, secureUser.Username)
 	suite.Equal(email, secureUser.Email)
-	suite.Nil(user.PreferredHours)
+	suite.Nil(user.WorkingHours)
 
 	// 3a. GET /api/users (as admin)
 	users := []*model.UserSecure{}
@@ -122,19 +140,30 @@ func (suite *APITestSuite) Test001_NormalUsage() {
 	suite.Equal(http.StatusForbidden, code)
 
 	// 4. PATCH /api/users/{userID}
-	user = &model.User{PreferredHours: model.NewTimeRange(1, 2)}
+	user = &model.User{WorkingHours: &model.WorkingHours{
+		TimeZone: "Europe/Berlin",
+		Weekly: map[string][]model.ClockRange{
+			"monday": {{Start: "09:00", Finish: "17:00"}},
+		},
+	}}
 	secureUser = &model.UserSecure{}
 	code, _ = suite.request("PATCH", "/api/users/"+uid, jwtAuth, user, secureUser)
 	suite.Equal(http.StatusOK, code)
 	suite.Equal(username, secureUser.Username)
 	suite.Equal(email, secureUser.Email)
 
-	suite.NotNil(secureUser.PreferredHours)
-	suite.Equal(1, int(*secureUser.PreferredHours.Start))
-	suite.Equal(2, int(*secureUser.PreferredHours.Finish))
-
-	// 4b. PATCH /api/users/{userID}.PreferredHours (fails)
-	user = &model.User{PreferredHours: model.NewTimeRange(2, 1)}
+	suite.NotNil(secureUser.WorkingHours)
+	suite.Equal("Europe/Berlin", secureUser.WorkingHours.TimeZone)
+	suite.Equal("09:00", secureUser.WorkingHours.Weekly["monday"][0].Start)
+	suite.Equal("17:00", secureUser.WorkingHours.Weekly["monday"][0].Finish)
+
+	// 4b. PATCH /api/users/{userID}.WorkingHours (fails)
+	user = &model.User{WorkingHours: &model.WorkingHours{
+		TimeZone: "Europe/Berlin",
+		Weekly: map[string][]model.ClockRange{
+			"monday": {{Start: "17:00", Finish: "09:00"}},
+		},
+	}}
 	secureUser = &model.UserSecure{}
 	code, _ = suite.request("PATCH", "/api/users/"+uid, jwtAuth, user, secureUser)
 	suite.Equal(http.StatusBadRequest, code)
@@ -152,9 +181,8 @@ func (suite *APITestSuite) Test001_NormalUsage() {
 	suite.Equal(username, secureUser.Username)
 	suite.Equal(email, secureUser.Email)
 
-	suite.NotNil(secureUser.PreferredHours)
-	suite.Equal(1, int(*secureUser.PreferredHours.Start))
-	suite.Equal(2, int(*secureUser.PreferredHours.Finish))
+	suite.NotNil(secureUser.WorkingHours)
+	suite.Equal("Europe/Berlin", secureUser.WorkingHours.TimeZone)
 }
 
 func (suite *APITestSuite) Test002_TaskUsage() {
@@ -354,11 +382,942 @@ func (suite *APITestSuite) Test002_TaskUsage() {
 	suite.Equal(http.StatusOK, code)
 }
 
+func (suite *APITestSuite) Test003_Summary() {
+	// 0. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request(
+		"GET", "/api/login",
+		basicAuthString("boss", "test_secret"),
+		nil, &token,
+	)
+	suite.Equal(http.StatusOK, code)
+	adminSession := token["session"]
+
+	// 1. POST /api/users with monday working hours in UTC
+	username, password, email := "foo", "bar", "foo@bar.com"
+	user := &model.User{
+		Username: &username,
+		Password: &password,
+		Email:    &email,
+		WorkingHours: &model.WorkingHours{
+			TimeZone: "UTC",
+			Weekly: map[string][]model.ClockRange{
+				"monday": {{Start: "09:00", Finish: "17:00"}},
+			},
+		},
+	}
+	var postUser model.UserSecure
+	code, _ = suite.request("POST", "/api/users", "", user, &postUser)
+	suite.Equal(http.StatusCreated, code)
+
+	code, _ = suite.request("GET", "/api/login", basicAuthString(username, password), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	session := token["session"]
+
+	// 2. POST /api/tasks on monday 2026-01-12 and tuesday 2026-01-13
+	monday := 1768208400 // 2026-01-12T09:00:00Z
+	for _, tr := range []*model.TimeRange{
+		model.NewTimeRange(monday, monday+4*3600),
+		model.NewTimeRange(monday+5*3600, monday+8*3600),
+		model.NewTimeRange(monday+24*3600, monday+25*3600),
+	} {
+		task := &model.Task{UserID: &postUser.ID, Title: "foo", TimeRange: *tr}
+		code, _ = suite.request("POST", "/api/tasks", jwtAuthString(session), task, nil)
+		suite.Equal(http.StatusCreated, code)
+	}
+
+	// 3a. GET /api/users/:userID/summary (as foo)
+	var summary model.UserSummary
+	url := fmt.Sprintf("/api/users/%s/summary?from=%d&to=%d", postUser.ID.Hex(), monday, monday+2*24*3600)
+	code, _ = suite.request("GET", url, jwtAuthString(session), nil, &summary)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal("UTC", summary.TimeZone)
+	suite.Equal(3, len(summary.Days))
+	suite.Equal(7*3600, summary.Days[0].Total)
+	suite.Equal(2, len(summary.Days[0].TaskIDs))
+	suite.Equal(model.ComplianceUnder, summary.Days[0].Compliance)
+	suite.Equal(3600, summary.Days[1].Total)
+	suite.Equal(model.ComplianceOver, summary.Days[1].Compliance)
+	suite.Equal(model.ComplianceWithin, summary.Days[2].Compliance)
+
+	// 3b. GET /api/users/:userID/summary (400 without range)
+	url = fmt.Sprintf("/api/users/%s/summary", postUser.ID.Hex())
+	code, _ = suite.request("GET", url, jwtAuthString(session), nil, nil)
+	suite.Equal(http.StatusBadRequest, code)
+
+	// 4a. GET /api/summary (as foo)
+	url = fmt.Sprintf("/api/summary?from=%d&to=%d", monday, monday+2*24*3600)
+	code, _ = suite.request("GET", url, jwtAuthString(session), nil, nil)
+	suite.Equal(http.StatusForbidden, code)
+
+	// 4b. GET /api/summary (as admin)
+	var summaries []*model.UserSummary
+	code, _ = suite.request("GET", url, jwtAuthString(adminSession), nil, &summaries)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(2, len(summaries))
+}
+
+func (suite *APITestSuite) Test004_Timesheet() {
+	// 0. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request(
+		"GET", "/api/login",
+		basicAuthString("boss", "test_secret"),
+		nil, &token,
+	)
+	suite.Equal(http.StatusOK, code)
+	adminSession := jwtAuthString(token["session"])
+
+	var admin model.UserSecure
+	code, _ = suite.request("GET", "/api/users/boss", adminSession, nil, &admin)
+	suite.Equal(http.StatusOK, code)
+
+	// 1. POST /api/tasks on 2026-01-12
+	day := 1768208400 // 2026-01-12T09:00:00Z
+	task := &model.Task{UserID: &admin.ID, Title: "foo", Description: "bar", TimeRange: *model.NewTimeRange(day, day+3600)}
+	code, _ = suite.request("POST", "/api/tasks", adminSession, task, nil)
+	suite.Equal(http.StatusCreated, code)
+
+	// 2a. GET /api/reports/timesheet?format=csv
+	url := fmt.Sprintf("/api/reports/timesheet?format=csv&from=%d&to=%d", day, day+24*3600)
+	code, csv := suite.request("GET", url, adminSession, nil, nil)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal("date,start,finish,duration,user,title,description\n"+
+		"2026-01-12,09:00,10:00,3600,boss,foo,bar\n"+
+		"2026-01-12,,,3600,,total,\n"+
+		",,,3600,,total,\n", csv)
+
+	// 2b. GET /api/reports/timesheet?format=pdf
+	code, pdf := suite.request("GET", "/api/reports/timesheet?format=pdf", adminSession, nil, nil)
+	suite.Equal(http.StatusOK, code)
+	suite.Contains(pdf, "%PDF-1.4")
+
+	// 2c. GET /api/reports/timesheet?format=docx (fails)
+	code, _ = suite.request("GET", "/api/reports/timesheet?format=docx", adminSession, nil, nil)
+	suite.Equal(http.StatusBadRequest, code)
+}
+
+func (suite *APITestSuite) Test005_Calendar() {
+	// 0. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request(
+		"GET", "/api/login",
+		basicAuthString("boss", "test_secret"),
+		nil, &token,
+	)
+	suite.Equal(http.StatusOK, code)
+	adminSession := jwtAuthString(token["session"])
+
+	var admin model.UserSecure
+	code, _ = suite.request("GET", "/api/users/boss", adminSession, nil, &admin)
+	suite.Equal(http.StatusOK, code)
+
+	// 1a. POST /api/tasks/import
+	now := time.Now().UTC()
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:standup@example.com",
+		"SUMMARY:standup",
+		"DTSTART:" + now.Format("20060102T150405Z"),
+		"DURATION:PT15M",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:broken@example.com",
+		"SUMMARY:broken",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	var result ImportResult
+	code, _ = suite.request("POST", "/api/tasks/import", adminSession, ics, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(1, result.Created)
+	suite.Equal(1, len(result.Errors))
+	suite.Equal("broken@example.com", result.Errors[0].UID)
+
+	// 1b. POST /api/tasks/import (same file updates instead of duplicating)
+	code, _ = suite.request("POST", "/api/tasks/import", adminSession, ics, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(0, result.Created)
+	suite.Equal(1, result.Updated)
+
+	// 2a. POST /api/users/:userID/feed
+	url := fmt.Sprintf("/api/users/%s/feed", admin.ID.Hex())
+	code, _ = suite.request("POST", url, adminSession, nil, &token)
+	suite.Equal(http.StatusCreated, code)
+	suite.Equal("/api/feeds/"+token["token"], token["url"])
+
+	// 2b. GET /api/feeds/:token
+	code, feed := suite.request("GET", token["url"], "", nil, nil)
+	suite.Equal(http.StatusOK, code)
+	suite.Contains(feed, "UID:standup@example.com")
+	suite.Equal(1, strings.Count(feed, "BEGIN:VEVENT"))
+
+	// 2c. GET /api/feeds/:token (fails with bad token)
+	code, _ = suite.request("GET", "/api/feeds/nope", "", nil, nil)
+	suite.Equal(http.StatusUnauthorized, code)
+}
+
+func (suite *APITestSuite) Test006_CalDAV() {
+	// 0. GET /api/login (as admin)
+	auth := basicAuthString("boss", "test_secret")
+	var token map[string]string
+	code, _ := suite.request("GET", "/api/login", auth, nil, &token)
+	suite.Equal(http.StatusOK, code)
+
+	var admin model.UserSecure
+	code, _ = suite.request("GET", "/api/users/boss", jwtAuthString(token["session"]), nil, &admin)
+	suite.Equal(http.StatusOK, code)
+	collection := fmt.Sprintf("/api/caldav/%s/tasks/", admin.ID.Hex())
+
+	// 1a. PROPFIND /api/caldav (fails without credentials)
+	code, _ = suite.request("PROPFIND", "/api/caldav/", "", nil, nil)
+	suite.Equal(http.StatusUnauthorized, code)
+
+	// 1b. PROPFIND /api/caldav
+	code, body := suite.request("PROPFIND", "/api/caldav/", auth, nil, nil)
+	suite.Equal(207, code)
+	suite.Contains(body, "<D:current-user-principal><D:href>/api/caldav/"+admin.ID.Hex()+"/</D:href>")
+
+	// 2a. PUT /api/caldav/:userID/tasks/:uid.ics
+	start := time.Now().UTC().AddDate(0, 0, 2)
+	event := func(summary string) string {
+		return strings.Join([]string{
+			"BEGIN:VCALENDAR",
+			"BEGIN:VEVENT",
+			"UID:review@example.com",
+			"SUMMARY:" + summary,
+			"DTSTART:" + start.Format("20060102T150405Z"),
+			"DURATION:PT1H",
+			"END:VEVENT",
+			"END:VCALENDAR",
+		}, "\r\n")
+	}
+	resource := collection + "review@example.com.ics"
+	code, _ = suite.request("PUT", resource, auth, event("review"), nil)
+	suite.Equal(http.StatusCreated, code)
+
+	// 2b. PUT (fails when the name doesn't match the UID)
+	code, _ = suite.request("PUT", collection+"other.ics", auth, event("review"), nil)
+	suite.Equal(http.StatusBadRequest, code)
+
+	// 2c. GET /api/caldav/:userID/tasks/:uid.ics
+	code, body = suite.request("GET", resource, auth, nil, nil)
+	suite.Equal(http.StatusOK, code)
+	suite.Contains(body, "SUMMARY:review")
+
+	// 3a. PROPFIND /api/caldav/:userID/tasks
+	code, body = suite.request("PROPFIND", collection, auth, nil, nil)
+	suite.Equal(207, code)
+	suite.Contains(body, "<CS:getctag>")
+	suite.Contains(body, "<D:href>"+resource+"</D:href>")
+	suite.Contains(body, `-1&#34;</D:getetag>`)
+
+	ctag := regexp.MustCompile(`<CS:getctag>([^<]+)</CS:getctag>`)
+	before := ctag.FindStringSubmatch(body)
+
+	// 3b. PUT updates and bumps the revision and the collection's ctag
+	code, _ = suite.request("PUT", resource, auth, event("code review"), nil)
+	suite.Equal(http.StatusNoContent, code)
+	code, body = suite.request("PROPFIND", collection, auth, nil, nil)
+	suite.Equal(207, code)
+	after := ctag.FindStringSubmatch(body)
+	if suite.Equal(2, len(before)) && suite.Equal(2, len(after)) {
+		suite.NotEqual(before[1], after[1])
+	}
+
+	// 4a. REPORT calendar-query
+	query := fmt.Sprintf(`<?xml version="1.0"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/><C:calendar-data/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">
+    <C:time-range start="%s" end="%s"/>
+  </C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`, start.Add(-time.Hour).Format("20060102T150405Z"), start.Add(time.Hour).Format("20060102T150405Z"))
+	code, body = suite.request("REPORT", collection, auth, query, nil)
+	suite.Equal(207, code)
+	suite.Contains(body, "SUMMARY:code review")
+	suite.Contains(body, `-2&#34;</D:getetag>`)
+
+	// 4b. REPORT calendar-multiget
+	multiget := `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <D:href>` + resource + `</D:href>
+  <D:href>` + collection + `missing.ics</D:href>
+</C:calendar-multiget>`
+	code, body = suite.request("REPORT", collection, auth, multiget, nil)
+	suite.Equal(207, code)
+	suite.Contains(body, "HTTP/1.1 404 Not Found")
+	suite.NotContains(body, "BEGIN:VCALENDAR")
+
+	// 5. DELETE /api/caldav/:userID/tasks/:uid.ics
+	code, _ = suite.request("DELETE", resource, auth, nil, nil)
+	suite.Equal(http.StatusNoContent, code)
+	code, _ = suite.request("GET", resource, auth, nil, nil)
+	suite.Equal(http.StatusNotFound, code)
+}
+
+func (suite *APITestSuite) Test007_BulkImport() {
+	// 0. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request("GET", "/api/login", basicAuthString("boss", "test_secret"), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	auth := jwtAuthString(token["session"])
+
+	day := time.Now().UTC().AddDate(0, 0, 3).Format("2006-01-02")
+	body := strings.Join([]string{
+		"date,start,finish,user,title,description",
+		day + ",09:00,10:00,boss,planning,",
+		day + ",10:00,11:00,nobody,review,",
+		day + ",11:00,12:00,boss,,",
+	}, "\n")
+
+	// 1a. POST /api/tasks/bulk?dryRun=true
+	var result ImportResult
+	code, _ = suite.request("POST", "/api/tasks/bulk?dryRun=true", auth, body, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.True(result.DryRun)
+	suite.Equal(1, result.Valid)
+	suite.Equal(0, result.Created)
+	suite.Equal([]*ImportError{
+		{Row: 3, Message: "unknown user nobody"},
+		{Row: 4, Message: "title field is required as string"},
+	}, result.Errors)
+
+	var tasks []model.Task
+	code, _ = suite.request("GET", "/api/tasks", auth, nil, &tasks)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(0, len(tasks))
+
+	// 1b. POST /api/tasks/bulk
+	result = ImportResult{}
+	code, _ = suite.request("POST", "/api/tasks/bulk", auth, body, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(1, result.Created)
+	code, _ = suite.request("GET", "/api/tasks", auth, nil, &tasks)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(1, len(tasks))
+	suite.Equal("planning", tasks[0].Title)
+
+	// 2. POST /api/tasks/bulk?format=toggl&type=json
+	toggl := `{"data": [{"email": "nobody@example.com", "description": "x",
+		"start": "2026-01-12T09:00:00Z", "end": "2026-01-12T10:00:00Z"}]}`
+	result = ImportResult{}
+	code, _ = suite.request("POST", "/api/tasks/bulk?format=toggl&type=json", auth, toggl, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(0, result.Created)
+	suite.Equal("unknown user nobody@example.com", result.Errors[0].Message)
+
+	// 3. POST /api/tasks/bulk (fails with unknown format)
+	code, _ = suite.request("POST", "/api/tasks/bulk?format=clockify", auth, body, nil)
+	suite.Equal(http.StatusBadRequest, code)
+}
+
+func (suite *APITestSuite) Test008_Filters() {
+	// 0a. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request("GET", "/api/login", basicAuthString("boss", "test_secret"), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	adminAuth := jwtAuthString(token["session"])
+
+	var users []model.UserSecure
+	code, _ = suite.request("GET", "/api/users", adminAuth, nil, &users)
+	suite.Equal(http.StatusOK, code)
+	admin := users[0]
+
+	// 0b. POST /api/users (foo: user)
+	username, password, email := "foo", "bar", "foo@bar.baz"
+	var foo model.UserSecure
+	code, _ = suite.request("POST", "/api/users", "", &model.User{Username: &username, Password: &password, Email: &email}, &foo)
+	suite.Equal(http.StatusCreated, code)
+	code, _ = suite.request("GET", "/api/login", basicAuthString(username, password), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	fooAuth := jwtAuthString(token["session"])
+
+	// 0c. POST /api/tasks (one tagged task each, today)
+	now := int(time.Now().Unix())
+	for _, task := range []*model.Task{
+		{UserID: &admin.ID, Title: "planning", Tags: []string{"client-x"}, TimeRange: *model.NewTimeRange(now-60, now)},
+		{UserID: &foo.ID, Title: "review", Tags: []string{"client-x"}, TimeRange: *model.NewTimeRange(now-120, now-60)},
+	} {
+		code, _ = suite.request("POST", "/api/tasks", adminAuth, task, nil)
+		suite.Equal(http.StatusCreated, code)
+	}
+
+	// 1. POST /api/filters (fails with bad query)
+	code, _ = suite.request("POST", "/api/filters", adminAuth, map[string]interface{}{"name": "x", "q": `title:"x`}, nil)
+	suite.Equal(http.StatusBadRequest, code)
+
+	// 2. POST /api/filters (shared with foo)
+	var filter model.Filter
+	code, _ = suite.request("POST", "/api/filters", adminAuth, map[string]interface{}{
+		"name": "client x", "q": "tag:client-x", "range": "today", "groupBy": "user",
+		"dashboard": true, "sharedWith": []string{foo.ID.Hex()},
+	}, &filter)
+	suite.Equal(http.StatusCreated, code)
+	suite.Equal(admin.ID, filter.OwnerID)
+	suite.Equal(model.ViewTasks, filter.View)
+	path := "/api/filters/" + filter.ID.Hex()
+
+	// 3a. GET /api/filters/:filterID/run (as admin, every user)
+	var result FilterResult
+	code, _ = suite.request("GET", path+"/run", adminAuth, nil, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(2, len(result.Groups))
+	suite.Equal("boss", result.Groups[0].Key)
+	suite.Equal(60, result.Groups[0].Total)
+	suite.Equal("foo", result.Groups[1].Key)
+	suite.Empty(result.Next)
+
+	// 3b. GET /api/filters/:filterID/run?limit=1 (a page of tasks)
+	result = FilterResult{}
+	code, _ = suite.request("GET", path+"/run?limit=1", adminAuth, nil, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(1, len(result.Groups))
+	suite.Equal("foo", result.Groups[0].Key)
+	suite.NotEmpty(result.Next)
+	next := result.Next
+	result = FilterResult{}
+	code, _ = suite.request("GET", path+"/run?limit=1&cursor="+next, adminAuth, nil, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(1, len(result.Groups))
+	suite.Equal("boss", result.Groups[0].Key)
+
+	// 3c. GET /api/filters/:filterID/run?view=summary (as foo, only their own)
+	result = FilterResult{}
+	code, _ = suite.request("GET", path+"/run?view=summary", fooAuth, nil, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(1, len(result.Summaries))
+	suite.Equal(foo.ID, result.Summaries[0].UserID)
+
+	// 4. PATCH /api/filters/:filterID (only the owner)
+	name := "client x today"
+	code, _ = suite.request("PATCH", path, fooAuth, &model.FilterPatch{Name: &name}, nil)
+	suite.Equal(http.StatusForbidden, code)
+	code, _ = suite.request("PATCH", path, adminAuth, &model.FilterPatch{Name: &name}, &filter)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(name, filter.Name)
+
+	// 5. GET /api/dashboard (a summary over a year fails on its own)
+	code, _ = suite.request("POST", "/api/filters", adminAuth, map[string]interface{}{
+		"name": "all time", "view": "summary", "start": 1, "finish": now, "dashboard": true,
+	}, nil)
+	suite.Equal(http.StatusCreated, code)
+	var dashboard []FilterResult
+	code, _ = suite.request("GET", "/api/dashboard", adminAuth, nil, &dashboard)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(2, len(dashboard))
+	suite.Nil(dashboard[0].Error)
+	suite.Equal(2, len(dashboard[0].Groups))
+	if suite.NotNil(dashboard[1].Error) {
+		suite.Equal(http.StatusBadRequest, dashboard[1].Error.Status)
+	}
+	code, _ = suite.request("GET", "/api/dashboard", fooAuth, nil, &dashboard)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(0, len(dashboard))
+
+	// 6. DELETE /api/filters/:filterID
+	code, _ = suite.request("DELETE", path, fooAuth, nil, nil)
+	suite.Equal(http.StatusForbidden, code)
+	code, _ = suite.request("DELETE", path, adminAuth, nil, nil)
+	suite.Equal(http.StatusOK, code)
+	code, _ = suite.request("GET", path, fooAuth, nil, nil)
+	suite.Equal(http.StatusNotFound, code)
+}
+
+func (suite *APITestSuite) Test009_Webhooks() {
+	// 0a. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request("GET", "/api/login", basicAuthString("boss", "test_secret"), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	adminAuth := jwtAuthString(token["session"])
+
+	// 0b. POST /api/users (foo: user)
+	username, password, email := "foo", "bar", "foo@bar.baz"
+	var foo model.UserSecure
+	code, _ = suite.request("POST", "/api/users", "", &model.User{Username: &username, Password: &password, Email: &email}, &foo)
+	suite.Equal(http.StatusCreated, code)
+	code, _ = suite.request("GET", "/api/login", basicAuthString(username, password), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	fooAuth := jwtAuthString(token["session"])
+
+	// 1a. POST /api/webhooks (as admin, every user)
+	var all model.Webhook
+	code, _ = suite.request("POST", "/api/webhooks", adminAuth, map[string]interface{}{
+		"url": "https://example.com/all", "events": []string{"task.created", "user.deleted"},
+	}, &all)
+	suite.Equal(http.StatusCreated, code)
+	suite.Nil(all.UserID)
+	suite.True(all.Active)
+	suite.Equal(64, len(all.Secret))
+
+	// 1b. POST /api/webhooks (as foo, user events are for every user)
+	code, _ = suite.request("POST", "/api/webhooks", fooAuth, map[string]interface{}{
+		"url": "https://example.com/own", "events": []string{"user.created"},
+	}, nil)
+	suite.Equal(http.StatusBadRequest, code)
+
+	// 1c. POST /api/webhooks (as foo, defaults to their own tasks)
+	var own model.Webhook
+	code, _ = suite.request("POST", "/api/webhooks", fooAuth, map[string]interface{}{
+		"url": "https://example.com/own", "events": []string{"task.created"},
+	}, &own)
+	suite.Equal(http.StatusCreated, code)
+	suite.Equal(foo.ID, *own.UserID)
+
+	// 1d. GET /api/webhooks (secrets are hidden)
+	var webhooks []model.Webhook
+	code, _ = suite.request("GET", "/api/webhooks", fooAuth, nil, &webhooks)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(1, len(webhooks))
+	suite.Equal("", webhooks[0].Secret)
+
+	// 2. POST /api/tasks queues a delivery for both
+	now := int(time.Now().Unix())
+	task := &model.Task{UserID: &foo.ID, Title: "planning", TimeRange: *model.NewTimeRange(now-60, now)}
+	code, _ = suite.request("POST", "/api/tasks", fooAuth, task, nil)
+	suite.Equal(http.StatusCreated, code)
+
+	var deliveries []model.Delivery
+	code, _ = suite.request("GET", "/api/webhooks/"+own.ID.Hex()+"/deliveries?status=pending", fooAuth, nil, &deliveries)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(1, len(deliveries))
+	suite.Equal("task.created", deliveries[0].Event)
+	suite.Contains(deliveries[0].Payload, `"title":"planning"`)
+
+	// 3. GET /api/webhooks/:webhookID/deliveries (only the owner)
+	code, _ = suite.request("GET", "/api/webhooks/"+all.ID.Hex()+"/deliveries", fooAuth, nil, nil)
+	suite.Equal(http.StatusNotFound, code)
+
+	// 4. PATCH /api/webhooks/:webhookID
+	active := false
+	code, _ = suite.request("PATCH", "/api/webhooks/"+own.ID.Hex(), fooAuth, &model.WebhookPatch{Active: &active}, &own)
+	suite.Equal(http.StatusOK, code)
+	suite.False(own.Active)
+
+	// 5. DELETE /api/users/:userID queues user.deleted and removes foo's webhooks
+	code, _ = suite.request("DELETE", "/api/users/"+foo.ID.Hex(), adminAuth, nil, nil)
+	suite.Equal(http.StatusOK, code)
+	code, _ = suite.request("GET", "/api/webhooks/"+all.ID.Hex()+"/deliveries?sort=createdAt", adminAuth, nil, &deliveries)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(2, len(deliveries))
+	suite.Equal("user.deleted", deliveries[1].Event)
+}
+
+func (suite *APITestSuite) Test010_Events() {
+	// 0a. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request("GET", "/api/login", basicAuthString("boss", "test_secret"), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	adminSession := token["session"]
+
+	// 0b. POST /api/users (foo: user)
+	username, password, email := "foo", "bar", "foo@bar.baz"
+	var foo model.UserSecure
+	code, _ = suite.request("POST", "/api/users", "", &model.User{Username: &username, Password: &password, Email: &email}, &foo)
+	suite.Equal(http.StatusCreated, code)
+	code, _ = suite.request("GET", "/api/login", basicAuthString(username, password), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	fooSession := token["session"]
+
+	// 0c. POST /api/tasks (one each)
+	now := int(time.Now().Unix())
+	var adminTask, fooTask model.Task
+	code, _ = suite.request("POST", "/api/tasks", jwtAuthString(fooSession),
+		&model.Task{UserID: &foo.ID, Title: "mine", TimeRange: *model.NewTimeRange(now-60, now)}, &fooTask)
+	suite.Equal(http.StatusCreated, code)
+	var users []model.UserSecure
+	suite.request("GET", "/api/users", jwtAuthString(adminSession), nil, &users)
+	code, _ = suite.request("POST", "/api/tasks", jwtAuthString(adminSession),
+		&model.Task{UserID: &users[0].ID, Title: "theirs", TimeRange: *model.NewTimeRange(now-60, now)}, &adminTask)
+	suite.Equal(http.StatusCreated, code)
+
+	// stream replays everything after lastEventID until timeout
+	stream := func(auth, ticket, lastEventID string) (int, string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequest("GET", "/api/events?ticket="+ticket, nil)
+		suite.Nil(err)
+		if len(auth) > 0 {
+			req.Header.Set(echo.HeaderAuthorization, auth)
+		}
+		req.Header.Set("Last-Event-ID", lastEventID)
+		rec := httptest.NewRecorder()
+		suite.e.ServeHTTP(rec, req.WithContext(ctx))
+		return rec.Code, rec.Body.String()
+	}
+	first := bson.NewObjectIdWithTime(time.Unix(0, 0)).Hex()
+
+	// 1. GET /api/events (as admin, every event)
+	code, body := stream(jwtAuthString(adminSession), "", first)
+	suite.Equal(http.StatusOK, code)
+	suite.Contains(body, "retry: 3000")
+	suite.Contains(body, "event: user.created")
+	suite.Contains(body, `"title":"mine"`)
+	suite.Contains(body, `"title":"theirs"`)
+
+	// 2. GET /api/events (as foo, only about themselves)
+	code, body = stream(jwtAuthString(fooSession), "", first)
+	suite.Equal(http.StatusOK, code)
+	suite.Contains(body, "event: user.created")
+	suite.Contains(body, `"title":"mine"`)
+	suite.NotContains(body, `"title":"theirs"`)
+
+	// 3. POST /api/events/ticket then GET /api/events?ticket= (only once)
+	var ticket map[string]interface{}
+	code, _ = suite.request("POST", "/api/events/ticket", jwtAuthString(fooSession), nil, &ticket)
+	suite.Equal(http.StatusCreated, code)
+	t, _ := ticket["ticket"].(string)
+	suite.NotEmpty(t)
+	code, body = stream("", t, first)
+	suite.Equal(http.StatusOK, code)
+	suite.Contains(body, `"title":"mine"`)
+	suite.NotContains(body, `"title":"theirs"`)
+	code, _ = stream("", t, first)
+	suite.Equal(http.StatusUnauthorized, code)
+
+	// 4. GET /api/events (fails without a valid token, ticket or Last-Event-ID)
+	code, _ = stream(jwtAuthString("nope"), "", first)
+	suite.Equal(http.StatusUnauthorized, code)
+	code, _ = stream("", "nope", first)
+	suite.Equal(http.StatusUnauthorized, code)
+	code, _ = stream(jwtAuthString(fooSession), "", "nope")
+	suite.Equal(http.StatusBadRequest, code)
+}
+
+func (suite *APITestSuite) Test011_GraphQL() {
+	// 0a. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request("GET", "/api/login", basicAuthString("boss", "test_secret"), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	adminAuth := jwtAuthString(token["session"])
+
+	// 0b. POST /api/users (foo: user)
+	username, password, email := "foo", "bar", "foo@bar.baz"
+	var foo model.UserSecure
+	code, _ = suite.request("POST", "/api/users", "", &model.User{Username: &username, Password: &password, Email: &email}, &foo)
+	suite.Equal(http.StatusCreated, code)
+	code, _ = suite.request("GET", "/api/login", basicAuthString(username, password), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	fooAuth := jwtAuthString(token["session"])
+
+	type gqlResponse struct {
+		Data   map[string]json.RawMessage `json:"data"`
+		Errors []struct {
+			Message    string                 `json:"message"`
+			Path       []interface{}          `json:"path"`
+			Extensions map[string]interface{} `json:"extensions"`
+		} `json:"errors"`
+	}
+	query := func(auth, q string, vars map[string]interface{}) gqlResponse {
+		var res gqlResponse
+		code, body := suite.request("POST", "/api/graphql", auth, map[string]interface{}{"query": q, "variables": vars}, &res)
+		suite.Equal(http.StatusOK, code, body)
+		return res
+	}
+
+	// 1. Requires a token
+	code, _ = suite.request("POST", "/api/graphql", "", map[string]interface{}{"query": "{ me { id } }"}, nil)
+	suite.Equal(http.StatusUnauthorized, code)
+
+	// 2. mutation createTask (foo can't create tasks for others)
+	now := int(time.Now().Unix())
+	create := `mutation ($task: TaskInput!) { createTask(task: $task) { id title tags start user { username } } }`
+	res := query(fooAuth, create, map[string]interface{}{"task": map[string]interface{}{
+		"userID": foo.ID.Hex(), "title": "review", "tags": []string{"x"}, "start": now - 120, "finish": now - 60,
+	}})
+	suite.Nil(res.Errors)
+	var created struct {
+		ID    string   `json:"id"`
+		Title string   `json:"title"`
+		Tags  []string `json:"tags"`
+		Start int      `json:"start"`
+		User  struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	}
+	suite.Nil(json.Unmarshal(res.Data["createTask"], &created))
+	suite.Equal("review", created.Title)
+	suite.Equal([]string{"x"}, created.Tags)
+	suite.Equal(now-120, created.Start)
+	suite.Equal("foo", created.User.Username)
+
+	var me struct {
+		ID string `json:"id"`
+	}
+	res = query(adminAuth, `{ me { id } }`, nil)
+	suite.Nil(json.Unmarshal(res.Data["me"], &me))
+	res = query(fooAuth, create, map[string]interface{}{"task": map[string]interface{}{
+		"userID": me.ID, "title": "planning", "start": now - 60, "finish": now,
+	}})
+	suite.Equal(1, len(res.Errors))
+	suite.Equal(float64(http.StatusUnauthorized), res.Errors[0].Extensions["status"])
+	suite.Equal("null", string(res.Data["createTask"]))
+	res = query(adminAuth, create, map[string]interface{}{"task": map[string]interface{}{
+		"userID": me.ID, "title": "planning", "start": now - 60, "finish": now,
+	}})
+	suite.Nil(res.Errors)
+
+	// 3a. query tasks (as admin, every task with its owner fetched at once)
+	fetches := 0
+	fetch := func(ids []bson.ObjectId) ([]*model.UserSecure, error) {
+		fetches++
+		db, _ := store.NewMongoStore()
+		defer db.Cleanup()
+		return db.GetUsersByIDs(ids)
+	}
+	db, _ := store.NewMongoStore()
+	loader := newUserLoader(db)
+	loader.fetch = fetch
+	resp := executeGraphQL(context.WithValue(context.Background(), gqlKey{}, &gqlRequest{
+		db: db, user: &model.UserSecure{ID: bson.ObjectIdHex(me.ID), Role: model.RoleAdmin}, users: loader,
+	}), &gqlQuery{Query: `{ tasks(sort: "start") { total items { title user { username } } } }`})
+	db.Cleanup()
+	suite.Nil(resp.Errors)
+	suite.Equal(1, fetches)
+	b, _ := json.Marshal(resp.Data)
+	suite.Equal(`{"tasks":{"items":[{"title":"review","user":{"username":"foo"}},{"title":"planning","user":{"username":"boss"}}],"total":2}}`, string(b))
+
+	// 3b. query tasks (as foo, only their own, and not others')
+	res = query(fooAuth, `{ tasks { items { title } } }`, nil)
+	suite.Nil(res.Errors)
+	suite.Equal(`{"items":[{"title":"review"}]}`, string(res.Data["tasks"]))
+	res = query(fooAuth, `query ($id: ID) { tasks(userID: $id) { items { title } } }`, map[string]interface{}{"id": me.ID})
+	suite.Equal(float64(http.StatusForbidden), res.Errors[0].Extensions["status"])
+
+	// 4. query user and summary (foo can't see the admin)
+	res = query(fooAuth, `query ($id: ID!) { user(id: $id) { username } me { username tasks { items { title } } } }`,
+		map[string]interface{}{"id": me.ID})
+	suite.Equal(1, len(res.Errors))
+	suite.Equal([]interface{}{"user"}, res.Errors[0].Path)
+	suite.Equal(`{"tasks":{"items":[{"title":"review"}]},"username":"foo"}`, string(res.Data["me"]))
+	res = query(adminAuth, `query ($from: Int!, $to: Int!) { summary(from: $from, to: $to, tz: "UTC") { user { username } days { total } } }`,
+		map[string]interface{}{"from": now - 3600, "to": now})
+	suite.Nil(res.Errors)
+	suite.Contains(string(res.Data["summary"]), `"user":{"username":"foo"}`)
+	res = query(fooAuth, `{ summary(from: 0, to: 1) { username } }`, nil)
+	suite.Equal(float64(http.StatusForbidden), res.Errors[0].Extensions["status"])
+	res = query(fooAuth, `query ($id: ID) { summary(userID: $id, from: 0, to: 1) { user { username } } }`,
+		map[string]interface{}{"id": foo.ID.Hex()})
+	suite.Nil(res.Errors)
+	suite.Equal(`[{"user":{"username":"foo"}}]`, string(res.Data["summary"]))
+
+	// 5. mutation updateTask and deleteTask (same rules as PATCH and DELETE)
+	res = query(fooAuth, `mutation ($id: ID!) { updateTask(id: $id, patch: {title: "reviewed"}) { title revision } }`,
+		map[string]interface{}{"id": created.ID})
+	suite.Nil(res.Errors)
+	suite.Equal(`{"revision":2,"title":"reviewed"}`, string(res.Data["updateTask"]))
+	res = query(fooAuth, `mutation ($id: ID!) { updateTask(id: $id, patch: {}) { title } }`,
+		map[string]interface{}{"id": created.ID})
+	suite.Equal("body is empty", res.Errors[0].Message)
+	res = query(fooAuth, `mutation { deleteTask(id: "nope") { id } }`, nil)
+	suite.Equal(float64(http.StatusBadRequest), res.Errors[0].Extensions["status"])
+	res = query(fooAuth, `mutation ($id: ID!) { deleteTask(id: $id) { id } }`, map[string]interface{}{"id": created.ID})
+	suite.Nil(res.Errors)
+
+	// 6. mutation updateUser (only admins change roles)
+	res = query(fooAuth, `mutation ($id: ID!) { updateUser(id: $id, patch: {role: 1}) { role } }`,
+		map[string]interface{}{"id": foo.ID.Hex()})
+	suite.Equal(float64(http.StatusForbidden), res.Errors[0].Extensions["status"])
+	res = query(fooAuth, `mutation ($id: ID!) { updateUser(id: $id, patch: {email: "foo@baz.bar"}) { email } }`,
+		map[string]interface{}{"id": foo.ID.Hex()})
+	suite.Nil(res.Errors)
+	suite.Equal(`{"email":"foo@baz.bar"}`, string(res.Data["updateUser"]))
+
+	// 7. GET /api/graphql (queries only)
+	code, _ = suite.request("GET", "/api/graphql?query="+url.QueryEscape("{ me { username } }"), fooAuth, nil, nil)
+	suite.Equal(http.StatusOK, code)
+	code, _ = suite.request("GET", "/api/graphql?query="+url.QueryEscape(`mutation { deleteUser(id: "x") { id } }`), fooAuth, nil, nil)
+	suite.Equal(http.StatusMethodNotAllowed, code)
+
+	// 8. GET /api/graphql/schema
+	code, sdl := suite.request("GET", "/api/graphql/schema", "", nil, nil)
+	suite.Equal(http.StatusOK, code)
+	suite.Contains(sdl, "  tasks(cursor: String, from: Int, limit: Int, q: String, sort: String, to: Int, tz: String, userID: ID): TaskPage!\n")
+}
+
+func (suite *APITestSuite) Test012_RPC() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Nil(err)
+	srv := NewRPCServer()
+	go srv.Serve(l)
+	defer srv.Stop()
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	suite.Nil(err)
+	defer conn.Close()
+	client := pb.NewManageMeClient(conn)
+	as := func(auth string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", auth)
+	}
+
+	// 0a. Login (as admin)
+	ctx := context.Background()
+	_, err = client.Login(as(basicAuthString("boss", "wrong")), &pb.LoginRequest{})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+	session, err := client.Login(as(basicAuthString("boss", "test_secret")), &pb.LoginRequest{})
+	suite.Nil(err)
+	admin := as(jwtAuthString(session.Session))
+
+	// 0b. CreateUser (foo signs up, the role they ask for is ignored)
+	role := int32(model.RoleAdmin)
+	foo, err := client.CreateUser(ctx, &pb.CreateUserRequest{Username: "foo", Email: "foo@bar.baz", Password: "bar", Role: &role})
+	suite.Nil(err)
+	suite.Equal(int32(model.RoleUser), foo.Role)
+	_, err = client.CreateUser(ctx, &pb.CreateUserRequest{Username: "foo", Email: "foo@bar.baz", Password: "bar"})
+	suite.Equal(codes.AlreadyExists, status.Code(err))
+	session, err = client.Login(as(basicAuthString("foo", "bar")), &pb.LoginRequest{})
+	suite.Nil(err)
+	fooCtx := as(jwtAuthString(session.Session))
+
+	// 1. Calls require a session
+	_, err = client.ListTasks(ctx, &pb.ListTasksRequest{})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+	_, err = client.ListTasks(as("Bearer nope"), &pb.ListTasksRequest{})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+
+	// 2. CreateTask (foo can't create tasks for others)
+	now := time.Now().Unix()
+	start, finish := now-120, now-60
+	task, err := client.CreateTask(fooCtx, &pb.CreateTaskRequest{Task: &pb.Task{
+		UserId: foo.Id, Title: "review", Tags: []string{"x"}, Start: &start, Finish: &finish,
+	}})
+	suite.Nil(err)
+	suite.Equal("review", task.Title)
+	suite.Equal(int32(1), task.Revision)
+	suite.Equal(start, *task.Start)
+	me, err := client.GetUser(admin, &pb.GetUserRequest{Id: "boss"})
+	suite.Nil(err)
+	_, err = client.CreateTask(fooCtx, &pb.CreateTaskRequest{Task: &pb.Task{
+		UserId: me.Id, Title: "planning", Start: &start, Finish: &finish,
+	}})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+	_, err = client.CreateTask(fooCtx, &pb.CreateTaskRequest{Task: &pb.Task{UserId: foo.Id}})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+
+	// 3. CreateTasks (valid tasks are created, errors reported by index)
+	bulk, err := client.CreateTasks(admin, &pb.CreateTasksRequest{Tasks: []*pb.Task{
+		{UserId: me.Id, Title: "planning", Start: &start, Finish: &finish},
+		{UserId: "nope", Title: "x", Start: &start, Finish: &finish},
+		{UserId: me.Id, Start: &start, Finish: &finish},
+	}})
+	suite.Nil(err)
+	suite.Equal(int32(1), bulk.Created)
+	suite.Equal(2, len(bulk.Errors))
+	suite.Equal(int32(1), bulk.Errors[0].Index)
+	suite.Equal(int32(2), bulk.Errors[1].Index)
+
+	// 4. ListTasks (admin sees every task, foo only their own)
+	tasks, err := client.ListTasks(admin, &pb.ListTasksRequest{Sort: "start", Limit: 1})
+	suite.Nil(err)
+	suite.Equal(1, len(tasks.Tasks))
+	suite.NotEqual("", tasks.Next)
+	tasks, err = client.ListTasks(admin, &pb.ListTasksRequest{Sort: "start", Limit: 1, Cursor: tasks.Next})
+	suite.Nil(err)
+	suite.Equal(1, len(tasks.Tasks))
+	tasks, err = client.ListTasks(fooCtx, &pb.ListTasksRequest{})
+	suite.Nil(err)
+	suite.Equal(1, len(tasks.Tasks))
+	suite.Equal("review", tasks.Tasks[0].Title)
+	_, err = client.ListTasks(fooCtx, &pb.ListTasksRequest{UserId: me.Id})
+	suite.Equal(codes.PermissionDenied, status.Code(err))
+
+	// 5. UpdateTask, GetTask and DeleteTask (same rules as PATCH, GET and DELETE)
+	title := "reviewed"
+	task, err = client.UpdateTask(fooCtx, &pb.UpdateTaskRequest{Id: task.Id, Title: &title, SetTags: true})
+	suite.Nil(err)
+	suite.Equal("reviewed", task.Title)
+	suite.Nil(task.Tags)
+	suite.Equal(int32(2), task.Revision)
+	_, err = client.UpdateTask(fooCtx, &pb.UpdateTaskRequest{Id: task.Id})
+	st, _ := status.FromError(err)
+	suite.Equal(codes.InvalidArgument, st.Code())
+	suite.Equal("body is empty", st.Message())
+	_, err = client.GetTask(admin, &pb.GetTaskRequest{Id: task.Id})
+	suite.Nil(err)
+	_, err = client.DeleteTask(fooCtx, &pb.DeleteTaskRequest{Id: tasks.Tasks[0].Id})
+	suite.Nil(err)
+	_, err = client.GetTask(fooCtx, &pb.GetTaskRequest{Id: task.Id})
+	suite.Equal(codes.NotFound, status.Code(err))
+
+	// 6. Users (foo can't list users or change roles, admins can)
+	_, err = client.ListUsers(fooCtx, &pb.ListUsersRequest{})
+	suite.Equal(codes.PermissionDenied, status.Code(err))
+	users, err := client.ListUsers(admin, &pb.ListUsersRequest{})
+	suite.Nil(err)
+	suite.Equal(2, len(users.Users))
+	_, err = client.UpdateUser(fooCtx, &pb.UpdateUserRequest{Id: foo.Id, Role: &role})
+	suite.Equal(codes.PermissionDenied, status.Code(err))
+	updated, err := client.UpdateUser(admin, &pb.UpdateUserRequest{Id: foo.Id, WorkingHours: `{"timeZone":"UTC","weekly":{"monday":[{"start":"09:00","finish":"17:00"}]}}`})
+	suite.Nil(err)
+	suite.Contains(updated.WorkingHours, `"timeZone":"UTC"`)
+	_, err = client.DeleteUser(fooCtx, &pb.DeleteUserRequest{Id: me.Id})
+	suite.Equal(codes.PermissionDenied, status.Code(err))
+	_, err = client.DeleteUser(fooCtx, &pb.DeleteUserRequest{Id: foo.Id})
+	suite.Nil(err)
+	_, err = client.ListTasks(fooCtx, &pb.ListTasksRequest{})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+}
+
+func (suite *APITestSuite) Test013_Backup() {
+	// 0a. GET /api/login (as admin)
+	var token map[string]string
+	code, _ := suite.request("GET", "/api/login", basicAuthString("boss", "test_secret"), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	adminAuth := jwtAuthString(token["session"])
+
+	// 0b. POST /api/users (foo: user)
+	username, password, email := "foo", "bar", "foo@bar.baz"
+	var foo model.UserSecure
+	code, _ = suite.request("POST", "/api/users", "", &model.User{Username: &username, Password: &password, Email: &email}, &foo)
+	suite.Equal(http.StatusCreated, code)
+	code, _ = suite.request("GET", "/api/login", basicAuthString(username, password), nil, &token)
+	suite.Equal(http.StatusOK, code)
+	fooAuth := jwtAuthString(token["session"])
+
+	// 1. GET /api/backup (fails as user)
+	code, _ = suite.request("GET", "/api/backup", fooAuth, nil, nil)
+	suite.Equal(http.StatusForbidden, code)
+
+	// 2. GET /api/backup
+	code, archive := suite.request("GET", "/api/backup", adminAuth, nil, nil)
+	suite.Equal(http.StatusOK, code)
+	a, err := backup.Open(strings.NewReader(archive), int64(len(archive)))
+	suite.Require().NoError(err)
+	suite.Equal(2, a.Manifest.Collection("users").Count)
+
+	// 3a. POST /api/backup/restore?dryRun=true
+	var result RestoreResponse
+	code, _ = suite.request("POST", "/api/backup/restore?dryRun=true", adminAuth, archive, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal("merge", result.Mode)
+	suite.Equal(&store.RestoreResult{Collection: "users", Skipped: 2}, result.Collections[0])
+
+	// 3b. POST /api/backup/restore?mode=replace, foo logs in again with its password
+	code, _ = suite.request("DELETE", "/api/users/"+foo.ID.Hex(), adminAuth, nil, nil)
+	suite.Equal(http.StatusOK, code)
+	result = RestoreResponse{}
+	code, _ = suite.request("POST", "/api/backup/restore?mode=replace", adminAuth, archive, &result)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(2, result.Collections[0].Restored)
+	code, _ = suite.request("GET", "/api/login", basicAuthString(username, password), nil, nil)
+	suite.Equal(http.StatusOK, code)
+
+	// 4. POST /api/backup/restore (fails with a corrupt archive or unknown mode)
+	code, _ = suite.request("POST", "/api/backup/restore", adminAuth, archive[:len(archive)/2], nil)
+	suite.Equal(http.StatusBadRequest, code)
+	code, _ = suite.request("POST", "/api/backup/restore?mode=overwrite", adminAuth, archive, nil)
+	suite.Equal(http.StatusBadRequest, code)
+}
+
 func (suite *APITestSuite) request(method, path, auth string, body, response interface{}) (int, string) {
 	var req *http.Request
 	var err error
 
-	if body != nil {
+	if text, ok := body.(string); ok {
+		// send strings as is
+		req, err = http.NewRequest(method, path, strings.NewReader(text))
+		suite.Nil(err)
+	} else if body != nil {
 		// interface to json string
 		buf, err := json.Marshal(body)
 		suite.Nil(err)
@@ -404,3 +1363,51 @@ func jwtAuthString(jwt string) string {
 func TestAPI(t *testing.T) {
 	suite.Run(t, new(APITestSuite))
 }
+
+// TestProblem checks errors are problems of the request, it doesn't need
+//   mongo
+func TestProblem(t *testing.T) {
+	assert := assert.New(t)
+
+	e := echo.New()
+	e.HTTPErrorHandler = problemHandler
+	e.Pre(middleware.RequestID())
+	e.POST("/api/tasks", func(c echo.Context) error {
+		user := &model.UserSecure{ID: bson.NewObjectId(), Role: model.RoleUser}
+		return checkNewTask(user, &model.Task{Tags: []string{"two words"}})
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/tasks", nil)
+	req.Header.Set(echo.HeaderXRequestID, "foo")
+	e.ServeHTTP(rec, req)
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.Equal("application/problem+json", rec.Header().Get(echo.HeaderContentType))
+
+	problem := struct {
+		Status    int    `json:"status"`
+		Code      string `json:"code"`
+		Instance  string `json:"instance"`
+		RequestID string `json:"requestID"`
+		Errors    []struct {
+			Field string `json:"field"`
+		} `json:"errors"`
+	}{}
+	assert.Nil(json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(http.StatusBadRequest, problem.Status)
+	assert.Equal("validation_failed", problem.Code)
+	assert.Equal("/api/tasks", problem.Instance)
+	assert.Equal("foo", problem.RequestID)
+	fields := []string{}
+	for _, err := range problem.Errors {
+		fields = append(fields, err.Field)
+	}
+	assert.Equal([]string{"userID", "title", "tags", "start", "finish"}, fields)
+
+	// Unknown routes are problems too, with a generated request ID
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/api/nothing", nil))
+	assert.Equal(http.StatusNotFound, rec.Code)
+	assert.Contains(rec.Body.String(), `"code":"not_found"`)
+	assert.NotEmpty(rec.Header().Get(echo.HeaderXRequestID))
+}
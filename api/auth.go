@@ -3,20 +3,20 @@ package api
 import (
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
 
+	"github.com/briansan/ManageMeServer/config"
 	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
 	"github.com/briansan/ManageMeServer/model/store"
+	"github.com/briansan/ManageMeServer/trace"
 )
 
 const (
-	envSecret = "MANAGEME_SECRET"
-
 	sessionDuration = time.Hour
 )
 
@@ -24,21 +24,20 @@ var (
 	secret []byte
 )
 
-func initSecret() {
-	if secret = []byte(os.Getenv(envSecret)); len(secret) == 0 {
-		panic("env.MANAGEME_SECRET is not defined")
-	}
+// initSecret signs sessions with the secret of cfg and creates the admin
+//   with it as password if missing
+func initSecret(cfg *config.API) error {
+	secret = []byte(cfg.Secret)
+
 	// Get user from db
 	db, err := store.NewMongoStore()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer db.Cleanup()
 
 	// Try to fetch user by creds
-	if err := db.AdminExistsOrCreate(string(secret)); err != nil {
-		panic(err)
-	}
+	return db.AdminExistsOrCreate(cfg.AdminUsername, cfg.AdminEmail, cfg.Secret)
 }
 
 // NewJWTSession creates a jwt token with
@@ -67,7 +66,7 @@ func AuthenticateJWT(authString string) (string, error) {
 	// Break up auth string by "Bearer" and "jwt"
 	parts := strings.Split(authString, " ")
 	if len(parts) != 2 {
-		return "", fmt.Errorf("failed to split in 2")
+		return "", jwtFailure(jwtMalformed, fmt.Errorf("failed to split in 2"))
 	}
 	jwtString := parts[1]
 
@@ -82,19 +81,60 @@ func AuthenticateJWT(authString string) (string, error) {
 		return secret, nil
 	})
 	if err != nil {
-		return "", err
+		return "", jwtFailure(jwtReason(err), err)
 	}
 
 	// Type assert as a map
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", fmt.Errorf("failed to assert as map claims")
+		return "", jwtFailure(jwtInvalid, fmt.Errorf("failed to assert as map claims"))
 	}
 
 	// Retrieve aud field
 	user, ok := claims["aud"].(string)
 	if !ok {
-		return "", fmt.Errorf("no aud field")
+		return "", jwtFailure(jwtNoAudience, fmt.Errorf("no aud field"))
+	}
+	return user, nil
+}
+
+// jwtUser validates the Authorization:Bearer token of c and
+//   fetches the corresponding user, traced as DoJWTAuth
+func jwtUser(c echo.Context) (user *model.UserSecure, err error) {
+	ctx, span := trace.Start(c.Request().Context(), "DoJWTAuth", trace.KindInternal)
+	defer func() {
+		trace.SetError(span, err)
+		span.End()
+	}()
+
+	// Get Authorization header value
+	values := c.Request().Header[echo.HeaderAuthorization]
+	if len(values) != 1 {
+		return nil, echo.ErrUnauthorized
+	}
+	auth := values[0]
+
+	// Get user id from token
+	id, err := AuthenticateJWT(auth)
+	if err != nil {
+		logger.Warn("jwt auth failed", "reason", err.Error())
+		return nil, echo.ErrUnauthorized
+	}
+
+	// Get user from db
+	db, err := store.NewMongoStoreContext(ctx)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Try to fetch user by creds
+	user, err = db.GetUserByID(id)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+	if user == nil {
+		return nil, echo.ErrUnauthorized
 	}
 	return user, nil
 }
@@ -104,34 +144,9 @@ func AuthenticateJWT(authString string) (string, error) {
 //   corresponding user
 func DoJWTAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// Get Authorization header value
-		values := c.Request().Header[echo.HeaderAuthorization]
-		if len(values) != 1 {
-			return echo.ErrUnauthorized
-		}
-		auth := values[0]
-
-		// Get user id from token
-		id, err := AuthenticateJWT(auth)
-		if err != nil {
-			logger.Warn("jwt auth failed", "reason", err.Error())
-			return echo.ErrUnauthorized
-		}
-
-		// Get user from db
-		db, err := store.NewMongoStore()
+		user, err := jwtUser(c)
 		if err != nil {
-			return errors.MongoErrorResponse(err)
-		}
-		defer db.Cleanup()
-
-		// Try to fetch user by creds
-		user, err := db.GetUserByID(id)
-		if err != nil {
-			return errors.MongoErrorResponse(err)
-		}
-		if user == nil {
-			return echo.ErrUnauthorized
+			return err
 		}
 
 		c.Set("user", user)
@@ -143,11 +158,12 @@ func GetLogin(c echo.Context) error {
 	// Get basic auth creds
 	u, p, ok := c.Request().BasicAuth()
 	if !ok {
+		logins.WithLabelValues(loginFailure).Inc()
 		return echo.ErrUnauthorized
 	}
 
 	// Authenticate
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
@@ -156,23 +172,24 @@ func GetLogin(c echo.Context) error {
 	// Try to fetch user by creds
 	user, err := db.GetUserByCreds(u, p)
 	if err != nil {
-		if err.Error() == "not found" {
+		if err == store.ErrNotFound {
+			logins.WithLabelValues(loginFailure).Inc()
 			return echo.ErrUnauthorized
 		}
 		if err != nil {
 			return errors.MongoErrorResponse(err)
 		}
 	}
+	logins.WithLabelValues(loginSuccess).Inc()
 
 	// Create JWT token
 	token, err := NewJWTSession(user.ID.Hex())
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 	return c.JSON(http.StatusOK, map[string]string{"session": token})
 }
 
 func initAuth(api *echo.Group) {
-	initSecret()
 	api.GET("/login", GetLogin)
 }
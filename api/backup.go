@@ -0,0 +1,135 @@
+package api
+
+import (
+	goerrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+
+	"github.com/briansan/ManageMeServer/backup"
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// maxRestoreSize allows for the archives of years of tasks
+const maxRestoreSize = "1G"
+
+// RestoreResponse is the outcome of a restore, by collection
+type RestoreResponse struct {
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dryRun,omitempty"`
+	// SchemaVersion is that of the archive
+	SchemaVersion int                    `json:"schemaVersion"`
+	Collections   []*store.RestoreResult `json:"collections"`
+}
+
+// GetBackup streams an archive of the database
+func GetBackup(c echo.Context) error {
+	// Type assert user from context and authorize
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+	if !allows(user.Role, model.PermissionModifyAllUsers) {
+		return echo.ErrForbidden
+	}
+
+	// Get db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Stream the archive, errors past this point can only be logged and
+	//   leave the archive without its manifest
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, backup.ContentType)
+	resp.Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="manageme-%s.zip"`, time.Now().UTC().Format("20060102-150405")))
+	resp.WriteHeader(http.StatusOK)
+	if _, err := db.Backup(resp); err != nil {
+		logger.Warn("backup failed", "err", err)
+	}
+	return nil
+}
+
+// PostRestore restores the archive sent as the body or as the file field
+//   of a multipart form, merged in the database unless mode is replace.
+//   The archive is checked as a whole first, nothing is written on a dry run
+func PostRestore(c echo.Context) error {
+	// Type assert user from context and authorize
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+	if !allows(user.Role, model.PermissionModifyAllUsers) {
+		return echo.ErrForbidden
+	}
+
+	// Validate params
+	mode := c.QueryParam("mode")
+	if len(mode) == 0 {
+		mode = store.RestoreMerge
+	}
+	if !isOneOf(mode, store.RestoreModes) {
+		return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("mode", "merge or replace"))
+	}
+	dryRun := c.QueryParam("dryRun") == "true"
+
+	// Archives are read at random, so the upload is kept in a file
+	body, _, err := bulkBody(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	defer body.Close()
+	f, err := ioutil.TempFile("", "manageme-restore-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, body)
+	if err != nil {
+		return err
+	}
+	a, err := backup.Open(f, size)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	results, err := db.Restore(a, mode, dryRun)
+	switch {
+	case goerrors.Is(err, backup.ErrCorrupt):
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	case goerrors.Is(err, backup.ErrIncompatible):
+		return echo.NewHTTPError(http.StatusConflict, err)
+	case err != nil:
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusOK, &RestoreResponse{
+		Mode:          mode,
+		DryRun:        dryRun,
+		SchemaVersion: a.Manifest.SchemaVersion,
+		Collections:   results,
+	})
+}
+
+func initBackup(api *echo.Group) {
+	api.GET("/backup", GetBackup, DoJWTAuth)
+	api.POST("/backup/restore", PostRestore, DoJWTAuth, middleware.BodyLimit(maxRestoreSize))
+}
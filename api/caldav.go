@@ -0,0 +1,565 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	"github.com/briansan/ManageMeServer/ical"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// CalDAV (RFC 4791) exposes each user's tasks as a calendar collection:
+//   /api/caldav/                        root, points clients to the principal
+//   /api/caldav/:userID/                principal and calendar home
+//   /api/caldav/:userID/tasks/          calendar collection
+//   /api/caldav/:userID/tasks/:uid.ics  a task as a VEVENT
+// echo can't route PROPFIND and REPORT so it is served before routing
+
+const (
+	caldavPrefix     = "/api/caldav"
+	caldavCollection = "tasks"
+	caldavResource   = ".ics"
+
+	nsDAV     = "DAV:"
+	nsCalDAV  = "urn:ietf:params:xml:ns:caldav"
+	nsCalServ = "http://calendarserver.org/ns/"
+
+	davStatusOK       = "HTTP/1.1 200 OK"
+	davStatusNotFound = "HTTP/1.1 404 Not Found"
+
+	maxCalDAVBody = 1 << 20
+)
+
+type davHref struct {
+	Href string `xml:"D:href"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+	Calendar   *struct{} `xml:"C:calendar,omitempty"`
+	Principal  *struct{} `xml:"D:principal,omitempty"`
+}
+
+type davComp struct {
+	Name string `xml:"name,attr"`
+}
+
+type davCompSet struct {
+	Comps []davComp `xml:"C:comp"`
+}
+
+type davProp struct {
+	ResourceType         *davResourceType `xml:"D:resourcetype,omitempty"`
+	DisplayName          string           `xml:"D:displayname,omitempty"`
+	CurrentUserPrincipal *davHref         `xml:"D:current-user-principal,omitempty"`
+	PrincipalURL         *davHref         `xml:"D:principal-URL,omitempty"`
+	CalendarHomeSet      *davHref         `xml:"C:calendar-home-set,omitempty"`
+	SupportedComponents  *davCompSet      `xml:"C:supported-calendar-component-set,omitempty"`
+	CTag                 string           `xml:"CS:getctag,omitempty"`
+	ETag                 string           `xml:"D:getetag,omitempty"`
+	ContentType          string           `xml:"D:getcontenttype,omitempty"`
+	CalendarData         string           `xml:"C:calendar-data,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davResponse struct {
+	Href     string       `xml:"D:href"`
+	Propstat *davPropstat `xml:"D:propstat,omitempty"`
+	Status   string       `xml:"D:status,omitempty"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	NSDAV     string        `xml:"xmlns:D,attr"`
+	NSCalDAV  string        `xml:"xmlns:C,attr"`
+	NSCalServ string        `xml:"xmlns:CS,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+// davReport holds what we need from a REPORT body
+type davReport struct {
+	Name     string
+	From, To string
+	Hrefs    []string
+	WithData bool
+}
+
+// caldavRequest is a request to the CalDAV tree of a user
+type caldavRequest struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	db    *store.MongoStore
+	user  *model.UserSecure
+	owner *model.UserSecure
+	path  []string
+	loc   *time.Location
+}
+
+func principalHref(userID string) string {
+	return caldavPrefix + "/" + userID + "/"
+}
+
+func collectionHref(userID string) string {
+	return principalHref(userID) + caldavCollection + "/"
+}
+
+func resourceHref(t *model.Task) string {
+	return collectionHref(t.UserID.Hex()) + url.PathEscape(taskUID(t)) + caldavResource
+}
+
+// taskETag derives the entity tag of a task from its revision
+func taskETag(t *model.Task) string {
+	return fmt.Sprintf(`"%s-%d"`, t.ID.Hex(), t.Revision)
+}
+
+// taskCalendar renders a task as a VCALENDAR holding a single VEVENT
+func taskCalendar(t *model.Task) string {
+	buf := &bytes.Buffer{}
+	ical.Encode(buf, "", []*ical.Event{taskToEvent(t)})
+	return buf.String()
+}
+
+// parseReport scans a REPORT body for the report name,
+// the time-range filter, the hrefs of a multiget and calendar-data
+func parseReport(body io.Reader) (*davReport, error) {
+	report := &davReport{}
+	d := xml.NewDecoder(body)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if len(report.Name) == 0 {
+			report.Name = el.Name.Local
+		}
+		switch el.Name.Local {
+		case "calendar-data":
+			report.WithData = true
+		case "time-range":
+			for _, a := range el.Attr {
+				t, err := time.Parse("20060102T150405Z", a.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid time-range %v", a.Value)
+				}
+				switch a.Name.Local {
+				case "start":
+					report.From = strconv.FormatInt(t.Unix(), 10)
+				case "end":
+					report.To = strconv.FormatInt(t.Unix(), 10)
+				}
+			}
+		case "href":
+			var href string
+			if err := d.DecodeElement(&href, &el); err != nil {
+				return nil, err
+			}
+			report.Hrefs = append(report.Hrefs, strings.TrimSpace(href))
+		}
+	}
+	return report, nil
+}
+
+// authenticateCalDAV checks the same credentials as GetLogin, or a session
+func authenticateCalDAV(db *store.MongoStore, r *http.Request) *model.UserSecure {
+	if u, p, ok := r.BasicAuth(); ok {
+		user, err := db.GetUserByCreds(u, p)
+		if err != nil {
+			return nil
+		}
+		return user
+	}
+	auth := r.Header.Get(echo.HeaderAuthorization)
+	if len(auth) == 0 {
+		return nil
+	}
+	id, err := AuthenticateJWT(auth)
+	if err != nil || !bson.IsObjectIdHex(id) {
+		return nil
+	}
+	user, err := db.GetUserByID(id)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// ServeCalDAV handles every request under caldavPrefix
+func ServeCalDAV(w http.ResponseWriter, r *http.Request) {
+	if r.Method == echo.OPTIONS {
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(r.Context())
+	if err != nil {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Cleanup()
+
+	// Authenticate, prompting clients for basic auth
+	user := authenticateCalDAV(db, r)
+	if user == nil {
+		w.Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="ManageMe"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	req := &caldavRequest{w: w, r: r, db: db, user: user}
+	p := strings.Trim(strings.TrimPrefix(r.URL.Path, caldavPrefix), "/")
+	if len(p) > 0 {
+		req.path = strings.Split(p, "/")
+	}
+
+	// The root only tells clients where the principal is
+	if len(req.path) == 0 {
+		if r.Method != "PROPFIND" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		req.multistatus([]davResponse{req.principalResponse(caldavPrefix + "/")})
+		return
+	}
+
+	// Authorize access to the owner's calendar
+	userID := req.path[0]
+	write := r.Method == echo.PUT || r.Method == echo.DELETE
+	switch {
+	case userID == user.ID.Hex():
+	case write && allows(user.Role, model.PermissionModifyAllTasks):
+	case !write && allows(user.Role, model.PermissionViewAllTasks):
+	default:
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if !bson.IsObjectIdHex(userID) {
+		http.NotFound(w, r)
+		return
+	}
+	if req.owner, err = db.GetUserByID(userID); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	req.loc, _ = model.SummaryLocation(req.owner, "")
+
+	switch {
+	case len(req.path) == 1 && r.Method == "PROPFIND":
+		req.propfindHome()
+	case len(req.path) == 2 && req.path[1] == caldavCollection && r.Method == "PROPFIND":
+		req.propfindCollection()
+	case len(req.path) == 2 && req.path[1] == caldavCollection && r.Method == "REPORT":
+		req.report()
+	case len(req.path) == 3 && req.path[1] == caldavCollection:
+		req.resource()
+	case len(req.path) > 3 || (len(req.path) > 1 && req.path[1] != caldavCollection):
+		http.NotFound(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (req *caldavRequest) multistatus(responses []davResponse) {
+	req.w.Header().Set(echo.HeaderContentType, "application/xml; charset=utf-8")
+	req.w.WriteHeader(207)
+	io.WriteString(req.w, xml.Header)
+	enc := xml.NewEncoder(req.w)
+	if err := enc.Encode(&davMultistatus{
+		NSDAV:     nsDAV,
+		NSCalDAV:  nsCalDAV,
+		NSCalServ: nsCalServ,
+		Responses: responses,
+	}); err != nil {
+		logger.Warn("caldav write failed", "err", err)
+	}
+}
+
+func (req *caldavRequest) principalResponse(href string) davResponse {
+	me := principalHref(req.user.ID.Hex())
+	return davResponse{Href: href, Propstat: &davPropstat{Status: davStatusOK, Prop: davProp{
+		ResourceType:         &davResourceType{Collection: &struct{}{}, Principal: &struct{}{}},
+		DisplayName:          req.user.Username,
+		CurrentUserPrincipal: &davHref{me},
+		PrincipalURL:         &davHref{me},
+		CalendarHomeSet:      &davHref{me},
+	}}}
+}
+
+func (req *caldavRequest) taskResponse(t *model.Task, withData bool) davResponse {
+	prop := davProp{ETag: taskETag(t), ContentType: "text/calendar; charset=utf-8; component=VEVENT"}
+	if withData {
+		prop.CalendarData = taskCalendar(t)
+	}
+	return davResponse{Href: resourceHref(t), Propstat: &davPropstat{Status: davStatusOK, Prop: prop}}
+}
+
+func (req *caldavRequest) collectionResponse() (davResponse, error) {
+	ctag, err := req.db.GetCalendarTag(req.owner.ID)
+	if err != nil {
+		return davResponse{}, err
+	}
+	return davResponse{Href: collectionHref(req.owner.ID.Hex()), Propstat: &davPropstat{Status: davStatusOK, Prop: davProp{
+		ResourceType:        &davResourceType{Collection: &struct{}{}, Calendar: &struct{}{}},
+		DisplayName:         "ManageMe " + req.owner.Username,
+		SupportedComponents: &davCompSet{Comps: []davComp{{Name: "VEVENT"}}},
+		CTag:                ctag,
+	}}}, nil
+}
+
+// propfindHome lists the calendar home with its single calendar collection
+func (req *caldavRequest) propfindHome() {
+	responses := []davResponse{req.principalResponse(principalHref(req.owner.ID.Hex()))}
+	if req.r.Header.Get("Depth") != "0" {
+		c, err := req.collectionResponse()
+		if err != nil {
+			http.Error(req.w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		responses = append(responses, c)
+	}
+	req.multistatus(responses)
+}
+
+// propfindCollection describes the calendar and, unless Depth is 0, its tasks
+func (req *caldavRequest) propfindCollection() {
+	c, err := req.collectionResponse()
+	if err != nil {
+		http.Error(req.w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	responses := []davResponse{c}
+
+	if req.r.Header.Get("Depth") != "0" {
+		q, _ := store.NewTaskQueryFromParams(req.owner.ID.Hex(), "", "", "")
+		tasks, _, err := req.db.GetAllTasks(&store.ListOptions{Query: q})
+		if err != nil {
+			http.Error(req.w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		for _, t := range tasks {
+			responses = append(responses, req.taskResponse(t, false))
+		}
+	}
+	req.multistatus(responses)
+}
+
+// report answers calendar-query, mapping its time-range onto
+// NewTaskQueryFromParams, and calendar-multiget
+func (req *caldavRequest) report() {
+	report, err := parseReport(http.MaxBytesReader(req.w, req.r.Body, maxCalDAVBody))
+	if err != nil {
+		http.Error(req.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	responses := []davResponse{}
+	switch report.Name {
+	case "calendar-query":
+		q, err := store.NewTaskQueryFromParams(req.owner.ID.Hex(), "", report.From, report.To)
+		if err != nil {
+			http.Error(req.w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tasks, _, err := req.db.GetAllTasks(&store.ListOptions{Query: q})
+		if err != nil {
+			http.Error(req.w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		for _, t := range tasks {
+			responses = append(responses, req.taskResponse(t, report.WithData))
+		}
+	case "calendar-multiget":
+		for _, href := range report.Hrefs {
+			t, err := req.findTask(resourceName(href))
+			if err != nil {
+				responses = append(responses, davResponse{Href: href, Status: davStatusNotFound})
+				continue
+			}
+			responses = append(responses, req.taskResponse(t, report.WithData))
+		}
+	default:
+		http.Error(req.w, "unsupported report "+report.Name, http.StatusForbidden)
+		return
+	}
+	req.multistatus(responses)
+}
+
+// resourceName returns the unescaped uid of a resource href
+func resourceName(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	name := href[strings.LastIndex(href, "/")+1:]
+	return strings.TrimSuffix(name, caldavResource)
+}
+
+// findTask looks up a task of the owner by the uid of its resource:
+// either the UID it was imported with or its id suffixed with @manageme
+func (req *caldavRequest) findTask(uid string) (*model.Task, error) {
+	if id := strings.TrimSuffix(uid, "@manageme"); id != uid && bson.IsObjectIdHex(id) {
+		q, _ := store.NewTaskQueryFromParams(req.owner.ID.Hex(), id, "", "")
+		return req.db.GetTask(q)
+	}
+	return req.db.GetTaskByICalUID(req.owner.ID, uid)
+}
+
+// resource serves GET, HEAD, PUT and DELETE of a single task
+func (req *caldavRequest) resource() {
+	uid, err := url.PathUnescape(strings.TrimSuffix(req.path[2], caldavResource))
+	if err != nil || !strings.HasSuffix(req.path[2], caldavResource) {
+		http.NotFound(req.w, req.r)
+		return
+	}
+	t, err := req.findTask(uid)
+	if err != nil && err != store.ErrNotFound {
+		http.Error(req.w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Preconditions
+	if match := req.r.Header.Get("If-Match"); len(match) > 0 && (t == nil || (match != "*" && match != taskETag(t))) {
+		http.Error(req.w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	if req.r.Header.Get("If-None-Match") == "*" && t != nil {
+		http.Error(req.w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	switch req.r.Method {
+	case echo.GET, echo.HEAD:
+		if t == nil {
+			http.NotFound(req.w, req.r)
+			return
+		}
+		req.w.Header().Set("ETag", taskETag(t))
+		req.w.Header().Set(echo.HeaderContentType, "text/calendar; charset=utf-8")
+		req.w.WriteHeader(http.StatusOK)
+		if req.r.Method == echo.GET {
+			io.WriteString(req.w, taskCalendar(t))
+		}
+	case echo.PUT:
+		req.put(uid, t)
+	case echo.DELETE:
+		if t == nil {
+			http.NotFound(req.w, req.r)
+			return
+		}
+		if _, err := req.db.DeleteTask(t.ID.Hex()); err != nil {
+			http.Error(req.w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		req.w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(req.w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// put creates or updates the task at uid from the VEVENT in the body
+func (req *caldavRequest) put(uid string, existing *model.Task) {
+	events, parseErrs, err := ical.Decode(http.MaxBytesReader(req.w, req.r.Body, maxCalDAVBody), req.loc)
+	if err == nil && len(parseErrs) > 0 {
+		err = parseErrs[0]
+	}
+	if err == nil && len(events) != 1 {
+		err = fmt.Errorf("body must hold exactly one VEVENT")
+	}
+	if err != nil {
+		http.Error(req.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ev := events[0]
+	t := eventToTask(ev, req.owner.ID)
+	if err := t.Validate(); err != nil {
+		http.Error(req.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Update in place
+	if existing != nil {
+		patch := &model.TaskPatch{TimeRange: t.TimeRange, Title: &t.Title, Description: &t.Description}
+		updated, err := req.db.UpdateTaskRevision(existing.ID.Hex(), existing.Revision, patch)
+		if err != nil {
+			req.storeError(err)
+			return
+		}
+		req.w.Header().Set("ETag", taskETag(updated))
+		req.w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// New resources must be named after the event's UID
+	if ev.UID != uid {
+		http.Error(req.w, "resource name must match the event UID", http.StatusBadRequest)
+		return
+	}
+	if err := req.db.CreateTask(t); err != nil {
+		req.storeError(err)
+		return
+	}
+	req.w.Header().Set("ETag", taskETag(t))
+	req.w.WriteHeader(http.StatusCreated)
+}
+
+func (req *caldavRequest) storeError(err error) {
+	if overlap, ok := err.(*errors.OverlapError); ok {
+		http.Error(req.w, overlap.Error(), http.StatusConflict)
+		return
+	}
+	// The task changed since its preconditions were checked
+	if err == store.ErrModified {
+		http.Error(req.w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	logger.Warn("caldav store failed", "err", err)
+	http.Error(req.w, "internal server error", http.StatusInternalServerError)
+}
+
+// caldav serves the CalDAV tree before echo routes the request, through
+//   chain, the middleware the routes get from echo
+func caldav(chain ...echo.MiddlewareFunc) echo.MiddlewareFunc {
+	handler := func(c echo.Context) error {
+		ServeCalDAV(c.Response(), c.Request())
+		return nil
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p := c.Request().URL.Path
+			if p == caldavPrefix || strings.HasPrefix(p, caldavPrefix+"/") {
+				// Named for the spans and metrics of requests, caldav has no routes
+				c.SetPath(caldavPrefix + "/*")
+				return handler(c)
+			}
+			return next(c)
+		}
+	}
+}
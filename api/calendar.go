@@ -0,0 +1,274 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	"github.com/briansan/ManageMeServer/ical"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+const (
+	defaultFeedDays = 30
+	maxFeedDays     = 366
+
+	maxImportSize = "10M"
+)
+
+// ImportError reports a record that couldn't be imported
+type ImportError struct {
+	UID     string `json:"uid,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Row     int    `json:"row,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportResult reports the outcome of an import
+type ImportResult struct {
+	// Valid counts the records that were or, on a dry run, would be created
+	Valid   int            `json:"valid,omitempty"`
+	DryRun  bool           `json:"dryRun,omitempty"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Errors  []*ImportError `json:"errors"`
+}
+
+// taskUID returns the UID of the calendar event for task
+func taskUID(t *model.Task) string {
+	if len(t.ICalUID) > 0 {
+		return t.ICalUID
+	}
+	return t.ID.Hex() + "@manageme"
+}
+
+func taskToEvent(t *model.Task) *ical.Event {
+	return &ical.Event{
+		UID:         taskUID(t),
+		Summary:     t.Title,
+		Description: t.Description,
+		Start:       time.Unix(int64(*t.Start), 0),
+		End:         time.Unix(int64(*t.Finish), 0),
+		Stamp:       t.ID.Time(),
+	}
+}
+
+// eventToTask converts ev into a task for userID, events without
+// a UID get one derived from their content so re-imports match
+func eventToTask(ev *ical.Event, userID bson.ObjectId) *model.Task {
+	uid := ev.UID
+	if len(uid) == 0 {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s", ev.Start.Unix(), ev.End.Unix(), ev.Summary)))
+		uid = hex.EncodeToString(sum[:16]) + "@import"
+	}
+	return &model.Task{
+		TimeRange:   *model.NewTimeRange(int(ev.Start.Unix()), int(ev.End.Unix())),
+		UserID:      &userID,
+		Title:       ev.Summary,
+		Description: ev.Description,
+		ICalUID:     uid,
+	}
+}
+
+// parseFeedDays reads an optional number of days for the feed window
+func parseFeedDays(c echo.Context, param string) (int, error) {
+	v := c.QueryParam(param)
+	if len(v) == 0 {
+		return defaultFeedDays, nil
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 0 || days > maxFeedDays {
+		return 0, errors.NewValidationError(param, fmt.Sprintf("int between 0 and %d", maxFeedDays))
+	}
+	return days, nil
+}
+
+// PostFeedToken issues a new calendar feed token for a user
+//   invalidating the previous one
+func PostFeedToken(c echo.Context) error {
+	userID := c.Param("userID")
+
+	// Type assert user from context and authorize
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+	if user.ID.Hex() != userID && !allows(user.Role, model.PermissionModifyAllUsers) {
+		return echo.ErrForbidden
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Issue token
+	token, err := db.NewFeedToken(userID)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusCreated, map[string]string{
+		"token": token,
+		"url":   "/api/feeds/" + token,
+	})
+}
+
+// GetFeed serves a user's tasks as an iCalendar feed
+//   authenticated by the feed token in the path
+func GetFeed(c echo.Context) error {
+	// Validate params
+	past, err := parseFeedDays(c, "past")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	future, err := parseFeedDays(c, "future")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Authenticate by token
+	user, err := db.GetUserByFeedToken(c.Param("token"))
+	if err != nil || user == nil {
+		return echo.ErrUnauthorized
+	}
+
+	// Construct query for the window around now
+	now := time.Now()
+	q, _ := store.NewTaskQueryFromParams(user.ID.Hex(), "",
+		strconv.FormatInt(now.AddDate(0, 0, -past).Unix(), 10),
+		strconv.FormatInt(now.AddDate(0, 0, future).Unix(), 10))
+
+	// Stream the feed, errors past this point can only be logged
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/calendar; charset=utf-8")
+	resp.WriteHeader(http.StatusOK)
+
+	enc := ical.NewEncoder(resp, "ManageMe "+user.Username)
+	iter := db.IterTasks(q)
+	t := model.Task{}
+	for iter.Next(&t) {
+		if err := enc.Encode(taskToEvent(&t)); err != nil {
+			logger.Warn("feed write failed", "err", err)
+			break
+		}
+		t = model.Task{}
+	}
+	if err := iter.Close(); err != nil {
+		logger.Warn("feed query failed", "err", err)
+	}
+	if err := enc.Close(); err != nil {
+		logger.Warn("feed write failed", "err", err)
+	}
+	return nil
+}
+
+// importBody returns the uploaded file of a multipart request
+//   or else the raw request body
+func importBody(c echo.Context) (io.ReadCloser, error) {
+	if fh, err := c.FormFile("file"); err == nil {
+		return fh.Open()
+	}
+	return c.Request().Body, nil
+}
+
+// PostTasksImport creates tasks from the VEVENTs of an uploaded .ics file
+//   for the caller, or for userID if the caller has ModifyAllTasks
+func PostTasksImport(c echo.Context) error {
+	// Type assert user from context and authorize
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+	userID := c.QueryParam("userID")
+	if len(userID) == 0 {
+		userID = user.ID.Hex()
+	}
+	if userID != user.ID.Hex() && !allows(user.Role, model.PermissionModifyAllTasks) {
+		return echo.ErrForbidden
+	}
+	if !bson.IsObjectIdHex(userID) {
+		return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("userID", "object id"))
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Floating times are in tz, else the owner's time zone
+	owner, err := db.GetUserByID(userID)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	tz := c.QueryParam("tz")
+	if err := validateTimeZone(tz); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	loc, _ := model.SummaryLocation(owner, tz)
+
+	// Decode calendar
+	body, err := importBody(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	defer body.Close()
+	events, parseErrs, err := ical.Decode(body, loc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	result := &ImportResult{Errors: []*ImportError{}}
+	for _, e := range parseErrs {
+		result.Errors = append(result.Errors, &ImportError{UID: e.UID, Line: e.Line, Message: e.Message})
+	}
+
+	// Import every event
+	for _, ev := range events {
+		t := eventToTask(ev, owner.ID)
+		if err := t.Validate(); err != nil {
+			result.Errors = append(result.Errors, &ImportError{UID: t.ICalUID, Message: err.Error()})
+			continue
+		}
+		created, err := db.ImportTask(t)
+		if err != nil {
+			if _, ok := err.(*errors.OverlapError); !ok {
+				return errors.MongoErrorResponse(err)
+			}
+			result.Errors = append(result.Errors, &ImportError{UID: t.ICalUID, Message: err.Error()})
+			continue
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func initCalendar(api *echo.Group) {
+	api.POST("/users/:userID/feed", PostFeedToken, DoJWTAuth)
+	api.GET("/feeds/:token", GetFeed)
+	api.POST("/tasks/import", PostTasksImport, DoJWTAuth, middleware.BodyLimit(maxImportSize))
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	"github.com/briansan/ManageMeServer/events"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+const (
+	headerLastEventID = "Last-Event-ID"
+
+	// maxReplay is how many missed events a reconnecting stream is sent
+	maxReplay = 1000
+	// eventsRetry is how long clients wait before reconnecting
+	eventsRetry = 3 * time.Second
+	// eventsHeartbeat keeps idle streams from timing out in proxies
+	eventsHeartbeat = 15 * time.Second
+)
+
+var (
+	hub     = events.NewHub()
+	hubOnce sync.Once
+)
+
+// canSeeEvent reports whether user may see ev, by the same rules as
+//   listing the tasks or users it is about
+func canSeeEvent(user *model.UserSecure, ev *model.Event) bool {
+	if ev.UserID == user.ID {
+		return true
+	}
+	if strings.HasPrefix(ev.Type, "task.") {
+		return allows(user.Role, model.PermissionViewAllTasks)
+	}
+	return allows(user.Role, model.PermissionModifyAllUsersRestricted)
+}
+
+// writeEvent writes ev in the text/event-stream format
+func writeEvent(res *echo.Response, ev *model.Event) {
+	fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, ev.Data)
+}
+
+// ticketAuth authenticates the stream with the single-use ticket param
+//   when there's no Authorization header, as browsers can't set headers on
+//   an EventSource. Tickets are exchanged for the jwt so that it doesn't end
+//   up in the access logs
+func ticketAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	jwtAuth := DoJWTAuth(next)
+	return func(c echo.Context) error {
+		token := c.QueryParam("ticket")
+		if len(token) == 0 || len(c.Request().Header.Get(echo.HeaderAuthorization)) > 0 {
+			return jwtAuth(c)
+		}
+
+		db, err := store.NewMongoStoreContext(c.Request().Context())
+		if err != nil {
+			return errors.MongoErrorResponse(err)
+		}
+		defer db.Cleanup()
+
+		userID, err := db.RedeemStreamTicket(token)
+		if err == store.ErrNotFound {
+			logger.Warn("ticket auth failed", "reason", "unknown, expired or redeemed")
+			return echo.ErrUnauthorized
+		}
+		if err != nil {
+			return errors.MongoErrorResponse(err)
+		}
+		user, err := db.GetUserByID(userID.Hex())
+		if err == store.ErrNotFound {
+			return echo.ErrUnauthorized
+		}
+		if err != nil {
+			return errors.MongoErrorResponse(err)
+		}
+		c.Set("user", user)
+		return next(c)
+	}
+}
+
+// PostEventsTicket issues a ticket authenticating a single stream of the
+//   user, see ticketAuth
+func PostEventsTicket(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	ticket, err := db.NewStreamTicket(user.ID)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"ticket":    ticket,
+		"expiresIn": int(store.TicketDuration / time.Second),
+	})
+}
+
+// GetEvents streams the task and user events the user can see as
+//   Server-Sent Events, replaying those after Last-Event-ID first
+func GetEvents(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Resume after the last event the client saw
+	lastID := c.Request().Header.Get(headerLastEventID)
+	if len(lastID) == 0 {
+		lastID = c.QueryParam("lastEventId")
+	}
+	last := int64(-1)
+	if len(lastID) > 0 && !bson.IsObjectIdHex(lastID) {
+		// Ids of the streams of former versions aren't sequences, they
+		//   just aren't replayed
+		var err error
+		if last, err = strconv.ParseInt(lastID, 10, 64); err != nil || last < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError(headerLastEventID, "id of an event"))
+		}
+	}
+
+	// Subscribe before replaying so no event is missed in between
+	sub := hub.Subscribe()
+	defer hub.Unsubscribe(sub)
+
+	var missed []*model.Event
+	if last >= 0 {
+		db, err := store.NewMongoStoreContext(c.Request().Context())
+		if err != nil {
+			return errors.MongoErrorResponse(err)
+		}
+		missed, err = db.GetEventsAfter(last, maxReplay)
+		db.Cleanup()
+		if err != nil {
+			return errors.MongoErrorResponse(err)
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: %d\n\n", eventsRetry/time.Millisecond)
+
+	// Replayed events may also be published. Published ones are sent
+	//   whatever their sequence, as an event numbered before another may
+	//   be recorded after it
+	replayed := map[int64]bool{}
+	send := func(ev *model.Event) {
+		if replayed[ev.Seq] {
+			return
+		}
+		if canSeeEvent(user, ev) {
+			writeEvent(res, ev)
+		}
+	}
+	for _, ev := range missed {
+		send(ev)
+		replayed[ev.Seq] = true
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				// Fell behind, the client reconnects and replays
+				return nil
+			}
+			send(ev)
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": ping\n\n")
+		case <-c.Request().Context().Done():
+			return nil
+		case <-draining:
+			// Shutting down, the client reconnects to another replica
+			return nil
+		}
+		res.Flush()
+	}
+}
+
+func initEvents(api *echo.Group) {
+	// Every replica tails the events recorded by all of them
+	hubOnce.Do(func() {
+		go hub.Run(events.MongoBroker{}, draining)
+	})
+
+	api.GET("/events", GetEvents, ticketAuth)
+	api.POST("/events/ticket", PostEventsTicket, DoJWTAuth)
+}
@@ -0,0 +1,418 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// FilterGroup holds the tasks of a group of the tasks view
+type FilterGroup struct {
+	Key   string        `json:"key"`
+	Total int           `json:"total"`
+	Tasks []*model.Task `json:"tasks"`
+}
+
+// FilterResult is the outcome of running a saved filter
+type FilterResult struct {
+	Filter *model.Filter `json:"filter"`
+	// From and To are the range the filter was run for, if any
+	From *int `json:"from,omitempty"`
+	To   *int `json:"to,omitempty"`
+
+	Tasks     []*model.Task        `json:"tasks,omitempty"`
+	Groups    []*FilterGroup       `json:"groups,omitempty"`
+	Summaries []*model.UserSummary `json:"summaries,omitempty"`
+	// Next is the cursor of the next page of tasks of the tasks view, if any
+	Next string `json:"next,omitempty"`
+
+	// Error is why the filter failed to run on a dashboard
+	Error *errors.Problem `json:"error,omitempty"`
+}
+
+// groupTasks groups tasks by day in loc, by username or by tag
+func groupTasks(tasks []*model.Task, groupBy string, usernames map[string]string, loc *time.Location) []*FilterGroup {
+	groups := map[string]*FilterGroup{}
+	add := func(key string, t *model.Task) {
+		g, ok := groups[key]
+		if !ok {
+			g = &FilterGroup{Key: key, Tasks: []*model.Task{}}
+			groups[key] = g
+		}
+		g.Tasks = append(g.Tasks, t)
+		g.Total += *t.Finish - *t.Start
+	}
+	for _, t := range tasks {
+		switch groupBy {
+		case model.GroupByDay:
+			add(time.Unix(int64(*t.Start), 0).In(loc).Format("2006-01-02"), t)
+		case model.GroupByUser:
+			add(usernames[t.UserID.Hex()], t)
+		case model.GroupByTag:
+			if len(t.Tags) == 0 {
+				add("", t)
+			}
+			for _, tag := range t.Tags {
+				add(tag, t)
+			}
+		}
+	}
+
+	result := []*FilterGroup{}
+	for _, g := range groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// runFilter runs f as user, with the permissions of user, as view. The
+//   tasks view lists the page of tasks by start described by the limit
+//   and cursor of page, which may be nil, defaultListLimit if no limit
+func runFilter(db *store.MongoStore, user *model.UserSecure, f *model.Filter, view string, page *store.ListOptions) (*FilterResult, error) {
+	result := &FilterResult{Filter: f}
+	loc, _ := model.SummaryLocation(user, f.TimeZone)
+
+	// Users who can't view all tasks only ever see their own
+	userID := ""
+	if f.UserID != nil {
+		userID = f.UserID.Hex()
+	}
+	if !allows(user.Role, model.PermissionViewAllTasks) {
+		if len(userID) > 0 && userID != user.ID.Hex() {
+			return nil, echo.ErrForbidden
+		}
+		userID = user.ID.Hex()
+	}
+
+	// Resolve the range at the time of running
+	from, to, ranged := f.Span(time.Now(), loc)
+	fromParam, toParam := "", ""
+	if ranged {
+		result.From, result.To = &from, &to
+		fromParam, toParam = strconv.Itoa(from), strconv.Itoa(to)
+	}
+	q, err := store.NewTaskQueryFromParams(userID, "", fromParam, toParam)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+
+	var sq bson.M
+	if len(f.Query) > 0 {
+		var err error
+		if sq, err = searchQuery(db, user, f.Query, f.TimeZone); err != nil {
+			return nil, err
+		}
+		q = bson.M{"$and": []bson.M{q, sq}}
+	}
+
+	// Users to show, by id
+	var users []*model.UserSecure
+	if len(userID) > 0 {
+		u, err := db.GetUserByID(userID)
+		if err != nil {
+			return nil, errors.MongoErrorResponse(err)
+		}
+		users = []*model.UserSecure{u}
+	} else {
+		var err error
+		if users, _, err = db.GetAllUsers(nil); err != nil {
+			return nil, errors.MongoErrorResponse(err)
+		}
+	}
+
+	switch view {
+	case model.ViewSummary:
+		if !ranged {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("range", "relative range or start and finish for the summary view"))
+		}
+		if err := checkSummaryRange(from, to); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		summaries, err := db.GetUserSummaries(users, from, to, f.TimeZone, sq)
+		if err != nil {
+			return nil, errors.MongoErrorResponse(err)
+		}
+		result.Summaries = summaries
+	default:
+		opts := &store.ListOptions{Query: q, Sort: "start", Limit: defaultListLimit}
+		if page != nil {
+			opts.Cursor = page.Cursor
+			if page.Limit > 0 {
+				opts.Limit = page.Limit
+			}
+		}
+		tasks, next, err := db.GetAllTasks(opts)
+		if err != nil {
+			return nil, errors.MongoErrorResponse(err)
+		}
+		if next.Next != nil {
+			result.Next = next.Next.String()
+		}
+		if len(f.GroupBy) == 0 {
+			result.Tasks = tasks
+			break
+		}
+		usernames := map[string]string{}
+		for _, u := range users {
+			usernames[u.ID.Hex()] = u.Username
+		}
+		result.Groups = groupTasks(tasks, f.GroupBy, usernames, loc)
+	}
+	return result, nil
+}
+
+// getVisibleFilter fetches the filter in the path if user can see it
+func getVisibleFilter(c echo.Context, db *store.MongoStore, user *model.UserSecure) (*model.Filter, error) {
+	filterID := c.Param("filterID")
+	if !bson.IsObjectIdHex(filterID) {
+		return nil, echo.ErrNotFound
+	}
+	f, err := db.GetFilter(filterID)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+	if !f.CanSee(user.ID) {
+		return nil, echo.ErrNotFound
+	}
+	return f, nil
+}
+
+// GetFilters retrieves the filters saved by or shared with the user
+func GetFilters(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	filters, err := db.GetFiltersForUser(user.ID, false)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusOK, filters)
+}
+
+// PostFilters saves a filter for the user
+func PostFilters(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Validate
+	f := model.Filter{}
+	if err := c.Bind(&f); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	f.OwnerID = user.ID
+	if err := f.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	if err := db.CreateFilter(&f); err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusCreated, f)
+}
+
+// GetFilter retrieves a filter saved by or shared with the user
+func GetFilter(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	f, err := getVisibleFilter(c, db, user)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, f)
+}
+
+// PatchFilter updates a filter by field
+//   only allowed to the filter's owner
+func PatchFilter(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	patch := &model.FilterPatch{}
+	if err := c.Bind(patch); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	f, err := getVisibleFilter(c, db, user)
+	if err != nil {
+		return err
+	}
+	if f.OwnerID != user.ID {
+		return echo.ErrForbidden
+	}
+
+	// Validate the patched filter as a whole
+	f = f.Patch(patch)
+	if err := f.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if err := db.UpdateFilter(f); err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusOK, f)
+}
+
+// DeleteFilter deletes a filter
+//   only allowed to the filter's owner
+func DeleteFilter(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	f, err := getVisibleFilter(c, db, user)
+	if err != nil {
+		return err
+	}
+	if f.OwnerID != user.ID {
+		return echo.ErrForbidden
+	}
+	if err := db.DeleteFilter(f.ID.Hex()); err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusOK, f)
+}
+
+// GetFilterRun runs a filter as its tasks or their daily summary
+//   with the permissions of the user running it
+func GetFilterRun(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	f, err := getVisibleFilter(c, db, user)
+	if err != nil {
+		return err
+	}
+
+	// The saved view can be overridden
+	view := c.QueryParam("view")
+	if len(view) == 0 {
+		view = f.View
+	}
+	if view != model.ViewTasks && view != model.ViewSummary {
+		return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("view", "tasks or summary"))
+	}
+
+	page, err := parseListOptions(c, nil, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	result, err := runFilter(db, user, f, view, page)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetDashboard runs every filter the user put on their dashboard
+func GetDashboard(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	filters, err := db.GetFiltersForUser(user.ID, true)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	// A filter failing, e.g. as it names a user since deleted, doesn't
+	//   fail the others
+	results := []*FilterResult{}
+	for _, f := range filters {
+		result, err := runFilter(db, user, f, f.View, nil)
+		if err != nil {
+			p := errors.ProblemOf(err)
+			if p.Status >= http.StatusInternalServerError {
+				logger.Error("dashboard filter failed", "filterID", f.ID.Hex(), "err", p.Cause)
+			}
+			result = &FilterResult{Filter: f, Error: p}
+		}
+		results = append(results, result)
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+func initFilters(api *echo.Group) {
+	api.GET("/filters", GetFilters, DoJWTAuth)
+	api.POST("/filters", PostFilters, DoJWTAuth)
+	api.GET("/filters/:filterID", GetFilter, DoJWTAuth)
+	api.PATCH("/filters/:filterID", PatchFilter, DoJWTAuth)
+	api.DELETE("/filters/:filterID", DeleteFilter, DoJWTAuth)
+	api.GET("/filters/:filterID/run", GetFilterRun, DoJWTAuth)
+	api.GET("/dashboard", GetDashboard, DoJWTAuth)
+}
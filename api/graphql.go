@@ -0,0 +1,1039 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+const (
+	// maxQueryDepth is how deeply the fields of a query may nest
+	maxQueryDepth = 10
+	// maxQueryComplexity caps the fields a query may resolve, see
+	//   checkQueryLimits
+	maxQueryComplexity = 10000
+	// maxGraphQLSize caps the body of a posted query
+	maxGraphQLSize = "1M"
+)
+
+// gqlKey keys the gqlRequest in the context of resolvers
+type gqlKey struct{}
+
+// gqlRequest is the state shared by the resolvers of a query
+type gqlRequest struct {
+	db    *store.MongoStore
+	user  *model.UserSecure
+	users *userLoader
+}
+
+// userLoader fetches the users of a query's tasks and summaries at once
+//   rather than one query per task
+type userLoader struct {
+	fetch   func(ids []bson.ObjectId) ([]*model.UserSecure, error)
+	pending map[bson.ObjectId]bool
+	loaded  map[bson.ObjectId]*model.UserSecure
+}
+
+func newUserLoader(db *store.MongoStore) *userLoader {
+	return &userLoader{
+		fetch:   db.GetUsersByIDs,
+		pending: map[bson.ObjectId]bool{},
+		loaded:  map[bson.ObjectId]*model.UserSecure{},
+	}
+}
+
+// prime queues id to be fetched along with the next load
+func (l *userLoader) prime(id bson.ObjectId) {
+	if _, ok := l.loaded[id]; !ok {
+		l.pending[id] = true
+	}
+}
+
+// add primes the loader with users already fetched
+func (l *userLoader) add(users ...*model.UserSecure) {
+	for _, u := range users {
+		l.loaded[u.ID] = u
+		delete(l.pending, u.ID)
+	}
+}
+
+// load returns the user with id, fetching every queued user if it isn't
+//   loaded yet. Users that don't exist are nil
+func (l *userLoader) load(id bson.ObjectId) (*model.UserSecure, error) {
+	if u, ok := l.loaded[id]; ok {
+		return u, nil
+	}
+	l.pending[id] = true
+
+	ids := make([]bson.ObjectId, 0, len(l.pending))
+	for pending := range l.pending {
+		ids = append(ids, pending)
+	}
+	users, err := l.fetch(ids)
+	if err != nil {
+		return nil, err
+	}
+	l.pending = map[bson.ObjectId]bool{}
+	for _, pending := range ids {
+		l.loaded[pending] = nil
+	}
+	for _, u := range users {
+		l.loaded[u.ID] = u
+	}
+	return l.loaded[id], nil
+}
+
+// gqlError carries the status of an api error to the errors of a response
+type gqlError struct {
+	status  int
+	message string
+}
+
+func (err *gqlError) Error() string {
+	return err.message
+}
+
+// Extensions implements gqlerrors.ExtendedError
+func (err *gqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"status": err.status}
+}
+
+// toGQLError converts the errors returned by the checks shared with the
+//   REST handlers, internal ones are logged and hidden like theirs
+func toGQLError(err error) error {
+	p := errors.ProblemOf(err)
+	if p.Status >= http.StatusInternalServerError {
+		logger.Error("graphql resolver failed", "err", p.Cause)
+	}
+	return &gqlError{status: p.Status, message: p.Detail}
+}
+
+// resolver adapts fn to a graphql.FieldResolveFn of the request's state
+func resolver(fn func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		r := p.Context.Value(gqlKey{}).(*gqlRequest)
+		v, err := fn(r, p)
+		if err != nil {
+			return nil, toGQLError(err)
+		}
+		return v, nil
+	}
+}
+
+// resolveID resolves a field of object ids by their hex, rather than
+//   by how bson prints them
+func resolveID(p graphql.ResolveParams) (interface{}, error) {
+	v, err := graphql.DefaultResolveFn(p)
+	switch id := v.(type) {
+	case bson.ObjectId:
+		return id.Hex(), err
+	case *bson.ObjectId:
+		if id == nil {
+			return nil, err
+		}
+		return id.Hex(), err
+	case []bson.ObjectId:
+		ids := make([]string, len(id))
+		for i := range id {
+			ids[i] = id[i].Hex()
+		}
+		return ids, err
+	}
+	return v, err
+}
+
+// objectID checks the arg named name is an object id
+func objectID(p graphql.ResolveParams, name string) (string, error) {
+	id, _ := p.Args[name].(string)
+	if !bson.IsObjectIdHex(id) {
+		return "", echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError(name, "object id"))
+	}
+	return id, nil
+}
+
+// hasArg tells whether the optional arg named name is given
+func hasArg(p graphql.ResolveParams, name string) bool {
+	v, ok := p.Args[name]
+	return ok && v != nil
+}
+
+// stringArg returns the optional string arg named name
+func stringArg(p graphql.ResolveParams, name string) string {
+	s, _ := p.Args[name].(string)
+	return s
+}
+
+// decodeInput decodes an input object into v by the json names of its
+//   fields, the same as the body of the matching REST request
+func decodeInput(in interface{}, v interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	return nil
+}
+
+// selects tells whether the field named name is selected on the value
+//   of p, directly or through fragments
+func selects(p graphql.ResolveParams, name string) bool {
+	var find func(set *ast.SelectionSet) bool
+	find = func(set *ast.SelectionSet) bool {
+		if set == nil {
+			return false
+		}
+		for _, sel := range set.Selections {
+			switch sel := sel.(type) {
+			case *ast.Field:
+				if sel.Name.Value == name {
+					return true
+				}
+			case *ast.InlineFragment:
+				if find(sel.SelectionSet) {
+					return true
+				}
+			case *ast.FragmentSpread:
+				if f, ok := p.Info.Fragments[sel.Name.Value].(*ast.FragmentDefinition); ok && find(f.SelectionSet) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, f := range p.Info.FieldASTs {
+		if find(f.SelectionSet) {
+			return true
+		}
+	}
+	return false
+}
+
+// gqlListOptions reads the limit, cursor and sort args of a listing
+//   matching q, counting it if its total is selected. Pages are of
+//   defaultListLimit items unless limited
+func gqlListOptions(p graphql.ResolveParams, q bson.M) (*store.ListOptions, error) {
+	opts := &store.ListOptions{Query: q, Sort: stringArg(p, "sort"), Limit: defaultListLimit, Count: selects(p, "total")}
+	if limit, ok := p.Args["limit"].(int); ok {
+		if limit < 1 || limit > maxListLimit {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("limit", fmt.Sprintf("int between 1 and %d", maxListLimit)))
+		}
+		opts.Limit = limit
+	}
+	if v := stringArg(p, "cursor"); len(v) > 0 {
+		cursor, err := store.ParseCursor(v)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		opts.Cursor = cursor
+	}
+	return opts, nil
+}
+
+// listPage is a page of a listing, resumed by passing next as the cursor
+func listPage(items interface{}, page *store.Page) map[string]interface{} {
+	m := map[string]interface{}{"items": items, "next": nil, "total": nil}
+	if page.Next != nil {
+		m["next"] = page.Next.String()
+	}
+	if page.Total >= 0 {
+		m["total"] = page.Total
+	}
+	return m
+}
+
+// listTasks lists the tasks of userID, or all if empty, between from and
+//   to matching the search q
+func listTasks(r *gqlRequest, p graphql.ResolveParams, userID string) (interface{}, error) {
+	from, to := "", ""
+	if v, ok := p.Args["from"].(int); ok {
+		from = fmt.Sprint(v)
+	}
+	if v, ok := p.Args["to"].(int); ok {
+		to = fmt.Sprint(v)
+	}
+	q, err := store.NewTaskQueryFromParams(userID, "", from, to)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+	if search := stringArg(p, "q"); len(search) > 0 {
+		sq, err := searchQuery(r.db, r.user, search, stringArg(p, "tz"))
+		if err != nil {
+			return nil, err
+		}
+		q = bson.M{"$and": []bson.M{q, sq}}
+	}
+
+	opts, err := gqlListOptions(p, q)
+	if err != nil {
+		return nil, err
+	}
+	tasks, page, err := r.db.GetAllTasks(opts)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+
+	// Fetch the owners of the page at once if they're selected
+	for _, t := range tasks {
+		if t.UserID != nil {
+			r.users.prime(*t.UserID)
+		}
+	}
+	return listPage(tasks, page), nil
+}
+
+// literalValue converts a literal of the JSON scalar to its JSON value
+func literalValue(v ast.Value) interface{} {
+	switch v := v.(type) {
+	case *ast.ObjectValue:
+		m := map[string]interface{}{}
+		for _, f := range v.Fields {
+			m[f.Name.Value] = literalValue(f.Value)
+		}
+		return m
+	case *ast.ListValue:
+		list := make([]interface{}, len(v.Values))
+		for i, item := range v.Values {
+			list[i] = literalValue(item)
+		}
+		return list
+	case *ast.IntValue:
+		n, _ := strconv.Atoi(v.Value)
+		return n
+	case *ast.FloatValue:
+		f, _ := strconv.ParseFloat(v.Value, 64)
+		return f
+	case *ast.StringValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.EnumValue:
+		return v.Value
+	}
+	return nil
+}
+
+// jsonScalar passes any JSON value through as is
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:         "JSON",
+	Serialize:    func(v interface{}) interface{} { return v },
+	ParseValue:   func(v interface{}) interface{} { return v },
+	ParseLiteral: literalValue,
+})
+
+// fieldArgs configures the args of a field by name
+func fieldArgs(types map[string]graphql.Input) graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{}
+	for name, t := range types {
+		args[name] = &graphql.ArgumentConfig{Type: t}
+	}
+	return args
+}
+
+// inputFields configures the fields of an input object by name
+func inputFields(types map[string]graphql.Input) graphql.InputObjectConfigFieldMap {
+	fields := graphql.InputObjectConfigFieldMap{}
+	for name, t := range types {
+		fields[name] = &graphql.InputObjectFieldConfig{Type: t}
+	}
+	return fields
+}
+
+// newGraphQLSchema builds the schema of users, tasks and summaries,
+//   resolved with the same permission checks as the REST handlers
+func newGraphQLSchema() graphql.Schema {
+	nonNull := graphql.NewNonNull
+	list := graphql.NewList
+
+	listArgs := map[string]graphql.Input{
+		"limit":  graphql.Int,
+		"cursor": graphql.String,
+		"sort":   graphql.String,
+	}
+	taskArgs := map[string]graphql.Input{
+		"from": graphql.Int,
+		"to":   graphql.Int,
+		"q":    graphql.String,
+		"tz":   graphql.String,
+	}
+	for k, v := range listArgs {
+		taskArgs[k] = v
+	}
+	pageOf := func(name string, item graphql.Output) *graphql.Object {
+		return graphql.NewObject(graphql.ObjectConfig{Name: name, Fields: graphql.Fields{
+			"items": {Type: nonNull(list(nonNull(item)))},
+			"next":  {Type: graphql.String, Description: "Cursor of the next page, null on the last"},
+			"total": {Type: graphql.Int, Description: "Number of items of every page"},
+		}})
+	}
+
+	timeRange := graphql.NewObject(graphql.ObjectConfig{Name: "TimeRange", Fields: graphql.Fields{
+		"start":  {Type: graphql.Int},
+		"finish": {Type: graphql.Int},
+	}})
+	user := graphql.NewObject(graphql.ObjectConfig{Name: "User", Fields: graphql.Fields{
+		"id":            {Type: nonNull(graphql.ID), Resolve: resolveID},
+		"username":      {Type: nonNull(graphql.String)},
+		"email":         {Type: nonNull(graphql.String)},
+		"role":          {Type: nonNull(graphql.Int)},
+		"workingHours":  {Type: jsonScalar},
+		"overlapPolicy": {Type: graphql.String},
+	}})
+	task := graphql.NewObject(graphql.ObjectConfig{Name: "Task", Fields: graphql.Fields{
+		"id":          {Type: nonNull(graphql.ID), Resolve: resolveID},
+		"userID":      {Type: nonNull(graphql.ID), Resolve: resolveID},
+		"title":       {Type: nonNull(graphql.String)},
+		"description": {Type: nonNull(graphql.String)},
+		"tags": {Type: nonNull(list(nonNull(graphql.String))), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			if tags := p.Source.(*model.Task).Tags; tags != nil {
+				return tags, nil
+			}
+			return []string{}, nil
+		}},
+		"start": {Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(*model.Task).Start, nil
+		}},
+		"finish": {Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(*model.Task).Finish, nil
+		}},
+		"revision": {Type: nonNull(graphql.Int)},
+		"overlaps": {
+			Type:        list(nonNull(graphql.ID)),
+			Description: "Tasks this one overlaps, on create or update under the warn policy",
+			Resolve:     resolveID,
+		},
+		"timeRange": {Type: nonNull(timeRange), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return &p.Source.(*model.Task).TimeRange, nil
+		}},
+	}})
+	task.AddFieldConfig("user", &graphql.Field{
+		Type:        user,
+		Description: "Owner of the task, fetched along with those of the other tasks",
+		Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+			t := p.Source.(*model.Task)
+			if t.UserID == nil {
+				return nil, nil
+			}
+			if !canViewUser(r.user, t.UserID.Hex()) {
+				return nil, echo.ErrForbidden
+			}
+			u, err := r.users.load(*t.UserID)
+			if err != nil {
+				return nil, errors.MongoErrorResponse(err)
+			}
+			return u, nil
+		}),
+	})
+	taskPage := pageOf("TaskPage", task)
+	user.AddFieldConfig("tasks", &graphql.Field{
+		Type: nonNull(taskPage),
+		Args: fieldArgs(taskArgs),
+		Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+			u := p.Source.(*model.UserSecure)
+			if !canViewTasksOf(r.user, u.ID.Hex()) {
+				return nil, echo.ErrUnauthorized
+			}
+			return listTasks(r, p, u.ID.Hex())
+		}),
+	})
+	userPage := pageOf("UserPage", user)
+
+	daySummary := graphql.NewObject(graphql.ObjectConfig{Name: "DaySummary", Fields: graphql.Fields{
+		"date":       {Type: nonNull(graphql.String)},
+		"total":      {Type: nonNull(graphql.Int)},
+		"preferred":  {Type: nonNull(graphql.Int)},
+		"compliance": {Type: graphql.String},
+		"taskIDs":    {Type: nonNull(list(nonNull(graphql.ID))), Resolve: resolveID},
+	}})
+	userSummary := graphql.NewObject(graphql.ObjectConfig{Name: "UserSummary", Fields: graphql.Fields{
+		"userID":   {Type: nonNull(graphql.ID), Resolve: resolveID},
+		"username": {Type: nonNull(graphql.String)},
+		"timeZone": {Type: nonNull(graphql.String)},
+		"days":     {Type: nonNull(list(nonNull(daySummary)))},
+		"user": {Type: user, Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+			userID := p.Source.(*model.UserSummary).UserID
+			if !canViewUser(r.user, userID.Hex()) {
+				return nil, echo.ErrForbidden
+			}
+			u, err := r.users.load(userID)
+			if err != nil {
+				return nil, errors.MongoErrorResponse(err)
+			}
+			return u, nil
+		})},
+	}})
+
+	tasksArgs := map[string]graphql.Input{"userID": graphql.ID}
+	for k, v := range taskArgs {
+		tasksArgs[k] = v
+	}
+	query := graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"me": {Type: nonNull(user), Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+			return r.user, nil
+		})},
+		"user": {
+			Type: user,
+			Args: fieldArgs(map[string]graphql.Input{"id": nonNull(graphql.ID)}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				userID := stringArg(p, "id")
+				if r.user.ID.Hex() == userID || r.user.Username == userID {
+					return r.user, nil
+				}
+				if !canViewUser(r.user, userID) {
+					return nil, echo.ErrForbidden
+				}
+				return findUser(r.db, userID)
+			}),
+		},
+		"users": {
+			Type: nonNull(userPage),
+			Args: fieldArgs(listArgs),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				if !allows(r.user.Role, model.PermissionModifyAllUsersRestricted) {
+					return nil, echo.ErrForbidden
+				}
+				opts, err := gqlListOptions(p, nil)
+				if err != nil {
+					return nil, err
+				}
+				users, page, err := r.db.GetAllUsers(opts)
+				if err != nil {
+					return nil, errors.MongoErrorResponse(err)
+				}
+				return listPage(users, page), nil
+			}),
+		},
+		"task": {
+			Type: task,
+			Args: fieldArgs(map[string]graphql.Input{"id": nonNull(graphql.ID)}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				taskID, err := objectID(p, "id")
+				if err != nil {
+					return nil, err
+				}
+				return getTaskFor(r.db, r.user, taskID, model.PermissionViewAllTasks)
+			}),
+		},
+		"tasks": {
+			Type: nonNull(taskPage),
+			Args: fieldArgs(tasksArgs),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				if hasArg(p, "userID") {
+					if _, err := objectID(p, "userID"); err != nil {
+						return nil, err
+					}
+				}
+				userID, err := taskListUserID(r.user, stringArg(p, "userID"))
+				if err != nil {
+					return nil, err
+				}
+				return listTasks(r, p, userID)
+			}),
+		},
+		"summary": {
+			Type:        nonNull(list(nonNull(userSummary))),
+			Description: "Daily summaries of userID, or of every user if omitted",
+			Args: fieldArgs(map[string]graphql.Input{
+				"userID": graphql.ID,
+				"from":   nonNull(graphql.Int),
+				"to":     nonNull(graphql.Int),
+				"tz":     graphql.String,
+			}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				from, to := p.Args["from"].(int), p.Args["to"].(int)
+				if err := checkSummaryRange(from, to); err != nil {
+					return nil, echo.NewHTTPError(http.StatusBadRequest, err)
+				}
+				tz := stringArg(p, "tz")
+				if err := validateTimeZone(tz); err != nil {
+					return nil, echo.NewHTTPError(http.StatusBadRequest, err)
+				}
+
+				var users []*model.UserSecure
+				if hasArg(p, "userID") {
+					userID, err := objectID(p, "userID")
+					if err != nil {
+						return nil, err
+					}
+					if !canViewTasksOf(r.user, userID) {
+						return nil, echo.ErrUnauthorized
+					}
+					u, err := r.db.GetUserByID(userID)
+					if err != nil {
+						return nil, errors.MongoErrorResponse(err)
+					}
+					users = []*model.UserSecure{u}
+				} else {
+					if !allows(r.user.Role, model.PermissionViewAllTasks) {
+						return nil, echo.ErrForbidden
+					}
+					var err error
+					if users, _, err = r.db.GetAllUsers(nil); err != nil {
+						return nil, errors.MongoErrorResponse(err)
+					}
+				}
+				// The user of every row is then resolved without a query
+				r.users.add(users...)
+				summaries, err := r.db.GetUserSummaries(users, from, to, tz, nil)
+				if err != nil {
+					return nil, errors.MongoErrorResponse(err)
+				}
+				return summaries, nil
+			}),
+		},
+	}})
+
+	userInput := graphql.NewInputObject(graphql.InputObjectConfig{Name: "UserInput", Fields: inputFields(map[string]graphql.Input{
+		"username":      nonNull(graphql.String),
+		"email":         nonNull(graphql.String),
+		"password":      nonNull(graphql.String),
+		"role":          graphql.Int,
+		"workingHours":  jsonScalar,
+		"overlapPolicy": graphql.String,
+	})})
+	userPatch := graphql.NewInputObject(graphql.InputObjectConfig{Name: "UserPatch", Fields: inputFields(map[string]graphql.Input{
+		"username":      graphql.String,
+		"email":         graphql.String,
+		"password":      graphql.String,
+		"oldPassword":   graphql.String,
+		"role":          graphql.Int,
+		"workingHours":  jsonScalar,
+		"overlapPolicy": graphql.String,
+	})})
+	taskInput := graphql.NewInputObject(graphql.InputObjectConfig{Name: "TaskInput", Fields: inputFields(map[string]graphql.Input{
+		"userID":      nonNull(graphql.ID),
+		"title":       nonNull(graphql.String),
+		"description": graphql.String,
+		"tags":        list(nonNull(graphql.String)),
+		"start":       nonNull(graphql.Int),
+		"finish":      nonNull(graphql.Int),
+	})})
+	taskPatch := graphql.NewInputObject(graphql.InputObjectConfig{Name: "TaskPatch", Fields: inputFields(map[string]graphql.Input{
+		"title":       graphql.String,
+		"description": graphql.String,
+		"tags":        list(nonNull(graphql.String)),
+		"start":       graphql.Int,
+		"finish":      graphql.Int,
+	})})
+
+	// The results of mutations are nullable so that a failed one leaves
+	//   those of the others in data
+	mutation := graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: graphql.Fields{
+		"createUser": {
+			Type: user,
+			Args: fieldArgs(map[string]graphql.Input{"user": nonNull(userInput)}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				u := &model.User{}
+				if err := decodeInput(p.Args["user"], u); err != nil {
+					return nil, err
+				}
+				if err := u.Validate(); err != nil {
+					return nil, echo.NewHTTPError(http.StatusBadRequest, err)
+				}
+				restrictNewUser(r.user, u)
+				if err := r.db.CreateUser(u); err != nil {
+					return nil, errors.MongoErrorResponse(err)
+				}
+				return r.db.GetUserByID(u.ID.Hex())
+			}),
+		},
+		"updateUser": {
+			Type: user,
+			Args: fieldArgs(map[string]graphql.Input{"id": nonNull(graphql.ID), "patch": nonNull(userPatch)}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				userID, err := objectID(p, "id")
+				if err != nil {
+					return nil, err
+				}
+				patch := &model.User{}
+				if err := decodeInput(p.Args["patch"], patch); err != nil {
+					return nil, err
+				}
+				if err := checkUserPatch(r.db, r.user, userID, patch); err != nil {
+					return nil, err
+				}
+				u, err := r.db.UpdateUser(userID, patch)
+				if err != nil {
+					return nil, errors.MongoErrorResponse(err)
+				}
+				return u, nil
+			}),
+		},
+		"deleteUser": {
+			Type: user,
+			Args: fieldArgs(map[string]graphql.Input{"id": nonNull(graphql.ID)}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				userID, err := objectID(p, "id")
+				if err != nil {
+					return nil, err
+				}
+				return deleteUser(r.db, r.user, userID)
+			}),
+		},
+		"createTask": {
+			Type: task,
+			Args: fieldArgs(map[string]graphql.Input{"task": nonNull(taskInput)}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				t := &model.Task{}
+				if err := decodeInput(p.Args["task"], t); err != nil {
+					return nil, err
+				}
+				if err := checkNewTask(r.user, t); err != nil {
+					return nil, err
+				}
+				if err := r.db.CreateTask(t); err != nil {
+					return nil, errors.MongoErrorResponse(err)
+				}
+				return t, nil
+			}),
+		},
+		"updateTask": {
+			Type: task,
+			Args: fieldArgs(map[string]graphql.Input{"id": nonNull(graphql.ID), "patch": nonNull(taskPatch)}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				taskID, err := objectID(p, "id")
+				if err != nil {
+					return nil, err
+				}
+				patch := &model.TaskPatch{}
+				if err := decodeInput(p.Args["patch"], patch); err != nil {
+					return nil, err
+				}
+				if err := checkTaskPatch(patch); err != nil {
+					return nil, err
+				}
+				if _, err := getTaskFor(r.db, r.user, taskID, model.PermissionModifyAllTasks); err != nil {
+					return nil, err
+				}
+				t, err := r.db.UpdateTask(taskID, patch)
+				if err != nil {
+					return nil, errors.MongoErrorResponse(err)
+				}
+				return t, nil
+			}),
+		},
+		"deleteTask": {
+			Type: task,
+			Args: fieldArgs(map[string]graphql.Input{"id": nonNull(graphql.ID)}),
+			Resolve: resolver(func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+				taskID, err := objectID(p, "id")
+				if err != nil {
+					return nil, err
+				}
+				if _, err := getTaskFor(r.db, r.user, taskID, model.PermissionModifyAllTasks); err != nil {
+					return nil, err
+				}
+				t, err := r.db.DeleteTask(taskID)
+				if err != nil {
+					return nil, errors.MongoErrorResponse(err)
+				}
+				return t, nil
+			}),
+		},
+	}})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+var gqlSchema = newGraphQLSchema()
+
+// gqlQuery is a query as posted by clients
+type gqlQuery struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// queryCost sums up the fields a query may resolve
+type queryCost struct {
+	vars      map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+}
+
+// limit returns the limit arg of the page f, defaultListLimit if not given
+func (q *queryCost) limit(f *ast.Field) int {
+	n := defaultListLimit
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			n, _ = strconv.Atoi(v.Value)
+		case *ast.Variable:
+			switch given := q.vars[v.Name.Value].(type) {
+			case float64:
+				n = int(given)
+			case int:
+				n = given
+			}
+		}
+	}
+	// Limits out of range fail, they're counted as the closest one
+	if n < 1 {
+		return 1
+	}
+	if n > maxListLimit {
+		return maxListLimit
+	}
+	return n
+}
+
+// field returns the cost of f on t and how deeply its fields nest below
+//   depth. The fields of pages are counted once per item of their limit
+func (q *queryCost) field(t *graphql.Object, f *ast.Field, depth int) (int, int) {
+	def, ok := t.Fields()[f.Name.Value]
+	if !ok {
+		// Introspection, bounded by the schema
+		return 1, depth
+	}
+	obj, ok := graphql.GetNamed(def.Type).(*graphql.Object)
+	if !ok {
+		return 1, depth
+	}
+	cost, deepest := q.selections(obj, f.SelectionSet, depth)
+	for _, arg := range def.Args {
+		if arg.Name() == "limit" {
+			cost *= q.limit(f)
+		}
+	}
+	return 1 + cost, deepest
+}
+
+// selections returns the cost of set on t and how deeply its fields nest
+//   below depth
+func (q *queryCost) selections(t *graphql.Object, set *ast.SelectionSet, depth int) (cost int, deepest int) {
+	deepest = depth
+	if set == nil {
+		return 0, deepest
+	}
+	for _, sel := range set.Selections {
+		c, d := 0, depth
+		switch sel := sel.(type) {
+		case *ast.Field:
+			c, d = q.field(t, sel, depth+1)
+		case *ast.InlineFragment:
+			c, d = q.selections(t, sel.SelectionSet, depth)
+		case *ast.FragmentSpread:
+			if f, ok := q.fragments[sel.Name.Value]; ok {
+				c, d = q.selections(t, f.SelectionSet, depth)
+			}
+		}
+		cost += c
+		if d > deepest {
+			deepest = d
+		}
+	}
+	return cost, deepest
+}
+
+// checkQueryLimits rejects the operation of doc run by req if its fields
+//   nest deeper than maxQueryDepth or it may resolve more than
+//   maxQueryComplexity of them, as every user of a page of tasks can
+//   select a page of their tasks. The document must be valid
+func checkQueryLimits(doc *ast.Document, req *gqlQuery) error {
+	q := &queryCost{vars: req.Variables, fragments: map[string]*ast.FragmentDefinition{}}
+	ops := []*ast.OperationDefinition{}
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			q.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			ops = append(ops, def)
+		}
+	}
+
+	for _, op := range ops {
+		if len(req.OperationName) > 0 && (op.Name == nil || op.Name.Value != req.OperationName) {
+			continue
+		}
+		root := gqlSchema.QueryType()
+		if op.Operation == ast.OperationTypeMutation {
+			root = gqlSchema.MutationType()
+		}
+		cost, depth := q.selections(root, op.SelectionSet, 0)
+		if depth > maxQueryDepth {
+			return &gqlError{status: http.StatusBadRequest, message: fmt.Sprintf("query nests %d fields deep, more than %d", depth, maxQueryDepth)}
+		}
+		if cost > maxQueryComplexity {
+			return &gqlError{status: http.StatusBadRequest, message: fmt.Sprintf("query may resolve %d fields, more than %d: lower the limits of its pages", cost, maxQueryComplexity)}
+		}
+	}
+	return nil
+}
+
+// executeGraphQL runs the operation of req once it's checked against the
+//   schema and the limits of queries
+func executeGraphQL(ctx context.Context, req *gqlQuery) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if result := graphql.ValidateDocument(&gqlSchema, doc, nil); !result.IsValid {
+		return &graphql.Result{Errors: result.Errors}
+	}
+	if err := checkQueryLimits(doc, req); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(&gqlerrors.Error{Message: err.Error(), OriginalError: err})}
+	}
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        gqlSchema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+}
+
+// PostGraphQL executes a GraphQL query of users, tasks and summaries
+//   posted as JSON, or given as the query, variables and operationName
+//   params of a GET. Mutations must be posted
+func PostGraphQL(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	req := &gqlQuery{}
+	if c.Request().Method == http.MethodGet {
+		req.Query = c.QueryParam("query")
+		req.OperationName = c.QueryParam("operationName")
+		if v := c.QueryParam("variables"); len(v) > 0 {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("variables", "JSON object"))
+			}
+		}
+		if doc, err := parser.Parse(parser.ParseParams{Source: req.Query}); err == nil {
+			for _, def := range doc.Definitions {
+				op, ok := def.(*ast.OperationDefinition)
+				if !ok || op.Operation == ast.OperationTypeQuery {
+					continue
+				}
+				if len(req.OperationName) == 0 || (op.Name != nil && op.Name.Value == req.OperationName) {
+					return echo.NewHTTPError(http.StatusMethodNotAllowed, "mutations must be posted")
+				}
+			}
+		}
+	} else if err := json.NewDecoder(c.Request().Body).Decode(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "body must be a JSON object of query, variables and operationName")
+	}
+	if len(req.Query) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("query", "string"))
+	}
+
+	// Get db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	r := &gqlRequest{db: db, user: user, users: newUserLoader(db)}
+	ctx := context.WithValue(c.Request().Context(), gqlKey{}, r)
+	return c.JSON(http.StatusOK, executeGraphQL(ctx, req))
+}
+
+// schemaSDL prints the types of schema in the schema definition language,
+//   sorted by name. Built in scalars and the types of introspection are
+//   left out
+func schemaSDL(schema graphql.Schema) string {
+	b := strings.Builder{}
+	b.WriteString("schema {\n")
+	b.WriteString("  query: " + schema.QueryType().Name() + "\n")
+	if m := schema.MutationType(); m != nil {
+		b.WriteString("  mutation: " + m.Name() + "\n")
+	}
+	b.WriteString("}\n")
+
+	types := schema.TypeMap()
+	names := make([]string, 0, len(types))
+	for name := range types {
+		if !strings.HasPrefix(name, "__") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch typ := types[name].(type) {
+		case *graphql.Scalar:
+			switch name {
+			case "Int", "Float", "String", "Boolean", "ID":
+				// Built in
+			default:
+				b.WriteString("\nscalar " + name + "\n")
+			}
+		case *graphql.InputObject:
+			fields := typ.Fields()
+			lines := []string{}
+			for fname, f := range fields {
+				lines = append(lines, "  "+fname+": "+f.Type.String()+"\n")
+			}
+			sort.Strings(lines)
+			b.WriteString("\ninput " + name + " {\n" + strings.Join(lines, "") + "}\n")
+		case *graphql.Object:
+			fields := typ.Fields()
+			fnames := make([]string, 0, len(fields))
+			for fname := range fields {
+				fnames = append(fnames, fname)
+			}
+			sort.Strings(fnames)
+
+			b.WriteString("\n")
+			if d := typ.Description(); len(d) > 0 {
+				b.WriteString("\"\"\"" + d + "\"\"\"\n")
+			}
+			b.WriteString("type " + name + " {\n")
+			for _, fname := range fnames {
+				f := fields[fname]
+				if len(f.Description) > 0 {
+					b.WriteString("  \"\"\"" + f.Description + "\"\"\"\n")
+				}
+				b.WriteString("  " + fname)
+				if len(f.Args) > 0 {
+					args := []string{}
+					for _, arg := range f.Args {
+						args = append(args, arg.Name()+": "+arg.Type.String())
+					}
+					sort.Strings(args)
+					b.WriteString("(" + strings.Join(args, ", ") + ")")
+				}
+				b.WriteString(": " + f.Type.String() + "\n")
+			}
+			b.WriteString("}\n")
+		}
+	}
+	return b.String()
+}
+
+// GetGraphQLSchema returns the schema in the schema definition language
+func GetGraphQLSchema(c echo.Context) error {
+	return c.String(http.StatusOK, schemaSDL(gqlSchema))
+}
+
+func initGraphQL(api *echo.Group) {
+	api.GET("/graphql", PostGraphQL, DoJWTAuth)
+	api.POST("/graphql", PostGraphQL, DoJWTAuth, middleware.BodyLimit(maxGraphQLSize))
+	api.GET("/graphql/schema", GetGraphQLSchema)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGraphQLLimits checks queries too deep or resolving too many fields
+//   are rejected before they're run, it doesn't need mongo
+func TestGraphQLLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	limits := func(query string, vars map[string]interface{}) error {
+		doc, err := parser.Parse(parser.ParseParams{Source: query})
+		assert.Nil(err)
+		return checkQueryLimits(doc, &gqlQuery{Query: query, Variables: vars})
+	}
+
+	// Pages nest twice at the default limit
+	nested := `{ me { tasks { items { user { tasks { items { title } } } } } } }`
+	err := limits(nested, nil)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "may resolve 20302 fields")
+	}
+	assert.Nil(limits(`{ me { tasks(limit: 10) { items { user { tasks { items { title } } } } } } }`, nil))
+	assert.Nil(limits(`query ($n: Int) { me { tasks(limit: $n) { items { user { tasks { items { title } } } } } } }`,
+		map[string]interface{}{"n": float64(10)}))
+
+	// Fragments are counted where they're spread
+	err = limits(`{ me { ...tasks } } fragment tasks on User { tasks { items { user { ...more } } } }
+		fragment more on User { tasks { items { title } } }`, nil)
+	assert.NotNil(err)
+
+	// Too deep, however small the pages
+	deep := `{ me { tasks(limit: 1) { items { user { tasks(limit: 1) { items { user {
+		tasks(limit: 1) { items { user { id } } } } } } } } } } }`
+	err = limits(deep, nil)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "nests 11 fields deep")
+	}
+
+	// Rejected with a status before any resolver runs
+	res := executeGraphQL(context.Background(), &gqlQuery{Query: nested})
+	assert.Nil(res.Data)
+	if assert.Equal(1, len(res.Errors)) {
+		assert.Equal(http.StatusBadRequest, res.Errors[0].Extensions["status"])
+	}
+	res = executeGraphQL(context.Background(), &gqlQuery{Query: `{ me { nope } }`})
+	assert.Equal(1, len(res.Errors))
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo"
+
+	"github.com/briansan/ManageMeServer/errors"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+const checkOK = "ok"
+
+var (
+	// draining is closed once the api is shutting down
+	draining  = make(chan struct{})
+	drainOnce sync.Once
+)
+
+// Drain fails the readiness of the api and ends its event streams, so it
+//   can be shut down without waiting on them
+func Drain() {
+	drainOnce.Do(func() {
+		close(draining)
+	})
+}
+
+// GetHealth tells the api is alive, whether or not it can serve
+func GetHealth(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": checkOK})
+}
+
+// GetReady tells whether the api can serve requests, that is it isn't
+//   shutting down, reaches mongo and its schema is the one of this build
+func GetReady(c echo.Context) error {
+	checks := map[string]string{}
+	ready := true
+	fail := func(check, reason string) {
+		checks[check] = reason
+		ready = false
+	}
+
+	select {
+	case <-draining:
+		fail("shutdown", "draining")
+	default:
+	}
+
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		fail("store", errors.ProblemOf(err).Detail)
+	} else {
+		defer db.Cleanup()
+		checks["store"] = checkOK
+
+		version, err := db.GetSchemaVersion()
+		switch {
+		case err != nil:
+			fail("schema", errors.ProblemOf(err).Detail)
+		case version < store.SchemaVersion:
+			// A newer replica may have migrated further during a rollout
+			fail("schema", fmt.Sprintf("version %d, expected at least %d", version, store.SchemaVersion))
+		default:
+			checks["schema"] = checkOK
+		}
+	}
+
+	if !ready {
+		return c.JSON(http.StatusServiceUnavailable, echo.Map{"status": "not ready", "checks": checks})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ready", "checks": checks})
+}
+
+func initHealth(e *echo.Echo) {
+	e.GET("/healthz", GetHealth)
+	e.GET("/readyz", GetReady)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	assert := assert.New(t)
+	defer func() {
+		draining = make(chan struct{})
+		drainOnce = sync.Once{}
+	}()
+
+	e := echo.New()
+	initHealth(e)
+	get := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		body := map[string]interface{}{}
+		assert.NoError(json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+
+	code, body := get("/healthz")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("ok", body["status"])
+
+	// Without a mongo session the store is unavailable
+	code, body = get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal("not ready", body["status"])
+	checks := body["checks"].(map[string]interface{})
+	assert.Contains(checks, "store")
+	assert.NotContains(checks, "shutdown")
+
+	Drain()
+	Drain()
+	code, body = get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal("draining", body["checks"].(map[string]interface{})["shutdown"])
+
+	// Liveness is kept while draining
+	code, _ = get("/healthz")
+	assert.Equal(http.StatusOK, code)
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	"github.com/briansan/ManageMeServer/importer"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// maxBulkImportSize allows for years of time entries
+const maxBulkImportSize = "50M"
+
+// bulkBody returns the uploaded file of a multipart request or else
+// the raw request body, along with its type from the file extension
+// or the content type
+func bulkBody(c echo.Context) (io.ReadCloser, string, error) {
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		return f, strings.TrimPrefix(strings.ToLower(filepath.Ext(fh.Filename)), "."), err
+	}
+	kind := "csv"
+	if strings.Contains(c.Request().Header.Get(echo.HeaderContentType), "json") {
+		kind = "json"
+	}
+	return c.Request().Body, kind, nil
+}
+
+// bulkUsers resolves the users of imported records by username or email
+// and checks the caller may import tasks for them
+type bulkUsers struct {
+	db     *store.MongoStore
+	caller *model.UserSecure
+	ids    map[string]bson.ObjectId
+}
+
+func (u *bulkUsers) resolve(name string) (bson.ObjectId, string, error) {
+	if id, ok := u.ids[name]; ok {
+		return id, "", nil
+	}
+	user, err := u.db.GetUserByUsername(name)
+	if err == store.ErrNotFound {
+		user, err = u.db.GetUserByEmail(name)
+	}
+	if err == store.ErrNotFound {
+		return "", "unknown user " + name, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if user.ID != u.caller.ID && !allows(u.caller.Role, model.PermissionModifyAllTasks) {
+		return "", "not allowed to import tasks for " + name, nil
+	}
+	u.ids[name] = user.ID
+	return user.ID, "", nil
+}
+
+// PostTasksBulk creates tasks from the time entries of a CSV or JSON export
+//   of ManageMe, Toggl or Harvest. Users are matched by username or email
+//   unless userID is given, and nothing is saved on a dry run
+func PostTasksBulk(c echo.Context) error {
+	// Type assert user from context and authorize
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+	userID := c.QueryParam("userID")
+	if len(userID) > 0 {
+		if userID != user.ID.Hex() && !allows(user.Role, model.PermissionModifyAllTasks) {
+			return echo.ErrForbidden
+		}
+		if !bson.IsObjectIdHex(userID) {
+			return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("userID", "object id"))
+		}
+	}
+
+	// Validate params
+	format := c.QueryParam("format")
+	if len(format) == 0 {
+		format = "generic"
+	}
+	if !isOneOf(format, importer.Formats) {
+		return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("format", "generic, toggl or harvest"))
+	}
+	tz := c.QueryParam("tz")
+	if err := validateTimeZone(tz); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	dryRun := c.QueryParam("dryRun") == "true"
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Times without a zone are read in tz, else the owner's time zone
+	owner := user
+	if len(userID) > 0 {
+		if owner, err = db.GetUserByID(userID); err != nil {
+			return errors.MongoErrorResponse(err)
+		}
+	}
+	loc, _ := model.SummaryLocation(owner, tz)
+
+	// Decode entries
+	body, kind, err := bulkBody(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	defer body.Close()
+	if t := c.QueryParam("type"); len(t) > 0 {
+		kind = t
+	}
+	if !isOneOf(kind, importer.Kinds) {
+		return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("type", "csv or json"))
+	}
+	records, rowErrs, err := importer.Decode(body, format, kind, loc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	result := &ImportResult{DryRun: dryRun, Errors: []*ImportError{}}
+	for _, e := range rowErrs {
+		result.Errors = append(result.Errors, &ImportError{Row: e.Row, Message: e.Message})
+	}
+
+	// Map records to tasks of their users
+	users := &bulkUsers{db: db, caller: user, ids: map[string]bson.ObjectId{"": owner.ID}}
+	tasks := []*model.Task{}
+	rows := []int{}
+	for _, rec := range records {
+		id := owner.ID
+		if len(userID) == 0 {
+			var message string
+			if id, message, err = users.resolve(rec.User); err != nil {
+				return errors.MongoErrorResponse(err)
+			}
+			if len(message) > 0 {
+				result.Errors = append(result.Errors, &ImportError{Row: rec.Row, Message: message})
+				continue
+			}
+		}
+		t := &model.Task{
+			TimeRange:   *model.NewTimeRange(int(rec.Start.Unix()), int(rec.Finish.Unix())),
+			UserID:      &id,
+			Title:       rec.Title,
+			Description: rec.Description,
+		}
+		if err := t.Validate(); err != nil {
+			result.Errors = append(result.Errors, &ImportError{Row: rec.Row, Message: err.Error()})
+			continue
+		}
+		tasks = append(tasks, t)
+		rows = append(rows, rec.Row)
+	}
+
+	// Insert in batches
+	failed, err := db.CreateTasks(tasks, dryRun)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	for i, err := range failed {
+		result.Errors = append(result.Errors, &ImportError{Row: rows[i], Message: err.Error()})
+	}
+	result.Valid = len(tasks) - len(failed)
+	if !dryRun {
+		result.Created = result.Valid
+	}
+	sort.Slice(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Row < result.Errors[j].Row
+	})
+	return c.JSON(http.StatusOK, result)
+}
+
+// isOneOf reports whether v is one of values
+func isOneOf(v string, values []string) bool {
+	for _, value := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func initImport(api *echo.Group) {
+	api.POST("/tasks/bulk", PostTasksBulk, DoJWTAuth, middleware.BodyLimit(maxBulkImportSize))
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+const (
+	maxListLimit = 1000
+	// defaultListLimit is the page size of the listings that aren't
+	//   complete without a limit, such as the pages of a GraphQL query
+	defaultListLimit = 100
+
+	headerLink       = "Link"
+	headerTotalCount = "X-Total-Count"
+)
+
+var (
+	// taskFields and userFields can be projected with the fields param
+	taskFields = []string{"id", "userID", "title", "description", "tags", "start", "finish", "revision", "icalUID"}
+	userFields = []string{"id", "username", "email", "role", "workingHours", "overlapPolicy"}
+)
+
+// parseListOptions reads the limit, cursor, sort, fields and count params
+//   of a listing matching q, fields lists the fields that may be projected
+func parseListOptions(c echo.Context, q bson.M, fields []string) (*store.ListOptions, error) {
+	opts := &store.ListOptions{
+		Query: q,
+		Sort:  c.QueryParam("sort"),
+		Count: c.QueryParam("count") == "true",
+	}
+
+	if v := c.QueryParam("limit"); len(v) > 0 {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, errors.NewValidationError("limit", fmt.Sprintf("int between 1 and %d", maxListLimit))
+		}
+		opts.Limit = limit
+	}
+
+	if v := c.QueryParam("cursor"); len(v) > 0 {
+		cursor, err := store.ParseCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.Cursor = cursor
+	}
+
+	if v := c.QueryParam("fields"); len(v) > 0 {
+		for _, f := range strings.Split(v, ",") {
+			if !isOneOf(f, fields) {
+				return nil, errors.NewValidationError("fields", "comma separated list of "+strings.Join(fields, ", "))
+			}
+			if f == "id" {
+				f = "_id"
+			}
+			opts.Fields = append(opts.Fields, f)
+		}
+	}
+	return opts, nil
+}
+
+// setListHeaders links to the first and next pages of a listing
+//   and reports the total count if it was requested
+func setListHeaders(c echo.Context, page *store.Page) {
+	link := func(cursor, rel string) string {
+		u := *c.Request().URL
+		q := u.Query()
+		q.Del("cursor")
+		if len(cursor) > 0 {
+			q.Set("cursor", cursor)
+		}
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	links := []string{link("", "first")}
+	if page.Next != nil {
+		links = append(links, link(page.Next.String(), "next"))
+	}
+	c.Response().Header().Set(headerLink, strings.Join(links, ", "))
+	if page.Total >= 0 {
+		c.Response().Header().Set(headerTotalCount, strconv.Itoa(page.Total))
+	}
+}
+
+// projectList keeps only the fields of opts in each of items, along with
+//   the id, returning items as is if every field is requested
+func projectList(items interface{}, opts *store.ListOptions) (interface{}, error) {
+	if len(opts.Fields) == 0 {
+		return items, nil
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	docs := []map[string]interface{}{}
+	if err := json.Unmarshal(b, &docs); err != nil {
+		return nil, err
+	}
+
+	keep := map[string]bool{"id": true}
+	for _, f := range opts.Fields {
+		keep[f] = true
+	}
+	for _, doc := range docs {
+		for k := range doc {
+			if !keep[k] {
+				delete(doc, k)
+			}
+		}
+	}
+	return docs, nil
+}
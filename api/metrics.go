@@ -0,0 +1,143 @@
+package api
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/briansan/ManageMeServer/config"
+)
+
+// Results of logins
+const (
+	loginSuccess = "success"
+	loginFailure = "failure"
+)
+
+// Reasons of jwt validation failures
+const (
+	jwtMalformed  = "malformed"
+	jwtAlgorithm  = "algorithm"
+	jwtSignature  = "signature"
+	jwtExpired    = "expired"
+	jwtInvalid    = "invalid"
+	jwtNoAudience = "no_audience"
+)
+
+// unmatchedRoute labels the requests of no route, to bound the series
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "manageme_http_requests_total",
+		Help: "Requests of the api by method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "manageme_http_request_duration_seconds",
+		Help: "Latency of the requests of the api by method, route and status.",
+	}, []string{"method", "route", "status"})
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "manageme_auth_logins_total",
+		Help: "Logins over http and gRPC by result.",
+	}, []string{"result"})
+	jwtFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "manageme_auth_jwt_failures_total",
+		Help: "Sessions failing validation by reason.",
+	}, []string{"reason"})
+)
+
+// jwtFailure counts the failure of a session for reason, returning err
+func jwtFailure(reason string, err error) error {
+	jwtFailures.WithLabelValues(reason).Inc()
+	return err
+}
+
+// jwtReason is the reason of the parse error of a session
+func jwtReason(err error) string {
+	verr, ok := err.(*jwt.ValidationError)
+	switch {
+	case !ok:
+		return jwtInvalid
+	case verr.Errors&jwt.ValidationErrorMalformed != 0:
+		return jwtMalformed
+	case verr.Errors&jwt.ValidationErrorUnverifiable != 0:
+		return jwtAlgorithm
+	case verr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return jwtSignature
+	case verr.Errors&jwt.ValidationErrorExpired != 0:
+		return jwtExpired
+	}
+	return jwtInvalid
+}
+
+// routeNames names requests by the route of e they matched, the path of
+//   unknown routes would make a name per request
+type routeNames struct {
+	e      *echo.Echo
+	once   sync.Once
+	routes map[string]bool
+}
+
+// of returns the route of the request of c once served
+func (r *routeNames) of(c echo.Context) string {
+	// Routes are all added by the first request
+	r.once.Do(func() {
+		r.routes = map[string]bool{caldavPrefix + "/*": true}
+		for _, route := range r.e.Routes() {
+			r.routes[route.Path] = true
+		}
+	})
+	if route := c.Path(); r.routes[route] {
+		return route
+	}
+	return unmatchedRoute
+}
+
+// metricsMiddleware counts and times the requests of e by the route they
+//   matched
+func metricsMiddleware(e *echo.Echo) echo.MiddlewareFunc {
+	names := &routeNames{e: e}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			route := names.of(c)
+			method := c.Request().Method
+			status := strconv.Itoa(c.Response().Status)
+			httpRequests.WithLabelValues(method, route, status).Inc()
+			httpSeconds.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
+
+// NewMetricsServer returns the server of the metrics configured by cfg,
+//   requiring basic auth if cfg has metrics credentials
+func NewMetricsServer(cfg *config.API) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HTTPErrorHandler = problemHandler
+	e.Use(middleware.Recover())
+	if len(cfg.MetricsAuth) > 0 {
+		parts := strings.SplitN(cfg.MetricsAuth, ":", 2)
+		e.Use(middleware.BasicAuth(func(u, p string, c echo.Context) (bool, error) {
+			ok := subtle.ConstantTimeCompare([]byte(u), []byte(parts[0])) == 1
+			ok = subtle.ConstantTimeCompare([]byte(p), []byte(parts[1])) == 1 && ok
+			return ok, nil
+		}))
+	}
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	return e
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/briansan/ManageMeServer/config"
+)
+
+// counted is the value of the counter of c by values
+func counted(c *prometheus.CounterVec, values ...string) float64 {
+	m := &dto.Metric{}
+	c.WithLabelValues(values...).Write(m)
+	return m.GetCounter().GetValue()
+}
+
+// observed is the number of observations of the histogram of h by values
+func observed(h *prometheus.HistogramVec, values ...string) uint64 {
+	m := &dto.Metric{}
+	h.WithLabelValues(values...).(prometheus.Histogram).Write(m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	e := echo.New()
+	e.HTTPErrorHandler = problemHandler
+	e.Use(metricsMiddleware(e))
+	e.GET("/api/users/:id", func(c echo.Context) error {
+		if c.Param("id") == "nobody" {
+			return echo.ErrNotFound
+		}
+		return c.String(http.StatusOK, c.Param("id"))
+	})
+
+	for _, path := range []string{"/api/users/foo", "/api/users/bar", "/api/users/nobody", "/api/foo", "/api/bar"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	assert.Equal(float64(2), counted(httpRequests, "GET", "/api/users/:id", "200"))
+	assert.Equal(float64(1), counted(httpRequests, "GET", "/api/users/:id", "404"))
+	assert.Equal(float64(2), counted(httpRequests, "GET", unmatchedRoute, "404"))
+	assert.Equal(uint64(2), observed(httpSeconds, "GET", "/api/users/:id", "200"))
+
+	// Sessions failing validation are counted by reason
+	secret = []byte("test_secret")
+	expired := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+		Audience:  "foo",
+		ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+	})
+	session, _ := expired.SignedString(secret)
+	other, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{Audience: "foo"}).SignedString([]byte("other"))
+	noAudience, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{}).SignedString(secret)
+
+	reasons := map[string]string{
+		"foo":                  jwtMalformed,
+		"Bearer foo":           jwtMalformed,
+		"Bearer " + session:    jwtExpired,
+		"Bearer " + other:      jwtSignature,
+		"Bearer " + noAudience: jwtNoAudience,
+	}
+	for auth, reason := range reasons {
+		before := counted(jwtFailures, reason)
+		_, err := AuthenticateJWT(auth)
+		assert.Error(err, auth)
+		assert.Equal(before+1, counted(jwtFailures, reason), auth)
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	assert := assert.New(t)
+	httpRequests.WithLabelValues("GET", "/api/service/ping", "200").Inc()
+
+	cfg := config.Default().API
+	rec := httptest.NewRecorder()
+	NewMetricsServer(&cfg).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), `manageme_http_requests_total{method="GET",route="/api/service/ping",status="200"}`)
+	assert.Contains(rec.Body.String(), "# TYPE manageme_store_session_copy_seconds histogram")
+
+	// Metrics credentials are required if set
+	cfg.MetricsAuth = "prometheus:scrape"
+	e := NewMetricsServer(&cfg)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.SetBasicAuth("prometheus", "wrong")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(http.StatusUnauthorized, rec.Code)
+
+	req.SetBasicAuth("prometheus", "scrape")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(http.StatusOK, rec.Code)
+}
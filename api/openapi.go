@@ -0,0 +1,489 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+)
+
+// apiOperation documents a route, keyed by its method and path in
+//   apiOperations
+type apiOperation struct {
+	Summary string
+	// Allows lists the roles allowed, * when restricted to their own
+	Allows string
+	// Auth is basic, bearer, optional (bearer) or empty if none
+	Auth   string
+	Params []apiParam
+	// Body is the request body, Response the body responded with Status
+	//   a string is sent as text/plain
+	Body     interface{}
+	Status   int
+	Response interface{}
+	Errors   []int
+}
+
+// apiParam documents a query param
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// sessionResponse is the body of GET /login
+type sessionResponse struct {
+	Session string `json:"session"`
+}
+
+// errorResponse is the problem body of http errors, see errors.Problem
+type errorResponse struct {
+	Type      string               `json:"type"`
+	Title     string               `json:"title"`
+	Status    int                  `json:"status"`
+	Detail    string               `json:"detail"`
+	Message   string               `json:"message"`
+	Code      string               `json:"code"`
+	Instance  string               `json:"instance"`
+	Errors    []*errors.FieldError `json:"errors,omitempty"`
+	RequestID string               `json:"requestID"`
+	TaskIDs   []string             `json:"taskIDs,omitempty"`
+}
+
+var (
+	listParams = []apiParam{
+		{"limit", "integer", fmt.Sprintf("Page size, between 1 and %d", maxListLimit)},
+		{"cursor", "string", "Cursor of the page, from the next link of the previous one"},
+		{"sort", "string", "Field to sort by, descending if prefixed with -"},
+		{"fields", "string", "Comma separated fields to project"},
+		{"count", "boolean", "Report the number of items of every page in X-Total-Count"},
+	}
+	rangeParams = []apiParam{
+		{"from", "integer", "Only tasks finishing after this unix timestamp"},
+		{"to", "integer", "Only tasks starting before this unix timestamp"},
+	}
+
+	// pathParams documents the params of paths by name
+	pathParams = map[string]string{
+		"userID": "Id, or username where allowed, of the user",
+		"taskID": "Id of the task",
+	}
+
+	// apiOperations documents the routes of the api
+	apiOperations = map[string]*apiOperation{
+		"GET /api/service/ping": {
+			Summary:  "Health check",
+			Status:   http.StatusOK,
+			Response: "pong",
+		},
+		"GET /api/openapi.json": {
+			Summary:  "This OpenAPI specification",
+			Status:   http.StatusOK,
+			Response: map[string]interface{}{},
+		},
+		"GET /api/login": {
+			Summary:  "Presents the user with a 1 hour JWT session",
+			Auth:     "basic",
+			Status:   http.StatusOK,
+			Response: sessionResponse{},
+			Errors:   []int{http.StatusUnauthorized},
+		},
+		"GET /api/users": {
+			Summary:  "Lists every user, as a map by id if mapped is true, sortable by username or email",
+			Allows:   "Manager, Admin",
+			Auth:     "bearer",
+			Params:   append([]apiParam{{"mapped", "boolean", "Respond with a map of users by id"}}, listParams...),
+			Status:   http.StatusOK,
+			Response: []model.UserSecure{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		},
+		"POST /api/users": {
+			Summary:  "Creates a user, signing up when anonymous. Only admins decide the role and managers the overlap policy",
+			Allows:   "Anon, Manager, Admin",
+			Auth:     "optional",
+			Body:     model.User{},
+			Status:   http.StatusCreated,
+			Response: model.UserSecure{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusConflict},
+		},
+		"GET /api/users/:userID": {
+			Summary:  "Retrieves a user by id or username",
+			Allows:   "User*, Manager, Admin",
+			Auth:     "bearer",
+			Status:   http.StatusOK,
+			Response: model.UserSecure{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		},
+		"PATCH /api/users/:userID": {
+			Summary:  "Updates the fields of a user given, oldPassword is required to change one's own password",
+			Allows:   "User*, Manager, Admin",
+			Auth:     "bearer",
+			Body:     model.User{},
+			Status:   http.StatusOK,
+			Response: model.UserSecure{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+		},
+		"DELETE /api/users/:userID": {
+			Summary:  "Deletes a user along with their tasks, filters and webhooks",
+			Allows:   "User*, Admin",
+			Auth:     "bearer",
+			Status:   http.StatusOK,
+			Response: model.UserSecure{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		},
+		"GET /api/users/:userID/tasks": {
+			Summary:  "Lists the tasks of a user, sortable by start, finish or title",
+			Allows:   "User*, Manager, Admin",
+			Auth:     "bearer",
+			Params:   append(append([]apiParam{}, rangeParams...), listParams...),
+			Status:   http.StatusOK,
+			Response: []model.Task{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized},
+		},
+		"POST /api/users/:userID/tasks": {
+			Summary:  "Creates a task of a user",
+			Allows:   "User*, Manager*, Admin",
+			Auth:     "bearer",
+			Body:     model.Task{},
+			Status:   http.StatusCreated,
+			Response: model.Task{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusConflict},
+		},
+		"GET /api/tasks": {
+			Summary: "Lists every task, or those of userID, narrowed down by the search q, sortable by start, finish or title",
+			Allows:  "User*, Manager, Admin",
+			Auth:    "bearer",
+			Params: append(append([]apiParam{
+				{"userID", "string", "Only the tasks of this user, the caller's unless allowed ViewAllTasks"},
+				{"q", "string", "Search of the task query language"},
+				{"tz", "string", "IANA time zone of the dates of q"},
+			}, rangeParams...), listParams...),
+			Status:   http.StatusOK,
+			Response: []model.Task{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		},
+		"POST /api/tasks": {
+			Summary:  "Creates a task, applying the overlap policy of its owner",
+			Allows:   "User*, Manager*, Admin",
+			Auth:     "bearer",
+			Body:     model.Task{},
+			Status:   http.StatusCreated,
+			Response: model.Task{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusConflict},
+		},
+		"GET /api/tasks/:taskID": {
+			Summary:  "Retrieves a task",
+			Allows:   "User*, Manager, Admin",
+			Auth:     "bearer",
+			Status:   http.StatusOK,
+			Response: model.Task{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusNotFound},
+		},
+		"PATCH /api/tasks/:taskID": {
+			Summary:  "Updates the fields of a task given, incrementing its revision",
+			Allows:   "User*, Manager*, Admin",
+			Auth:     "bearer",
+			Body:     model.TaskPatch{},
+			Status:   http.StatusOK,
+			Response: model.Task{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict},
+		},
+		"DELETE /api/tasks/:taskID": {
+			Summary:  "Deletes a task",
+			Allows:   "User*, Manager*, Admin",
+			Auth:     "bearer",
+			Status:   http.StatusOK,
+			Response: model.Task{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusNotFound},
+		},
+	}
+
+	// schemaNames names the components of types whose Go name differs
+	schemaNames = map[reflect.Type]string{
+		reflect.TypeOf(model.UserSecure{}): "User",
+		reflect.TypeOf(model.User{}):       "UserInput",
+		reflect.TypeOf(sessionResponse{}):  "Session",
+		reflect.TypeOf(errorResponse{}):    "Problem",
+	}
+
+	// schemaFields documents the fields of the components by json name
+	schemaFields = map[string]map[string]string{
+		"User": {
+			"id":            "Id of the user",
+			"username":      "Unique username",
+			"email":         "Unique email",
+			"role":          "Role as a bit set of permissions: 2 User, 26 Manager or 62 Admin",
+			"workingHours":  "Preferred working hours, null if unset",
+			"overlapPolicy": "Policy for overlapping tasks: allow, warn or reject, the server's default if empty",
+		},
+		"UserInput": {
+			"id":            "Ignored",
+			"username":      "Unique username",
+			"password":      "Password",
+			"oldPassword":   "Current password, required to change one's own password",
+			"email":         "Unique email",
+			"role":          "Role, only admins may set it",
+			"workingHours":  "Preferred working hours",
+			"overlapPolicy": "Policy for overlapping tasks: allow, warn or reject, only managers and admins may set it",
+		},
+		"WorkingHours": {
+			"timeZone":   "IANA time zone, such as Europe/Berlin",
+			"weekly":     "Intervals by weekday, sunday to saturday, weekdays missing have none",
+			"exceptions": "Days whose intervals differ from the weekly ones",
+		},
+		"ClockRange": {
+			"start":  "Wall clock time as HH:MM",
+			"finish": "Wall clock time as HH:MM, 24:00 for the end of the day",
+		},
+		"DayException": {
+			"date":      "Date as YYYY-MM-DD in the time zone",
+			"intervals": "Intervals of the day replacing the weekly ones, empty for a day off",
+			"note":      "Why the day differs",
+		},
+		"Task": {
+			"id":          "Id of the task, ignored on create",
+			"userID":      "Id of the owner",
+			"user":        "Username of the owner, unused",
+			"title":       "Title",
+			"description": "Description",
+			"tags":        "Single word tags",
+			"start":       "Unix timestamp",
+			"finish":      "Unix timestamp, not before start",
+			"revision":    "Read only, incremented on every update",
+			"icalUID":     "UID of the calendar event it was imported from",
+			"overlaps":    "Read only, the tasks it overlaps under the warn policy",
+		},
+		"TaskPatch": {
+			"title":       "Title",
+			"description": "Description",
+			"tags":        "Single word tags, replacing the current ones",
+			"start":       "Unix timestamp",
+			"finish":      "Unix timestamp, not before start",
+		},
+		"Session": {
+			"session": "JWT to send as Authorization: Bearer <session>",
+		},
+		"Problem": {
+			"type":      "URI of the kind of problem",
+			"title":     "Text of the status",
+			"status":    "HTTP status",
+			"detail":    "What went wrong",
+			"message":   "Same as detail",
+			"code":      "Stable name of the kind of problem, such as validation_failed or task_overlap",
+			"instance":  "Path of the request",
+			"errors":    "Every invalid field, for validation_failed",
+			"requestID": "X-Request-ID of the request",
+			"taskIDs":   "Tasks overlapped, for 409 under the reject policy",
+		},
+		"FieldError": {
+			"field":    "Path of the field",
+			"expected": "What the field is required as",
+			"message":  "What went wrong",
+		},
+	}
+)
+
+// openAPISpec builds an OpenAPI 3 document from routes, as registered
+//   in echo, and the types of the documentation in apiOperations.
+//   Routes that aren't documented are left out
+type openAPISpec struct {
+	schemas map[string]interface{}
+}
+
+var (
+	objectIDType = reflect.TypeOf(bson.ObjectId(""))
+	pathParamRe  = regexp.MustCompile(`:(\w+)`)
+)
+
+// newOpenAPISpec returns the document of the routes documented
+func newOpenAPISpec(routes []*echo.Route) map[string]interface{} {
+	s := &openAPISpec{schemas: map[string]interface{}{}}
+	s.schema(reflect.TypeOf(errorResponse{}))
+
+	paths := map[string]map[string]interface{}{}
+	for _, r := range routes {
+		op, ok := apiOperations[r.Method+" "+r.Path]
+		if !ok {
+			continue
+		}
+		path := pathParamRe.ReplaceAllString(r.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(r.Method)] = s.operation(r, op)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ManageMe",
+			"version": "1.0.0",
+			"description": "Time tracking of users and their tasks. Roles allowed are listed " +
+				"in each description, * meaning only for their own resources",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]interface{}{
+				"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+// operation documents the route r
+func (s *openAPISpec) operation(r *echo.Route, op *apiOperation) map[string]interface{} {
+	o := map[string]interface{}{"summary": op.Summary}
+	if len(op.Allows) > 0 {
+		o["description"] = "Allows: " + op.Allows
+	}
+
+	switch op.Auth {
+	case "basic":
+		o["security"] = []interface{}{map[string]interface{}{"basicAuth": []string{}}}
+	case "bearer":
+		o["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+	case "optional":
+		o["security"] = []interface{}{map[string]interface{}{}, map[string]interface{}{"bearerAuth": []string{}}}
+	}
+
+	params := []interface{}{}
+	for _, m := range pathParamRe.FindAllStringSubmatch(r.Path, -1) {
+		params = append(params, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true,
+			"description": pathParams[m[1]], "schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, p := range op.Params {
+		params = append(params, map[string]interface{}{
+			"name": p.Name, "in": "query", "description": p.Description,
+			"schema": map[string]interface{}{"type": p.Type},
+		})
+	}
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+
+	if op.Body != nil {
+		o["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  s.content(op.Body),
+		}
+	}
+	responses := map[string]interface{}{
+		fmt.Sprint(op.Status): map[string]interface{}{
+			"description": http.StatusText(op.Status),
+			"content":     s.content(op.Response),
+		},
+	}
+	for _, code := range op.Errors {
+		responses[fmt.Sprint(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content": map[string]interface{}{
+				errors.ProblemContentType: map[string]interface{}{"schema": s.schema(reflect.TypeOf(errorResponse{}))},
+			},
+		}
+	}
+	o["responses"] = responses
+	return o
+}
+
+// content describes a body of the type of v
+func (s *openAPISpec) content(v interface{}) map[string]interface{} {
+	if _, ok := v.(string); ok {
+		return map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+	}
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(v))}}
+}
+
+// schema describes t, structs as components
+func (s *openAPISpec) schema(t reflect.Type) map[string]interface{} {
+	if t == objectIDType {
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.schema(t.Elem())
+		if _, ref := schema["$ref"]; ref {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]interface{}{"type": "object"}
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		name, ok := schemaNames[t]
+		if !ok {
+			name = t.Name()
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, ok := s.schemas[name]; ok {
+			return ref
+		}
+		// Reserve the name before describing the fields of recursive types
+		s.schemas[name] = nil
+		properties := map[string]interface{}{}
+		s.properties(name, t, properties)
+		s.schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+// properties describes the json fields of t, including those of embedded
+//   structs, documented by the fields of the component name
+func (s *openAPISpec) properties(name string, t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" || (len(f.PkgPath) > 0 && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && len(tag) == 0 && f.Type.Kind() == reflect.Struct {
+			s.properties(name, f.Type, properties)
+			continue
+		}
+		if len(tag) == 0 {
+			tag = f.Name
+		}
+		schema := s.schema(f.Type)
+		if doc := schemaFields[name][tag]; len(doc) > 0 {
+			if _, ref := schema["$ref"]; ref {
+				schema = map[string]interface{}{"allOf": []interface{}{schema}}
+			}
+			schema["description"] = doc
+		}
+		properties[tag] = schema
+	}
+}
+
+// initOpenAPI serves the document of the routes registered in e so far
+func initOpenAPI(e *echo.Echo, api *echo.Group) {
+	var spec map[string]interface{}
+	api.GET("/openapi.json", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, spec)
+	})
+	spec = newOpenAPISpec(e.Routes())
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+// undocumentedFields lists the properties of the components of spec
+//   without a description as Component.field
+func undocumentedFields(spec map[string]interface{}) []string {
+	missing := []string{}
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for name, schema := range schemas {
+		for field, p := range schema.(map[string]interface{})["properties"].(map[string]interface{}) {
+			if _, ok := p.(map[string]interface{})["description"]; !ok {
+				missing = append(missing, name+"."+field)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// TestOpenAPI is the contract of the specification with the routes and
+//   schema structs, it doesn't need mongo
+func TestOpenAPI(t *testing.T) {
+	assert := assert.New(t)
+
+	e := echo.New()
+	api := e.Group("/api")
+	api.GET("/service/ping", nil)
+	initAuth(api)
+	initUsers(api)
+	initTasks(api)
+	initOpenAPI(e, api)
+
+	// Every route is documented, and every documentation is of a route
+	routes := map[string]bool{}
+	for _, r := range e.Routes() {
+		// Skip the catch-all routes of the group's middleware
+		if r.Path == "/api" || strings.HasSuffix(r.Path, "/*") {
+			continue
+		}
+		route := r.Method + " " + r.Path
+		routes[route] = true
+		_, ok := apiOperations[route]
+		assert.True(ok, "%s is routed but undocumented", route)
+	}
+	for route := range apiOperations {
+		assert.True(routes[route], "%s is documented but not routed", route)
+	}
+
+	// Served as JSON
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	spec := map[string]interface{}{}
+	assert.Nil(json.Unmarshal(rec.Body.Bytes(), &spec))
+	assert.Equal("3.0.3", spec["openapi"])
+
+	paths := spec["paths"].(map[string]interface{})
+	assert.Equal(len(apiOperations), func() int {
+		n := 0
+		for _, ops := range paths {
+			n += len(ops.(map[string]interface{}))
+		}
+		return n
+	}())
+	op := paths["/api/users/{userID}/tasks"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.Equal("#/components/schemas/Task",
+		op["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})["$ref"])
+
+	// Every field of the schema structs is documented, by its json name
+	assert.Equal([]string{}, undocumentedFields(spec))
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for name, fields := range schemaFields {
+		schema, ok := schemas[name].(map[string]interface{})
+		if !assert.True(ok, "%s is documented but not a schema", name) {
+			continue
+		}
+		for field := range fields {
+			assert.Contains(schema["properties"], field, "%s.%s is documented but not a field", name, field)
+		}
+	}
+	task := schemas["Task"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Contains(task, "userID")
+	assert.Contains(task, "start")
+	assert.False(strings.Contains(rec.Body.String(), "user_id"))
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+	"github.com/briansan/ManageMeServer/report"
+)
+
+// GetTimesheet streams the tasks matching the same filters as GetTasks
+//   as a report grouped by day in the requested format
+func GetTimesheet(c echo.Context) error {
+	// Type assert user from context and authorize
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Get userID from param and decide authorization
+	userID, err := taskListUserID(user, c.QueryParam("userID"))
+	if err != nil {
+		return err
+	}
+
+	// Validate params
+	format := c.QueryParam("format")
+	if len(format) == 0 {
+		format = "csv"
+	}
+	tz := c.QueryParam("tz")
+	if err := validateTimeZone(tz); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	loc := time.UTC
+	if len(tz) > 0 {
+		loc, _ = time.LoadLocation(tz)
+	}
+	w, err := report.NewWriter(format, c.Response())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("format", "csv, html, pdf or md"))
+	}
+
+	// Construct query
+	q, err := store.NewTaskQueryFromParams(
+		userID, "",
+		c.QueryParam("from"), c.QueryParam("to"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Get db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Resolve usernames up front, there are far fewer users than tasks
+	usernames := map[string]string{user.ID.Hex(): user.Username}
+	if allows(user.Role, model.PermissionViewAllTasks) {
+		users, _, err := db.GetAllUsers(nil)
+		if err != nil {
+			return errors.MongoErrorResponse(err)
+		}
+		for _, u := range users {
+			usernames[u.ID.Hex()] = u.Username
+		}
+	}
+
+	// Stream the report, errors past this point can only be logged
+	title := "Timesheet"
+	if from, to := c.QueryParam("from"), c.QueryParam("to"); len(from) > 0 || len(to) > 0 {
+		title = fmt.Sprintf("Timesheet %v - %v", from, to)
+	}
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, w.ContentType())
+	resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="timesheet.%s"`, format))
+	resp.WriteHeader(http.StatusOK)
+
+	iter := db.IterTasks(q)
+	next := func(t *model.Task) bool { return iter.Next(t) }
+	if err := report.Timesheet(w, title, next, usernames, loc); err != nil {
+		logger.Warn("timesheet write failed", "err", err)
+	}
+	if err := iter.Close(); err != nil {
+		logger.Warn("timesheet query failed", "err", err)
+	}
+	return nil
+}
+
+func initReports(api *echo.Group) {
+	api.GET("/reports/timesheet", GetTimesheet, DoJWTAuth)
+}
@@ -0,0 +1,600 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+	"github.com/briansan/ManageMeServer/rpc/pb"
+)
+
+// NewRPCServer returns the gRPC service of users, tasks and auth, api.New
+//   must have been called for sessions to be signed
+func NewRPCServer() *grpc.Server {
+	s := grpc.NewServer(grpc.UnaryInterceptor(rpcRecover))
+	pb.RegisterManageMeServer(s, &rpcService{})
+	return s
+}
+
+// rpcService implements pb.ManageMeServer with the same checks as the
+//   REST handlers
+type rpcService struct {
+	pb.UnimplementedManageMeServer
+}
+
+// rpcRecover turns the panics of calls into internal errors, like the
+//   Recover middleware of the api
+func rpcRecover(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("rpc panicked", "method", info.FullMethod, "err", r)
+			err = status.Error(codes.Internal, http.StatusText(http.StatusInternalServerError))
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// rpcError converts the errors returned by the checks shared with the
+//   REST handlers to statuses, internal ones are logged and hidden like theirs
+func rpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	p := errors.ProblemOf(err)
+
+	code := codes.Internal
+	switch p.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+		if p.Code == errors.CodeModified {
+			code = codes.Aborted
+		}
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	if code == codes.Internal {
+		logger.Error("rpc failed", "err", p.Cause)
+	}
+	return status.Error(code, p.Detail)
+}
+
+// rpcMetadata is the header of the metadata of the call of ctx
+func rpcMetadata(ctx context.Context) http.Header {
+	md, _ := metadata.FromIncomingContext(ctx)
+	h := http.Header{}
+	for k, v := range md {
+		h[http.CanonicalHeaderKey(k)] = v
+	}
+	return h
+}
+
+// rpcUser authenticates the caller of ctx by the session of its
+//   authorization metadata, nil if there is none and !required
+func rpcUser(ctx context.Context, db *store.MongoStore, required bool) (*model.UserSecure, error) {
+	auth := rpcMetadata(ctx).Get(echo.HeaderAuthorization)
+	if len(auth) == 0 && !required {
+		return nil, nil
+	}
+	id, err := AuthenticateJWT(auth)
+	if err != nil {
+		logger.Warn("jwt auth failed", "reason", err.Error())
+		return nil, status.Errorf(codes.Unauthenticated, "invalid session")
+	}
+	user, err := db.GetUserByID(id)
+	if err != nil || user == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid session")
+	}
+	return user, nil
+}
+
+// rpcCall opens a store and authenticates the caller of ctx for a call,
+//   the store must be cleaned up unless err isn't nil
+func rpcCall(ctx context.Context) (*store.MongoStore, *model.UserSecure, error) {
+	db, err := store.NewMongoStore()
+	if err != nil {
+		return nil, nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	user, err := rpcUser(ctx, db, true)
+	if err != nil {
+		db.Cleanup()
+		return nil, nil, err
+	}
+	return db, user, nil
+}
+
+// rpcObjectID checks id, of the field named name, is an object id
+func rpcObjectID(name, id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return status.Errorf(codes.InvalidArgument, "%v", errors.NewValidationError(name, "object id"))
+	}
+	return nil
+}
+
+// rpcListOptions reads the limit, cursor and sort of a listing matching
+//   q, a limit of 0 being the default
+func rpcListOptions(q bson.M, limit int32, cursor, sort string) (*store.ListOptions, error) {
+	opts := &store.ListOptions{Query: q, Sort: sort}
+	if limit < 0 || limit > maxListLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", errors.NewValidationError("limit", fmt.Sprintf("int between 1 and %d", maxListLimit)))
+	}
+	opts.Limit = int(limit)
+	if len(cursor) > 0 {
+		c, err := store.ParseCursor(cursor)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		opts.Cursor = c
+	}
+	return opts, nil
+}
+
+// nextCursor is the cursor of the page after page, empty on the last
+func nextCursor(page *store.Page) string {
+	if page.Next == nil {
+		return ""
+	}
+	return page.Next.String()
+}
+
+// workingHours decodes the JSON of working hours, nil if empty
+func workingHours(s string) (*model.WorkingHours, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	wh := &model.WorkingHours{}
+	if err := json.Unmarshal([]byte(s), wh); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", errors.NewValidationError("working_hours", "JSON object"))
+	}
+	return wh, nil
+}
+
+func toPBUser(u *model.UserSecure) *pb.User {
+	m := &pb.User{
+		Id:            u.ID.Hex(),
+		Username:      u.Username,
+		Email:         u.Email,
+		Role:          int32(u.Role),
+		OverlapPolicy: u.OverlapPolicy,
+	}
+	if u.WorkingHours != nil {
+		b, _ := json.Marshal(u.WorkingHours)
+		m.WorkingHours = string(b)
+	}
+	return m
+}
+
+func toPBTask(t *model.Task) *pb.Task {
+	m := &pb.Task{
+		Id:          t.ID.Hex(),
+		Title:       t.Title,
+		Description: t.Description,
+		Tags:        t.Tags,
+		Revision:    int32(t.Revision),
+	}
+	if t.UserID != nil {
+		m.UserId = t.UserID.Hex()
+	}
+	if t.Start != nil {
+		start := int64(*t.Start)
+		m.Start = &start
+	}
+	if t.Finish != nil {
+		finish := int64(*t.Finish)
+		m.Finish = &finish
+	}
+	for _, id := range t.Overlaps {
+		m.Overlaps = append(m.Overlaps, id.Hex())
+	}
+	return m
+}
+
+// fromPBTask converts a new task, checking its user id
+func fromPBTask(m *pb.Task) (*model.Task, error) {
+	t := &model.Task{Title: m.Title, Description: m.Description, Tags: m.Tags}
+	if len(m.UserId) > 0 {
+		if err := rpcObjectID("user_id", m.UserId); err != nil {
+			return nil, err
+		}
+		id := bson.ObjectIdHex(m.UserId)
+		t.UserID = &id
+	}
+	if m.Start != nil {
+		start := int(*m.Start)
+		t.Start = &start
+	}
+	if m.Finish != nil {
+		finish := int(*m.Finish)
+		t.Finish = &finish
+	}
+	return t, nil
+}
+
+// Login exchanges basic credentials for a session, like GET /login
+func (s *rpcService) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	r := &http.Request{Header: rpcMetadata(ctx)}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		logins.WithLabelValues(loginFailure).Inc()
+		return nil, status.Errorf(codes.Unauthenticated, "basic credentials are required")
+	}
+
+	db, err := store.NewMongoStore()
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	defer db.Cleanup()
+
+	user, err := db.GetUserByCreds(username, password)
+	if err != nil {
+		if err == store.ErrNotFound {
+			logins.WithLabelValues(loginFailure).Inc()
+			return nil, status.Errorf(codes.Unauthenticated, "invalid credentials")
+		}
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	logins.WithLabelValues(loginSuccess).Inc()
+	token, err := NewJWTSession(user.ID.Hex())
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &pb.LoginResponse{Session: token}, nil
+}
+
+func (s *rpcService) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	if !allows(user.Role, model.PermissionModifyAllUsersRestricted) {
+		return nil, rpcError(echo.ErrForbidden)
+	}
+	opts, err := rpcListOptions(nil, req.Limit, req.Cursor, req.Sort)
+	if err != nil {
+		return nil, err
+	}
+	users, page, err := db.GetAllUsers(opts)
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+
+	resp := &pb.ListUsersResponse{Next: nextCursor(page)}
+	for _, u := range users {
+		resp.Users = append(resp.Users, toPBUser(u))
+	}
+	return resp, nil
+}
+
+func (s *rpcService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	if user.ID.Hex() == req.Id || user.Username == req.Id {
+		return toPBUser(user), nil
+	}
+	if !canViewUser(user, req.Id) {
+		return nil, rpcError(echo.ErrForbidden)
+	}
+	u, err := findUser(db, req.Id)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return toPBUser(u), nil
+}
+
+// CreateUser signs up a user, or creates one as the caller if
+//   authenticated, like POST /users
+func (s *rpcService) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.User, error) {
+	db, err := store.NewMongoStore()
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	defer db.Cleanup()
+
+	user, err := rpcUser(ctx, db, false)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &model.User{
+		Username:      &req.Username,
+		Email:         &req.Email,
+		Password:      &req.Password,
+		OverlapPolicy: req.OverlapPolicy,
+	}
+	if req.Role != nil {
+		role := int(*req.Role)
+		u.Role = &role
+	}
+	if u.WorkingHours, err = workingHours(req.WorkingHours); err != nil {
+		return nil, err
+	}
+	if err := u.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	restrictNewUser(user, u)
+	if err := db.CreateUser(u); err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+
+	created, err := db.GetUserByID(u.ID.Hex())
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	return toPBUser(created), nil
+}
+
+func (s *rpcService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.User, error) {
+	if err := rpcObjectID("id", req.Id); err != nil {
+		return nil, err
+	}
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	patch := &model.User{
+		Username:      req.Username,
+		Email:         req.Email,
+		Password:      req.Password,
+		OldPassword:   req.OldPassword,
+		OverlapPolicy: req.OverlapPolicy,
+	}
+	if req.Role != nil {
+		role := int(*req.Role)
+		patch.Role = &role
+	}
+	if patch.WorkingHours, err = workingHours(req.WorkingHours); err != nil {
+		return nil, err
+	}
+	if err := checkUserPatch(db, user, req.Id, patch); err != nil {
+		return nil, rpcError(err)
+	}
+	u, err := db.UpdateUser(req.Id, patch)
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	return toPBUser(u), nil
+}
+
+func (s *rpcService) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.User, error) {
+	if err := rpcObjectID("id", req.Id); err != nil {
+		return nil, err
+	}
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	u, err := deleteUser(db, user, req.Id)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return toPBUser(u), nil
+}
+
+// ListTasks lists the tasks of a user, or every task if allowed, like
+//   GET /tasks
+func (s *rpcService) ListTasks(ctx context.Context, req *pb.ListTasksRequest) (*pb.ListTasksResponse, error) {
+	if len(req.UserId) > 0 {
+		if err := rpcObjectID("user_id", req.UserId); err != nil {
+			return nil, err
+		}
+	}
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	userID, err := taskListUserID(user, req.UserId)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	from, to := "", ""
+	if req.From != 0 {
+		from = fmt.Sprint(req.From)
+	}
+	if req.To != 0 {
+		to = fmt.Sprint(req.To)
+	}
+	q, err := store.NewTaskQueryFromParams(userID, "", from, to)
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	if len(req.Q) > 0 {
+		sq, err := searchQuery(db, user, req.Q, req.Tz)
+		if err != nil {
+			return nil, rpcError(err)
+		}
+		q = bson.M{"$and": []bson.M{q, sq}}
+	}
+
+	opts, err := rpcListOptions(q, req.Limit, req.Cursor, req.Sort)
+	if err != nil {
+		return nil, err
+	}
+	tasks, page, err := db.GetAllTasks(opts)
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+
+	resp := &pb.ListTasksResponse{Next: nextCursor(page)}
+	for _, t := range tasks {
+		resp.Tasks = append(resp.Tasks, toPBTask(t))
+	}
+	return resp, nil
+}
+
+func (s *rpcService) GetTask(ctx context.Context, req *pb.GetTaskRequest) (*pb.Task, error) {
+	if err := rpcObjectID("id", req.Id); err != nil {
+		return nil, err
+	}
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	t, err := getTaskFor(db, user, req.Id, model.PermissionViewAllTasks)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return toPBTask(t), nil
+}
+
+func (s *rpcService) CreateTask(ctx context.Context, req *pb.CreateTaskRequest) (*pb.Task, error) {
+	if req.Task == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", errors.NewValidationError("task", "Task"))
+	}
+	t, err := fromPBTask(req.Task)
+	if err != nil {
+		return nil, err
+	}
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	if err := checkNewTask(user, t); err != nil {
+		return nil, rpcError(err)
+	}
+	if err := db.CreateTask(t); err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	return toPBTask(t), nil
+}
+
+// CreateTasks creates the valid tasks of a batch, like POST /tasks/bulk
+//   of tasks rather than time entries
+func (s *rpcService) CreateTasks(ctx context.Context, req *pb.CreateTasksRequest) (*pb.CreateTasksResponse, error) {
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	resp := &pb.CreateTasksResponse{}
+	rowError := func(i int, err error) {
+		resp.Errors = append(resp.Errors, &pb.RowError{Index: int32(i), Message: status.Convert(rpcError(err)).Message()})
+	}
+
+	// Check each task, keeping the index of those that may be created
+	tasks := []*model.Task{}
+	rows := []int{}
+	for i, m := range req.Tasks {
+		t, err := fromPBTask(m)
+		if err == nil {
+			err = checkNewTask(user, t)
+		}
+		if err != nil {
+			rowError(i, err)
+			continue
+		}
+		tasks = append(tasks, t)
+		rows = append(rows, i)
+	}
+
+	failed, err := db.CreateTasks(tasks, req.DryRun)
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	for i, err := range failed {
+		rowError(rows[i], err)
+	}
+	resp.Valid = int32(len(tasks) - len(failed))
+	if !req.DryRun {
+		resp.Created = resp.Valid
+	}
+	sort.Slice(resp.Errors, func(i, j int) bool {
+		return resp.Errors[i].Index < resp.Errors[j].Index
+	})
+	return resp, nil
+}
+
+func (s *rpcService) UpdateTask(ctx context.Context, req *pb.UpdateTaskRequest) (*pb.Task, error) {
+	if err := rpcObjectID("id", req.Id); err != nil {
+		return nil, err
+	}
+	patch := &model.TaskPatch{Title: req.Title, Description: req.Description}
+	if req.SetTags {
+		tags := req.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		patch.Tags = &tags
+	}
+	if req.Start != nil {
+		start := int(*req.Start)
+		patch.Start = &start
+	}
+	if req.Finish != nil {
+		finish := int(*req.Finish)
+		patch.Finish = &finish
+	}
+	if err := checkTaskPatch(patch); err != nil {
+		return nil, rpcError(err)
+	}
+
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	if _, err := getTaskFor(db, user, req.Id, model.PermissionModifyAllTasks); err != nil {
+		return nil, rpcError(err)
+	}
+	t, err := db.UpdateTask(req.Id, patch)
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	return toPBTask(t), nil
+}
+
+func (s *rpcService) DeleteTask(ctx context.Context, req *pb.DeleteTaskRequest) (*pb.Task, error) {
+	if err := rpcObjectID("id", req.Id); err != nil {
+		return nil, err
+	}
+	db, user, err := rpcCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Cleanup()
+
+	if _, err := getTaskFor(db, user, req.Id, model.PermissionModifyAllTasks); err != nil {
+		return nil, rpcError(err)
+	}
+	t, err := db.DeleteTask(req.Id)
+	if err != nil {
+		return nil, rpcError(errors.MongoErrorResponse(err))
+	}
+	return toPBTask(t), nil
+}
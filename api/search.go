@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+	"github.com/briansan/ManageMeServer/search"
+)
+
+// searchError responds with the message and position of a search error
+func searchError(code int, err *search.ParseError) error {
+	return echo.NewHTTPError(code, echo.Map{
+		"message":  err.Error(),
+		"position": err.Pos,
+	})
+}
+
+// searchQuery parses the search s of user into a task query, checking
+//   user may see the tasks of the users it names. Dates are days in tz,
+//   else in user's working hours time zone
+func searchQuery(db *store.MongoStore, user *model.UserSecure, s, tz string) (bson.M, error) {
+	if err := validateTimeZone(tz); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	loc, _ := model.SummaryLocation(user, tz)
+
+	q, err := search.Parse(s, loc)
+	if err != nil {
+		return nil, searchError(http.StatusBadRequest, err.(*search.ParseError))
+	}
+
+	// Resolve usernames, only users who can view all tasks may name others
+	userIDs := map[string]bson.ObjectId{}
+	for _, t := range q.Terms {
+		u, ok := t.(*search.User)
+		if !ok {
+			continue
+		}
+		if u.Username != user.Username && !allows(user.Role, model.PermissionViewAllTasks) {
+			return nil, searchError(http.StatusForbidden, &search.ParseError{
+				Pos: u.Pos(), Message: "not allowed to search the tasks of " + u.Username,
+			})
+		}
+		named, err := db.GetUserByUsername(u.Username)
+		if err == store.ErrNotFound {
+			return nil, searchError(http.StatusBadRequest, &search.ParseError{
+				Pos: u.Pos(), Message: "unknown user " + u.Username,
+			})
+		}
+		if err != nil {
+			return nil, errors.MongoErrorResponse(err)
+		}
+		userIDs[u.Username] = named.ID
+	}
+	return store.NewTaskSearchQuery(q, userIDs), nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// maxSummaryRange bounds the number of days a summary can span
+const maxSummaryRange = 366 * 24 * 60 * 60
+
+// parseSummaryRange reads the required from and to unix timestamps
+func parseSummaryRange(c echo.Context) (int, int, error) {
+	from, err := strconv.Atoi(c.QueryParam("from"))
+	if err != nil {
+		return 0, 0, errors.NewValidationError("from", "int")
+	}
+	to, err := strconv.Atoi(c.QueryParam("to"))
+	if err != nil {
+		return 0, 0, errors.NewValidationError("to", "int")
+	}
+	return from, to, checkSummaryRange(from, to)
+}
+
+// checkSummaryRange ensures from and to span at most a year
+func checkSummaryRange(from, to int) error {
+	if from > to {
+		return errors.NewValidationError("from", "less than to")
+	}
+	if to-from > maxSummaryRange {
+		return errors.NewValidationError("to", "at most a year after from")
+	}
+	return nil
+}
+
+// validateTimeZone ensures the optional tz query param is an IANA time zone
+func validateTimeZone(tz string) error {
+	if len(tz) == 0 {
+		return nil
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return errors.NewValidationError("tz", "IANA time zone")
+	}
+	return nil
+}
+
+// GetUserSummary retrieves the daily totals of a user's tasks
+//   compared to that user's working hours
+func GetUserSummary(c echo.Context) error {
+	userID := c.Param("userID")
+
+	// Type assert user from context and authorize
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok || !canViewTasksOf(user, userID) {
+		return echo.ErrUnauthorized
+	}
+
+	// Validate params
+	from, to, err := parseSummaryRange(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	tz := c.QueryParam("tz")
+	if err := validateTimeZone(tz); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Fetch the user being summarized
+	u, err := db.GetUserByID(userID)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+
+	// Summarize
+	summaries, err := db.GetUserSummaries([]*model.UserSecure{u}, from, to, tz, nil)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusOK, summaries[0])
+}
+
+// GetSummary retrieves the daily summaries of every user
+//   available to roles with ViewAllTasks
+func GetSummary(c echo.Context) error {
+	// Type assert user from context and authorize
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+	if !allows(user.Role, model.PermissionViewAllTasks) {
+		return echo.ErrForbidden
+	}
+
+	// Validate params
+	from, to, err := parseSummaryRange(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	tz := c.QueryParam("tz")
+	if err := validateTimeZone(tz); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	// Summarize every user
+	users, _, err := db.GetAllUsers(nil)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	summaries, err := db.GetUserSummaries(users, from, to, tz, nil)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusOK, summaries)
+}
+
+func initSummary(api *echo.Group) {
+	api.GET("/summary", GetSummary, DoJWTAuth)
+	api.GET("/users/:userID/summary", GetUserSummary, DoJWTAuth)
+}
@@ -11,6 +11,71 @@ import (
 	"github.com/briansan/ManageMeServer/model/store"
 )
 
+// canViewTasksOf reports whether user may see the tasks of userID
+func canViewTasksOf(user *model.UserSecure, userID string) bool {
+	return user.ID.Hex() == userID || allows(user.Role, model.PermissionViewAllTasks)
+}
+
+// taskListUserID restricts a task list to the tasks of user unless its role
+//   has ViewAllTasks, in which case userID may be any user's or empty for all
+func taskListUserID(user *model.UserSecure, userID string) (string, error) {
+	if !allows(user.Role, model.PermissionViewAllTasks) {
+		if len(userID) == 0 {
+			return user.ID.Hex(), nil
+		} else if userID != user.ID.Hex() {
+			return "", echo.ErrForbidden
+		}
+	}
+	return userID, nil
+}
+
+// checkNewTask validates t, which only its owner or roles with
+//   ModifyAllTasks may create
+func checkNewTask(user *model.UserSecure, t *model.Task) error {
+	if err := t.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// If not admin or task is for user, 401
+	ownerIsPosting := user.ID.Hex() == t.UserID.Hex()
+	isAdmin := allows(user.Role, model.PermissionModifyAllTasks)
+	if !ownerIsPosting && !isAdmin {
+		return echo.ErrUnauthorized
+	}
+	return nil
+}
+
+// checkTaskPatch ensures p changes something valid
+func checkTaskPatch(p *model.TaskPatch) error {
+	if p.NoChange() {
+		return echo.NewHTTPError(http.StatusBadRequest, "body is empty")
+	}
+	if err := p.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	return nil
+}
+
+// getTaskFor fetches the task with taskID, which must be user's own
+//   unless its role has perm
+func getTaskFor(db *store.MongoStore, user *model.UserSecure, taskID string, perm int) (*model.Task, error) {
+	// Construct query: no permission restricts query to include userID
+	userID := ""
+	if !allows(user.Role, perm) {
+		userID = user.ID.Hex()
+	}
+	q, err := store.NewTaskQueryFromParams(userID, taskID, "", "")
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+
+	t, err := db.GetTask(q)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+	return t, nil
+}
+
 // GetTasks retrieves all tasks
 //   available to roles with ViewAllTasks
 func GetTasks(c echo.Context) error {
@@ -21,19 +86,15 @@ func GetTasks(c echo.Context) error {
 	}
 
 	// Get userID from param and decide authorization
-	userID := c.QueryParam("userID")
-	if !allows(user.Role, model.PermissionViewAllTasks) {
-		if len(userID) == 0 {
-			userID = user.ID.Hex()
-		} else if userID != user.ID.Hex() {
-			return echo.ErrForbidden
-		}
+	userID, err := taskListUserID(user, c.QueryParam("userID"))
+	if err != nil {
+		return err
 	}
 
 	// Get db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
-		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+		return errors.MongoErrorResponse(err)
 	}
 	defer db.Cleanup()
 
@@ -44,37 +105,47 @@ func GetTasks(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err)
 	}
+	if search := c.QueryParam("q"); len(search) > 0 {
+		sq, err := searchQuery(db, user, search, c.QueryParam("tz"))
+		if err != nil {
+			return err
+		}
+		q = bson.M{"$and": []bson.M{q, sq}}
+	}
+
+	opts, err := parseListOptions(c, q, taskFields)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
 
 	// Fetch tasks
-	tasks, err := db.GetAllTasks(q)
+	tasks, page, err := db.GetAllTasks(opts)
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
-	return c.JSON(http.StatusOK, tasks)
+	setListHeaders(c, page)
+	body, err := projectList(tasks, opts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, body)
 }
 
 func PostTasks(c echo.Context) error {
 	t := model.Task{}
 	c.Bind(&t)
 
-	// Validate
-	if err := t.Validate(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-	}
-
-	// If not admin or task is for user, 401
+	// Validate and authorize
 	user, ok := c.Get("user").(*model.UserSecure)
 	if !ok {
 		return echo.ErrUnauthorized
 	}
-	ownerIsPosting := user.ID.Hex() == t.UserID.Hex()
-	isAdmin := allows(user.Role, model.PermissionModifyAllTasks)
-	if !ownerIsPosting && !isAdmin {
-		return echo.ErrUnauthorized
+	if err := checkNewTask(user, &t); err != nil {
+		return err
 	}
 
 	// Get db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
@@ -98,24 +169,16 @@ func GetTaskByID(c echo.Context) error {
 	}
 
 	// Establish db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
 	defer db.Cleanup()
 
-	// Construct query: no permission to view all tasks
-	//   restricts query to include userID
-	userID := ""
-	if !allows(user.Role, model.PermissionViewAllTasks) {
-		userID = user.ID.Hex()
-	}
-	q, _ := store.NewTaskQueryFromParams(userID, taskID, "", "")
-
-	// Fetch task
-	t, err := db.GetTask(q)
+	// Fetch task: no permission to view all tasks restricts it to user's
+	t, err := getTaskFor(db, user, taskID, model.PermissionViewAllTasks)
 	if err != nil {
-		return errors.MongoErrorResponse(err)
+		return err
 	}
 
 	return c.JSON(http.StatusOK, t)
@@ -134,30 +197,22 @@ func PatchTask(c echo.Context) error {
 	taskPatch := &model.TaskPatch{}
 	c.Bind(taskPatch)
 
-	// Return if empty patch
-	if taskPatch.NoChange() {
-		return echo.NewHTTPError(http.StatusBadRequest, "body is empty")
+	// Return if empty or invalid patch
+	if err := checkTaskPatch(taskPatch); err != nil {
+		return err
 	}
 
 	// Establish db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
 	defer db.Cleanup()
 
-	// Construct query: no permission to view all tasks
-	//   restricts query to include userID
-	userID := ""
-	if !allows(user.Role, model.PermissionModifyAllTasks) {
-		userID = user.ID.Hex()
-	}
-	q, _ := store.NewTaskQueryFromParams(userID, taskID, "", "")
-
-	// Fetch task
-	t, err := db.GetTask(q)
+	// Fetch task: no permission to modify all tasks restricts it to user's
+	t, err := getTaskFor(db, user, taskID, model.PermissionModifyAllTasks)
 	if err != nil {
-		return errors.MongoErrorResponse(err)
+		return err
 	}
 
 	// Try to update task
@@ -177,24 +232,16 @@ func DeleteTask(c echo.Context) error {
 	}
 
 	// Establish db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
 	defer db.Cleanup()
 
-	// Construct query: no permission to view all tasks
-	//   restricts query to include userID
-	userID := ""
-	if !allows(user.Role, model.PermissionModifyAllTasks) {
-		userID = user.ID.Hex()
-	}
-	q, _ := store.NewTaskQueryFromParams(userID, taskID, "", "")
-
-	// Fetch task
-	t, err := db.GetTask(q)
+	// Fetch task: no permission to modify all tasks restricts it to user's
+	t, err := getTaskFor(db, user, taskID, model.PermissionModifyAllTasks)
 	if err != nil {
-		return errors.MongoErrorResponse(err)
+		return err
 	}
 
 	// Try to delete task
@@ -211,12 +258,12 @@ func GetUserTasks(c echo.Context) error {
 
 	// Type assert user from context and authorize
 	user, ok := c.Get("user").(*model.UserSecure)
-	if !ok || (user.ID.Hex() != userID && !allows(user.Role, model.PermissionViewAllTasks)) {
+	if !ok || !canViewTasksOf(user, userID) {
 		return echo.ErrUnauthorized
 	}
 
 	// Establish db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
@@ -229,13 +276,23 @@ func GetUserTasks(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err)
 	}
 
+	opts, err := parseListOptions(c, q, taskFields)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
 	// Fetch task (include userID in query if no permissions to modify all
-	tasks, err := db.GetAllTasks(q)
+	tasks, page, err := db.GetAllTasks(opts)
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
+	setListHeaders(c, page)
+	body, err := projectList(tasks, opts)
+	if err != nil {
+		return err
+	}
 
-	return c.JSON(http.StatusOK, tasks)
+	return c.JSON(http.StatusOK, body)
 }
 
 func PostUserTasks(c echo.Context) error {
@@ -245,27 +302,23 @@ func PostUserTasks(c echo.Context) error {
 	c.Bind(&t)
 
 	// set user id
+	if !bson.IsObjectIdHex(userID) {
+		return errors.MongoErrorResponse(store.ErrInvalidID)
+	}
 	uid := bson.ObjectIdHex(userID)
 	t.UserID = &uid
 
-	// Validate
-	if err := t.Validate(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-	}
-
-	// If not admin or task is for user, 401
+	// Validate and authorize
 	user, ok := c.Get("user").(*model.UserSecure)
 	if !ok {
 		return echo.ErrUnauthorized
 	}
-	ownerIsPosting := user.ID.Hex() == t.UserID.Hex()
-	isAdmin := allows(user.Role, model.PermissionModifyAllTasks)
-	if !ownerIsPosting && !isAdmin {
-		return echo.ErrUnauthorized
+	if err := checkNewTask(user, &t); err != nil {
+		return err
 	}
 
 	// Get db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
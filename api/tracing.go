@@ -0,0 +1,50 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/briansan/ManageMeServer/trace"
+)
+
+// tracing spans each request of e, continuing the trace of its
+//   traceparent header. It runs before routing so the span covers caldav,
+//   the span is named once the route is known
+func tracing(e *echo.Echo) echo.MiddlewareFunc {
+	names := &routeNames{e: e}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !trace.Enabled() {
+				return next(c)
+			}
+
+			req := c.Request()
+			ctx, span := trace.Start(trace.Extract(req.Context(), req.Header), req.Method, trace.KindServer)
+			defer span.End()
+			c.SetRequest(req.WithContext(ctx))
+
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			route := names.of(c)
+			status := c.Response().Status
+			span.SetName(fmt.Sprintf("%s %s", req.Method, route))
+			span.SetAttributes(
+				attribute.String("http.method", req.Method),
+				attribute.String("http.route", route),
+				attribute.String("http.target", req.URL.Path),
+				attribute.Int("http.status_code", status),
+				attribute.String("http.request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
+			)
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/briansan/ManageMeServer/trace"
+)
+
+// spanRecorder keeps the spans it exports past the shutdown of tracing,
+//   which would reset them
+type spanRecorder struct {
+	*tracetest.InMemoryExporter
+}
+
+func (spanRecorder) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	assert := assert.New(t)
+
+	r := spanRecorder{tracetest.NewInMemoryExporter()}
+	trace.SetExporter(r)
+	defer trace.SetExporter(nil)
+
+	e := echo.New()
+	e.HTTPErrorHandler = problemHandler
+	e.Pre(middleware.RequestID())
+	e.Pre(tracing(e))
+	e.GET("/api/users/:id", func(c echo.Context) error {
+		_, span := trace.Start(c.Request().Context(), "store.GetUserByID", trace.KindClient)
+		span.End()
+		return echo.NewHTTPError(http.StatusServiceUnavailable)
+	})
+
+	// The trace of the client is continued
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest("GET", "/api/users/foo", nil)
+	req.Header.Set(trace.HeaderTraceparent, parent)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(http.StatusServiceUnavailable, rec.Code)
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/foo", nil))
+	trace.Shutdown()
+
+	spans := r.GetSpans()
+	if !assert.Len(spans, 3) {
+		return
+	}
+	store, server, unmatched := spans[0], spans[1], spans[2]
+	assert.Equal("store.GetUserByID", store.Name)
+	assert.Equal(server.SpanContext.SpanID(), store.Parent.SpanID())
+	assert.Equal(server.SpanContext.TraceID(), store.SpanContext.TraceID())
+
+	assert.Equal("GET /api/users/:id", server.Name)
+	assert.Equal(trace.KindServer, server.SpanKind)
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal("00f067aa0ba902b7", server.Parent.SpanID().String())
+	attrs := attributes(server)
+	assert.Equal(int64(http.StatusServiceUnavailable), attrs["http.status_code"])
+	assert.Equal("/api/users/foo", attrs["http.target"])
+	assert.Equal(rec.Header().Get(echo.HeaderXRequestID), attrs["http.request_id"])
+	assert.Equal(codes.Error, server.Status.Code)
+	assert.Equal("Service Unavailable", server.Status.Description)
+
+	assert.Equal("GET unmatched", unmatched.Name)
+	assert.Equal(int64(http.StatusNotFound), attributes(unmatched)["http.status_code"])
+	assert.Equal(codes.Unset, unmatched.Status.Code)
+}
+
+// attributes are the values of the attributes of span by key
+func attributes(span tracetest.SpanStub) map[string]interface{} {
+	attrs := map[string]interface{}{}
+	for _, kv := range span.Attributes {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	return attrs
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/labstack/echo"
 	"github.com/mgutz/logxi/v1"
+	"gopkg.in/mgo.v2/bson"
 
 	"github.com/briansan/ManageMeServer/errors"
 	model "github.com/briansan/ManageMeServer/model/schema"
@@ -16,6 +17,122 @@ var (
 	allows = model.RoleHasPermission
 )
 
+// canViewUser reports whether user may see the user with id or username
+//   userID, its own or any with ModifyAllUsersRestricted
+func canViewUser(user *model.UserSecure, userID string) bool {
+	return user.ID.Hex() == userID || user.Username == userID || allows(user.Role, model.PermissionModifyAllUsersRestricted)
+}
+
+// findUser fetches the user with username or else id userID
+func findUser(db *store.MongoStore, userID string) (*model.UserSecure, error) {
+	// Try to fetch by username
+	u, err := db.GetUserByUsername(userID)
+	if err != nil && err != store.ErrNotFound {
+		return nil, errors.MongoErrorResponse(err)
+	}
+
+	// Return if found
+	if u != nil {
+		return u, nil
+	}
+	if !bson.IsObjectIdHex(userID) {
+		return nil, errors.MongoErrorResponse(err)
+	}
+
+	// Try to fetch by ID
+	u, err = db.GetUserByID(userID)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+	return u, nil
+}
+
+// restrictNewUser limits what creator, nil if signing up, decides of u
+func restrictNewUser(creator *model.UserSecure, u *model.User) {
+	// If not admin, default role to user
+	if creator == nil || !allows(creator.Role, model.PermissionModifyAllUsers) {
+		u.Role = &model.RoleUser
+	}
+
+	// Only managers and admins may decide the overlap policy
+	if creator == nil || !allows(creator.Role, model.PermissionModifyAllUsersRestricted) {
+		u.OverlapPolicy = nil
+	}
+}
+
+// checkUserPatch authorizes user to apply userPatch to userID, checking
+//   the old password of users changing their own
+func checkUserPatch(db *store.MongoStore, user *model.UserSecure, userID string, userPatch *model.User) error {
+	// Only allow roles who have permission to ModifyAllUsersRestricted
+	if user.ID.Hex() != userID && user.Username != userID && !allows(user.Role, model.PermissionModifyAllUsersRestricted) {
+		return echo.ErrForbidden
+	}
+
+	// Validate working hours if specified
+	if userPatch.WorkingHours != nil {
+		if err := userPatch.WorkingHours.Validate(); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+	}
+
+	// Only allow ModifyAllUsers permission to touch the Role field
+	if userPatch.Role != nil && !allows(user.Role, model.PermissionModifyAllUsers) {
+		return echo.ErrForbidden
+	}
+
+	// Only allow ModifyAllUsersRestricted permission to touch the OverlapPolicy field
+	if userPatch.OverlapPolicy != nil {
+		if !allows(user.Role, model.PermissionModifyAllUsersRestricted) {
+			return echo.ErrForbidden
+		}
+		if !model.ValidOverlapPolicy(*userPatch.OverlapPolicy) {
+			return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("overlapPolicy", "allow, warn or reject"))
+		}
+	}
+
+	// Authenticate user if password is being touched and isn't an admin
+	if userPatch.Password != nil && !allows(user.Role, model.PermissionModifyAllUsers) {
+		if userPatch.OldPassword == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("oldPassword", "string"))
+		}
+		if u, _ := db.GetUserByCreds(user.Username, *userPatch.OldPassword); u == nil {
+			return echo.ErrUnauthorized
+		}
+	}
+	return nil
+}
+
+// deleteUser removes userID along with everything of theirs, only users
+//   themselves or roles with ModifyAllUsers may
+func deleteUser(db *store.MongoStore, user *model.UserSecure, userID string) (*model.UserSecure, error) {
+	// Only allow roles who have permission to ModifyAllUsers
+	if user.ID.Hex() != userID && !allows(user.Role, model.PermissionModifyAllUsers) {
+		return nil, echo.ErrForbidden
+	}
+
+	// Try to delete user
+	u, err := db.DeleteUser(userID)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+
+	// Also delete that user's tasks
+	if err = db.DeleteTasksForUser(userID); err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+
+	// And the filters they saved
+	if err = db.DeleteFiltersForUser(userID); err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+
+	// And the webhooks they subscribed or that were about them
+	if err = db.DeleteWebhooksForUser(userID); err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+	return u, nil
+}
+
 // GetUsers retrieves all users
 //   available to roles with ModifyAllUsersRestricted permission
 func GetUsers(c echo.Context) error {
@@ -29,27 +146,43 @@ func GetUsers(c echo.Context) error {
 	}
 
 	// Get db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
-		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+		return errors.MongoErrorResponse(err)
 	}
 	defer db.Cleanup()
 
+	opts, err := parseListOptions(c, nil, userFields)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
 	// Try to get users
-	users, err := db.GetAllUsers()
+	users, page, err := db.GetAllUsers(opts)
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
+	setListHeaders(c, page)
+	body, err := projectList(users, opts)
+	if err != nil {
+		return err
+	}
 
 	//
 	if c.QueryParam("mapped") == "true" {
-		m := map[string]*model.UserSecure{}
-		for _, u := range users {
-			m[u.ID.Hex()] = u
+		m := map[string]interface{}{}
+		if docs, ok := body.([]map[string]interface{}); ok {
+			for _, u := range docs {
+				m[u["id"].(string)] = u
+			}
+		} else {
+			for _, u := range users {
+				m[u.ID.Hex()] = u
+			}
 		}
 		return c.JSON(http.StatusOK, m)
 	}
-	return c.JSON(http.StatusOK, users)
+	return c.JSON(http.StatusOK, body)
 }
 
 func PostUsers(c echo.Context) error {
@@ -58,11 +191,11 @@ func PostUsers(c echo.Context) error {
 
 	// Validate
 	if err := u.Validate(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, err)
 	}
 
 	// Get user from db
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
@@ -89,10 +222,8 @@ func PostUsers(c echo.Context) error {
 		}
 	}
 
-	// If not admin, default role to user
-	if user == nil || !allows(user.Role, model.PermissionModifyAllUsers) {
-		u.Role = &model.RoleUser
-	}
+	// Only admins decide the role and managers the overlap policy
+	restrictNewUser(user, &u)
 
 	// Try to add user
 	if err = db.CreateUser(&u); err != nil {
@@ -112,32 +243,21 @@ func GetUserByUserID(c echo.Context) error {
 	}
 
 	// Don't go any further if user role doesn't have permission to view other users
-	if !ok || !allows(user.Role, model.PermissionModifyAllUsersRestricted) {
+	if !ok || !canViewUser(user, userID) {
 		return echo.ErrForbidden
 	}
 
 	// Establish db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
 	defer db.Cleanup()
 
-	// Try to fetch by username
-	u, err := db.GetUserByUsername(userID)
-	if err != nil && err.Error() != "not found" {
-		return errors.MongoErrorResponse(err)
-	}
-
-	// Return if found
-	if u != nil {
-		return c.JSON(http.StatusOK, u)
-	}
-
-	// Try to fetch by ID
-	u, err = db.GetUserByID(userID)
+	// Try to fetch by username, then ID
+	u, err := findUser(db, userID)
 	if err != nil {
-		return errors.MongoErrorResponse(err)
+		return err
 	}
 
 	return c.JSON(http.StatusOK, u)
@@ -152,42 +272,20 @@ func PatchUser(c echo.Context) error {
 		return echo.ErrUnauthorized
 	}
 
-	// Only allow roles who have permission to ModifyAllUsersRestricted
-	if user.ID.Hex() != userID && user.Username != userID && !allows(user.Role, model.PermissionModifyAllUsersRestricted) {
-		return echo.ErrForbidden
-	}
-
 	// Get user patch doc
 	userPatch := &model.User{}
 	c.Bind(userPatch)
 
-	// Validate preferred hours if specified
-	if userPatch.PreferredHours != nil {
-		if err := userPatch.PreferredHours.Validate(); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
-	}
-
-	// Only allow ModifyAllUsers permission to touch the Role field
-	if userPatch.Role != nil && !allows(user.Role, model.PermissionModifyAllUsers) {
-		return echo.ErrForbidden
-	}
-
 	// Establish db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
 	defer db.Cleanup()
 
-	// Authenticate user if password is being touched and isn't an admin
-	if userPatch.Password != nil && !allows(user.Role, model.PermissionModifyAllUsers) {
-		if userPatch.OldPassword == nil {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("oldPassword", "string"))
-		}
-		if user, err = db.GetUserByCreds(user.Username, *userPatch.OldPassword); user == nil {
-			return echo.ErrUnauthorized
-		}
+	// Authorize the patch
+	if err := checkUserPatch(db, user, userID, userPatch); err != nil {
+		return err
 	}
 
 	// Try to update user
@@ -206,27 +304,17 @@ func DeleteUser(c echo.Context) error {
 		return echo.ErrUnauthorized
 	}
 
-	// Only allow roles who have permission to ModifyAllUsers
-	if user.ID.Hex() != userID && !allows(user.Role, model.PermissionModifyAllUsers) {
-		return echo.ErrForbidden
-	}
-
 	// Establish db connection
-	db, err := store.NewMongoStore()
+	db, err := store.NewMongoStoreContext(c.Request().Context())
 	if err != nil {
 		return errors.MongoErrorResponse(err)
 	}
 	defer db.Cleanup()
 
-	// Try to delete user
-	u, err := db.DeleteUser(userID)
+	// Try to delete user and everything of theirs
+	u, err := deleteUser(db, user, userID)
 	if err != nil {
-		return errors.MongoErrorResponse(err)
-	}
-
-	// Also delete that user's tasks
-	if err = db.DeleteTasksForUser(userID); err != nil {
-		return errors.MongoErrorResponse(err)
+		return err
 	}
 
 	return c.JSON(http.StatusOK, u)
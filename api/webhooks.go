@@ -0,0 +1,292 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	model "github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// deliveryFields can be projected with the fields param
+var deliveryFields = []string{"id", "webhookID", "event", "payload", "status", "nextAttempt", "attempts", "createdAt"}
+
+// newWebhookSecret returns a random key to sign deliveries with
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// getOwnWebhook fetches the webhook in the path if user owns it
+func getOwnWebhook(c echo.Context, db *store.MongoStore, user *model.UserSecure) (*model.Webhook, error) {
+	webhookID := c.Param("webhookID")
+	if !bson.IsObjectIdHex(webhookID) {
+		return nil, echo.ErrNotFound
+	}
+	w, err := db.GetWebhook(webhookID)
+	if err != nil {
+		return nil, errors.MongoErrorResponse(err)
+	}
+	if w.OwnerID != user.ID {
+		return nil, echo.ErrNotFound
+	}
+	return w, nil
+}
+
+// GetWebhooks retrieves the webhooks of the user
+func GetWebhooks(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	webhooks, err := db.GetWebhooksForUser(user.ID)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	for _, w := range webhooks {
+		w.Secret = ""
+	}
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// PostWebhooks subscribes a url to events
+//   about the user's own tasks by default, another user's tasks require
+//   ViewAllTasks and every user's events ModifyAllUsers
+func PostWebhooks(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Validate
+	w := model.Webhook{Active: true}
+	if err := c.Bind(&w); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	w.OwnerID = user.ID
+	if w.UserID == nil && !allows(user.Role, model.PermissionModifyAllUsers) {
+		w.UserID = &user.ID
+	}
+	if w.UserID != nil && *w.UserID != user.ID && !allows(user.Role, model.PermissionViewAllTasks) {
+		return echo.ErrForbidden
+	}
+	if err := w.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	if w.UserID != nil {
+		if _, err := db.GetUserByID(w.UserID.Hex()); err != nil {
+			return errors.MongoErrorResponse(err)
+		}
+	}
+
+	// The secret is only ever shown in this response
+	if w.Secret, err = newWebhookSecret(); err != nil {
+		return err
+	}
+	if err := db.CreateWebhook(&w); err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusCreated, w)
+}
+
+// GetWebhook retrieves a webhook of the user
+func GetWebhook(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	w, err := getOwnWebhook(c, db, user)
+	if err != nil {
+		return err
+	}
+	w.Secret = ""
+	return c.JSON(http.StatusOK, w)
+}
+
+// PatchWebhook updates a webhook of the user by field
+func PatchWebhook(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	patch := &model.WebhookPatch{}
+	if err := c.Bind(patch); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	w, err := getOwnWebhook(c, db, user)
+	if err != nil {
+		return err
+	}
+
+	// Validate the patched webhook as a whole
+	w = w.Patch(patch)
+	if err := w.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if err := db.UpdateWebhook(w); err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	w.Secret = ""
+	return c.JSON(http.StatusOK, w)
+}
+
+// DeleteWebhook deletes a webhook of the user along with its delivery log
+func DeleteWebhook(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	w, err := getOwnWebhook(c, db, user)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteWebhook(w.ID.Hex()); err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	w.Secret = ""
+	return c.JSON(http.StatusOK, w)
+}
+
+// GetWebhookDeliveries retrieves the delivery log of a webhook of the user
+func GetWebhookDeliveries(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Narrow down by status
+	var q bson.M
+	if status := c.QueryParam("status"); len(status) > 0 {
+		if !isOneOf(status, []string{model.DeliveryPending, model.DeliveryDelivered, model.DeliveryFailed}) {
+			return echo.NewHTTPError(http.StatusBadRequest, errors.NewValidationError("status", "pending, delivered or failed"))
+		}
+		q = bson.M{"status": status}
+	}
+	opts, err := parseListOptions(c, q, deliveryFields)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	w, err := getOwnWebhook(c, db, user)
+	if err != nil {
+		return err
+	}
+	deliveries, page, err := db.GetDeliveries(w.ID.Hex(), opts)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	setListHeaders(c, page)
+	result, err := projectList(deliveries, opts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// PostWebhookRedeliver queues a delivery of a webhook of the user again
+func PostWebhookRedeliver(c echo.Context) error {
+	// Type assert user from context
+	user, ok := c.Get("user").(*model.UserSecure)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	// Establish db connection
+	db, err := store.NewMongoStoreContext(c.Request().Context())
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	defer db.Cleanup()
+
+	w, err := getOwnWebhook(c, db, user)
+	if err != nil {
+		return err
+	}
+	deliveryID := c.Param("deliveryID")
+	if !bson.IsObjectIdHex(deliveryID) {
+		return echo.ErrNotFound
+	}
+	d, err := db.GetDelivery(w.ID.Hex(), deliveryID)
+	if err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	if err := db.Redeliver(d.ID); err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	if d, err = db.GetDelivery(w.ID.Hex(), deliveryID); err != nil {
+		return errors.MongoErrorResponse(err)
+	}
+	return c.JSON(http.StatusAccepted, d)
+}
+
+func initWebhooks(api *echo.Group) {
+	api.GET("/webhooks", GetWebhooks, DoJWTAuth)
+	api.POST("/webhooks", PostWebhooks, DoJWTAuth)
+	api.GET("/webhooks/:webhookID", GetWebhook, DoJWTAuth)
+	api.PATCH("/webhooks/:webhookID", PatchWebhook, DoJWTAuth)
+	api.DELETE("/webhooks/:webhookID", DeleteWebhook, DoJWTAuth)
+	api.GET("/webhooks/:webhookID/deliveries", GetWebhookDeliveries, DoJWTAuth)
+	api.POST("/webhooks/:webhookID/deliveries/:deliveryID/redeliver", PostWebhookRedeliver, DoJWTAuth)
+}
@@ -0,0 +1,283 @@
+// Package backup writes and reads archives of the collections of ManageMe,
+// portable to another database: a zip, served as application/zip, of a
+// manifest.json and of the documents of each collection as gzipped lines
+// of MongoDB extended JSON. Zip rather than tar lets a restore check the
+// manifest, written last, before reading the collections
+package backup
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// Format is the version of the layout of archives
+	Format = 1
+	// ContentType is the media type of archives
+	ContentType = "application/zip"
+
+	manifestName = "manifest.json"
+	// maxLine bounds a document, mongo's own limit is 16MB
+	maxLine = 32 << 20
+)
+
+var (
+	// ErrCorrupt is wrapped by the errors of archives that aren't intact
+	ErrCorrupt = goerrors.New("corrupt archive")
+	// ErrIncompatible is wrapped by the errors of intact archives that
+	//   can't be restored in a database
+	ErrIncompatible = goerrors.New("incompatible archive")
+)
+
+// Collection describes the documents of a collection in an archive
+type Collection struct {
+	Name string `json:"name"`
+	// File is the gzipped JSON lines of the documents
+	File  string `json:"file"`
+	Count int    `json:"count"`
+	// SHA256 is the hex digest of the JSON lines before compression
+	SHA256 string `json:"sha256"`
+	// Orphans reference a document of another collection the archive
+	//   lacks, deleted before or while the collections were read
+	Orphans int `json:"orphans"`
+}
+
+// Manifest describes an archive
+type Manifest struct {
+	Format int `json:"format"`
+	// SchemaVersion is that of the database backed up
+	SchemaVersion int `json:"schemaVersion"`
+	// CreatedAt is the unix time the backup started
+	CreatedAt   int           `json:"createdAt"`
+	Collections []*Collection `json:"collections"`
+}
+
+// Collection returns the collection called name, nil if not archived
+func (m *Manifest) Collection(name string) *Collection {
+	for _, c := range m.Collections {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Writer writes an archive, one collection after the other
+type Writer struct {
+	zw       *zip.Writer
+	manifest *Manifest
+}
+
+// NewWriter starts an archive of a database of schemaVersion on w, which
+//   needn't be seekable
+func NewWriter(w io.Writer, schemaVersion int) *Writer {
+	return &Writer{
+		zw: zip.NewWriter(w),
+		manifest: &Manifest{
+			Format:        Format,
+			SchemaVersion: schemaVersion,
+			CreatedAt:     int(time.Now().Unix()),
+			Collections:   []*Collection{},
+		},
+	}
+}
+
+// Collection writes the documents of the collection name, decoded by next
+//   until it returns false, as an mgo iterator does. It returns the entry
+//   of the manifest, to be completed until the archive is closed
+func (w *Writer) Collection(name string, next func(doc interface{}) bool) (*Collection, error) {
+	c := &Collection{Name: name, File: name + ".jsonl.gz"}
+	f, err := w.zw.CreateHeader(&zip.FileHeader{Name: c.File, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	sum := sha256.New()
+	out := io.MultiWriter(gz, sum)
+
+	// Documents are decoded in fresh maps, mgo would keep the former keys
+	var doc bson.M
+	for next(&doc) {
+		line, err := bson.MarshalJSON(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if _, err := out.Write(line); err != nil {
+			return nil, err
+		}
+		c.Count++
+		doc = nil
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	c.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	w.manifest.Collections = append(w.manifest.Collections, c)
+	return c, nil
+}
+
+// Close writes the manifest, last as it knows the collections, and
+//   returns it
+func (w *Writer) Close() (*Manifest, error) {
+	f, err := w.zw.Create(manifestName)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(w.manifest); err != nil {
+		return nil, err
+	}
+	return w.manifest, w.zw.Close()
+}
+
+// Archive is an archive being read
+type Archive struct {
+	Manifest *Manifest
+	files    map[string]*zip.File
+}
+
+// corrupt returns an ErrCorrupt detailed by format
+func corrupt(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, args...))
+}
+
+// Open reads the manifest of the archive r of size bytes, checking it
+//   lists files of the archive. Documents are checked as they are read
+func Open(r io.ReaderAt, size int64) (*Archive, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, corrupt("%v", err)
+	}
+	a := &Archive{files: map[string]*zip.File{}}
+	for _, f := range zr.File {
+		a.files[f.Name] = f
+	}
+
+	f, ok := a.files[manifestName]
+	if !ok {
+		return nil, corrupt("missing %s", manifestName)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, corrupt("%s: %v", manifestName, err)
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(&a.Manifest); err != nil {
+		return nil, corrupt("%s: %v", manifestName, err)
+	}
+	if a.Manifest.Format != Format {
+		return nil, corrupt("format %d, expected %d", a.Manifest.Format, Format)
+	}
+	seen := map[string]bool{}
+	for _, c := range a.Manifest.Collections {
+		if seen[c.Name] {
+			return nil, corrupt("%s is listed twice", c.Name)
+		}
+		seen[c.Name] = true
+		if _, ok := a.files[c.File]; !ok {
+			return nil, corrupt("missing %s of %s", c.File, c.Name)
+		}
+	}
+	return a, nil
+}
+
+// Each calls fn with the documents of the collection name in order. The
+//   count and digest of the manifest are checked once all are read, so fn
+//   must not keep the documents of a failed read
+func (a *Archive) Each(name string, fn func(doc bson.M) error) error {
+	c := a.Manifest.Collection(name)
+	if c == nil {
+		return nil
+	}
+	rc, err := a.files[c.File].Open()
+	if err != nil {
+		return corrupt("%s: %v", c.File, err)
+	}
+	defer rc.Close()
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return corrupt("%s: %v", c.File, err)
+	}
+	defer gz.Close()
+
+	sum := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(gz, sum))
+	scanner.Buffer(nil, maxLine)
+	n := 0
+	for scanner.Scan() {
+		n++
+		doc, err := decode(scanner.Bytes())
+		if err != nil {
+			return corrupt("%s:%d: %v", c.File, n, err)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return corrupt("%s: %v", c.File, err)
+	}
+	return check(c, n, sum)
+}
+
+// check compares the count and digest of the documents read with c
+func check(c *Collection, n int, sum hash.Hash) error {
+	if n != c.Count {
+		return corrupt("%s has %d documents, expected %d", c.Name, n, c.Count)
+	}
+	if digest := hex.EncodeToString(sum.Sum(nil)); digest != c.SHA256 {
+		return corrupt("%s has digest %s, expected %s", c.Name, digest, c.SHA256)
+	}
+	return nil
+}
+
+// decode reads a document of a line, which must have an _id
+func decode(line []byte) (bson.M, error) {
+	doc := bson.M{}
+	if err := bson.UnmarshalJSON(line, &doc); err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		return nil, fmt.Errorf("missing _id")
+	}
+	return normalize(doc).(bson.M), nil
+}
+
+// normalize restores the integers of v decoded as floats by JSON, the
+//   documents of ManageMe have no other numbers
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= 1<<53 {
+			return int(v)
+		}
+	case bson.M:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+	case map[string]interface{}:
+		doc := bson.M{}
+		for k, e := range v {
+			doc[k] = normalize(e)
+		}
+		return doc
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	goerrors "errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// iterate returns a next over docs, as an mgo iterator
+func iterate(docs []bson.M) func(interface{}) bool {
+	i := 0
+	return func(doc interface{}) bool {
+		if i == len(docs) {
+			return false
+		}
+		*doc.(*bson.M) = docs[i]
+		i++
+		return true
+	}
+}
+
+func newUsers() []bson.M {
+	return []bson.M{
+		{
+			"_id":      bson.ObjectIdHex("5a0000000000000000000001"),
+			"username": "foo",
+			"password": "LCa0a2j/xo/5m0U8HTBBNBNCLXBkg7+g+YpeiGJm564=",
+			"role":     3,
+			"workingHours": bson.M{
+				"timeZone": "Europe/Paris",
+				"weekly":   bson.M{"mon": []interface{}{bson.M{"start": "09:00", "finish": "17:00"}}},
+			},
+		},
+		{"_id": bson.ObjectIdHex("5a0000000000000000000002"), "username": "bar", "role": 1},
+	}
+}
+
+func newTasks() []bson.M {
+	userID := bson.ObjectIdHex("5a0000000000000000000001")
+	return []bson.M{
+		{"_id": bson.NewObjectId(), "userID": userID, "start": 1768208400, "finish": 1768212000, "tags": []interface{}{"a"}},
+	}
+}
+
+// archive writes an archive of users and tasks
+func archive(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, 2)
+	_, err := w.Collection("users", iterate(newUsers()))
+	assert.NoError(t, err)
+	_, err = w.Collection("tasks", iterate(newTasks()))
+	assert.NoError(t, err)
+	m, err := w.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, m.Collection("users").Count)
+	return buf.Bytes()
+}
+
+// rewrite copies the archive b, replacing the files of replace
+func rewrite(t *testing.T, b []byte, replace map[string][]byte) []byte {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, f := range zr.File {
+		body, ok := replace[f.Name]
+		if !ok {
+			rc, _ := f.Open()
+			body, _ = io.ReadAll(rc)
+			rc.Close()
+		} else if body == nil {
+			continue
+		}
+		w, _ := zw.Create(f.Name)
+		w.Write(body)
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func Test001_RoundTrip(t *testing.T) {
+	b := archive(t)
+	a, err := Open(bytes.NewReader(b), int64(len(b)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, Format, a.Manifest.Format)
+	assert.Equal(t, 2, a.Manifest.SchemaVersion)
+	assert.Nil(t, a.Manifest.Collection("filters"))
+
+	// Ids, hashes and integers are kept
+	for name, want := range map[string][]bson.M{"users": newUsers(), "tasks": newTasks()} {
+		docs := []bson.M{}
+		assert.NoError(t, a.Each(name, func(doc bson.M) error {
+			docs = append(docs, doc)
+			return nil
+		}))
+		if name == "users" {
+			assert.Equal(t, want, docs)
+		} else {
+			assert.Equal(t, want[0]["userID"], docs[0]["userID"])
+			assert.Equal(t, 1768208400, docs[0]["start"])
+		}
+	}
+	assert.NoError(t, a.Each("filters", func(bson.M) error { return nil }))
+}
+
+func Test002_Corrupt(t *testing.T) {
+	b := archive(t)
+	tests := map[string][]byte{
+		"missing manifest.json":           rewrite(t, b, map[string][]byte{manifestName: nil}),
+		"format 2, expected 1":            rewrite(t, b, map[string][]byte{manifestName: []byte(`{"format": 2}`)}),
+		"missing tasks.jsonl.gz of tasks": rewrite(t, b, map[string][]byte{"tasks.jsonl.gz": nil}),
+		"users is listed twice": rewrite(t, b, map[string][]byte{manifestName: []byte(`{"format": 1, "collections": [
+			{"name": "users", "file": "users.jsonl.gz"}, {"name": "users", "file": "users.jsonl.gz"}]}`)}),
+		"zip: not a valid zip file": []byte("nope"),
+	}
+	for msg, archive := range tests {
+		_, err := Open(bytes.NewReader(archive), int64(len(archive)))
+		if assert.True(t, goerrors.Is(err, ErrCorrupt), msg) {
+			assert.Contains(t, err.Error(), msg)
+		}
+	}
+
+	// Documents are checked as they are read
+	manifest := []byte(`{"format": 1, "collections": [{"name": "users", "file": "users.jsonl.gz", "count": 3}]}`)
+	archive := rewrite(t, b, map[string][]byte{manifestName: manifest})
+	a, err := Open(bytes.NewReader(archive), int64(len(archive)))
+	assert.NoError(t, err)
+	err = a.Each("users", func(bson.M) error { return nil })
+	assert.True(t, goerrors.Is(err, ErrCorrupt))
+	assert.EqualError(t, err, "corrupt archive: users has 2 documents, expected 3")
+
+	manifest = []byte(`{"format": 1, "collections": [{"name": "users", "file": "users.jsonl.gz", "count": 2, "sha256": "00"}]}`)
+	archive = rewrite(t, b, map[string][]byte{manifestName: manifest})
+	a, _ = Open(bytes.NewReader(archive), int64(len(archive)))
+	err = a.Each("users", func(bson.M) error { return nil })
+	assert.True(t, goerrors.Is(err, ErrCorrupt))
+	assert.Contains(t, err.Error(), "expected 00")
+
+	manifest = []byte(`{"format": 1, "collections": [{"name": "users", "file": "users.jsonl.gz"}]}`)
+	archive = rewrite(t, b, map[string][]byte{manifestName: manifest, "users.jsonl.gz": []byte("plain")})
+	a, _ = Open(bytes.NewReader(archive), int64(len(archive)))
+	assert.True(t, goerrors.Is(a.Each("users", func(bson.M) error { return nil }), ErrCorrupt))
+}
@@ -0,0 +1,437 @@
+// Package config loads the settings of the server from a TOML file, the
+// environment and command line flags, each overriding the former
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/briansan/ManageMeServer/errors"
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+// Modes of the server
+const (
+	ModeAPI = "api"
+	ModeWWW = "www"
+	ModeAll = "all"
+)
+
+// Exporters of traces
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+)
+
+// envConfig names the config file when the config flag isn't set
+const envConfig = "MANAGEME_CONFIG"
+
+// Config is every setting of the server
+type Config struct {
+	// Mode is api to serve the api, www to serve the webapp or all to
+	//   serve both on the port of the api
+	Mode string
+	// ShutdownTimeout is how many seconds requests in flight have to
+	//   finish once the server is asked to stop
+	ShutdownTimeout int
+
+	API   API
+	WWW   WWW
+	Store Store
+	Trace Trace
+}
+
+// API configures the REST, GraphQL and gRPC services
+type API struct {
+	Port     int
+	GRPCPort int
+	// WWWHost is the origin of the webapp allowed by CORS
+	WWWHost string
+	// Secret signs sessions and is the admin's default password
+	Secret        string
+	AdminUsername string
+	AdminEmail    string
+	// MetricsPort serves the metrics apart from the api, so they aren't
+	//   public
+	MetricsPort int
+	// MetricsAuth is user:pass required to scrape the metrics if set
+	MetricsAuth string
+	// WebhookAllow lists the private networks as comma separated CIDRs
+	//   that webhooks may post to, none by default
+	WebhookAllow string
+}
+
+// WWW configures the webapp server
+type WWW struct {
+	Port int
+	// APIHost is the url of the api reported to the webapp
+	APIHost string
+	// AssetsDir serves the webapp from a directory rather than the one
+	//   embedded, to try changes without building
+	AssetsDir string
+}
+
+// Store configures the mongo database
+type Store struct {
+	// Host is host:port of mongo
+	Host string
+	// Auth is user:pass of mongo
+	Auth     string
+	Database string
+	// OverlapPolicy is the policy of users who have none
+	OverlapPolicy string
+	// ComplianceTolerance is the seconds a day's total may be off its
+	//   working hours in summaries and still be within them
+	ComplianceTolerance int
+	// Migrate applies the pending migrations of the database on startup
+	Migrate bool
+}
+
+// Trace configures where the spans of the api are exported
+type Trace struct {
+	// Exporter is none, stdout, file or otlp
+	Exporter string
+	// Endpoint is the OTLP/HTTP traces url of the otlp exporter
+	Endpoint string
+	// File is the path the file exporter appends spans to
+	File string
+}
+
+// Addr is the address of the REST api
+func (c *API) Addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+// GRPCAddr is the address of the gRPC service
+func (c *API) GRPCAddr() string {
+	return fmt.Sprintf(":%d", c.GRPCPort)
+}
+
+// MetricsAddr is the address of the metrics
+func (c *API) MetricsAddr() string {
+	return fmt.Sprintf(":%d", c.MetricsPort)
+}
+
+// WebhookNetworks parses WebhookAllow
+func (c *API) WebhookNetworks() ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, cidr := range strings.Split(c.WebhookAllow, ",") {
+		if cidr = strings.TrimSpace(cidr); len(cidr) == 0 {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Addr is the address of the webapp
+func (c *WWW) Addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+// Default returns the settings used unless overridden
+func Default() *Config {
+	return &Config{
+		Mode:            ModeWWW,
+		ShutdownTimeout: 30,
+		API: API{
+			Port:          8888,
+			GRPCPort:      8890,
+			MetricsPort:   8891,
+			WWWHost:       "http://localhost:8889",
+			AdminUsername: "boss",
+			AdminEmail:    "bk@breadtech.com",
+		},
+		WWW: WWW{
+			Port:    8889,
+			APIHost: "http://localhost:8888",
+		},
+		Store: Store{
+			Host:                "localhost:27017",
+			Auth:                "mdbmanageme:manageme",
+			Database:            "manageme",
+			OverlapPolicy:       schema.OverlapAllow,
+			ComplianceTolerance: schema.DefaultComplianceTolerance,
+			Migrate:             true,
+		},
+		Trace: Trace{
+			Exporter: ExporterNone,
+			Endpoint: "http://localhost:4318/v1/traces",
+		},
+	}
+}
+
+type stringValue string
+
+func (v *stringValue) String() string { return string(*v) }
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+type boolValue bool
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v = intValue(i)
+	return nil
+}
+
+// setting is a field of a config, named key in files and flags, and env
+//   in the environment
+type setting struct {
+	key, env, usage string
+	value           flag.Value
+}
+
+// settings lists the fields of c
+func (c *Config) settings() []*setting {
+	return []*setting{
+		{"mode", "MANAGEME_SERVER_MODE", "api to serve the api, www to serve the webapp or all to serve both", (*stringValue)(&c.Mode)},
+		{"shutdownTimeout", "MANAGEME_SHUTDOWN_TIMEOUT", "seconds requests in flight have to finish on shutdown", (*intValue)(&c.ShutdownTimeout)},
+		{"api.port", "MANAGEME_API_PORT", "port of the api", (*intValue)(&c.API.Port)},
+		{"api.grpcPort", "MANAGEME_GRPC_PORT", "port of the gRPC service", (*intValue)(&c.API.GRPCPort)},
+		{"api.wwwHost", "MANAGEME_WWW_HOST", "origin of the webapp allowed by CORS", (*stringValue)(&c.API.WWWHost)},
+		{"api.secret", "MANAGEME_SECRET", "key signing sessions, also the admin's default password", (*stringValue)(&c.API.Secret)},
+		{"api.adminUsername", "MANAGEME_ADMIN_USERNAME", "username of the admin created on startup", (*stringValue)(&c.API.AdminUsername)},
+		{"api.adminEmail", "MANAGEME_ADMIN_EMAIL", "email of the admin created on startup", (*stringValue)(&c.API.AdminEmail)},
+		{"api.metricsPort", "MANAGEME_METRICS_PORT", "port of the metrics", (*intValue)(&c.API.MetricsPort)},
+		{"api.metricsAuth", "MANAGEME_METRICS_AUTH", "credentials required to scrape the metrics as user:pass, none if empty", (*stringValue)(&c.API.MetricsAuth)},
+		{"api.webhookAllow", "MANAGEME_WEBHOOK_ALLOW", "private networks webhooks may post to as comma separated CIDRs, none if empty", (*stringValue)(&c.API.WebhookAllow)},
+		{"www.port", "MANAGEME_WWW_PORT", "port of the webapp", (*intValue)(&c.WWW.Port)},
+		{"www.apiHost", "MANAGEME_API_HOST", "url of the api used by the webapp", (*stringValue)(&c.WWW.APIHost)},
+		{"www.assetsDir", "MANAGEME_ASSETS_DIR", "directory of the webapp code, embedded if empty", (*stringValue)(&c.WWW.AssetsDir)},
+		{"store.host", "MANAGEME_MONGO_HOST", "mongo host as host:port", (*stringValue)(&c.Store.Host)},
+		{"store.auth", "MANAGEME_MONGO_AUTH", "mongo credentials as user:pass", (*stringValue)(&c.Store.Auth)},
+		{"store.database", "MANAGEME_MONGO_DATABASE", "mongo database", (*stringValue)(&c.Store.Database)},
+		{"store.overlapPolicy", "MANAGEME_OVERLAP_POLICY", "default policy for overlapping tasks: allow, warn or reject", (*stringValue)(&c.Store.OverlapPolicy)},
+		{"store.complianceTolerance", "MANAGEME_COMPLIANCE_TOLERANCE", "seconds a day's total may be off its working hours and still be within them", (*intValue)(&c.Store.ComplianceTolerance)},
+		{"store.migrate", "MANAGEME_MIGRATE", "apply the pending migrations of the database on startup", (*boolValue)(&c.Store.Migrate)},
+		{"trace.exporter", "MANAGEME_TRACE_EXPORTER", "where spans are exported: none, stdout, file or otlp", (*stringValue)(&c.Trace.Exporter)},
+		{"trace.endpoint", "MANAGEME_TRACE_ENDPOINT", "OTLP/HTTP traces url of the otlp exporter", (*stringValue)(&c.Trace.Endpoint)},
+		{"trace.file", "MANAGEME_TRACE_FILE", "file the file exporter appends spans to", (*stringValue)(&c.Trace.File)},
+	}
+}
+
+// Load reads the config of the server from the file named by the config
+//   flag or MANAGEME_CONFIG, then the environment and then args, the
+//   command line flags without the program name. The config is validated
+func Load(args []string) (*Config, error) {
+	return load(args, os.Getenv)
+}
+
+// LoadFlags reads the config like Load, parsing args with fs on which a
+//   command has defined its own flags. The arguments left are fs.Args()
+//   and the config isn't validated, as commands need parts of it
+func LoadFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	return loadFlags(fs, args, os.Getenv)
+}
+
+func load(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("manageme", flag.ContinueOnError)
+	c, err := loadFlags(fs, args, getenv)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func loadFlags(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	// Flags are applied last, so they are only collected while parsing
+	path := fs.String("config", getenv(envConfig), fmt.Sprintf("TOML config file ($%s)", envConfig))
+	flags := map[string]string{}
+	for _, s := range settings {
+		key := s.key
+		fs.Func(key, fmt.Sprintf("%s ($%s, default %v)", s.usage, s.env, s.value), func(v string) error {
+			flags[key] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Config file
+	if len(*path) > 0 {
+		values, err := parseFile(*path)
+		if err != nil {
+			return nil, err
+		}
+		byKey := map[string]*setting{}
+		for _, s := range settings {
+			byKey[s.key] = s
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s, ok := byKey[key]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown setting %s", *path, key)
+			}
+			if err := s.value.Set(values[key]); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", *path, key, err)
+			}
+		}
+	}
+
+	// Environment
+	for _, s := range settings {
+		if v := getenv(s.env); len(v) > 0 {
+			if err := s.value.Set(v); err != nil {
+				return nil, fmt.Errorf("env.%s: %v", s.env, err)
+			}
+		}
+	}
+
+	// Flags
+	for _, s := range settings {
+		if v, ok := flags[s.key]; ok {
+			if err := s.value.Set(v); err != nil {
+				return nil, fmt.Errorf("flag -%s: %v", s.key, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// validatePort checks port is a tcp port
+func validatePort(errs *errors.ValidationErrors, key string, port int) {
+	if port < 1 || port > 65535 {
+		errs.Add(errors.NewValidationError(key, "port between 1 and 65535"))
+	}
+}
+
+// validateURL checks u is an absolute http url
+func validateURL(errs *errors.ValidationErrors, key string, u string) {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		errs.Add(errors.NewValidationError(key, "http(s) url"))
+	}
+}
+
+// validateStore checks the settings of the mongo database
+func (c *Config) validateStore(errs *errors.ValidationErrors) {
+	if len(c.Store.Host) == 0 {
+		errs.Add(errors.NewValidationError("store.host", "host:port"))
+	}
+	if len(c.Store.Database) == 0 {
+		errs.Add(errors.NewValidationError("store.database", "non empty string"))
+	}
+	if !schema.ValidOverlapPolicy(c.Store.OverlapPolicy) {
+		errs.Add(errors.NewValidationError("store.overlapPolicy", "allow, warn or reject"))
+	}
+	if c.Store.ComplianceTolerance < 0 {
+		errs.Add(errors.NewValidationError("store.complianceTolerance", "non negative number of seconds"))
+	}
+}
+
+// ValidateStore checks the settings of the mongo database, for commands
+//   using nothing else
+func (c *Config) ValidateStore() error {
+	errs := errors.ValidationErrors{}
+	c.validateStore(&errs)
+	return errs.Err()
+}
+
+// Validate checks the settings needed by the mode of c, reporting every
+//   violation by the key of its setting
+func (c *Config) Validate() error {
+	errs := errors.ValidationErrors{}
+	if c.ShutdownTimeout < 1 {
+		errs.Add(errors.NewValidationError("shutdownTimeout", "positive number of seconds"))
+	}
+	switch c.Mode {
+	case ModeAPI, ModeAll:
+		validatePort(&errs, "api.port", c.API.Port)
+		validatePort(&errs, "api.grpcPort", c.API.GRPCPort)
+		validatePort(&errs, "api.metricsPort", c.API.MetricsPort)
+		if c.API.Port == c.API.GRPCPort {
+			errs.Add(errors.NewValidationError("api.grpcPort", "port other than api.port"))
+		}
+		if c.API.MetricsPort == c.API.Port || c.API.MetricsPort == c.API.GRPCPort {
+			errs.Add(errors.NewValidationError("api.metricsPort", "port other than api.port and api.grpcPort"))
+		}
+		if len(c.API.MetricsAuth) > 0 && !strings.Contains(c.API.MetricsAuth, ":") {
+			errs.Add(errors.NewValidationError("api.metricsAuth", "user:pass"))
+		}
+		if _, err := c.API.WebhookNetworks(); err != nil {
+			errs.Add(errors.NewValidationError("api.webhookAllow", "comma separated CIDRs"))
+		}
+		validateURL(&errs, "api.wwwHost", c.API.WWWHost)
+		if len(c.API.Secret) == 0 {
+			errs.Add(errors.NewValidationError("api.secret", "non empty string"))
+		}
+		if len(c.API.AdminUsername) == 0 {
+			errs.Add(errors.NewValidationError("api.adminUsername", "non empty string"))
+		}
+		if len(c.API.AdminEmail) == 0 {
+			errs.Add(errors.NewValidationError("api.adminEmail", "non empty string"))
+		}
+		c.validateStore(&errs)
+		switch c.Trace.Exporter {
+		case ExporterNone, ExporterStdout:
+		case ExporterFile:
+			if len(c.Trace.File) == 0 {
+				errs.Add(errors.NewValidationError("trace.file", "path of the file exporter"))
+			}
+		case ExporterOTLP:
+			validateURL(&errs, "trace.endpoint", c.Trace.Endpoint)
+		default:
+			errs.Add(errors.NewValidationError("trace.exporter", "none, stdout, file or otlp"))
+		}
+	case ModeWWW:
+		validatePort(&errs, "www.port", c.WWW.Port)
+		validateURL(&errs, "www.apiHost", c.WWW.APIHost)
+	default:
+		errs.Add(errors.NewValidationError("mode", "api, www or all"))
+	}
+	if c.Mode != ModeAPI && len(c.WWW.AssetsDir) > 0 {
+		if info, err := os.Stat(c.WWW.AssetsDir); err != nil || !info.IsDir() {
+			errs.Add(errors.NewValidationError("www.assetsDir", "existing directory"))
+		}
+	}
+	return errs.Err()
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile writes a config file in a temporary directory
+func writeFile(t *testing.T, content string) string {
+	name := filepath.Join(t.TempDir(), "manageme.toml")
+	if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// env returns a getenv of vars
+func env(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func Test001_Precedence(t *testing.T) {
+	name := writeFile(t, `
+# the api of the test
+mode = "api"
+
+[api]
+port = 9_000
+secret = 'file_secret' # a literal string
+adminUsername = "file_admin"
+
+[store]
+database = "file"
+host = "file:27017"
+migrate = false
+`)
+
+	c, err := load([]string{"-config", name, "-store.database", "flag"}, env(map[string]string{
+		"MANAGEME_SECRET":         "env_secret",
+		"MANAGEME_MONGO_DATABASE": "env",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, ModeAPI, c.Mode)
+	assert.Equal(t, 9000, c.API.Port)
+	assert.Equal(t, ":9000", c.API.Addr())
+	assert.Equal(t, "env_secret", c.API.Secret)
+	assert.Equal(t, "file_admin", c.API.AdminUsername)
+	assert.Equal(t, "flag", c.Store.Database)
+	assert.Equal(t, "file:27017", c.Store.Host)
+	assert.False(t, c.Store.Migrate)
+
+	// Defaults are kept when nothing sets them
+	assert.Equal(t, Default().API.GRPCPort, c.API.GRPCPort)
+	assert.Equal(t, Default().Store.Auth, c.Store.Auth)
+	assert.True(t, Default().Store.Migrate)
+
+	// The config file can be named by the environment
+	c, err = load(nil, env(map[string]string{envConfig: name}))
+	assert.Nil(t, err)
+	assert.Equal(t, "file_secret", c.API.Secret)
+}
+
+func Test002_File(t *testing.T) {
+	tests := map[string]string{
+		"expected '.' or '='":          "mode\n",
+		"unknown setting mo-de":        "mo-de = \"api\"\n",
+		"expected value":               "mode = api\n",
+		"already been defined":         "mode = \"api\"\nmode = \"www\"\n",
+		"api.x is nested in a table":   "[api.x]\ny = 1\n",
+		"api.x.y is nested in a table": "[api]\nx.y = 1\n",
+		"is not a string":              "[api]\nport = 1.5\n",
+		"unknown setting api.x":        "[api]\nx = 1\n",
+		"not an integer":               "[api]\nport = \"http\"\n",
+		"not a boolean":                "[store]\nmigrate = \"no\"\n",
+	}
+	for msg, content := range tests {
+		_, err := load([]string{"-config", writeFile(t, content)}, env(nil))
+		if assert.Error(t, err, msg) {
+			assert.Contains(t, err.Error(), msg)
+		}
+	}
+
+	_, err := load([]string{"-config", filepath.Join(t.TempDir(), "missing.toml")}, env(nil))
+	assert.Error(t, err)
+}
+
+func Test003_EnvAndFlags(t *testing.T) {
+	_, err := load([]string{"-mode", "api"}, env(map[string]string{"MANAGEME_API_PORT": "http"}))
+	assert.EqualError(t, err, `env.MANAGEME_API_PORT: "http" is not an integer`)
+
+	_, err = load([]string{"-mode", "api", "-api.port", "http"}, env(nil))
+	assert.EqualError(t, err, `flag -api.port: "http" is not an integer`)
+
+	_, err = load([]string{"-mode", "api", "extra"}, env(nil))
+	assert.EqualError(t, err, `unexpected argument "extra"`)
+
+	_, err = load([]string{"-unknown"}, env(nil))
+	assert.Error(t, err)
+}
+
+func Test004_Validate(t *testing.T) {
+	_, err := load([]string{"-mode", "api", "-api.port", "0", "-api.grpcPort", "0", "-store.overlapPolicy", "never", "-store.complianceTolerance", "-1"}, env(nil))
+	assert.EqualError(t, err, "api.port field is required as port between 1 and 65535; "+
+		"api.grpcPort field is required as port between 1 and 65535; "+
+		"api.grpcPort field is required as port other than api.port; "+
+		"api.secret field is required as non empty string; "+
+		"store.overlapPolicy field is required as allow, warn or reject; "+
+		"store.complianceTolerance field is required as non negative number of seconds")
+
+	_, err = load([]string{"-mode", "api", "-api.secret", "foo", "-api.metricsPort", "8888", "-api.metricsAuth", "foo"}, env(nil))
+	assert.EqualError(t, err, "api.metricsPort field is required as port other than api.port and api.grpcPort; "+
+		"api.metricsAuth field is required as user:pass")
+
+	cfg, err := load([]string{"-mode", "api", "-api.secret", "foo", "-api.webhookAllow", "10.0.0.0/8, fd00::/8"}, env(nil))
+	if assert.NoError(t, err) {
+		networks, _ := cfg.API.WebhookNetworks()
+		assert.Equal(t, 2, len(networks))
+	}
+	_, err = load([]string{"-mode", "api", "-api.secret", "foo", "-api.webhookAllow", "10.0.0.1"}, env(nil))
+	assert.EqualError(t, err, "api.webhookAllow field is required as comma separated CIDRs")
+
+	_, err = load([]string{"-mode", "api", "-api.secret", "foo", "-trace.exporter", "file"}, env(nil))
+	assert.EqualError(t, err, "trace.file field is required as path of the file exporter")
+
+	_, err = load([]string{"-mode", "api", "-api.secret", "foo", "-trace.exporter", "otlp", "-trace.endpoint", ""}, env(nil))
+	assert.EqualError(t, err, "trace.endpoint field is required as http(s) url")
+
+	_, err = load([]string{"-mode", "api", "-api.secret", "foo", "-trace.exporter", "jaeger"}, env(nil))
+	assert.EqualError(t, err, "trace.exporter field is required as none, stdout, file or otlp")
+
+	_, err = load([]string{"-www.apiHost", "localhost", "-www.assetsDir", filepath.Join(t.TempDir(), "missing")}, env(nil))
+	assert.EqualError(t, err, "www.apiHost field is required as http(s) url; "+
+		"www.assetsDir field is required as existing directory")
+
+	c, err := load(nil, env(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, ModeWWW, c.Mode)
+	assert.Empty(t, c.WWW.AssetsDir)
+
+	// All mode needs the settings of the api, and of www if set
+	_, err = load([]string{"-mode", "all", "-www.assetsDir", filepath.Join(t.TempDir(), "missing")}, env(nil))
+	assert.EqualError(t, err, "api.secret field is required as non empty string; "+
+		"www.assetsDir field is required as existing directory")
+
+	c, err = load([]string{"-mode", "all", "-api.secret", "foo", "-www.assetsDir", t.TempDir()}, env(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, ModeAll, c.Mode)
+
+	_, err = load([]string{"-mode", "both", "-shutdownTimeout", "0"}, env(nil))
+	assert.EqualError(t, err, "shutdownTimeout field is required as positive number of seconds; "+
+		"mode field is required as api, www or all")
+}
+
+func Test005_LoadFlags(t *testing.T) {
+	fs := flag.NewFlagSet("manageme user list", flag.ContinueOnError)
+	role := fs.String("role", "", "")
+	c, err := loadFlags(fs, []string{"-store.database", "flag", "-role", "admin", "bob"}, env(map[string]string{
+		"MANAGEME_MONGO_HOST": "env:27017",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", *role)
+	assert.Equal(t, []string{"bob"}, fs.Args())
+	assert.Equal(t, "flag", c.Store.Database)
+	assert.Equal(t, "env:27017", c.Store.Host)
+
+	// Commands using the store only need its settings
+	c.Mode = ModeAPI
+	assert.Error(t, c.Validate())
+	assert.Nil(t, c.ValidateStore())
+	c.Store.Host = ""
+	assert.EqualError(t, c.ValidateStore(), "store.host field is required as host:port")
+}
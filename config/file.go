@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// settingValue is the text that sets a setting to the TOML value v, a
+//   string, integer or boolean
+func settingValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("%v is not a string, integer or boolean", v)
+}
+
+// parseFile reads the settings of the TOML file name by section.key. Only
+//   tables of key/value pairs of strings, integers and booleans are
+//   supported, which is all a config has
+func parseFile(name string) (map[string]string, error) {
+	doc := map[string]interface{}{}
+	md, err := toml.DecodeFile(name, &doc)
+	if perr, ok := err.(toml.ParseError); ok {
+		return nil, fmt.Errorf("%s: %v", name, perr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, key := range md.Keys() {
+		v := interface{}(doc)
+		for _, k := range key {
+			m, _ := v.(map[string]interface{})
+			v = m[k]
+		}
+		_, table := v.(map[string]interface{})
+		if table && len(key) == 1 {
+			continue
+		}
+		if table || len(key) > 2 {
+			return nil, fmt.Errorf("%s: %s is nested in a table", name, key)
+		}
+		value, err := settingValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", name, key, err)
+		}
+		values[key.String()] = value
+	}
+	return values, nil
+}
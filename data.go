@@ -0,0 +1,351 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/backup"
+	"github.com/briansan/ManageMeServer/config"
+	"github.com/briansan/ManageMeServer/importer"
+	"github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+	"github.com/briansan/ManageMeServer/report"
+)
+
+// seedUsers are the demo users created by seed, by role
+var seedUsers = []struct {
+	username, role string
+}{
+	{"demo_user", "user"},
+	{"demo_manager", "manager"},
+}
+
+// seedTasks are the demo tasks of a day, as titles and hours of the day
+var seedTasks = []struct {
+	title, description string
+	start, finish      int
+}{
+	{"Standup", "daily sync with the team", 9, 10},
+	{"Code review", "review the open pull requests", 10, 12},
+	{"Planning", "plan the next sprint", 13, 15},
+	{"Gym", "", 18, 19},
+}
+
+var seedCommand = &command{
+	name:    "seed",
+	summary: "create demo users with a few days of tasks, skipping the users that exist",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		password := fs.String("password", "demo", "password of the demo users")
+		days := fs.Int("days", 7, "days of tasks up to today")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 0); err != nil {
+				return err
+			}
+
+			return withStore(cfg, store.InitMongoSession, func(db *store.MongoStore) error {
+				today := time.Now().UTC().Truncate(24 * time.Hour)
+				for _, seed := range seedUsers {
+					username, email, role := seed.username, seed.username+"@example.com", schema.RoleNames[seed.role]
+					u := &schema.User{Username: &username, Email: &email, Password: password, Role: &role}
+					if err := db.CreateUser(u); errors.Is(err, store.ErrConflict) {
+						fmt.Fprintf(stdout, "skipped %s, it exists\n", username)
+						continue
+					} else if err != nil {
+						return err
+					}
+
+					tasks := []*schema.Task{}
+					for day := 1 - *days; day <= 0; day++ {
+						date := today.AddDate(0, 0, day)
+						for _, t := range seedTasks {
+							start := date.Add(time.Duration(t.start) * time.Hour)
+							finish := date.Add(time.Duration(t.finish) * time.Hour)
+							tasks = append(tasks, &schema.Task{
+								TimeRange:   *schema.NewTimeRange(int(start.Unix()), int(finish.Unix())),
+								UserID:      &u.ID,
+								Title:       t.title,
+								Description: t.description,
+							})
+						}
+					}
+					failed, err := db.CreateTasks(tasks, false)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(stdout, "created %s %s with %d tasks\n", seed.role, username, len(tasks)-len(failed))
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var exportCommand = &command{
+	name:    "export",
+	summary: "write the timesheet of the tasks, as csv it can be imported back",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		username := fs.String("user", "", "only export the tasks of this username")
+		from := fs.String("from", "", "only export the tasks finishing from this unix time")
+		to := fs.String("to", "", "only export the tasks starting until this unix time")
+		tz := fs.String("tz", "", "time zone of the dates and times, UTC if empty")
+		format := fs.String("format", "csv", "format of the timesheet: csv, html, pdf or md")
+		output := fs.String("o", "", "file to write, stdout if empty")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 0); err != nil {
+				return err
+			}
+			loc, err := time.LoadLocation(*tz)
+			if err != nil {
+				return usageError{err}
+			}
+			if !isOneOf(*format, report.Formats) {
+				return usageError{fmt.Errorf("unknown format %q, expected csv, html, pdf or md", *format)}
+			}
+
+			return withStore(cfg, store.InitMongoSession, func(db *store.MongoStore) error {
+				users, _, err := db.GetAllUsers(nil)
+				if err != nil {
+					return err
+				}
+				usernames := map[string]string{}
+				userID := ""
+				for _, u := range users {
+					usernames[u.ID.Hex()] = u.Username
+					if u.Username == *username {
+						userID = u.ID.Hex()
+					}
+				}
+				if len(*username) > 0 && len(userID) == 0 {
+					return fmt.Errorf("user %s: %v", *username, store.ErrNotFound)
+				}
+				q, err := store.NewTaskQueryFromParams(userID, "", *from, *to)
+				if err != nil {
+					return usageError{err}
+				}
+
+				var out io.Writer = stdout
+				if len(*output) > 0 {
+					f, err := os.Create(*output)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					out = f
+				}
+				w, _ := report.NewWriter(*format, out)
+				iter := db.IterTasks(q)
+				next := func(t *schema.Task) bool { return iter.Next(t) }
+				if err := report.Timesheet(w, "Timesheet", next, usernames, loc); err != nil {
+					iter.Close()
+					return err
+				}
+				return iter.Close()
+			})
+		}
+	},
+}
+
+var importCommand = &command{
+	name:    "import",
+	args:    "<file>",
+	summary: "create the tasks of a csv or json export of ManageMe, Toggl or Harvest",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		format := fs.String("format", "generic", "format of the export: generic, toggl or harvest")
+		kind := fs.String("type", "csv", "encoding of the export: csv or json")
+		username := fs.String("user", "", "username owning every task, else matched by the username or email of each entry")
+		tz := fs.String("tz", "", "time zone of the times without one, UTC if empty")
+		dryRun := fs.Bool("dry-run", false, "check the entries without saving them")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 1); err != nil {
+				return err
+			}
+			loc, err := time.LoadLocation(*tz)
+			if err != nil {
+				return usageError{err}
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			records, rowErrs, err := importer.Decode(f, *format, *kind, loc)
+			if err != nil {
+				return err
+			}
+			failures := map[int]string{}
+			for _, e := range rowErrs {
+				failures[e.Row] = e.Message
+			}
+
+			return withStore(cfg, store.InitMongoSession, func(db *store.MongoStore) error {
+				// Users are resolved once by username or email
+				ids := map[string]bson.ObjectId{}
+				resolve := func(name string) (bson.ObjectId, error) {
+					if id, ok := ids[name]; ok {
+						return id, nil
+					}
+					user, err := db.GetUserByUsername(name)
+					if err == store.ErrNotFound {
+						user, err = db.GetUserByEmail(name)
+					}
+					if err != nil {
+						return "", err
+					}
+					ids[name] = user.ID
+					return user.ID, nil
+				}
+
+				tasks := []*schema.Task{}
+				rows := []int{}
+				for _, rec := range records {
+					name := rec.User
+					if len(*username) > 0 {
+						name = *username
+					}
+					id, err := resolve(name)
+					if err == store.ErrNotFound {
+						failures[rec.Row] = "unknown user " + name
+						continue
+					} else if err != nil {
+						return err
+					}
+					t := &schema.Task{
+						TimeRange:   *schema.NewTimeRange(int(rec.Start.Unix()), int(rec.Finish.Unix())),
+						UserID:      &id,
+						Title:       rec.Title,
+						Description: rec.Description,
+					}
+					if err := t.Validate(); err != nil {
+						failures[rec.Row] = err.Error()
+						continue
+					}
+					tasks = append(tasks, t)
+					rows = append(rows, rec.Row)
+				}
+				failed, err := db.CreateTasks(tasks, *dryRun)
+				if err != nil {
+					return err
+				}
+				for i, err := range failed {
+					failures[rows[i]] = err.Error()
+				}
+
+				sorted := make([]int, 0, len(failures))
+				for row := range failures {
+					sorted = append(sorted, row)
+				}
+				sort.Ints(sorted)
+				for _, row := range sorted {
+					fmt.Fprintf(os.Stderr, "row %d: %s\n", row, failures[row])
+				}
+				verb := "created"
+				if *dryRun {
+					verb = "would create"
+				}
+				fmt.Fprintf(stdout, "%s %d tasks, %d entries failed\n", verb, len(tasks)-len(failed), len(failures))
+				return nil
+			})
+		}
+	},
+}
+
+var backupCommand = &command{
+	name:    "backup",
+	summary: "write an archive of the database, restored by restore",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		output := fs.String("o", "", "file to write, stdout if empty")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 0); err != nil {
+				return err
+			}
+
+			return withStore(cfg, store.ConnectMongoSession, func(db *store.MongoStore) error {
+				// The summary goes to stderr when the archive goes to stdout
+				var out, summary io.Writer = stdout, os.Stderr
+				if len(*output) > 0 {
+					f, err := os.Create(*output)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					out, summary = f, stdout
+				}
+				manifest, err := db.Backup(out)
+				if err != nil {
+					if len(*output) > 0 {
+						os.Remove(*output)
+					}
+					return err
+				}
+				for _, c := range manifest.Collections {
+					fmt.Fprintf(summary, "backed up %d %s", c.Count, c.Name)
+					if c.Orphans > 0 {
+						fmt.Fprintf(summary, ", %d orphans", c.Orphans)
+					}
+					fmt.Fprintln(summary)
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var restoreCommand = &command{
+	name:    "restore",
+	args:    "<file>",
+	summary: "restore an archive of backup, once checked as a whole",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		replace := fs.Bool("replace", false, "replace the database, else only insert the documents it misses")
+		dryRun := fs.Bool("dry-run", false, "check the archive without restoring it")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 1); err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			a, err := backup.Open(f, info.Size())
+			if err != nil {
+				return err
+			}
+
+			mode := store.RestoreMerge
+			if *replace {
+				mode = store.RestoreReplace
+			}
+
+			return withStore(cfg, store.ConnectMongoSession, func(db *store.MongoStore) error {
+				results, err := db.Restore(a, mode, *dryRun)
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "COLLECTION\tRESTORED\tSKIPPED\tORPHANS")
+				for _, r := range results {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", r.Collection, r.Restored, r.Skipped, r.Orphans)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				if *dryRun {
+					fmt.Fprintln(stdout, "dry run, nothing was restored")
+				}
+				return nil
+			})
+		}
+	},
+}
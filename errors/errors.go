@@ -3,11 +3,19 @@ package errors
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo"
-	"gopkg.in/mgo.v2"
 )
 
+// statusError is an error of another layer, such as the store, that
+//   knows how it is described as a response
+type statusError interface {
+	error
+	Status() int
+	Code() string
+}
+
 type ConflictError struct {
 	error
 	Type  string
@@ -20,6 +28,13 @@ func (err ConflictError) Error() string {
 		err.Type, err.Field, err.Value)
 }
 
+// Is makes conflicts the errors of other layers describing conflicts,
+//   such as the ErrConflict of the store
+func (err ConflictError) Is(target error) bool {
+	se, ok := target.(statusError)
+	return ok && se.Status() == http.StatusConflict
+}
+
 func NewConflictError(typ, field, value string) error {
 	return &ConflictError{
 		Type:  typ,
@@ -42,15 +57,52 @@ func (err ValidationError) Error() string {
 	return fmt.Sprintf("%v field is required as %v", err.Field, err.Type)
 }
 
-func MongoErrorResponse(err error) error {
-	if err == mgo.ErrNotFound {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+// ValidationErrors collects every violation found validating a document
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
 	}
-	if mgo.IsDup(err) {
-		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	return strings.Join(msgs, "; ")
+}
+
+// Add appends the violations of err, a *ValidationError or
+//   ValidationErrors, nil adds nothing
+func (errs *ValidationErrors) Add(err error) {
+	switch err := err.(type) {
+	case *ValidationError:
+		*errs = append(*errs, err)
+	case ValidationErrors:
+		*errs = append(*errs, err...)
 	}
-	if conflict, ok := err.(*ConflictError); ok {
-		return echo.NewHTTPError(http.StatusConflict, conflict.Error())
+}
+
+// Err is errs as an error, nil if there are no violations
+func (errs ValidationErrors) Err() error {
+	if len(errs) == 0 {
+		return nil
 	}
-	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	return errs
+}
+
+type OverlapError struct {
+	error
+	TaskIDs []string
+}
+
+func NewOverlapError(taskIDs []string) *OverlapError {
+	return &OverlapError{TaskIDs: taskIDs}
+}
+
+func (err OverlapError) Error() string {
+	return fmt.Sprintf("task overlaps with %v", strings.Join(err.TaskIDs, ", "))
+}
+
+// MongoErrorResponse converts err to an http error whose message is the
+//   problem it describes
+func MongoErrorResponse(err error) error {
+	p := problemOf(err)
+	return echo.NewHTTPError(p.Status, p)
 }
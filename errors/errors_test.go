@@ -1,16 +1,40 @@
 package errors
 
 import (
+	"encoding/json"
+	goerrors "errors"
 	"fmt"
+	"net/http"
 	"testing"
 
+	"github.com/labstack/echo"
 	"github.com/stretchr/testify/assert"
-	"gopkg.in/mgo.v2"
+)
+
+// kindError knows how it is described as a response, like the errors of
+//   the store
+type kindError struct {
+	msg    string
+	status int
+	code   string
+}
+
+func (err *kindError) Error() string { return err.msg }
+func (err *kindError) Status() int   { return err.status }
+func (err *kindError) Code() string  { return err.code }
+
+var (
+	errNotFound    = &kindError{"not found", http.StatusNotFound, ""}
+	errConflict    = &kindError{"already exists", http.StatusConflict, CodeConflict}
+	errInvalidID   = &kindError{"invalid id", http.StatusBadRequest, CodeInvalidID}
+	errUnavailable = &kindError{"database unavailable", http.StatusServiceUnavailable, ""}
 )
 
 func Test001_Conflict(t *testing.T) {
 	err := NewConflictError("foo", "bar", "baz")
 	assert.Equal(t, "foo with bar as baz already exists", err.Error())
+	assert.True(t, goerrors.Is(err, errConflict))
+	assert.False(t, goerrors.Is(err, errNotFound))
 }
 
 func Test002_Validation(t *testing.T) {
@@ -20,12 +44,93 @@ func Test002_Validation(t *testing.T) {
 
 func Test003_Mongo(t *testing.T) {
 	var err error
-	err = MongoErrorResponse(mgo.ErrNotFound)
+	err = MongoErrorResponse(errNotFound)
 	assert.Equal(t, "code=404, message=not found", err.Error())
 
+	err = MongoErrorResponse(errInvalidID)
+	assert.Equal(t, "code=400, message=invalid id", err.Error())
+
+	err = MongoErrorResponse(fmt.Errorf("%w: no reachable servers", errUnavailable))
+	assert.Equal(t, "code=503, message=database unavailable", err.Error())
+
 	err = MongoErrorResponse(NewConflictError("foo", "bar", "baz"))
 	assert.Equal(t, "code=409, message=foo with bar as baz already exists", err.Error())
 
+	err = MongoErrorResponse(NewValidationError("foo", "bar"))
+	assert.Equal(t, "code=400, message=foo field is required as bar", err.Error())
+
+	err = MongoErrorResponse(NewOverlapError([]string{"foo"}))
+	he, ok := err.(*echo.HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusConflict, he.Code)
+	assert.Equal(t, CodeOverlap, he.Message.(*Problem).Code)
+	assert.Equal(t, []string{"foo"}, he.Message.(*Problem).Extensions["taskIDs"])
+
+	// Internal errors are hidden but kept as the cause
 	err = MongoErrorResponse(fmt.Errorf("foo"))
-	assert.Equal(t, "code=500, message=foo", err.Error())
+	assert.Equal(t, "code=500, message=Internal Server Error", err.Error())
+	assert.Equal(t, "foo", err.(*echo.HTTPError).Message.(*Problem).Cause.Error())
+}
+
+func Test004_Overlap(t *testing.T) {
+	err := NewOverlapError([]string{"foo", "bar"})
+	assert.Equal(t, "task overlaps with foo, bar", err.Error())
+}
+
+func Test005_ValidationErrors(t *testing.T) {
+	errs := ValidationErrors{}
+	errs.Add(nil)
+	assert.Nil(t, errs.Err())
+
+	errs.Add(NewValidationError("foo", "bar"))
+	errs.Add(ValidationErrors{NewValidationError("baz", "qux")})
+	assert.Equal(t, "foo field is required as bar; baz field is required as qux", errs.Err().Error())
+}
+
+func Test006_Problem(t *testing.T) {
+	// Validation errors list their fields
+	errs := ValidationErrors{NewValidationError("foo", "bar"), NewValidationError("baz", "qux")}
+	p := ProblemOf(echo.NewHTTPError(http.StatusBadRequest, errs))
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, CodeValidation, p.Code)
+	assert.Equal(t, 2, len(p.Errors))
+	assert.Equal(t, "baz", p.Errors[1].Field)
+	assert.Equal(t, "qux", p.Errors[1].Expected)
+
+	body, err := json.Marshal(p)
+	assert.NoError(t, err)
+	m := map[string]interface{}{}
+	json.Unmarshal(body, &m)
+	assert.Equal(t, "urn:manageme:problem:validation_failed", m["type"])
+	assert.Equal(t, "Bad Request", m["title"])
+	assert.Equal(t, float64(400), m["status"])
+	assert.Equal(t, errs.Error(), m["detail"])
+	assert.Equal(t, m["detail"], m["message"])
+	assert.Equal(t, 2, len(m["errors"].([]interface{})))
+
+	// Statuses name the problems of plain http errors
+	p = ProblemOf(echo.ErrNotFound)
+	assert.Equal(t, "not_found", p.Code)
+	assert.Equal(t, "Not Found", p.Detail)
+	p = ProblemOf(echo.NewHTTPError(http.StatusBadRequest, fmt.Errorf("foo")))
+	assert.Equal(t, "bad_request", p.Code)
+	assert.Equal(t, "foo", p.Detail)
+
+	// Extra members of maps are extensions
+	p = ProblemOf(echo.NewHTTPError(http.StatusBadRequest, echo.Map{"message": "foo", "position": 3}))
+	assert.Equal(t, "foo", p.Detail)
+	assert.Equal(t, 3, p.Extensions["position"])
+
+	// Store errors keep their status, others are hidden
+	p = ProblemOf(MongoErrorResponse(NewConflictError("foo", "bar", "baz")))
+	assert.Equal(t, http.StatusConflict, p.Status)
+	assert.Equal(t, CodeConflict, p.Code)
+	p = ProblemOf(fmt.Errorf("secret"))
+	assert.Equal(t, http.StatusInternalServerError, p.Status)
+	assert.Equal(t, "internal_server_error", p.Code)
+	assert.Equal(t, "Internal Server Error", p.Detail)
+	p = ProblemOf(echo.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("secret")))
+	assert.Equal(t, "Internal Server Error", p.Detail)
+	body, _ = json.Marshal(p)
+	assert.NotContains(t, string(body), "secret")
 }
@@ -0,0 +1,190 @@
+package errors
+
+import (
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// ProblemContentType is the media type of problem responses
+const ProblemContentType = "application/problem+json"
+
+// Codes of the problems more specific than their status
+const (
+	CodeValidation = "validation_failed"
+	CodeConflict   = "already_exists"
+	CodeOverlap    = "task_overlap"
+	CodeModified   = "modified_concurrently"
+	CodeInvalidID  = "invalid_id"
+)
+
+// FieldError is the violation of one field of a document
+type FieldError struct {
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Message  string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details body. Code names the kind of
+//   problem and is stable across releases, unlike Detail
+type Problem struct {
+	Type      string
+	Title     string
+	Status    int
+	Detail    string
+	Instance  string
+	Code      string
+	Errors    []*FieldError
+	RequestID string
+
+	// Cause is the error behind an internal problem, to be logged but
+	//   never sent to clients
+	Cause error
+
+	// Extensions are further members of the body, such as the taskIDs of
+	//   an overlap
+	Extensions map[string]interface{}
+}
+
+// statusCode is the code of problems with no more specific one than
+//   their status, e.g. not_found
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if len(text) == 0 {
+		return "error"
+	}
+	return strings.ToLower(strings.Replace(strings.Replace(text, " ", "_", -1), "-", "_", -1))
+}
+
+// NewProblem returns a problem of status, code defaults to the one of
+//   status and detail to its text
+func NewProblem(status int, code, detail string) *Problem {
+	if len(code) == 0 {
+		code = statusCode(status)
+	}
+	if len(detail) == 0 {
+		detail = http.StatusText(status)
+	}
+	return &Problem{
+		Type:   "urn:manageme:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+// MarshalJSON writes the members of p followed by its extensions. The
+//   detail is repeated as message for clients of the former error body
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{}
+	for k, v := range p.Extensions {
+		body[k] = v
+	}
+	body["type"] = p.Type
+	body["title"] = p.Title
+	body["status"] = p.Status
+	body["detail"] = p.Detail
+	body["message"] = p.Detail
+	body["code"] = p.Code
+	if len(p.Instance) > 0 {
+		body["instance"] = p.Instance
+	}
+	if len(p.Errors) > 0 {
+		body["errors"] = p.Errors
+	}
+	if len(p.RequestID) > 0 {
+		body["requestID"] = p.RequestID
+	}
+	return json.Marshal(body)
+}
+
+// validationProblem lists the violations of errs
+func validationProblem(status int, errs ValidationErrors) *Problem {
+	p := NewProblem(status, CodeValidation, errs.Error())
+	for _, err := range errs {
+		p.Errors = append(p.Errors, &FieldError{
+			Field:    err.Field,
+			Expected: err.Type,
+			Message:  err.Error(),
+		})
+	}
+	return p
+}
+
+// problemOf describes the validation errors and the ones knowing their
+//   status, such as those of the store. Any other err is an internal
+//   problem caused by err
+func problemOf(err error) *Problem {
+	switch err := err.(type) {
+	case *Problem:
+		return err
+	case *ValidationError:
+		return validationProblem(http.StatusBadRequest, ValidationErrors{err})
+	case ValidationErrors:
+		return validationProblem(http.StatusBadRequest, err)
+	case *ConflictError:
+		return NewProblem(http.StatusConflict, CodeConflict, err.Error())
+	case *OverlapError:
+		p := NewProblem(http.StatusConflict, CodeOverlap, err.Error())
+		p.Extensions = map[string]interface{}{"taskIDs": err.TaskIDs}
+		return p
+	}
+
+	var p *Problem
+	var se statusError
+	if goerrors.As(err, &se) {
+		p = NewProblem(se.Status(), se.Code(), se.Error())
+	} else {
+		p = NewProblem(http.StatusInternalServerError, "", "")
+	}
+	p.Cause = err
+	return p
+}
+
+// ProblemOf describes err as a response. The errors of this package are
+//   described the same wrapped in an http error or not, other http errors
+//   keep their status and their message becomes the detail unless they are
+//   internal. Internal problems only tell what went wrong by their Cause
+func ProblemOf(err error) *Problem {
+	he, ok := err.(*echo.HTTPError)
+	if !ok {
+		return problemOf(err)
+	}
+
+	switch msg := he.Message.(type) {
+	case *Problem:
+		return msg
+	case echo.Map:
+		p := NewProblem(he.Code, "", fmt.Sprint(msg["message"]))
+		for k, v := range msg {
+			if k != "message" {
+				if p.Extensions == nil {
+					p.Extensions = map[string]interface{}{}
+				}
+				p.Extensions[k] = v
+			}
+		}
+		return p
+	case error:
+		if p := problemOf(msg); p.Status != http.StatusInternalServerError {
+			return p
+		}
+		if he.Code >= http.StatusInternalServerError {
+			p := NewProblem(he.Code, "", "")
+			p.Cause = msg
+			return p
+		}
+		return NewProblem(he.Code, "", msg.Error())
+	default:
+		return NewProblem(he.Code, "", fmt.Sprint(msg))
+	}
+}
@@ -0,0 +1,143 @@
+// Package events fans out the task and user events recorded by the store
+// to the streams of connected clients. Events reach every replica through
+// a Broker, by default by tailing the events recorded in mongo
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mgutz/logxi/v1"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+const (
+	// bufferSize is how many events a subscriber can fall behind by
+	// before it is dropped
+	bufferSize = 64
+
+	maxRetry = 30 * time.Second
+)
+
+var logger = log.New("events")
+
+// Broker delivers the events recorded by any replica
+type Broker interface {
+	// Tail calls fn with every event after the sequence after, or from now
+	// on if negative, until stop is closed. fn may see an event twice
+	Tail(after int64, stop <-chan struct{}, fn func(*schema.Event)) error
+}
+
+// MongoBroker tails the events recorded in mongo
+type MongoBroker struct{}
+
+// Tail implements Broker
+func (MongoBroker) Tail(after int64, stop <-chan struct{}, fn func(*schema.Event)) error {
+	db, err := store.NewMongoStore()
+	if err != nil {
+		return err
+	}
+	defer db.Cleanup()
+	return db.TailEvents(after, stop, fn)
+}
+
+// Subscription receives the events published to a hub on C, which is
+// closed if the subscriber falls too far behind
+type Subscription struct {
+	C chan *schema.Event
+}
+
+// Hub fans out the events of a broker to its subscribers
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+	// last is the highest sequence published, -1 before any
+	last int64
+	// seen are the sequences published from last - store.EventSlack,
+	//   those a resumed tail sends again
+	seen map[int64]bool
+}
+
+// NewHub returns a hub without subscribers
+func NewHub() *Hub {
+	return &Hub{subs: map[*Subscription]bool{}, last: -1, seen: map[int64]bool{}}
+}
+
+// Subscribe returns a subscription to the events published from now on
+func (h *Hub) Subscribe() *Subscription {
+	s := &Subscription{C: make(chan *schema.Event, bufferSize)}
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+	return s
+}
+
+// Unsubscribe stops publishing to s
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[s] {
+		delete(h.subs, s)
+		close(s.C)
+	}
+}
+
+// Publish sends ev to every subscriber once, dropping those whose buffer
+// is full so they can catch up by replaying
+func (h *Hub) Publish(ev *schema.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.seen[ev.Seq] {
+		return
+	}
+	h.seen[ev.Seq] = true
+	if ev.Seq > h.last {
+		h.last = ev.Seq
+		for seq := range h.seen {
+			if seq < h.last-store.EventSlack {
+				delete(h.seen, seq)
+			}
+		}
+	}
+	for s := range h.subs {
+		select {
+		case s.C <- ev:
+		default:
+			delete(h.subs, s)
+			close(s.C)
+		}
+	}
+}
+
+// Run publishes the events of b recorded from now on until stop is closed,
+// resuming store.EventSlack sequences before the last one published if the
+// broker fails, to pick up those numbered before it but recorded after
+func (h *Hub) Run(b Broker, stop <-chan struct{}) {
+	retry := time.Second
+	for {
+		h.mu.Lock()
+		after := h.last
+		h.mu.Unlock()
+		if after >= 0 {
+			if after -= store.EventSlack; after < 0 {
+				after = 0
+			}
+		}
+
+		err := b.Tail(after, stop, h.Publish)
+		if err == nil {
+			return
+		}
+		logger.Warn("event tail failed", "err", err, "retry", retry)
+		select {
+		case <-stop:
+			return
+		case <-time.After(retry):
+		}
+		if retry *= 2; retry > maxRetry {
+			retry = maxRetry
+		}
+	}
+}
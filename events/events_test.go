@@ -0,0 +1,82 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// fakeBroker fails its first tail after sending one event
+type fakeBroker struct {
+	events []*schema.Event
+	afters []int64
+}
+
+func (b *fakeBroker) Tail(after int64, stop <-chan struct{}, fn func(*schema.Event)) error {
+	b.afters = append(b.afters, after)
+	if len(b.afters) == 1 {
+		fn(b.events[0])
+		return fmt.Errorf("connection reset")
+	}
+	for _, ev := range b.events[1:] {
+		fn(ev)
+	}
+	return nil
+}
+
+func Test001_Hub(t *testing.T) {
+	h := NewHub()
+	a, b := h.Subscribe(), h.Subscribe()
+
+	ev := &schema.Event{ID: bson.NewObjectId(), Seq: 1, Type: schema.EventTaskCreated}
+	h.Publish(ev)
+	assert.Equal(t, ev, <-a.C)
+	assert.Equal(t, ev, <-b.C)
+
+	// Unsubscribing closes the subscription once
+	h.Unsubscribe(a)
+	h.Unsubscribe(a)
+	_, ok := <-a.C
+	assert.False(t, ok)
+
+	// Events are published once, even numbered before the last one
+	h.Publish(ev)
+	late := &schema.Event{ID: bson.NewObjectId(), Seq: 3}
+	early := &schema.Event{ID: bson.NewObjectId(), Seq: 2}
+	h.Publish(late)
+	h.Publish(early)
+	assert.Equal(t, late, <-b.C)
+	assert.Equal(t, early, <-b.C)
+
+	// Subscribers that fall behind are dropped
+	for i := 0; i <= bufferSize; i++ {
+		h.Publish(&schema.Event{Seq: int64(10 + i)})
+	}
+	n := 0
+	for range b.C {
+		n++
+	}
+	assert.Equal(t, bufferSize, n)
+}
+
+func Test002_Run(t *testing.T) {
+	first := &schema.Event{ID: bson.NewObjectId(), Seq: 200, Type: schema.EventTaskCreated}
+	second := &schema.Event{ID: bson.NewObjectId(), Seq: 201, Type: schema.EventTaskUpdated}
+	broker := &fakeBroker{events: []*schema.Event{first, first, second}}
+
+	h := NewHub()
+	s := h.Subscribe()
+	h.Run(broker, nil)
+
+	// Starts from now, then resumes before the last event published once
+	//   the broker fails, without publishing it again
+	assert.Equal(t, []int64{-1, 200 - store.EventSlack}, broker.afters)
+	assert.Equal(t, first, <-s.C)
+	assert.Equal(t, second, <-s.C)
+	assert.Equal(t, 0, len(s.C))
+}
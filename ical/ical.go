@@ -0,0 +1,327 @@
+// Package ical encodes and decodes the subset of iCalendar (RFC 5545)
+// needed to exchange tasks as VEVENTs with calendar applications
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	dateTimeLayout    = "20060102T150405"
+	dateTimeUTCLayout = "20060102T150405Z"
+	dateLayout        = "20060102"
+
+	maxLineLength = 75
+	prodID        = "-//ManageMe//ManageMe Tasks//EN"
+)
+
+// Event is a VEVENT
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+	// Stamp is the DTSTAMP, the time the event was created, now if zero
+	Stamp time.Time
+}
+
+var (
+	textEscaper   = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+)
+
+// Encoder writes a VCALENDAR as events are added
+type Encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewEncoder writes the VCALENDAR header to w
+func NewEncoder(w io.Writer, name string) *Encoder {
+	e := &Encoder{w: bufio.NewWriter(w)}
+	e.line("BEGIN:VCALENDAR")
+	e.line("VERSION:2.0")
+	e.line("PRODID:" + prodID)
+	e.line("CALSCALE:GREGORIAN")
+	if len(name) > 0 {
+		e.line("X-WR-CALNAME:" + textEscaper.Replace(name))
+	}
+	return e
+}
+
+// line writes a content line folded at 75 octets, the leading space of
+// a continuation line counting towards them
+func (e *Encoder) line(l string) {
+	if e.err != nil {
+		return
+	}
+	for max := maxLineLength; len(l) > max; max = maxLineLength - 1 {
+		// Don't split a multi byte character
+		i := max
+		for i > 0 && l[i]&0xc0 == 0x80 {
+			i--
+		}
+		_, e.err = e.w.WriteString(l[:i] + "\r\n ")
+		l = l[i:]
+	}
+	_, err := e.w.WriteString(l + "\r\n")
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// Encode writes ev as a VEVENT
+func (e *Encoder) Encode(ev *Event) error {
+	e.line("BEGIN:VEVENT")
+	e.line("UID:" + textEscaper.Replace(ev.UID))
+	stamp := ev.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	e.line("DTSTAMP:" + stamp.UTC().Format(dateTimeUTCLayout))
+	e.line("DTSTART:" + ev.Start.UTC().Format(dateTimeUTCLayout))
+	e.line("DTEND:" + ev.End.UTC().Format(dateTimeUTCLayout))
+	e.line("SUMMARY:" + textEscaper.Replace(ev.Summary))
+	if len(ev.Description) > 0 {
+		e.line("DESCRIPTION:" + textEscaper.Replace(ev.Description))
+	}
+	e.line("END:VEVENT")
+	return e.err
+}
+
+// Close writes the VCALENDAR footer and flushes
+func (e *Encoder) Close() error {
+	e.line("END:VCALENDAR")
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// Encode writes a VCALENDAR holding events to w
+func Encode(w io.Writer, name string, events []*Event) error {
+	e := NewEncoder(w, name)
+	for _, ev := range events {
+		if err := e.Encode(ev); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// ParseError reports a VEVENT that couldn't be decoded
+type ParseError struct {
+	Line    int
+	UID     string
+	Message string
+}
+
+func (err ParseError) Error() string {
+	if len(err.UID) > 0 {
+		return fmt.Sprintf("line %d: event %v: %v", err.Line, err.UID, err.Message)
+	}
+	return fmt.Sprintf("line %d: %v", err.Line, err.Message)
+}
+
+type contentLine struct {
+	num    int
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfold joins folded lines and returns the content lines of r
+func unfold(r io.Reader) ([]*contentLine, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lines := []*contentLine{}
+	raw := []string{}
+	nums := []int{}
+	for num := 1; scanner.Scan(); num++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if len(raw) > 0 && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) {
+			raw[len(raw)-1] += text[1:]
+			continue
+		}
+		if len(text) == 0 {
+			continue
+		}
+		raw = append(raw, text)
+		nums = append(nums, num)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, text := range raw {
+		colon := strings.Index(text, ":")
+		if colon < 0 {
+			return nil, &ParseError{Line: nums[i], Message: "content line has no value"}
+		}
+		parts := strings.Split(text[:colon], ";")
+		l := &contentLine{
+			num:    nums[i],
+			name:   strings.ToUpper(parts[0]),
+			params: map[string]string{},
+			value:  text[colon+1:],
+		}
+		for _, p := range parts[1:] {
+			if eq := strings.Index(p, "="); eq > 0 {
+				l.params[strings.ToUpper(p[:eq])] = strings.Trim(p[eq+1:], `"`)
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// parseTime decodes a DATE or DATE-TIME value honoring the TZID parameter,
+// floating times and dates are interpreted in loc
+func parseTime(l *contentLine, loc *time.Location) (time.Time, error) {
+	if tzid, ok := l.params["TZID"]; ok {
+		tz, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown TZID %v", tzid)
+		}
+		loc = tz
+	}
+	switch {
+	case strings.HasSuffix(l.value, "Z"):
+		return time.Parse(dateTimeUTCLayout, l.value)
+	case len(l.value) == len(dateLayout):
+		return time.ParseInLocation(dateLayout, l.value, loc)
+	}
+	return time.ParseInLocation(dateTimeLayout, l.value, loc)
+}
+
+// parseDuration decodes a DURATION value such as P1D or PT1H30M
+func parseDuration(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("invalid duration %v", s)
+	}
+
+	var d time.Duration
+	n := 0
+	inTime := false
+	for _, r := range s[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			n = n*10 + int(r-'0')
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		case r == 'W' && !inTime:
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			d += time.Duration(n) * 24 * time.Hour
+		case r == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %v", s)
+		}
+		n = 0
+	}
+	return sign * d, nil
+}
+
+// Decode reads every VEVENT of r. Events that can't be decoded are
+// reported as ParseErrors alongside the events that could, while a
+// malformed stream fails entirely. Floating times are interpreted in loc
+func Decode(r io.Reader, loc *time.Location) ([]*Event, []*ParseError, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := []*Event{}
+	errs := []*ParseError{}
+
+	var ev *Event
+	var evErr *ParseError
+	var duration *time.Duration
+	depth := 0
+	for _, l := range lines {
+		switch {
+		case l.name == "BEGIN" && strings.ToUpper(l.value) == "VEVENT":
+			ev, evErr, duration = &Event{}, nil, nil
+			depth = 0
+			continue
+		case ev == nil:
+			continue
+		case l.name == "BEGIN":
+			// Skip nested components such as VALARM
+			depth++
+			continue
+		case l.name == "END" && depth > 0:
+			depth--
+			continue
+		case depth > 0:
+			continue
+		case l.name == "END" && strings.ToUpper(l.value) == "VEVENT":
+			if evErr == nil && ev.End.IsZero() && duration != nil {
+				ev.End = ev.Start.Add(*duration)
+			}
+			if evErr == nil && ev.Start.IsZero() {
+				evErr = &ParseError{Line: l.num, Message: "DTSTART is required"}
+			}
+			if evErr == nil && ev.End.IsZero() {
+				evErr = &ParseError{Line: l.num, Message: "DTEND or DURATION is required"}
+			}
+			if evErr == nil && ev.End.Before(ev.Start) {
+				evErr = &ParseError{Line: l.num, Message: "DTEND is before DTSTART"}
+			}
+			if evErr != nil {
+				evErr.UID = ev.UID
+				errs = append(errs, evErr)
+			} else {
+				events = append(events, ev)
+			}
+			ev = nil
+			continue
+		case evErr != nil:
+			continue
+		}
+
+		switch l.name {
+		case "UID":
+			ev.UID = textUnescaper.Replace(l.value)
+		case "SUMMARY":
+			ev.Summary = textUnescaper.Replace(l.value)
+		case "DESCRIPTION":
+			ev.Description = textUnescaper.Replace(l.value)
+		case "DTSTAMP":
+			ev.Stamp, _ = parseTime(l, time.UTC)
+		case "DTSTART":
+			if ev.Start, err = parseTime(l, loc); err != nil {
+				evErr = &ParseError{Line: l.num, Message: "invalid DTSTART: " + err.Error()}
+			}
+		case "DTEND":
+			if ev.End, err = parseTime(l, loc); err != nil {
+				evErr = &ParseError{Line: l.num, Message: "invalid DTEND: " + err.Error()}
+			}
+		case "DURATION":
+			d, err := parseDuration(l.value)
+			if err != nil {
+				evErr = &ParseError{Line: l.num, Message: err.Error()}
+			}
+			duration = &d
+		}
+	}
+	return events, errs, nil
+}
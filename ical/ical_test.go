@@ -0,0 +1,98 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test001_Encode(t *testing.T) {
+	start := time.Date(2026, 1, 12, 9, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	err := Encode(buf, "foo", []*Event{{
+		UID:         "1@manageme",
+		Summary:     "design review, part 1; notes",
+		Description: strings.Repeat("x", 200),
+		Start:       start,
+		End:         start.Add(time.Hour),
+		Stamp:       start,
+	}})
+	assert.Nil(t, err)
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.Contains(t, out, "DTSTART:20260112T090000Z\r\nDTEND:20260112T100000Z\r\n")
+	assert.Contains(t, out, `SUMMARY:design review\, part 1\; notes`)
+	assert.True(t, strings.HasSuffix(out, "END:VEVENT\r\nEND:VCALENDAR\r\n"))
+	for _, l := range strings.Split(out, "\r\n") {
+		assert.True(t, len(l) <= maxLineLength, l)
+	}
+
+	// Round trip
+	events, errs, err := Decode(buf, time.UTC)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(errs))
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "design review, part 1; notes", events[0].Summary)
+	assert.Equal(t, strings.Repeat("x", 200), events[0].Description)
+	assert.True(t, start.Equal(events[0].Start))
+}
+
+func Test002_Decode(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:a",
+		"SUMMARY:berlin",
+		"DTSTART;TZID=Europe/Berlin:20260112T090000",
+		"DURATION:PT1H30M",
+		"BEGIN:VALARM",
+		"DTSTART:19700101T000000Z",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:b",
+		"SUMMARY:floating",
+		"DTSTART:20260112T090000",
+		"DTEND:20260112T100000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:c",
+		"DTSTART:20260112T090000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:d",
+		"DTSTART:yesterday",
+		"DTEND:20260112T090000Z",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	ny, _ := time.LoadLocation("America/New_York")
+	events, errs, err := Decode(strings.NewReader(ics), ny)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(events))
+
+	assert.Equal(t, "a", events[0].UID)
+	assert.Equal(t, time.Date(2026, 1, 12, 8, 0, 0, 0, time.UTC), events[0].Start.UTC())
+	assert.Equal(t, 90*time.Minute, events[0].End.Sub(events[0].Start))
+
+	assert.Equal(t, time.Date(2026, 1, 12, 14, 0, 0, 0, time.UTC), events[1].Start.UTC())
+
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, "line 20: event c: DTEND or DURATION is required", errs[0].Error())
+	assert.Equal(t, "c", errs[0].UID)
+	assert.Equal(t, "d", errs[1].UID)
+}
+
+func Test003_Duration(t *testing.T) {
+	d, err := parseDuration("P1W2DT3H4M5S")
+	assert.Nil(t, err)
+	assert.Equal(t, 9*24*time.Hour+3*time.Hour+4*time.Minute+5*time.Second, d)
+
+	_, err = parseDuration("P1H")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,58 @@
+package importer
+
+import (
+	"fmt"
+	"time"
+)
+
+// genericMapping reads entries with the user, title, description, start
+// and finish of a task, as exported by the ManageMe csv timesheet. start
+// and finish are unix timestamps or RFC 3339 times, or clock times if a
+// date is given. The total rows of a timesheet are skipped
+type genericMapping struct{}
+
+func (genericMapping) envelope() string {
+	return "tasks"
+}
+
+func (genericMapping) record(f fields, loc *time.Location) (*Record, error) {
+	rec := &Record{
+		User:        f.get("user"),
+		Title:       f.get("title"),
+		Description: f.get("description"),
+	}
+	start, finish, date := f.get("start"), f.get("finish"), f.get("date")
+
+	// Skip day and grand totals of a timesheet
+	if rec.Title == "total" && len(rec.User) == 0 && len(start) == 0 && len(finish) == 0 {
+		return nil, nil
+	}
+	if len(start) == 0 {
+		return nil, fmt.Errorf("start is required")
+	}
+	if len(finish) == 0 {
+		return nil, fmt.Errorf("finish is required")
+	}
+
+	var err error
+	if len(date) > 0 {
+		if rec.Start, err = parseDateClock(date, start, loc); err != nil {
+			return nil, err
+		}
+		if rec.Finish, err = parseDateClock(date, finish, loc); err != nil {
+			return nil, err
+		}
+		// Tasks running past midnight finish the next day
+		if rec.Finish.Before(rec.Start) {
+			rec.Finish = rec.Finish.AddDate(0, 0, 1)
+		}
+		return rec, nil
+	}
+	if rec.Start, err = parseTimestamp(start, loc); err != nil {
+		return nil, err
+	}
+	if rec.Finish, err = parseTimestamp(finish, loc); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
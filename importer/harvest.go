@@ -0,0 +1,82 @@
+package importer
+
+import (
+	"fmt"
+	"time"
+)
+
+// harvestMapping reads Harvest exports, the detailed time report as CSV
+// and time entries from the API as JSON. Harvest tracks durations per
+// day, so entries without start and end times are laid out one after
+// the other from defaultDayStart
+type harvestMapping struct {
+	// next is the start of the next entry of a user on a date
+	next map[string]time.Time
+}
+
+func newHarvestMapping() *harvestMapping {
+	return &harvestMapping{next: map[string]time.Time{}}
+}
+
+func (*harvestMapping) envelope() string {
+	return "time_entries"
+}
+
+func (h *harvestMapping) record(f fields, loc *time.Location) (*Record, error) {
+	rec := &Record{
+		User:        f.get("user.name"),
+		Title:       f.get("notes"),
+		Description: joinNonEmpty(" / ", f.get("client"), f.get("project"), f.get("task")),
+	}
+	if len(rec.User) == 0 {
+		rec.User = joinNonEmpty(" ", f.get("first name"), f.get("last name"))
+	}
+
+	// JSON nests the names of related objects
+	if len(rec.Description) == 0 {
+		rec.Description = joinNonEmpty(" / ", f.get("client.name"), f.get("project.name"), f.get("task.name"))
+	}
+	task := f.get("task")
+	if len(task) == 0 {
+		task = f.get("task.name")
+	}
+	if len(rec.Title) == 0 {
+		rec.Title = task
+	}
+
+	date := f.get("date")
+	if len(date) == 0 {
+		date = f.get("spent_date")
+	}
+	if len(date) == 0 {
+		return nil, fmt.Errorf("date is required")
+	}
+	day, err := time.ParseInLocation(dateLayout, date, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %v", date)
+	}
+
+	// Entries tracked with a timer have times
+	if started, ended := f.get("started_time"), f.get("ended_time"); len(started) > 0 && len(ended) > 0 {
+		if rec.Start, err = parseDateClock(date, started, loc); err != nil {
+			return nil, err
+		}
+		if rec.Finish, err = parseDateClock(date, ended, loc); err != nil {
+			return nil, err
+		}
+		return rec, nil
+	}
+
+	hours, err := parseHours(f.get("hours"))
+	if err != nil {
+		return nil, err
+	}
+	key := rec.User + "|" + date
+	start, ok := h.next[key]
+	if !ok {
+		start = time.Date(day.Year(), day.Month(), day.Day(), defaultDayStart, 0, 0, 0, loc)
+	}
+	rec.Start, rec.Finish = start, start.Add(hours)
+	h.next[key] = rec.Finish
+	return rec, nil
+}
@@ -0,0 +1,262 @@
+// Package importer decodes time entries exported from ManageMe or other
+// time trackers, as CSV or JSON, into records that can become tasks
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout = "2006-01-02"
+
+	// entries with a date and duration but no times start at this hour
+	defaultDayStart = 9
+)
+
+// Formats lists the supported export formats
+var Formats = []string{"generic", "toggl", "harvest"}
+
+// Kinds lists the supported encodings
+var Kinds = []string{"csv", "json"}
+
+// Record is a time entry to be imported
+type Record struct {
+	// Row is the line of a CSV record or the index of a JSON one, from 1
+	Row int
+	// User is a username or email, empty for the importing user
+	User        string
+	Title       string
+	Description string
+	Start       time.Time
+	Finish      time.Time
+}
+
+// RowError reports a record that couldn't be decoded
+type RowError struct {
+	Row     int
+	Message string
+}
+
+func (err RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", err.Row, err.Message)
+}
+
+// fields gives access to the values of a record by field name
+type fields interface {
+	get(name string) string
+}
+
+// csvFields looks up values of a CSV record by case insensitive column name
+type csvFields struct {
+	columns map[string]int
+	values  []string
+}
+
+func (f *csvFields) get(name string) string {
+	if i, ok := f.columns[name]; ok && i < len(f.values) {
+		return strings.TrimSpace(f.values[i])
+	}
+	return ""
+}
+
+// jsonFields looks up values of a JSON object by key, nested objects
+// are addressed with dots as in user.name
+type jsonFields map[string]interface{}
+
+func (f jsonFields) get(name string) string {
+	var v interface{} = map[string]interface{}(f)
+	for _, key := range strings.Split(name, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// mapping converts the fields of one format into a record
+type mapping interface {
+	// envelope is the key of the entries in a JSON object, if any
+	envelope() string
+	record(f fields, loc *time.Location) (*Record, error)
+}
+
+func newMapping(format string) (mapping, error) {
+	switch format {
+	case "generic":
+		return genericMapping{}, nil
+	case "toggl":
+		return togglMapping{}, nil
+	case "harvest":
+		return newHarvestMapping(), nil
+	}
+	return nil, fmt.Errorf("unknown import format %v", format)
+}
+
+// Decode reads the records of r exported in format and encoded as kind.
+// Records that can't be decoded are reported as RowErrors alongside the
+// ones that could, while a malformed stream fails entirely. Times without
+// a zone are interpreted in loc
+func Decode(r io.Reader, format, kind string, loc *time.Location) ([]*Record, []*RowError, error) {
+	m, err := newMapping(format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := []*Record{}
+	errs := []*RowError{}
+	add := func(row int, f fields) {
+		rec, err := m.record(f, loc)
+		switch {
+		case err != nil:
+			errs = append(errs, &RowError{Row: row, Message: err.Error()})
+		case rec != nil:
+			rec.Row = row
+			records = append(records, rec)
+		}
+	}
+	fail := func(row int, message string) {
+		errs = append(errs, &RowError{Row: row, Message: message})
+	}
+
+	switch kind {
+	case "csv":
+		err = decodeCSV(r, add)
+	case "json":
+		err = decodeJSON(r, m.envelope(), add, fail)
+	default:
+		err = fmt.Errorf("unknown import type %v", kind)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, errs, nil
+}
+
+func decodeCSV(r io.Reader, add func(int, fields)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		// Excel prefixes UTF-8 files with a byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for {
+		values, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		add(line, &csvFields{columns: columns, values: values})
+	}
+}
+
+func decodeJSON(r io.Reader, envelope string, add func(int, fields), fail func(int, string)) error {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var body interface{}
+	if err := d.Decode(&body); err != nil {
+		return err
+	}
+
+	// Entries are either the top level array or wrapped in an object
+	if obj, ok := body.(map[string]interface{}); ok && len(envelope) > 0 {
+		body = obj[envelope]
+	}
+	entries, ok := body.([]interface{})
+	if !ok {
+		if len(envelope) > 0 {
+			return fmt.Errorf("expected an array of entries or an object with %v", envelope)
+		}
+		return fmt.Errorf("expected an array of entries")
+	}
+	for i, e := range entries {
+		obj, ok := e.(map[string]interface{})
+		if !ok {
+			fail(i+1, "entry is not an object")
+			continue
+		}
+		add(i+1, jsonFields(obj))
+	}
+	return nil
+}
+
+// parseTimestamp reads a unix timestamp or an RFC 3339 time,
+// falling back to a date and time without zone in loc
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %v", s)
+}
+
+// parseDateClock reads a date and a clock time such as 09:00, 09:00:00 or 9:00am in loc
+func parseDateClock(date, clock string, loc *time.Location) (time.Time, error) {
+	clock = strings.ToLower(strings.Replace(clock, " ", "", -1))
+	for _, layout := range []string{"15:04", "15:04:05", "3:04pm", "3pm"} {
+		if t, err := time.ParseInLocation(dateLayout+" "+layout, date+" "+clock, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %v %v", date, clock)
+}
+
+// parseHours reads a duration in decimal hours such as 1.5 or as 1:30
+func parseHours(s string) (time.Duration, error) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		h, errH := strconv.Atoi(s[:i])
+		m, errM := strconv.Atoi(s[i+1:])
+		if errH == nil && errM == nil && h >= 0 && m >= 0 && m < 60 {
+			return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+		}
+	} else if h, err := strconv.ParseFloat(s, 64); err == nil && h >= 0 {
+		return time.Duration(h * float64(time.Hour)).Round(time.Second), nil
+	}
+	return 0, fmt.Errorf("invalid hours %v", s)
+}
+
+// joinNonEmpty joins the non empty parts with sep
+func joinNonEmpty(sep string, parts ...string) string {
+	kept := []string{}
+	for _, p := range parts {
+		if len(p) > 0 {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, sep)
+}
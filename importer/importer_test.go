@@ -0,0 +1,112 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, format, kind, body string) ([]*Record, []*RowError) {
+	records, errs, err := Decode(strings.NewReader(body), format, kind, time.UTC)
+	assert.Nil(t, err)
+	return records, errs
+}
+
+func Test001_Unknown(t *testing.T) {
+	_, _, err := Decode(strings.NewReader(""), "clockify", "csv", time.UTC)
+	assert.Equal(t, "unknown import format clockify", err.Error())
+	_, _, err = Decode(strings.NewReader(""), "generic", "xml", time.UTC)
+	assert.Equal(t, "unknown import type xml", err.Error())
+	_, _, err = Decode(strings.NewReader(`{"foo": []}`), "generic", "json", time.UTC)
+	assert.Equal(t, "expected an array of entries or an object with tasks", err.Error())
+}
+
+func Test002_Generic(t *testing.T) {
+	// The csv timesheet export round trips
+	records, errs := decode(t, "generic", "csv", `date,start,finish,duration,user,title,description
+2026-01-12,09:00,10:00,3600,alice,foo,bar
+2026-01-12,23:30,00:30,3600,alice,late,
+2026-01-12,,,5400,,total,
+2026-01-13,nine,10:00,3600,alice,baz,
+,,,9000,,total,
+`)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, &Record{
+		Row: 2, User: "alice", Title: "foo", Description: "bar",
+		Start:  time.Date(2026, 1, 12, 9, 0, 0, 0, time.UTC),
+		Finish: time.Date(2026, 1, 12, 10, 0, 0, 0, time.UTC),
+	}, records[0])
+	assert.Equal(t, time.Date(2026, 1, 13, 0, 30, 0, 0, time.UTC), records[1].Finish)
+	assert.Equal(t, []*RowError{{Row: 5, Message: "invalid time 2026-01-13 nine"}}, errs)
+
+	records, errs = decode(t, "generic", "json", `[
+		{"title": "foo", "start": 1768208400, "finish": "2026-01-12T10:00:00Z"},
+		{"title": "bar", "start": 1768208400},
+		"baz"
+	]`)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, int64(1768208400), records[0].Start.Unix())
+	assert.Equal(t, int64(1768212000), records[0].Finish.Unix())
+	assert.Equal(t, []*RowError{
+		{Row: 2, Message: "finish is required"},
+		{Row: 3, Message: "entry is not an object"},
+	}, errs)
+}
+
+func Test003_Toggl(t *testing.T) {
+	records, errs := decode(t, "toggl", "csv", "\ufeff"+`User,Email,Client,Project,Task,Description,Billable,Start date,Start time,End date,End time,Duration,Tags
+Alice,alice@example.com,Acme,Website,,Fix header,Yes,2026-01-12,09:00:00,2026-01-12,10:30:00,01:30:00,
+Alice,alice@example.com,,,,,No,2026-01-12,11:00:00,2026-01-12,11:15:00,00:15:00,
+`)
+	assert.Equal(t, 0, len(errs))
+	assert.Equal(t, &Record{
+		Row: 2, User: "alice@example.com", Title: "Fix header", Description: "Acme / Website",
+		Start:  time.Date(2026, 1, 12, 9, 0, 0, 0, time.UTC),
+		Finish: time.Date(2026, 1, 12, 10, 30, 0, 0, time.UTC),
+	}, records[0])
+	assert.Equal(t, "(no description)", records[1].Title)
+
+	records, errs = decode(t, "toggl", "json", `{"data": [
+		{"user": "Alice", "description": "Standup", "project": "Internal",
+		 "start": "2026-01-12T09:00:00+01:00", "end": "2026-01-12T09:15:00+01:00"},
+		{"description": "Running", "start": "2026-01-12T10:00:00Z", "stop": null}
+	]}`)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "Alice", records[0].User)
+	assert.Equal(t, "Internal", records[0].Description)
+	assert.Equal(t, int64(1768205700), records[0].Finish.Unix())
+	assert.Equal(t, []*RowError{{Row: 2, Message: "entry is still running"}}, errs)
+}
+
+func Test004_Harvest(t *testing.T) {
+	// Durations are laid out from 9am per user and day
+	records, errs := decode(t, "harvest", "csv", `Date,Client,Project,Project Code,Task,Notes,Hours,Hours Rounded,Billable?,First Name,Last Name
+2026-01-12,Acme,Website,,Design,Mockups,1.5,1.5,Yes,Alice,Smith
+2026-01-12,Acme,Website,,Design,,0:45,0.75,Yes,Alice,Smith
+2026-01-12,Acme,Website,,Design,Review,2,2,Yes,Bob,Jones
+2026-01-12,Acme,Website,,Design,Oops,lots,2,Yes,Bob,Jones
+`)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, &Record{
+		Row: 2, User: "Alice Smith", Title: "Mockups", Description: "Acme / Website / Design",
+		Start:  time.Date(2026, 1, 12, 9, 0, 0, 0, time.UTC),
+		Finish: time.Date(2026, 1, 12, 10, 30, 0, 0, time.UTC),
+	}, records[0])
+	assert.Equal(t, "Design", records[1].Title)
+	assert.Equal(t, time.Date(2026, 1, 12, 10, 30, 0, 0, time.UTC), records[1].Start)
+	assert.Equal(t, time.Date(2026, 1, 12, 11, 15, 0, 0, time.UTC), records[1].Finish)
+	assert.Equal(t, time.Date(2026, 1, 12, 9, 0, 0, 0, time.UTC), records[2].Start)
+	assert.Equal(t, []*RowError{{Row: 5, Message: "invalid hours lots"}}, errs)
+
+	records, errs = decode(t, "harvest", "json", `{"time_entries": [
+		{"spent_date": "2026-01-12", "hours": 1.0, "notes": null, "started_time": "1:00pm", "ended_time": "2:00pm",
+		 "user": {"name": "Alice Smith"}, "project": {"name": "Website"}, "task": {"name": "Design"}}
+	]}`)
+	assert.Equal(t, 0, len(errs))
+	assert.Equal(t, "Design", records[0].Title)
+	assert.Equal(t, "Website / Design", records[0].Description)
+	assert.Equal(t, time.Date(2026, 1, 12, 13, 0, 0, 0, time.UTC), records[0].Start)
+	assert.Equal(t, time.Date(2026, 1, 12, 14, 0, 0, 0, time.UTC), records[0].Finish)
+}
@@ -0,0 +1,58 @@
+package importer
+
+import (
+	"fmt"
+	"time"
+)
+
+// togglMapping reads Toggl Track exports, the detailed report as CSV
+// or as JSON from the reports API, and time entries from the API
+type togglMapping struct{}
+
+func (togglMapping) envelope() string {
+	return "data"
+}
+
+func (togglMapping) record(f fields, loc *time.Location) (*Record, error) {
+	rec := &Record{
+		User:        f.get("email"),
+		Title:       f.get("description"),
+		Description: joinNonEmpty(" / ", f.get("client"), f.get("project"), f.get("task")),
+	}
+	if len(rec.User) == 0 {
+		rec.User = f.get("user")
+	}
+	if len(rec.Title) == 0 {
+		rec.Title = "(no description)"
+	}
+
+	var err error
+	if date := f.get("start date"); len(date) > 0 {
+		// CSV has separate date and time columns
+		if rec.Start, err = parseDateClock(date, f.get("start time"), loc); err != nil {
+			return nil, err
+		}
+		if rec.Finish, err = parseDateClock(f.get("end date"), f.get("end time"), loc); err != nil {
+			return nil, err
+		}
+		return rec, nil
+	}
+
+	start, end := f.get("start"), f.get("end")
+	if len(end) == 0 {
+		end = f.get("stop")
+	}
+	if len(start) == 0 {
+		return nil, fmt.Errorf("start is required")
+	}
+	if len(end) == 0 {
+		return nil, fmt.Errorf("entry is still running")
+	}
+	if rec.Start, err = parseTimestamp(start, loc); err != nil {
+		return nil, err
+	}
+	if rec.Finish, err = parseTimestamp(end, loc); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
@@ -1,26 +1,162 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 
-	"github.com/briansan/ManageMeServer/api"
+	"github.com/mgutz/logxi/v1"
+
+	"github.com/briansan/ManageMeServer/config"
 	"github.com/briansan/ManageMeServer/model/store"
-	"github.com/briansan/ManageMeServer/www"
 )
 
-const (
-	envMode = "MANAGEME_SERVER_MODE"
+var (
+	logger = log.New("main")
+
+	// stdout is where commands print their results
+	stdout io.Writer = os.Stdout
 )
 
-func main() {
-	if mode := os.Getenv(envMode); mode == "api" {
-		err := store.InitMongoSession()
-		if err != nil {
-			panic(err)
+// command is a subcommand of manageme
+type command struct {
+	// name is the words calling the command, e.g. user create
+	name string
+	// args describes the arguments following the flags, if any
+	args    string
+	summary string
+	// define defines the flags of the command on fs, returning how to
+	//   run it with the config and the arguments left
+	define func(fs *flag.FlagSet) func(cfg *config.Config, args []string) error
+}
+
+// commands are the subcommands of manageme, the first runs when none is
+//   named
+var commands = []*command{
+	serveCommand,
+	userCreateCommand,
+	userSetRoleCommand,
+	userResetPasswordCommand,
+	userListCommand,
+	migrateCommand,
+	seedCommand,
+	exportCommand,
+	importCommand,
+	backupCommand,
+	restoreCommand,
+	doctorCommand,
+}
+
+// usageError is a mistake in the command line or the config
+type usageError struct {
+	error
+}
+
+// exit reports err and exits with code
+func exit(code int, err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(code)
+}
+
+// usage lists the commands to w
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: manageme [command] [flags] [args]")
+	fmt.Fprintln(w, "\ncommands, serve by default:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-22s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nrun manageme <command> -h for the flags of a command")
+}
+
+// find returns the command named by the first words of args, and the
+//   arguments following them
+func find(args []string) (*command, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commands[0], args
+	}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):]
+		}
+	}
+	return nil, args
+}
+
+// run runs the command of args, the command line without the program name
+func run(args []string) error {
+	if len(args) == 1 && args[0] == "help" {
+		usage(stdout)
+		return nil
+	}
+	cmd, args := find(args)
+	if cmd == nil {
+		usage(os.Stderr)
+		return usageError{fmt.Errorf("unknown command %q", strings.Join(args, " "))}
+	}
+
+	fs := flag.NewFlagSet("manageme "+cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		line := strings.TrimSpace(fmt.Sprintf("manageme %s [flags] %s", cmd.name, cmd.args))
+		fmt.Fprintf(fs.Output(), "usage: %s\n\n%s\n\nflags:\n", line, cmd.summary)
+		fs.PrintDefaults()
+	}
+	exec := cmd.define(fs)
+	cfg, err := config.LoadFlags(fs, args)
+	if err == flag.ErrHelp {
+		return err
+	} else if err != nil {
+		return usageError{fmt.Errorf("invalid config: %v", err)}
+	}
+	return exec(cfg, fs.Args())
+}
+
+// withStore runs fn with a store of the database of cfg, connected by
+//   connect as the server does unless told otherwise
+func withStore(cfg *config.Config, connect func(*config.Store) error, fn func(db *store.MongoStore) error) error {
+	if err := cfg.ValidateStore(); err != nil {
+		return usageError{fmt.Errorf("invalid config: %v", err)}
+	}
+	if err := connect(&cfg.Store); err != nil {
+		return err
+	}
+	defer store.CleanupMongoSession()
+
+	db, err := store.NewMongoStore()
+	if err != nil {
+		return err
+	}
+	defer db.Cleanup()
+	return fn(db)
+}
+
+// expectArgs checks the number of arguments of a command
+func expectArgs(args []string, n int) error {
+	if len(args) != n {
+		return usageError{fmt.Errorf("expected %d argument(s), got %d", n, len(args))}
+	}
+	return nil
+}
+
+// isOneOf reports whether v is one of values
+func isOneOf(v string, values []string) bool {
+	for _, value := range values {
+		if v == value {
+			return true
 		}
+	}
+	return false
+}
 
-		api.New().Start(":8888")
-	} else {
-		www.New().Start(":8889")
+func main() {
+	err := run(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if _, ok := err.(usageError); ok {
+		exit(2, err)
+	} else if err != nil {
+		exit(1, err)
 	}
 }
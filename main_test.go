@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFind(t *testing.T) {
+	cmd, args := find(nil)
+	assert.Equal(t, serveCommand, cmd)
+	assert.Empty(t, args)
+
+	// Flags alone serve, as before there were commands
+	cmd, args = find([]string{"-mode", "api"})
+	assert.Equal(t, serveCommand, cmd)
+	assert.Equal(t, []string{"-mode", "api"}, args)
+
+	cmd, args = find([]string{"user", "set-role", "-store.database", "test", "bob", "admin"})
+	assert.Equal(t, userSetRoleCommand, cmd)
+	assert.Equal(t, []string{"-store.database", "test", "bob", "admin"}, args)
+
+	cmd, _ = find([]string{"user"})
+	assert.Nil(t, cmd)
+}
+
+func TestRun(t *testing.T) {
+	out := &bytes.Buffer{}
+	stdout = out
+	defer func() { stdout = os.Stdout }()
+
+	assert.NoError(t, run([]string{"help"}))
+	assert.Contains(t, out.String(), "user reset-password")
+
+	// Mistakes are reported before connecting to mongo
+	usage := map[string][]string{
+		`unknown command "user remove bob"`:                                              {"user", "remove", "bob"},
+		"expected 2 argument(s), got 1":                                                  {"user", "set-role", "bob"},
+		`unknown role "boss", expected user, manager or admin`:                           {"user", "set-role", "bob", "boss"},
+		"email field is required as string":                                              {"user", "create", "-username", "bob"},
+		`unknown format "xls", expected csv, html, pdf or md`:                            {"export", "-format", "xls"},
+		"expected 1 argument(s), got 0":                                                  {"restore", "-replace"},
+		"invalid config: store.overlapPolicy field is required as allow, warn or reject": {"user", "list", "-store.overlapPolicy", "never"},
+	}
+	for msg, args := range usage {
+		err := run(args)
+		if assert.IsType(t, usageError{}, err, msg) {
+			assert.EqualError(t, err, msg)
+		}
+	}
+
+	assert.Equal(t, flag.ErrHelp, run([]string{"doctor", "-h"}))
+}
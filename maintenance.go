@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/briansan/ManageMeServer/config"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+var migrateCommand = &command{
+	name:    "migrate",
+	summary: "apply the pending migrations of the database and ensure its indexes",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		dryRun := fs.Bool("dry-run", false, "list the pending migrations without applying them")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 0); err != nil {
+				return err
+			}
+
+			return withStore(cfg, store.ConnectMongoSession, func(db *store.MongoStore) error {
+				from, err := db.GetSchemaVersion()
+				if err != nil {
+					return err
+				}
+				migrations, err := db.Migrate(*dryRun)
+				verb := "applied"
+				if *dryRun {
+					verb = "would apply"
+				}
+				for _, m := range migrations {
+					fmt.Fprintf(stdout, "%s %d: %s\n", verb, m.Version, m.Description)
+				}
+				if err != nil {
+					return err
+				}
+				if len(migrations) == 0 {
+					fmt.Fprintf(stdout, "schema version %d is up to date\n", from)
+				}
+				return nil
+			})
+		}
+	},
+}
+
+// check is the outcome of a check of doctor, ok if problem is empty
+type check struct {
+	name, problem string
+}
+
+var doctorCommand = &command{
+	name:    "doctor",
+	summary: "check the config, the connection to mongo, the schema version and the indexes",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 0); err != nil {
+				return err
+			}
+			checks := []*check{}
+			failed := 0
+			report := func(name string, err error) bool {
+				c := &check{name: name}
+				if err != nil {
+					c.problem = err.Error()
+					failed++
+				}
+				checks = append(checks, c)
+				return err == nil
+			}
+
+			// The database is left as is, so it shows what the server would find
+			report("config", cfg.Validate())
+			if report("store config", cfg.ValidateStore()) {
+				var schema, indexes error
+				err := withStore(cfg, store.ConnectMongoSession, func(db *store.MongoStore) error {
+					version, err := db.GetSchemaVersion()
+					if err == nil && version != store.SchemaVersion {
+						err = fmt.Errorf("version %d, expected %d: run migrate", version, store.SchemaVersion)
+					}
+					schema = err
+
+					missing, err := db.MissingIndexes()
+					if err == nil && len(missing) > 0 {
+						err = fmt.Errorf("missing %s: run migrate", strings.Join(missing, ", "))
+					}
+					indexes = err
+					return nil
+				})
+				if report("mongo", err) {
+					report("schema", schema)
+					report("indexes", indexes)
+				}
+			}
+
+			for _, c := range checks {
+				if len(c.problem) == 0 {
+					fmt.Fprintf(stdout, "ok    %s\n", c.name)
+				} else {
+					fmt.Fprintf(stdout, "FAIL  %s: %s\n", c.name, c.problem)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d check(s) failed", failed)
+			}
+			return nil
+		}
+	},
+}
@@ -0,0 +1,20 @@
+package schema
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Event records a change to a task or user, as sent to webhooks
+//   and streamed from /events
+type Event struct {
+	ID bson.ObjectId `bson:"_id" json:"id"`
+	// Seq numbers the events of every replica in the order they are
+	//   emitted, streams resume after it
+	Seq  int64  `bson:"seq" json:"seq"`
+	Type string `bson:"type" json:"type"`
+	// UserID is the owner of the task or the user that changed
+	UserID bson.ObjectId `bson:"userID" json:"userID"`
+	// Data is the JSON of the task or user
+	Data      string `bson:"data" json:"data"`
+	CreatedAt int    `bson:"createdAt" json:"createdAt"`
+}
@@ -0,0 +1,203 @@
+package schema
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+	"github.com/briansan/ManageMeServer/search"
+)
+
+// Views a saved filter can be run as
+const (
+	ViewTasks   = "tasks"
+	ViewSummary = "summary"
+)
+
+// Groupings of the tasks view
+const (
+	GroupByDay  = "day"
+	GroupByUser = "user"
+	GroupByTag  = "tag"
+)
+
+// Ranges lists the relative date ranges of a saved filter
+var Ranges = []string{
+	"today", "yesterday", "this-week", "last-week",
+	"this-month", "last-month", "last-7-days", "last-30-days",
+}
+
+// Filter is a saved task query of its owner, optionally shared
+type Filter struct {
+	ID      bson.ObjectId `bson:"_id" json:"id"`
+	OwnerID bson.ObjectId `bson:"ownerID" json:"ownerID"`
+	Name    string        `bson:"name" json:"name"`
+	// SharedWith lists the other users who can see and run the filter
+	SharedWith []bson.ObjectId `bson:"sharedWith" json:"sharedWith"`
+	// Dashboard shows the filter on its owner's dashboard
+	Dashboard bool `bson:"dashboard" json:"dashboard"`
+
+	// UserID narrows the filter down to a single user's tasks
+	UserID *bson.ObjectId `bson:"userID,omitempty" json:"userID,omitempty"`
+	// Query is a search as for GET /tasks?q=
+	Query string `bson:"q" json:"q"`
+	// Range is relative to when the filter is run, else start and finish are used
+	Range     string `bson:"range,omitempty" json:"range,omitempty"`
+	TimeRange `bson:",inline" json:",inline"`
+	// TimeZone of Range, defaulting to the working hours of who runs it
+	TimeZone string `bson:"timeZone,omitempty" json:"timeZone,omitempty"`
+
+	View    string `bson:"view" json:"view"`
+	GroupBy string `bson:"groupBy,omitempty" json:"groupBy,omitempty"`
+}
+
+// FilterPatch holds the fields of a filter to update, see Filter.Patch
+type FilterPatch struct {
+	TimeRange
+
+	Name       *string          `json:"name,omitempty"`
+	SharedWith *[]bson.ObjectId `json:"sharedWith,omitempty"`
+	Dashboard  *bool            `json:"dashboard,omitempty"`
+	UserID     *bson.ObjectId   `json:"userID,omitempty"`
+	Query      *string          `json:"q,omitempty"`
+	Range      *string          `json:"range,omitempty"`
+	TimeZone   *string          `json:"timeZone,omitempty"`
+	View       *string          `json:"view,omitempty"`
+	GroupBy    *string          `json:"groupBy,omitempty"`
+}
+
+// Validate checks the filter, defaulting its view to tasks
+func (f *Filter) Validate() error {
+	if len(f.Name) == 0 {
+		return errors.NewValidationError("name", "string")
+	}
+	if len(f.View) == 0 {
+		f.View = ViewTasks
+	}
+	if f.View != ViewTasks && f.View != ViewSummary {
+		return errors.NewValidationError("view", "tasks or summary")
+	}
+	switch f.GroupBy {
+	case "", GroupByDay, GroupByUser, GroupByTag:
+	default:
+		return errors.NewValidationError("groupBy", "day, user or tag")
+	}
+	if len(f.TimeZone) > 0 {
+		if _, err := time.LoadLocation(f.TimeZone); err != nil {
+			return errors.NewValidationError("timeZone", "IANA time zone")
+		}
+	}
+	if _, err := search.Parse(f.Query, time.UTC); err != nil {
+		return errors.NewValidationError("q", "search, "+err.Error())
+	}
+
+	// Either a relative range, an absolute one or none
+	if len(f.Range) > 0 {
+		for _, r := range Ranges {
+			if f.Range == r {
+				return nil
+			}
+		}
+		return errors.NewValidationError("range", "relative range such as last-week")
+	}
+	if f.Start != nil || f.Finish != nil {
+		return f.TimeRange.Validate()
+	}
+	if f.View == ViewSummary {
+		return errors.NewValidationError("range", "relative range or start and finish for the summary view")
+	}
+	return nil
+}
+
+// Patch applies p to a copy of f
+func (f *Filter) Patch(p *FilterPatch) *Filter {
+	patched := *f
+	if p.Start != nil {
+		patched.Start = p.Start
+	}
+	if p.Finish != nil {
+		patched.Finish = p.Finish
+	}
+	if p.Name != nil {
+		patched.Name = *p.Name
+	}
+	if p.SharedWith != nil {
+		patched.SharedWith = *p.SharedWith
+	}
+	if p.Dashboard != nil {
+		patched.Dashboard = *p.Dashboard
+	}
+	if p.UserID != nil {
+		patched.UserID = p.UserID
+	}
+	if p.Query != nil {
+		patched.Query = *p.Query
+	}
+	if p.Range != nil {
+		patched.Range = *p.Range
+	}
+	if p.TimeZone != nil {
+		patched.TimeZone = *p.TimeZone
+	}
+	if p.View != nil {
+		patched.View = *p.View
+	}
+	if p.GroupBy != nil {
+		patched.GroupBy = *p.GroupBy
+	}
+	return &patched
+}
+
+// Span returns the unix timestamps the filter spans when run at now in loc,
+// ok is false if it isn't restricted to a range. Weeks start on Monday
+func (f *Filter) Span(now time.Time, loc *time.Location) (from, to int, ok bool) {
+	if len(f.Range) == 0 {
+		if f.Start == nil || f.Finish == nil {
+			return 0, 0, false
+		}
+		return *f.Start, *f.Finish, true
+	}
+
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+
+	var start, end time.Time
+	switch f.Range {
+	case "today":
+		start, end = today, today.AddDate(0, 0, 1)
+	case "yesterday":
+		start, end = today.AddDate(0, 0, -1), today
+	case "this-week":
+		start, end = monday, monday.AddDate(0, 0, 7)
+	case "last-week":
+		start, end = monday.AddDate(0, 0, -7), monday
+	case "this-month":
+		start, end = month, month.AddDate(0, 1, 0)
+	case "last-month":
+		start, end = month.AddDate(0, -1, 0), month
+	case "last-7-days":
+		start, end = today.AddDate(0, 0, -6), today.AddDate(0, 0, 1)
+	case "last-30-days":
+		start, end = today.AddDate(0, 0, -29), today.AddDate(0, 0, 1)
+	default:
+		return 0, 0, false
+	}
+	// Ranges are inclusive of to
+	return int(start.Unix()), int(end.Unix()) - 1, true
+}
+
+// CanSee reports whether user owns the filter or it is shared with user
+func (f *Filter) CanSee(userID bson.ObjectId) bool {
+	if f.OwnerID == userID {
+		return true
+	}
+	for _, id := range f.SharedWith {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/briansan/ManageMeServer/errors"
+)
+
+const (
+	clockLayout = "15:04"
+	dateLayout  = "2006-01-02"
+
+	minutesPerDay = 24 * 60
+)
+
+// Weekdays are the keys of WorkingHours.Weekly in time.Weekday order
+var Weekdays = []string{
+	"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday",
+}
+
+// ClockRange defines a start and finish time of day
+type ClockRange struct {
+	// Start is a wall clock time as HH:MM
+	Start string `bson:"start" json:"start"`
+	// Finish is a wall clock time as HH:MM, 24:00 means end of day
+	Finish string `bson:"finish" json:"finish"`
+}
+
+// DayException overrides the weekly schedule on a given date
+type DayException struct {
+	// Date is a calendar date as YYYY-MM-DD in the schedule's time zone
+	Date string `bson:"date" json:"date"`
+	// Intervals replace the weekly intervals, empty means a day off
+	Intervals []ClockRange `bson:"intervals" json:"intervals"`
+	Note      string       `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// WorkingHours defines a user's preferred working hours as a weekly
+// schedule in an IANA time zone with dated exceptions
+type WorkingHours struct {
+	TimeZone   string                  `bson:"timeZone" json:"timeZone"`
+	Weekly     map[string][]ClockRange `bson:"weekly" json:"weekly"`
+	Exceptions []DayException          `bson:"exceptions,omitempty" json:"exceptions,omitempty"`
+}
+
+// parseClock converts HH:MM into minutes since midnight
+func parseClock(s string) (int, bool) {
+	if s == "24:00" {
+		return minutesPerDay, true
+	}
+	t, err := time.Parse(clockLayout, s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// formatClock converts minutes since midnight into HH:MM
+func formatClock(m int) string {
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
+
+func (c *ClockRange) minutes() (int, int) {
+	start, _ := parseClock(c.Start)
+	finish, _ := parseClock(c.Finish)
+	return start, finish
+}
+
+// validateIntervals ensures every interval is well formed
+// and that no two intervals of the same day overlap
+func validateIntervals(field string, intervals []ClockRange) error {
+	for i, c := range intervals {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		start, ok := parseClock(c.Start)
+		if !ok || start == minutesPerDay {
+			return errors.NewValidationError(f+".start", "time (HH:MM)")
+		}
+		finish, ok := parseClock(c.Finish)
+		if !ok {
+			return errors.NewValidationError(f+".finish", "time (HH:MM)")
+		}
+		if start >= finish {
+			return errors.NewValidationError(f+".start", "less than finish")
+		}
+	}
+
+	sorted := append([]ClockRange{}, intervals...)
+	sort.Slice(sorted, func(i, j int) bool {
+		si, _ := sorted[i].minutes()
+		sj, _ := sorted[j].minutes()
+		return si < sj
+	})
+	for i := 1; i < len(sorted); i++ {
+		_, prevFinish := sorted[i-1].minutes()
+		start, _ := sorted[i].minutes()
+		if start < prevFinish {
+			return errors.NewValidationError(field, "non overlapping intervals")
+		}
+	}
+	return nil
+}
+
+// Validate checks the time zone, weekly schedule and exceptions of w,
+//   reporting every violation
+func (w *WorkingHours) Validate() error {
+	errs := errors.ValidationErrors{}
+	if _, err := time.LoadLocation(w.TimeZone); len(w.TimeZone) == 0 || err != nil {
+		errs.Add(errors.NewValidationError("timeZone", "IANA time zone"))
+	}
+	days := make([]string, 0, len(w.Weekly))
+	for day := range w.Weekly {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		if !isWeekday(day) {
+			errs.Add(errors.NewValidationError("weekly."+day, "weekday (sunday-saturday)"))
+			continue
+		}
+		errs.Add(validateIntervals("weekly."+day, w.Weekly[day]))
+	}
+	dates := map[string]bool{}
+	for i, e := range w.Exceptions {
+		f := fmt.Sprintf("exceptions[%d]", i)
+		if _, err := time.Parse(dateLayout, e.Date); err != nil {
+			errs.Add(errors.NewValidationError(f+".date", "date (YYYY-MM-DD)"))
+		} else if dates[e.Date] {
+			errs.Add(errors.NewValidationError(f+".date", "unique date"))
+		}
+		dates[e.Date] = true
+		errs.Add(validateIntervals(f+".intervals", e.Intervals))
+	}
+	return errs.Err()
+}
+
+func isWeekday(day string) bool {
+	for _, d := range Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Location returns the schedule's time zone, UTC if it cannot be loaded
+func (w *WorkingHours) Location() *time.Location {
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// IntervalsOn returns the working intervals of the calendar day containing t
+// in the schedule's time zone, preferring a dated exception over the weekly schedule
+func (w *WorkingHours) IntervalsOn(t time.Time) []ClockRange {
+	t = t.In(w.Location())
+	date := t.Format(dateLayout)
+	for _, e := range w.Exceptions {
+		if e.Date == date {
+			return e.Intervals
+		}
+	}
+	return w.Weekly[strings.ToLower(t.Weekday().String())]
+}
+
+// RangesOn returns the working intervals of the calendar day containing t
+// as absolute time ranges, accounting for DST transitions on that day
+func (w *WorkingHours) RangesOn(t time.Time) []*TimeRange {
+	loc := w.Location()
+	t = t.In(loc)
+	y, m, d := t.Date()
+
+	ranges := []*TimeRange{}
+	for _, c := range w.IntervalsOn(t) {
+		start, finish := c.minutes()
+		ranges = append(ranges, NewTimeRange(
+			int(time.Date(y, m, d, 0, start, 0, 0, loc).Unix()),
+			int(time.Date(y, m, d, 0, finish, 0, 0, loc).Unix()),
+		))
+	}
+	return ranges
+}
+
+// NewWorkingHoursFromTimeRange converts the legacy preferredHours, a time range
+// on 1970-01-01 UTC applied to every day, into an equivalent weekly schedule
+func NewWorkingHoursFromTimeRange(tr *TimeRange) *WorkingHours {
+	w := &WorkingHours{TimeZone: "UTC", Weekly: map[string][]ClockRange{}}
+	if tr == nil || tr.Start == nil || tr.Finish == nil {
+		return w
+	}
+
+	start := (*tr.Start % 86400) / 60
+	finish := start + (*tr.Finish-*tr.Start)/60
+	if finish > minutesPerDay {
+		finish = minutesPerDay
+	}
+	if start >= finish {
+		return w
+	}
+
+	for _, day := range Weekdays {
+		w.Weekly[day] = []ClockRange{{Start: formatClock(start), Finish: formatClock(finish)}}
+	}
+	return w
+}
@@ -0,0 +1,18 @@
+package schema
+
+// Overlap policies decide what happens when a task overlaps
+// another task belonging to the same user
+const (
+	OverlapAllow  = "allow"
+	OverlapWarn   = "warn"
+	OverlapReject = "reject"
+)
+
+// ValidOverlapPolicy reports whether p is a known overlap policy
+func ValidOverlapPolicy(p string) bool {
+	switch p {
+	case OverlapAllow, OverlapWarn, OverlapReject:
+		return true
+	}
+	return false
+}
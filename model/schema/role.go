@@ -1,5 +1,7 @@
 package schema
 
+import "strconv"
+
 const (
 	PermissionCreateUser = 1 << iota
 	PermissionModifySelfTasks
@@ -19,3 +21,20 @@ var (
 func RoleHasPermission(role, perm int) bool {
 	return (role & perm) > 0
 }
+
+// RoleNames are the roles given to users by name
+var RoleNames = map[string]int{
+	"user":    RoleUser,
+	"manager": RoleManager,
+	"admin":   RoleAdmin,
+}
+
+// RoleName returns the name of role, its number if it has none
+func RoleName(role int) string {
+	for name, r := range RoleNames {
+		if r == role {
+			return name
+		}
+	}
+	return strconv.Itoa(role)
+}
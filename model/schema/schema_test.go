@@ -2,10 +2,14 @@ package schema
 
 import (
 	"encoding/json"
+	"time"
 
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
 )
 
 func Test001_Roles(t *testing.T) {
@@ -41,6 +45,11 @@ func Test001_Roles(t *testing.T) {
 	assert.True(t, RoleHasPermission(RoleAdmin, PermissionModifyAllUsersRestricted))
 	assert.True(t, RoleHasPermission(RoleAdmin, PermissionViewAllTasks))
 	assert.True(t, RoleHasPermission(RoleAdmin, PermissionModifyAllTasks))
+
+	// Test names
+	assert.Equal(t, RoleManager, RoleNames["manager"])
+	assert.Equal(t, "admin", RoleName(RoleAdmin))
+	assert.Equal(t, "1", RoleName(RoleAnon))
 }
 
 func Test002_User(t *testing.T) {
@@ -48,15 +57,20 @@ func Test002_User(t *testing.T) {
 	var err error
 	u := User{}
 
-	// Test email field
+	// Test every missing field is reported
 	err = u.Validate()
-	assert.Equal(t, "email field is required as string", err.Error())
+	assert.Equal(t, "email field is required as string; username field is required as string; password field is required as string", err.Error())
+	errs, ok := err.(errors.ValidationErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(errs))
+	assert.Equal(t, "email", errs[0].Field)
 
 	// Test username field
-	json.Unmarshal([]byte(`{"email": "foo"}`), &u)
+	json.Unmarshal([]byte(`{"email": "foo", "password": "baz"}`), &u)
 	err = u.Validate()
 	assert.Equal(t, "username field is required as string", err.Error())
 	assert.Equal(t, "foo", *u.Email)
+	u.Password = nil
 
 	// Test password field
 	json.Unmarshal([]byte(`{"email": "foo", "username": "bar"}`), &u)
@@ -70,21 +84,39 @@ func Test002_User(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "baz", *u.Password)
 
-	// Test preferredHours.finish as wrong type
-	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "preferredHours": {"start": 100, "finish": "200"}}`), &u)
+	// Test workingHours.timeZone is required
+	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "workingHours": {"weekly": {}}}`), &u)
 	err = u.Validate()
-	assert.Equal(t, "finish field is required as unix timestamp (int)", err.Error())
+	assert.Equal(t, "timeZone field is required as IANA time zone", err.Error())
 
-	// Test preferredHours.start < preferredHours.finish
-	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "preferredHours": {"start": 100, "finish": 50}}`), &u)
+	// Test workingHours interval start < finish
+	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "workingHours": {"timeZone": "UTC", "weekly": {"monday": [{"start": "17:00", "finish": "09:00"}]}}}`), &u)
 	err = u.Validate()
-	assert.Equal(t, "start field is required as less than finish", err.Error())
+	assert.Equal(t, "weekly.monday[0].start field is required as less than finish", err.Error())
 
 	// Test all good
-	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "preferredHours": {"start": 100, "finish": 200}}`), &u)
+	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "workingHours": {"timeZone": "UTC", "weekly": {"monday": [{"start": "09:00", "finish": "17:00"}]}}}`), &u)
 	err = u.Validate()
-	assert.Equal(t, 100, *u.PreferredHours.Start)
-	assert.Equal(t, 200, *u.PreferredHours.Finish)
+	assert.Nil(t, err)
+	assert.Equal(t, "09:00", u.WorkingHours.Weekly["monday"][0].Start)
+	assert.Equal(t, "17:00", u.WorkingHours.Weekly["monday"][0].Finish)
+
+	// Test overlapPolicy
+	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "overlapPolicy": "sometimes"}`), &u)
+	err = u.Validate()
+	assert.Equal(t, "overlapPolicy field is required as allow, warn or reject", err.Error())
+
+	// Test violations of working hours are reported with the others
+	u = User{}
+	json.Unmarshal([]byte(`{"email": "foo", "overlapPolicy": "sometimes", "workingHours": {"timeZone": "Mars/Olympus", "weekly": {"funday": []}}}`), &u)
+	err = u.Validate()
+	assert.Equal(t, "username field is required as string; password field is required as string; overlapPolicy field is required as allow, warn or reject; timeZone field is required as IANA time zone; weekly.funday field is required as weekday (sunday-saturday)", err.Error())
+	u = User{}
+
+	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "overlapPolicy": "warn"}`), &u)
+	err = u.Validate()
+	assert.Nil(t, err)
+	assert.Equal(t, OverlapWarn, *u.OverlapPolicy)
 
 	// Test oldPassword
 	json.Unmarshal([]byte(`{"email": "foo", "username": "bar", "password": "baz", "oldPassword": "foobar"}`), &u)
@@ -95,30 +127,224 @@ func Test003_Task(t *testing.T) {
 	// Test time range
 	tr := &TimeRange{}
 	err := tr.Validate()
-	assert.Equal(t, "start field is required as unix timestamp (int)", err.Error())
+	assert.Equal(t, "start field is required as unix timestamp (int); finish field is required as unix timestamp (int)", err.Error())
+	tr = NewTimeRange(2, 1)
+	err = tr.Validate()
+	assert.Equal(t, "start field is required as less than finish", err.Error())
 
-	// Test user field
+	// Test every missing field is reported
 	task := &Task{}
 	err = task.Validate()
-	assert.Equal(t, "user field is required as string", err.Error())
+	assert.Equal(t, "userID field is required as string; title field is required as string; start field is required as unix timestamp (int); finish field is required as unix timestamp (int)", err.Error())
 
 	// Test title field
-	json.Unmarshal([]byte(`{"user": "foo"}`), task)
+	json.Unmarshal([]byte(`{"userID": "5a0a3e7f1b2c3d4e5f607182", "start": 1, "finish": 1}`), task)
 	err = task.Validate()
 	assert.Equal(t, "title field is required as string", err.Error())
 
-	// Test start field
-	json.Unmarshal([]byte(`{"user": "foo", "title": "bar"}`), task)
+	// Test tags and finish fields
+	task = &Task{}
+	json.Unmarshal([]byte(`{"userID": "5a0a3e7f1b2c3d4e5f607182", "title": "bar", "start": 1, "tags": ["two words"]}`), task)
 	err = task.Validate()
-	assert.Equal(t, "start field is required as unix timestamp (int)", err.Error())
-
-	// Test finish field
-	json.Unmarshal([]byte(`{"user": "foo", "title": "bar", "start": 1}`), task)
-	err = task.Validate()
-	assert.Equal(t, "finish field is required as unix timestamp (int)", err.Error())
+	assert.Equal(t, "tags field is required as list of words; finish field is required as unix timestamp (int)", err.Error())
 
 	// Test good
-	json.Unmarshal([]byte(`{"user": "foo", "title": "bar", "start": 1, "finish": 1}`), task)
+	json.Unmarshal([]byte(`{"userID": "5a0a3e7f1b2c3d4e5f607182", "title": "bar", "start": 1, "finish": 1, "tags": ["bar"]}`), task)
 	err = task.Validate()
 	assert.Nil(t, err)
 }
+
+func Test004_WorkingHours(t *testing.T) {
+	w := &WorkingHours{
+		TimeZone: "America/New_York",
+		Weekly: map[string][]ClockRange{
+			"monday": {{Start: "09:00", Finish: "12:00"}, {Start: "13:00", Finish: "17:00"}},
+			"sunday": {{Start: "00:00", Finish: "24:00"}},
+		},
+		Exceptions: []DayException{
+			{Date: "2026-01-05", Intervals: []ClockRange{}, Note: "holiday"},
+		},
+	}
+	assert.Nil(t, w.Validate())
+
+	// Test weekday keys
+	w.Weekly["funday"] = []ClockRange{}
+	assert.Equal(t, "weekly.funday field is required as weekday (sunday-saturday)", w.Validate().Error())
+	delete(w.Weekly, "funday")
+
+	// Test intervals of a day can't overlap
+	w.Weekly["friday"] = []ClockRange{{Start: "13:00", Finish: "17:00"}, {Start: "09:00", Finish: "13:30"}}
+	assert.Equal(t, "weekly.friday field is required as non overlapping intervals", w.Validate().Error())
+
+	// Test intervals are ordered by time, not by text
+	w.Weekly["friday"] = []ClockRange{{Start: "13:00", Finish: "17:00"}, {Start: "9:00", Finish: "12:00"}}
+	assert.NoError(t, w.Validate())
+	delete(w.Weekly, "friday")
+
+	// Test exception dates
+	w.Exceptions = append(w.Exceptions, DayException{Date: "2026-01-05"})
+	assert.Equal(t, "exceptions[1].date field is required as unique date", w.Validate().Error())
+	w.Exceptions = w.Exceptions[:1]
+
+	// Monday 2026-01-12 10:00 in New York follows the weekly schedule
+	monday := time.Date(2026, 1, 12, 15, 0, 0, 0, time.UTC)
+	assert.Equal(t, 2, len(w.IntervalsOn(monday)))
+
+	// Monday 2026-01-05 is a day off
+	holiday := time.Date(2026, 1, 5, 15, 0, 0, 0, time.UTC)
+	assert.Equal(t, 0, len(w.IntervalsOn(holiday)))
+
+	// Monday 2026-01-13 02:00 UTC is still monday in New York
+	assert.Equal(t, 2, len(w.IntervalsOn(time.Date(2026, 1, 13, 2, 0, 0, 0, time.UTC))))
+
+	// Sunday 2026-03-08 is only 23 hours long in New York
+	ranges := w.RangesOn(time.Date(2026, 3, 8, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, 1, len(ranges))
+	assert.Equal(t, 23*3600, *ranges[0].Finish-*ranges[0].Start)
+
+	// Test legacy preferredHours conversion
+	legacy := NewWorkingHoursFromTimeRange(NewTimeRange(9*3600, 17*3600))
+	assert.Nil(t, legacy.Validate())
+	assert.Equal(t, "UTC", legacy.TimeZone)
+	assert.Equal(t, 7, len(legacy.Weekly))
+	assert.Equal(t, ClockRange{Start: "09:00", Finish: "17:00"}, legacy.Weekly["wednesday"][0])
+}
+
+func Test005_Summary(t *testing.T) {
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	user := &UserSecure{
+		ID:       bson.NewObjectId(),
+		Username: "foo",
+		WorkingHours: &WorkingHours{
+			TimeZone: "Europe/Berlin",
+			Weekly: map[string][]ClockRange{
+				"monday":  {{Start: "09:00", Finish: "17:00"}},
+				"tuesday": {{Start: "09:00", Finish: "12:00"}},
+			},
+		},
+	}
+	taskID := bson.NewObjectId()
+	totals := []*DayTotal{
+		{UserID: user.ID, Date: "2026-01-12", Total: 4 * 3600, TaskIDs: []bson.ObjectId{taskID}},
+		{UserID: user.ID, Date: "2026-01-13", Total: 3 * 3600},
+		{UserID: user.ID, Date: "2026-01-14", Total: 3600},
+		{UserID: bson.NewObjectId(), Date: "2026-01-15", Total: 3600},
+	}
+
+	// Monday 00:00 through Thursday 23:59 in Berlin
+	from := int(time.Date(2026, 1, 12, 0, 0, 0, 0, loc).Unix())
+	to := int(time.Date(2026, 1, 15, 23, 59, 0, 0, loc).Unix())
+
+	s := NewUserSummary(user, totals, from, to, loc, DefaultComplianceTolerance)
+	assert.Equal(t, "Europe/Berlin", s.TimeZone)
+	assert.Equal(t, 4, len(s.Days))
+
+	assert.Equal(t, "2026-01-12", s.Days[0].Date)
+	assert.Equal(t, 8*3600, s.Days[0].Preferred)
+	assert.Equal(t, ComplianceUnder, s.Days[0].Compliance)
+	assert.Equal(t, []bson.ObjectId{taskID}, s.Days[0].TaskIDs)
+
+	assert.Equal(t, ComplianceWithin, s.Days[1].Compliance)
+	assert.Equal(t, ComplianceOver, s.Days[2].Compliance)
+
+	// Other users' totals are ignored
+	assert.Equal(t, 0, s.Days[3].Total)
+	assert.Equal(t, ComplianceWithin, s.Days[3].Compliance)
+
+	// A realistic day is within its working hours give or take the tolerance
+	user.WorkingHours.Weekly["friday"] = []ClockRange{{Start: "09:00", Finish: "12:30"}, {Start: "13:30", Finish: "18:00"}}
+	friday := int(time.Date(2026, 1, 16, 12, 0, 0, 0, loc).Unix())
+	for total, want := range map[int]string{
+		7*3600 + 50*60: ComplianceWithin,
+		8*3600 + 10*60: ComplianceWithin,
+		7*3600 + 30*60: ComplianceUnder,
+		8*3600 + 20*60: ComplianceOver,
+	} {
+		totals := []*DayTotal{{UserID: user.ID, Date: "2026-01-16", Total: total}}
+		s = NewUserSummary(user, totals, friday, friday, loc, DefaultComplianceTolerance)
+		assert.Equal(t, 8*3600, s.Days[0].Preferred)
+		assert.Equal(t, want, s.Days[0].Compliance, total)
+	}
+
+	// The tolerance is configurable
+	totals = []*DayTotal{{UserID: user.ID, Date: "2026-01-16", Total: 7*3600 + 50*60}}
+	s = NewUserSummary(user, totals, friday, friday, loc, 0)
+	assert.Equal(t, ComplianceUnder, s.Days[0].Compliance)
+
+	// Days summarized in another zone get the schedule of their date
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	s = NewUserSummary(user, nil, friday, friday, tokyo, DefaultComplianceTolerance)
+	assert.Equal(t, "2026-01-16", s.Days[0].Date)
+	assert.Equal(t, 8*3600, s.Days[0].Preferred)
+
+	// No working hours means no compliance
+	user.WorkingHours = nil
+	s = NewUserSummary(user, totals, from, to, loc, DefaultComplianceTolerance)
+	assert.Equal(t, "", s.Days[0].Compliance)
+
+	// Summary location falls back to UTC
+	l, err := SummaryLocation(user, "")
+	assert.Nil(t, err)
+	assert.Equal(t, time.UTC, l)
+}
+
+func Test006_Filter(t *testing.T) {
+	f := Filter{}
+
+	// Test name field
+	err := f.Validate()
+	assert.Equal(t, "name field is required as string", err.Error())
+
+	// Test view defaults to tasks
+	f.Name = "review"
+	assert.Nil(t, f.Validate())
+	assert.Equal(t, ViewTasks, f.View)
+
+	// Test the query must parse
+	f.Query = `title:"design`
+	err = f.Validate()
+	assert.Equal(t, "q field is required as search, at 7: unterminated quote", err.Error())
+	f.Query = "tag:client-x"
+
+	// Test the summary view needs a range
+	f.View = ViewSummary
+	assert.NotNil(t, f.Validate())
+	f.Range = "next-week"
+	assert.NotNil(t, f.Validate())
+	f.Range = "last-week"
+	assert.Nil(t, f.Validate())
+
+	// Test patching leaves the original alone
+	view, groupBy := ViewTasks, GroupByTag
+	p := f.Patch(&FilterPatch{View: &view, GroupBy: &groupBy})
+	assert.Equal(t, ViewSummary, f.View)
+	assert.Equal(t, ViewTasks, p.View)
+	assert.Equal(t, GroupByTag, p.GroupBy)
+	assert.Equal(t, "last-week", p.Range)
+
+	// Wednesday afternoon in Berlin, weeks start on Monday
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	now := time.Date(2026, 3, 4, 15, 0, 0, 0, loc)
+	from, to, ok := f.Span(now, loc)
+	assert.True(t, ok)
+	assert.Equal(t, int(time.Date(2026, 2, 23, 0, 0, 0, 0, loc).Unix()), from)
+	assert.Equal(t, int(time.Date(2026, 3, 2, 0, 0, 0, 0, loc).Unix())-1, to)
+
+	f.Range = "last-month"
+	from, to, _ = f.Span(now, loc)
+	assert.Equal(t, int(time.Date(2026, 2, 1, 0, 0, 0, 0, loc).Unix()), from)
+	assert.Equal(t, int(time.Date(2026, 3, 1, 0, 0, 0, 0, loc).Unix())-1, to)
+
+	// Test no range
+	f.Range = ""
+	_, _, ok = f.Span(now, loc)
+	assert.False(t, ok)
+
+	// Test visibility
+	f.OwnerID = bson.NewObjectId()
+	other := bson.NewObjectId()
+	assert.True(t, f.CanSee(f.OwnerID))
+	assert.False(t, f.CanSee(other))
+	f.SharedWith = []bson.ObjectId{other}
+	assert.True(t, f.CanSee(other))
+}
@@ -0,0 +1,121 @@
+package schema
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Compliance of a day's total with the user's working hours
+const (
+	ComplianceUnder  = "under"
+	ComplianceWithin = "within"
+	ComplianceOver   = "over"
+
+	// DefaultComplianceTolerance is how far in seconds a day's total may be
+	//   from its working hours and still be within them, unless configured
+	DefaultComplianceTolerance = 15 * 60
+)
+
+// DayTotal is the sum of a user's tasks starting on a calendar day
+type DayTotal struct {
+	UserID  bson.ObjectId   `bson:"userID" json:"userID"`
+	Date    string          `bson:"date" json:"date"`
+	Total   int             `bson:"total" json:"total"`
+	TaskIDs []bson.ObjectId `bson:"taskIDs" json:"taskIDs"`
+}
+
+// DaySummary reports a day's total against the user's working hours
+type DaySummary struct {
+	// Date is a calendar date as YYYY-MM-DD
+	Date string `json:"date"`
+	// Total is the duration of the day's tasks in seconds
+	Total int `json:"total"`
+	// Preferred is the duration of the day's working hours in seconds
+	Preferred int `json:"preferred"`
+	// Compliance is empty if the user has no working hours
+	Compliance string          `json:"compliance,omitempty"`
+	TaskIDs    []bson.ObjectId `json:"taskIDs"`
+}
+
+// UserSummary holds the daily summaries of a user
+type UserSummary struct {
+	UserID   bson.ObjectId `json:"userID"`
+	Username string        `json:"username"`
+	TimeZone string        `json:"timeZone"`
+	Days     []*DaySummary `json:"days"`
+}
+
+// SummaryLocation returns the time zone to summarize a user's days in,
+// which is tz if specified, else the user's working hours time zone, else UTC
+func SummaryLocation(user *UserSecure, tz string) (*time.Location, error) {
+	if len(tz) == 0 && user.WorkingHours != nil {
+		tz = user.WorkingHours.TimeZone
+	}
+	if len(tz) == 0 {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// NewUserSummary lays out the user's totals for every calendar day
+// in loc between the unix timestamps from and to, a day being within its
+// working hours if its total is at most tolerance seconds away
+func NewUserSummary(user *UserSecure, totals []*DayTotal, from, to int, loc *time.Location, tolerance int) *UserSummary {
+	byDate := map[string]*DayTotal{}
+	for _, t := range totals {
+		if t.UserID == user.ID {
+			byDate[t.Date] = t
+		}
+	}
+
+	summary := &UserSummary{
+		UserID:   user.ID,
+		Username: user.Username,
+		TimeZone: loc.String(),
+		Days:     []*DaySummary{},
+	}
+
+	y, m, d := time.Unix(int64(from), 0).In(loc).Date()
+	last := time.Unix(int64(to), 0).In(loc).Format(dateLayout)
+	for day := time.Date(y, m, d, 12, 0, 0, 0, loc); day.Format(dateLayout) <= last; day = day.AddDate(0, 0, 1) {
+		ds := &DaySummary{Date: day.Format(dateLayout), TaskIDs: []bson.ObjectId{}}
+		if t, ok := byDate[ds.Date]; ok {
+			ds.Total = t.Total
+			ds.TaskIDs = t.TaskIDs
+		}
+		if user.WorkingHours != nil {
+			ds.Preferred = preferredOn(user.WorkingHours, ds.Date)
+			ds.Compliance = compliance(ds.Total, ds.Preferred, tolerance)
+		}
+		summary.Days = append(summary.Days, ds)
+	}
+	return summary
+}
+
+// preferredOn returns the seconds of working hours scheduled on date, the
+//   calendar date of the day summarized in its location. The intervals of
+//   that date are those of the schedule, measured in its own time zone so
+//   that its DST transitions count, whichever zone the days are summarized in
+func preferredOn(w *WorkingHours, date string) int {
+	day, err := time.ParseInLocation(dateLayout, date, w.Location())
+	if err != nil {
+		return 0
+	}
+	total := 0
+	for _, r := range w.RangesOn(day.Add(12 * time.Hour)) {
+		total += *r.Finish - *r.Start
+	}
+	return total
+}
+
+// compliance compares total with preferred, give or take tolerance
+func compliance(total, preferred, tolerance int) string {
+	switch {
+	case total < preferred-tolerance:
+		return ComplianceUnder
+	case total > preferred+tolerance:
+		return ComplianceOver
+	}
+	return ComplianceWithin
+}
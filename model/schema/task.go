@@ -1,6 +1,9 @@
 package schema
 
 import (
+	"strings"
+	"unicode"
+
 	"gopkg.in/mgo.v2/bson"
 
 	"github.com/briansan/ManageMeServer/errors"
@@ -15,16 +18,17 @@ type TimeRange struct {
 }
 
 func (t *TimeRange) Validate() error {
+	errs := errors.ValidationErrors{}
 	if t.Start == nil || *t.Start == 0 {
-		return errors.NewValidationError("start", "unix timestamp (int)")
+		errs.Add(errors.NewValidationError("start", "unix timestamp (int)"))
 	}
 	if t.Finish == nil || *t.Finish == 0 {
-		return errors.NewValidationError("finish", "unix timestamp (int)")
+		errs.Add(errors.NewValidationError("finish", "unix timestamp (int)"))
 	}
-	if *t.Start > *t.Finish {
-		return errors.NewValidationError("start", "less than finish")
+	if len(errs) == 0 && *t.Start > *t.Finish {
+		errs.Add(errors.NewValidationError("start", "less than finish"))
 	}
-	return nil
+	return errs.Err()
 }
 
 func NewTimeRange(start, finish int) *TimeRange {
@@ -37,28 +41,61 @@ type Task struct {
 	ID     bson.ObjectId  `bson:"_id" json:"id"`
 	UserID *bson.ObjectId `bson:"userID" json:"userID"`
 
-	User        *string `json:"user,omitempty"`
-	Title       string  `bson:"title" json:"title"`
-	Description string  `bson:"description" json:"description"`
+	User        *string  `json:"user,omitempty"`
+	Title       string   `bson:"title" json:"title"`
+	Description string   `bson:"description" json:"description"`
+	Tags        []string `bson:"tags,omitempty" json:"tags,omitempty"`
+
+	// Revision is incremented every time the task is updated
+	Revision int `bson:"revision" json:"revision"`
+
+	// ICalUID is the UID of the calendar event the task was imported from
+	ICalUID string `bson:"icalUID,omitempty" json:"icalUID,omitempty"`
+
+	// Overlaps lists tasks of the same user that overlap this one
+	//   only populated when the owner's overlap policy is warn
+	Overlaps []bson.ObjectId `bson:"-" json:"overlaps,omitempty"`
 }
 
 type TaskPatch struct {
 	TimeRange `bson:",inline" json:",inline"`
 
-	Title       *string `bson:"title,omitempty" json:"title,omitempty"`
-	Description *string `bson:"description,omitempty" json:"description,omitempty"`
+	Title       *string   `bson:"title,omitempty" json:"title,omitempty"`
+	Description *string   `bson:"description,omitempty" json:"description,omitempty"`
+	Tags        *[]string `bson:"tags,omitempty" json:"tags,omitempty"`
 }
 
+// Validate checks t is a complete new task, reporting every violation
 func (t *Task) Validate() error {
+	errs := errors.ValidationErrors{}
 	if t.UserID == nil {
-		return errors.NewValidationError("userID", "string")
+		errs.Add(errors.NewValidationError("userID", "string"))
 	}
 	if len(t.Title) == 0 {
-		return errors.NewValidationError("title", "string")
+		errs.Add(errors.NewValidationError("title", "string"))
 	}
-	return t.TimeRange.Validate()
+	errs.Add(validateTags(t.Tags))
+	errs.Add(t.TimeRange.Validate())
+	return errs.Err()
+}
+
+// validateTags checks tags are single words
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if len(tag) == 0 || strings.IndexFunc(tag, unicode.IsSpace) >= 0 {
+			return errors.NewValidationError("tags", "list of words")
+		}
+	}
+	return nil
+}
+
+func (t *TaskPatch) Validate() error {
+	if t.Tags != nil {
+		return validateTags(*t.Tags)
+	}
+	return nil
 }
 
 func (t *TaskPatch) NoChange() bool {
-	return t.Title == nil && t.Description == nil && t.TimeRange.Start == nil && t.TimeRange.Finish == nil
+	return t.Title == nil && t.Description == nil && t.Tags == nil && t.TimeRange.Start == nil && t.TimeRange.Finish == nil
 }
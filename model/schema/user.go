@@ -7,35 +7,42 @@ import (
 )
 
 type UserSecure struct {
-	ID             bson.ObjectId `bson:"_id,omitempty" json:"id"`
-	Username       string        `bson:"username" json:"username"`
-	Email          string        `bson:"email" json:"email"`
-	Role           int           `bson:"role" json:"role"`
-	PreferredHours *TimeRange    `bson:"preferredHours" json:"preferredHours"`
+	ID            bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	Username      string        `bson:"username" json:"username"`
+	Email         string        `bson:"email" json:"email"`
+	Role          int           `bson:"role" json:"role"`
+	WorkingHours  *WorkingHours `bson:"workingHours,omitempty" json:"workingHours"`
+	OverlapPolicy string        `bson:"overlapPolicy,omitempty" json:"overlapPolicy,omitempty"`
 }
 
 type User struct {
-	ID             bson.ObjectId `bson:"_id,omitempty" json:"id"`
-	Username       *string       `bson:"username,omitempty" json:"username,omitempty"`
-	OldPassword    *string       `bson:"-" json:"oldPassword,omitempty"`
-	Password       *string       `bson:"password,omitempty" json:"password,omitempty"`
-	Email          *string       `bson:"email,omitempty" json:"email,omitempty"`
-	Role           *int          `bson:"role,omitempty" json:"role"`
-	PreferredHours *TimeRange    `bson:"preferredHours" json:"preferredHours"`
+	ID            bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	Username      *string       `bson:"username,omitempty" json:"username,omitempty"`
+	OldPassword   *string       `bson:"-" json:"oldPassword,omitempty"`
+	Password      *string       `bson:"password,omitempty" json:"password,omitempty"`
+	Email         *string       `bson:"email,omitempty" json:"email,omitempty"`
+	Role          *int          `bson:"role,omitempty" json:"role"`
+	WorkingHours  *WorkingHours `bson:"workingHours,omitempty" json:"workingHours"`
+	OverlapPolicy *string       `bson:"overlapPolicy,omitempty" json:"overlapPolicy,omitempty"`
 }
 
+// Validate checks u is a complete new user, reporting every violation
 func (u *User) Validate() error {
+	errs := errors.ValidationErrors{}
 	if u.Email == nil || len(*u.Email) == 0 {
-		return errors.NewValidationError("email", "string")
+		errs.Add(errors.NewValidationError("email", "string"))
 	}
 	if u.Username == nil || len(*u.Username) == 0 {
-		return errors.NewValidationError("username", "string")
+		errs.Add(errors.NewValidationError("username", "string"))
 	}
 	if u.Password == nil || len(*u.Password) == 0 {
-		return errors.NewValidationError("password", "string")
+		errs.Add(errors.NewValidationError("password", "string"))
 	}
-	if u.PreferredHours != nil {
-		return u.PreferredHours.Validate()
+	if u.OverlapPolicy != nil && !ValidOverlapPolicy(*u.OverlapPolicy) {
+		errs.Add(errors.NewValidationError("overlapPolicy", "allow, warn or reject"))
 	}
-	return nil
+	if u.WorkingHours != nil {
+		errs.Add(u.WorkingHours.Validate())
+	}
+	return errs.Err()
 }
@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"net/url"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+)
+
+// Events webhooks can subscribe to
+const (
+	EventTaskCreated = "task.created"
+	EventTaskUpdated = "task.updated"
+	EventTaskDeleted = "task.deleted"
+	EventUserCreated = "user.created"
+	EventUserDeleted = "user.deleted"
+)
+
+// Events lists every event, TaskEvents those about a single user's tasks
+var (
+	Events     = []string{EventTaskCreated, EventTaskUpdated, EventTaskDeleted, EventUserCreated, EventUserDeleted}
+	TaskEvents = []string{EventTaskCreated, EventTaskUpdated, EventTaskDeleted}
+)
+
+// Statuses of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook subscribes URL to events, deliveries are signed with Secret
+type Webhook struct {
+	ID      bson.ObjectId `bson:"_id" json:"id"`
+	OwnerID bson.ObjectId `bson:"ownerID" json:"ownerID"`
+	URL     string        `bson:"url" json:"url"`
+	Events  []string      `bson:"events" json:"events"`
+	// UserID narrows the events down to a single user, all users if nil
+	UserID *bson.ObjectId `bson:"userID" json:"userID"`
+	// Secret is only shown when the webhook is created
+	Secret string `bson:"secret" json:"secret,omitempty"`
+	Active bool   `bson:"active" json:"active"`
+}
+
+// WebhookPatch holds the fields of a webhook to update, see Webhook.Patch
+type WebhookPatch struct {
+	URL    *string   `json:"url,omitempty"`
+	Events *[]string `json:"events,omitempty"`
+	Active *bool     `json:"active,omitempty"`
+}
+
+// Delivery is an event queued for a webhook in the outbox, along with
+//   the log of its attempts
+type Delivery struct {
+	ID        bson.ObjectId `bson:"_id" json:"id"`
+	WebhookID bson.ObjectId `bson:"webhookID" json:"webhookID"`
+	Event     string        `bson:"event" json:"event"`
+	// Payload is the exact body that is signed and posted
+	Payload string `bson:"payload" json:"payload"`
+	Status  string `bson:"status" json:"status"`
+	// NextAttempt is the unix timestamp the delivery is due at
+	NextAttempt int                `bson:"nextAttempt" json:"nextAttempt,omitempty"`
+	Attempts    []*DeliveryAttempt `bson:"attempts" json:"attempts"`
+	CreatedAt   int                `bson:"createdAt" json:"createdAt"`
+}
+
+// DeliveryAttempt records the outcome of posting a delivery once
+type DeliveryAttempt struct {
+	At         int    `bson:"at" json:"at"`
+	StatusCode int    `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error      string `bson:"error,omitempty" json:"error,omitempty"`
+	// Duration of the request in milliseconds
+	Duration int `bson:"duration" json:"duration"`
+}
+
+// Validate checks the webhook's url and events
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return errors.NewValidationError("url", "http or https url")
+	}
+	if len(w.Events) == 0 {
+		return errors.NewValidationError("events", "list of events")
+	}
+	allowed := Events
+	if w.UserID != nil {
+		allowed = TaskEvents
+	}
+	for _, e := range w.Events {
+		if !hasString(allowed, e) {
+			if w.UserID != nil {
+				return errors.NewValidationError("events", "list of task.created, task.updated or task.deleted for a single user")
+			}
+			return errors.NewValidationError("events", "list of task.created, task.updated, task.deleted, user.created or user.deleted")
+		}
+	}
+	return nil
+}
+
+// Patch applies p to a copy of w
+func (w *Webhook) Patch(p *WebhookPatch) *Webhook {
+	patched := *w
+	if p.URL != nil {
+		patched.URL = *p.URL
+	}
+	if p.Events != nil {
+		patched.Events = *p.Events
+	}
+	if p.Active != nil {
+		patched.Active = *p.Active
+	}
+	return &patched
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
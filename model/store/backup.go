@@ -0,0 +1,398 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/backup"
+)
+
+const (
+	// RestoreMerge inserts the documents of an archive missing from the
+	//   database, leaving the others as they are
+	RestoreMerge = "merge"
+	// RestoreReplace replaces the documents of the database by those of
+	//   an archive
+	RestoreReplace = "replace"
+)
+
+// RestoreModes lists the modes of Restore
+var RestoreModes = []string{RestoreMerge, RestoreReplace}
+
+// restorePrefix prefixes the collections an archive is written to before
+//   they replace those of the database
+const restorePrefix = "restore."
+
+// backupCollections are the collections archived, referenced ones first.
+//   Events are left out, they are a log of changes replayed for a while
+var backupCollections = []string{
+	usersCollectionName,
+	tasksCollectionName,
+	filtersCollectionName,
+	webhooksCollectionName,
+	deliveriesCollectionName,
+}
+
+// backupRefs are the fields of documents referencing documents of another
+//   collection, by collection
+var backupRefs = map[string]map[string]string{
+	tasksCollectionName:      {"userID": usersCollectionName},
+	filtersCollectionName:    {"ownerID": usersCollectionName},
+	webhooksCollectionName:   {"ownerID": usersCollectionName},
+	deliveriesCollectionName: {"webhookID": webhooksCollectionName},
+}
+
+// RestoreResult counts the documents of a collection restored from an
+//   archive
+type RestoreResult struct {
+	Collection string `json:"collection"`
+	// Restored are inserted, or would be on a dry run
+	Restored int `json:"restored"`
+	// Skipped are left as is in merge mode, as their id exists
+	Skipped int `json:"skipped"`
+	// Orphans reference a document found neither in the archive nor the
+	//   database, e.g. the tasks of a deleted user. They are restored
+	//   as the database had them
+	Orphans int `json:"orphans"`
+}
+
+// Backup writes an archive of the database to w. Documents are read in
+//   order of id, so each is archived once even if changed meanwhile, and
+//   those created once the backup started are left out. mgo can't read
+//   the collections at a single point in time, so a document deleted once
+//   its collection is read may leave documents referencing it, counted
+//   as orphans of their collection in the manifest
+func (m *MongoStore) Backup(w io.Writer) (*backup.Manifest, error) {
+	defer m.observe("Backup", time.Now())
+	db := m.GetDatabase()
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	// ids are those of the referenced documents archived, by collection
+	ids := map[string]map[bson.ObjectId]bool{}
+	for _, refs := range backupRefs {
+		for _, ref := range refs {
+			ids[ref] = map[bson.ObjectId]bool{}
+		}
+	}
+
+	// Ids start with their creation time in seconds
+	until := bson.NewObjectIdWithTime(time.Now().Add(time.Second))
+	bw := backup.NewWriter(w, version)
+	for _, name := range backupCollections {
+		iter := db.C(name).Find(bson.M{"_id": bson.M{"$lt": until}}).Sort("_id").Iter()
+		orphans := 0
+		next := func(doc interface{}) bool {
+			if !iter.Next(doc) {
+				return false
+			}
+			d := *doc.(*bson.M)
+			if archived, ok := ids[name]; ok {
+				id, _ := d["_id"].(bson.ObjectId)
+				archived[id] = true
+			}
+			for field, ref := range backupRefs[name] {
+				if target, _ := d[field].(bson.ObjectId); !ids[ref][target] {
+					orphans++
+					break
+				}
+			}
+			return true
+		}
+		c, err := bw.Collection(name, next)
+		if cerr := iter.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, storeError(err)
+		}
+		c.Orphans = orphans
+	}
+	manifest, err := bw.Close()
+	return manifest, storeError(err)
+}
+
+// restorer restores an archive in a database
+type restorer struct {
+	db      *mgo.Database
+	archive *backup.Archive
+	mode    string
+	// ids are those of the documents of the archive by collection
+	ids map[string]map[bson.ObjectId]bool
+	// found caches whether referenced documents missing from the archive
+	//   are in the database
+	found   map[bson.ObjectId]bool
+	results map[string]*RestoreResult
+}
+
+// exists tells whether the document id of the collection name is in the
+//   database
+func (r *restorer) exists(name string, id bson.ObjectId) (bool, error) {
+	n, err := r.db.C(name).FindId(id).Count()
+	return n > 0, err
+}
+
+// check reads the archive, counting the documents to restore. The
+//   archive is rejected if corrupt or if restoring it would fail midway,
+//   before the database is changed
+func (r *restorer) check() error {
+	usernames := map[string]bool{}
+	for _, name := range backupCollections {
+		ids := map[bson.ObjectId]bool{}
+		res := &RestoreResult{Collection: name}
+		err := r.archive.Each(name, func(doc bson.M) error {
+			id, ok := doc["_id"].(bson.ObjectId)
+			if !ok {
+				return fmt.Errorf("%w: %s: _id %v is not an object id", backup.ErrCorrupt, name, doc["_id"])
+			}
+			if ids[id] {
+				return fmt.Errorf("%w: %s: _id %s is duplicated", backup.ErrCorrupt, name, id.Hex())
+			}
+			ids[id] = true
+
+			// A document of the archive is kept in merge mode
+			if r.mode == RestoreMerge {
+				exists, err := r.exists(name, id)
+				if err != nil {
+					return err
+				}
+				if exists {
+					res.Skipped++
+					return nil
+				}
+			}
+			res.Restored++
+
+			// Usernames are unique, the restore would stop at a duplicate
+			if name == usersCollectionName {
+				username, _ := doc["username"].(string)
+				if usernames[username] {
+					return fmt.Errorf("%w: %s: username %q is duplicated", backup.ErrCorrupt, name, username)
+				}
+				usernames[username] = true
+				if r.mode == RestoreMerge {
+					n, err := r.db.C(name).Find(bson.M{"username": username, "_id": bson.M{"$ne": id}}).Count()
+					if err != nil {
+						return err
+					}
+					if n > 0 {
+						return fmt.Errorf("%w: %s %s: username %q belongs to another user", backup.ErrIncompatible, name, id.Hex(), username)
+					}
+				}
+			}
+
+			for field, ref := range backupRefs[name] {
+				target, _ := doc[field].(bson.ObjectId)
+				if r.ids[ref][target] {
+					continue
+				}
+				found, ok := r.found[target]
+				if !ok && r.mode == RestoreMerge && target.Valid() {
+					var err error
+					if found, err = r.exists(ref, target); err != nil {
+						return err
+					}
+					r.found[target] = found
+				}
+				if !found {
+					res.Orphans++
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		r.ids[name] = ids
+		r.results[name] = res
+	}
+	return nil
+}
+
+// insert inserts the documents of the archive name in c, in merge mode
+//   those missing from the collection name of the database
+func (r *restorer) insert(name string, c *mgo.Collection) error {
+	batch := []interface{}{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := c.Insert(batch...)
+		batch = batch[:0]
+		return err
+	}
+	err := r.archive.Each(name, func(doc bson.M) error {
+		if r.mode == RestoreMerge {
+			exists, err := r.exists(name, doc["_id"].(bson.ObjectId))
+			if exists || err != nil {
+				return err
+			}
+		}
+		if batch = append(batch, doc); len(batch) == insertBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// dropRestored drops the collections a replace restore writes to, left
+//   over by one that failed
+func (r *restorer) dropRestored() error {
+	for _, name := range backupCollections {
+		err := r.db.C(restorePrefix + name).DropCollection()
+		if qerr, ok := err.(*mgo.QueryError); ok && (qerr.Code == 26 || qerr.Message == "ns not found") {
+			// It doesn't exist
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace writes the archive in collections of their own, indexed as the
+//   collections they replace, then renames each over its collection once
+//   all are written. A restore failing while writing leaves the database
+//   as it was. Each rename is atomic but not all of them together, one
+//   failing in between, e.g. as mongo went down, leaves the collections
+//   renamed so far restored and is to be fixed by restoring again
+func (r *restorer) replace() error {
+	if err := r.dropRestored(); err != nil {
+		return err
+	}
+	defer r.dropRestored()
+
+	all := indexes()
+	for _, name := range backupCollections {
+		c := r.db.C(restorePrefix + name)
+		if err := c.Create(&mgo.CollectionInfo{}); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, index := range all[name] {
+			if err := c.EnsureIndex(index); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if err := r.insert(name, c); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range backupCollections {
+		err := r.db.Session.Run(bson.D{
+			{Name: "renameCollection", Value: r.db.Name + "." + restorePrefix + name},
+			{Name: "to", Value: r.db.Name + "." + name},
+			{Name: "dropTarget", Value: true},
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	_, err := r.db.C(metaCollectionName).UpsertId(schemaVersionID, bson.M{"$set": bson.M{"version": r.archive.Manifest.SchemaVersion}})
+	return err
+}
+
+// write writes the documents of the archive, merging them in the database
+//   or replacing its collections by mode
+func (r *restorer) write() error {
+	if r.mode == RestoreReplace {
+		if err := r.replace(); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range backupCollections {
+			if err := r.insert(name, r.db.C(name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Calendars are synced again, their tasks may be back to an older state
+	_, err := r.db.C(usersCollectionName).UpdateAll(nil, bson.M{"$set": bson.M{calendarTagField: bson.NewObjectId().Hex()}})
+	return err
+}
+
+// restore checks then writes the archive holding the migration lock, so
+//   no replica migrates the database meanwhile
+func (r *restorer) restore(dryRun bool) error {
+	if dryRun {
+		return r.check()
+	}
+	owner, err := lock(r.db)
+	if err != nil {
+		return err
+	}
+	defer unlock(r.db, owner)
+	if err := r.check(); err != nil {
+		return err
+	}
+	return r.write()
+}
+
+// Restore restores the archive a in mode, merge or replace, once checked
+//   as a whole. In replace mode it may be of an older schema version, then
+//   migrated, while it must be of that of the database to merge it.
+//   Nothing is written on a dry run
+func (m *MongoStore) Restore(a *backup.Archive, mode string, dryRun bool) ([]*RestoreResult, error) {
+	defer m.observe("Restore", time.Now())
+	db := m.GetDatabase()
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	switch archived := a.Manifest.SchemaVersion; {
+	case mode != RestoreMerge && mode != RestoreReplace:
+		return nil, fmt.Errorf("unknown restore mode %q", mode)
+	case mode == RestoreReplace && archived > SchemaVersion:
+		return nil, fmt.Errorf("%w: schema version %d is newer than %d of this build", backup.ErrIncompatible, archived, SchemaVersion)
+	case mode == RestoreMerge && archived != version:
+		return nil, fmt.Errorf("%w: schema version %d, the database has %d", backup.ErrIncompatible, archived, version)
+	}
+	known := map[string]bool{}
+	for _, name := range backupCollections {
+		known[name] = true
+	}
+	for _, c := range a.Manifest.Collections {
+		if !known[c.Name] {
+			return nil, fmt.Errorf("%w: unknown collection %s", backup.ErrIncompatible, c.Name)
+		}
+	}
+
+	r := &restorer{
+		db:      db,
+		archive: a,
+		mode:    mode,
+		ids:     map[string]map[bson.ObjectId]bool{},
+		found:   map[bson.ObjectId]bool{},
+		results: map[string]*RestoreResult{},
+	}
+	if err := r.restore(dryRun); err != nil {
+		return nil, storeError(err)
+	}
+	results := []*RestoreResult{}
+	for _, name := range backupCollections {
+		results = append(results, r.results[name])
+	}
+	if mode == RestoreReplace && !dryRun {
+		if _, err := migrate(db, false); err != nil {
+			return results, storeError(err)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+// feedTokenBytes is the entropy of calendar feed tokens
+const feedTokenBytes = 32
+
+// NewFeedToken generates a random calendar feed token for the user
+// replacing any previous one, only its hash is stored
+// error is 500 if mongo fails, 404 if user doesn't exist, else nil
+func (m *MongoStore) NewFeedToken(userID string) (string, error) {
+	defer m.observe("NewFeedToken", time.Now())
+	b := make([]byte, feedTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	q, err := newUserQueryByID(userID)
+	if err != nil {
+		return "", err
+	}
+	update := bson.M{"$set": bson.M{"feedToken": hash(token)}}
+	if err := m.GetUsersCollection().Update(q, update); err != nil {
+		return "", storeError(err)
+	}
+	return token, nil
+}
+
+// GetUserByFeedToken looks up the user owning the calendar feed token
+func (m *MongoStore) GetUserByFeedToken(token string) (*schema.UserSecure, error) {
+	defer m.observe("GetUserByFeedToken", time.Now())
+	return m.GetUser(bson.M{"feedToken": hash(token)})
+}
+
+// ImportTask creates task, or updates the task of the same user previously
+// imported from the calendar event with the same ICalUID
+// returns true if the task was created
+func (m *MongoStore) ImportTask(task *schema.Task) (bool, error) {
+	defer m.observe("ImportTask", time.Now())
+	if len(task.ICalUID) > 0 && task.UserID != nil {
+		existing, err := m.GetTaskByICalUID(*task.UserID, task.ICalUID)
+		if err != nil && err != ErrNotFound {
+			return false, err
+		}
+		if existing != nil {
+			patch := &schema.TaskPatch{
+				TimeRange:   task.TimeRange,
+				Title:       &task.Title,
+				Description: &task.Description,
+			}
+			updated, err := m.UpdateTask(existing.ID.Hex(), patch)
+			if err != nil {
+				return false, err
+			}
+			*task = *updated
+			return false, nil
+		}
+	}
+	return true, m.CreateTask(task)
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+)
+
+// StoreError is a kind of failure of the store, independent of the
+//   database behind it. Its status and code describe it as a response
+type StoreError struct {
+	msg    string
+	status int
+	code   string
+}
+
+func (err *StoreError) Error() string {
+	return err.msg
+}
+
+// Status is the status of the responses describing err
+func (err *StoreError) Status() int {
+	return err.status
+}
+
+// Code is the problem code of err, empty for the one of its status
+func (err *StoreError) Code() string {
+	return err.code
+}
+
+// The errors of the store don't depend on mongo, any other error it
+//   returns is internal
+var (
+	// ErrNotFound is returned when no document matches
+	ErrNotFound = &StoreError{"not found", http.StatusNotFound, ""}
+	// ErrConflict is returned when a document would duplicate another
+	ErrConflict = &StoreError{"already exists", http.StatusConflict, errors.CodeConflict}
+	// ErrModified is returned when a document changed since it was read
+	ErrModified = &StoreError{"modified concurrently", http.StatusConflict, errors.CodeModified}
+	// ErrInvalidID is returned for malformed ids, before reaching the database
+	ErrInvalidID = &StoreError{"invalid id", http.StatusBadRequest, errors.CodeInvalidID}
+	// ErrUnavailable wraps the failures to reach the database
+	ErrUnavailable = &StoreError{"database unavailable", http.StatusServiceUnavailable, ""}
+)
+
+// unreachable tells whether err is a failure to reach mongo rather than
+//   a reply of mongo. mgo doesn't type the failures of its own, finding no
+//   reachable servers is told by its message
+func unreachable(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.EOF || err.Error() == "no reachable servers"
+}
+
+// storeError converts the errors of mgo to the errors of the store, the
+//   errors already described as a response, such as validation errors, are
+//   kept
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == mgo.ErrNotFound:
+		return ErrNotFound
+	case mgo.IsDup(err):
+		return ErrConflict
+	case errors.ProblemOf(err).Status != http.StatusInternalServerError:
+		return err
+	case unreachable(err):
+		unavailable()
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+// objectID parses the hex id, error is ErrInvalidID if malformed
+func objectID(id string) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(id) {
+		return "", ErrInvalidID
+	}
+	return bson.ObjectIdHex(id), nil
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+const (
+	eventsCollectionName = "events"
+	// eventSeqID is the meta document counting the events emitted
+	eventSeqID = "eventSeq"
+
+	// eventsMaxBytes caps the events kept for replay, oldest go first
+	eventsMaxBytes = 64 << 20
+	// tailTimeout is how long a tail waits for new events between
+	//   checks of whether to stop
+	tailTimeout = time.Second
+
+	// EventSlack is how many sequences before the last event seen a tail
+	//   resumes from, as an event numbered before another may be recorded
+	//   after it
+	EventSlack = 100
+)
+
+// ensureEventCollection creates the capped collection events are tailed from
+func ensureEventCollection(db *mgo.Database) error {
+	c := db.C(eventsCollectionName)
+	err := c.Create(&mgo.CollectionInfo{Capped: true, MaxBytes: eventsMaxBytes})
+	if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == 48 {
+		// Already exists
+		return nil
+	}
+	return err
+}
+
+// GetEventsCollection returns an mgo instance to the events collection
+func (m *MongoStore) GetEventsCollection() *mgo.Collection {
+	return m.GetDatabase().C(eventsCollectionName)
+}
+
+// nextEventSeq counts n events, returning the sequence of the last. Ids
+//   aren't ordered across replicas, their clocks and counters differ
+func nextEventSeq(db *mgo.Database, n int) (int64, error) {
+	doc := struct {
+		Seq int64 `bson:"seq"`
+	}{}
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"seq": n}}, Upsert: true, ReturnNew: true}
+	_, err := db.C(metaCollectionName).FindId(eventSeqID).Apply(change, &doc)
+	return doc.Seq, err
+}
+
+// lastEventSeq returns the sequence of the last event emitted, 0 if none
+func lastEventSeq(db *mgo.Database) (int64, error) {
+	doc := struct {
+		Seq int64 `bson:"seq"`
+	}{}
+	err := db.C(metaCollectionName).FindId(eventSeqID).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	return doc.Seq, err
+}
+
+// emission is an event about userID, emitted along with others of its kind
+type emission struct {
+	userID bson.ObjectId
+	data   interface{}
+}
+
+// emit records event about userID and queues it for the webhooks subscribed
+//   to it. The change emitting it has already been saved, so failures are
+//   logged rather than returned
+func (m *MongoStore) emit(event string, userID bson.ObjectId, data interface{}) {
+	m.emitAll(event, []emission{{userID, data}})
+}
+
+// emitAll records the emissions of event like emit, numbering, inserting
+//   and queueing them at once
+func (m *MongoStore) emitAll(event string, emissions []emission) {
+	now := int(time.Now().Unix())
+	events := []*schema.Event{}
+	for _, e := range emissions {
+		b, err := json.Marshal(e.data)
+		if err != nil {
+			logger.Warn("event encoding failed", "event", event, "err", err)
+			continue
+		}
+		events = append(events, &schema.Event{
+			ID:        bson.NewObjectId(),
+			Type:      event,
+			UserID:    e.userID,
+			Data:      string(b),
+			CreatedAt: now,
+		})
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	last, err := nextEventSeq(m.GetDatabase(), len(events))
+	if err != nil {
+		logger.Warn("event sequence failed", "event", event, "err", err)
+		return
+	}
+	docs := make([]interface{}, len(events))
+	for i, ev := range events {
+		ev.Seq = last - int64(len(events)-1-i)
+		docs[i] = ev
+	}
+	if err := m.GetEventsCollection().Insert(docs...); err != nil {
+		logger.Warn("event insert failed", "event", event, "err", err)
+	}
+	if err := m.queueDeliveries(event, events); err != nil {
+		logger.Warn("webhook queue failed", "event", event, "err", err)
+	}
+}
+
+// GetEventsAfter retrieves up to limit of the events after the sequence
+//   after, in order of sequence
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) GetEventsAfter(after int64, limit int) ([]*schema.Event, error) {
+	defer m.observe("GetEventsAfter", time.Now())
+	events := []*schema.Event{}
+	q := bson.M{"seq": bson.M{"$gt": after}}
+	if err := m.GetEventsCollection().Find(q).Sort("seq").Limit(limit).All(&events); err != nil {
+		return nil, storeError(err)
+	}
+	return events, nil
+}
+
+// TailEvents calls fn with every event after the sequence after, or emitted
+//   from now on if after is negative, as they are recorded by any replica
+//   until stop is closed. A restarted cursor resumes EventSlack sequences
+//   before the last event seen, so fn may be called again with an event
+// error is 500 if mongo fails, else nil once stopped
+func (m *MongoStore) TailEvents(after int64, stop <-chan struct{}, fn func(*schema.Event)) error {
+	stopped := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if after < 0 {
+		var err error
+		if after, err = lastEventSeq(m.GetDatabase()); err != nil {
+			return storeError(err)
+		}
+	}
+
+	c := m.GetEventsCollection()
+	from, last := after, after
+	for !stopped() {
+		iter := c.Find(bson.M{"seq": bson.M{"$gt": from}}).Sort("$natural").Tail(tailTimeout)
+		for {
+			ev := &schema.Event{}
+			for iter.Next(ev) {
+				fn(ev)
+				if ev.Seq > last {
+					last = ev.Seq
+				}
+				ev = &schema.Event{}
+			}
+			if iter.Err() != nil || !iter.Timeout() || stopped() {
+				break
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return storeError(err)
+		}
+		if from = last - EventSlack; from < after {
+			from = after
+		}
+
+		// The cursor dies if there was nothing to tail yet
+		select {
+		case <-stop:
+		case <-time.After(tailTimeout):
+		}
+	}
+	return nil
+}
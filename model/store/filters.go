@@ -0,0 +1,102 @@
+package store
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+const (
+	filtersCollectionName = "filters"
+)
+
+// newFilterQueryForUser matches the filters owned by or shared with userID
+func newFilterQueryForUser(userID bson.ObjectId) bson.M {
+	return bson.M{"$or": []bson.M{{"ownerID": userID}, {"sharedWith": userID}}}
+}
+
+// GetFiltersCollection returns an mgo instance to the filters collection
+func (m *MongoStore) GetFiltersCollection() *mgo.Collection {
+	return m.GetDatabase().C(filtersCollectionName)
+}
+
+// CreateFilter inserts filter object into db
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) CreateFilter(filter *schema.Filter) error {
+	defer m.observe("CreateFilter", time.Now())
+	filter.ID = bson.NewObjectId()
+	if filter.SharedWith == nil {
+		filter.SharedWith = []bson.ObjectId{}
+	}
+	return storeError(m.GetFiltersCollection().Insert(filter))
+}
+
+// GetFilter looks up the filter with given id
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) GetFilter(filterID string) (*schema.Filter, error) {
+	defer m.observe("GetFilter", time.Now())
+	id, err := objectID(filterID)
+	if err != nil {
+		return nil, err
+	}
+	filter := schema.Filter{}
+	if err := m.GetFiltersCollection().FindId(id).One(&filter); err != nil {
+		return nil, storeError(err)
+	}
+	return &filter, nil
+}
+
+// GetFiltersForUser retrieves the filters owned by or shared with userID
+//   optionally only those on its owner's dashboard
+func (m *MongoStore) GetFiltersForUser(userID bson.ObjectId, dashboard bool) ([]*schema.Filter, error) {
+	defer m.observe("GetFiltersForUser", time.Now())
+	q := newFilterQueryForUser(userID)
+	if dashboard {
+		q = bson.M{"ownerID": userID, "dashboard": true}
+	}
+	filters := []*schema.Filter{}
+	if err := m.GetFiltersCollection().Find(q).Sort("name", "_id").All(&filters); err != nil {
+		return nil, storeError(err)
+	}
+	return filters, nil
+}
+
+// UpdateFilter replaces the filter with the same id
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) UpdateFilter(filter *schema.Filter) error {
+	defer m.observe("UpdateFilter", time.Now())
+	if filter.SharedWith == nil {
+		filter.SharedWith = []bson.ObjectId{}
+	}
+	return storeError(m.GetFiltersCollection().UpdateId(filter.ID, filter))
+}
+
+// DeleteFilter removes the filter with given id
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) DeleteFilter(filterID string) error {
+	defer m.observe("DeleteFilter", time.Now())
+	id, err := objectID(filterID)
+	if err != nil {
+		return err
+	}
+	return storeError(m.GetFiltersCollection().RemoveId(id))
+}
+
+// DeleteFiltersForUser removes the filters of userID and stops sharing
+//   others' filters with it
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) DeleteFiltersForUser(userID string) error {
+	defer m.observe("DeleteFiltersForUser", time.Now())
+	id, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	if _, err := m.GetFiltersCollection().RemoveAll(bson.M{"ownerID": id}); err != nil {
+		return storeError(err)
+	}
+	_, err = m.GetFiltersCollection().UpdateAll(bson.M{"sharedWith": id}, bson.M{"$pull": bson.M{"sharedWith": id}})
+	return storeError(err)
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// indexes lists the indexes of each collection declared by the migrations
+func indexes() map[string][]mgo.Index {
+	all := map[string][]mgo.Index{}
+	for _, m := range migrations {
+		for name, list := range m.indexes {
+			all[name] = append(all[name], list...)
+		}
+	}
+	return all
+}
+
+// ensureIndexes creates the indexes of db that are missing
+func ensureIndexes(db *mgo.Database) error {
+	for name, list := range indexes() {
+		for _, index := range list {
+			if err := db.C(name).EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexKey identifies index by its key. Text indexes are listed by mongo
+//   with their fields in any order
+func indexKey(index mgo.Index) string {
+	key := append([]string{}, index.Key...)
+	if len(key) > 0 && strings.HasPrefix(key[0], "$text:") {
+		sort.Strings(key)
+	}
+	return strings.Join(key, ",")
+}
+
+// MissingIndexes lists the indexes of the store that the database lacks,
+//   as collection(key,...)
+func (m *MongoStore) MissingIndexes() ([]string, error) {
+	defer m.observe("MissingIndexes", time.Now())
+	missing := []string{}
+	for name, list := range indexes() {
+		existing, err := m.GetDatabase().C(name).Indexes()
+		if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == 26 {
+			// The collection doesn't exist yet
+			err = nil
+		}
+		if err != nil {
+			return nil, storeError(err)
+		}
+		keys := map[string]bool{}
+		for _, index := range existing {
+			keys[indexKey(index)] = true
+		}
+		for _, index := range list {
+			if !keys[indexKey(index)] {
+				missing = append(missing, fmt.Sprintf("%s(%s)", name, strings.Join(index.Key, ",")))
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/errors"
+)
+
+// ListOptions narrows, orders and pages a listing
+type ListOptions struct {
+	// Query filters the documents, nil matches all
+	Query bson.M
+	// Sort is the field to order by, prefixed with - for descending.
+	// Ties are broken by _id so the order is stable
+	Sort string
+	// Limit is the size of a page, 0 lists everything
+	Limit int
+	// Cursor resumes a listing after the last item of the previous page
+	Cursor *Cursor
+	// Fields restricts the fields fetched, nil fetches whole documents
+	Fields []string
+	// Count requests the number of documents matching Query
+	Count bool
+}
+
+// Page tells where a listing stopped
+type Page struct {
+	// Next resumes the listing, nil on the last page
+	Next *Cursor
+	// Total counts the documents matching Query if requested, else -1
+	Total int
+}
+
+// Cursor is the position of an item in a listing sorted by Sort
+type Cursor struct {
+	Sort  string        `json:"s"`
+	Value interface{}   `json:"v,omitempty"`
+	ID    bson.ObjectId `json:"id"`
+}
+
+// String encodes the cursor as an opaque token
+func (c *Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a token returned by Cursor.String
+func ParseCursor(token string) (*Cursor, error) {
+	invalid := errors.NewValidationError("cursor", "token of a previous page")
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalid
+	}
+	d := json.NewDecoder(strings.NewReader(string(b)))
+	d.UseNumber()
+	c := &Cursor{}
+	if err := d.Decode(c); err != nil || !c.ID.Valid() {
+		return nil, invalid
+	}
+	if n, ok := c.Value.(json.Number); ok {
+		if c.Value, err = n.Int64(); err != nil {
+			return nil, invalid
+		}
+	}
+	return c, nil
+}
+
+// sortField splits a sort parameter into its field and direction
+func sortField(sort string) (string, bool) {
+	if strings.HasPrefix(sort, "-") {
+		return sort[1:], true
+	}
+	return sort, false
+}
+
+// newListQuery adds the position of cursor to the query of opts
+func newListQuery(opts *ListOptions) (bson.M, error) {
+	q := opts.Query
+	if q == nil {
+		q = bson.M{}
+	}
+	if opts.Cursor == nil {
+		return q, nil
+	}
+	if opts.Cursor.Sort != opts.Sort {
+		return nil, errors.NewValidationError("cursor", "token of a listing with the same sort")
+	}
+
+	field, desc := sortField(opts.Sort)
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	after := bson.M{"_id": bson.M{op: opts.Cursor.ID}}
+	if field != "_id" {
+		after = bson.M{"$or": []bson.M{
+			{field: bson.M{op: opts.Cursor.Value}},
+			{field: opts.Cursor.Value, "_id": bson.M{op: opts.Cursor.ID}},
+		}}
+	}
+	if len(q) == 0 {
+		return after, nil
+	}
+	return bson.M{"$and": []bson.M{q, after}}, nil
+}
+
+// list runs the listing described by opts on c into result,
+// a pointer to a slice
+func list(c *mgo.Collection, opts *ListOptions, result interface{}) (*Page, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	if len(opts.Sort) == 0 {
+		opts.Sort = "_id"
+	}
+	q, err := newListQuery(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort stably by the field then by id
+	field, desc := sortField(opts.Sort)
+	sort := []string{opts.Sort}
+	if field != "_id" {
+		id := "_id"
+		if desc {
+			id = "-_id"
+		}
+		sort = append(sort, id)
+	}
+	query := c.Find(q).Sort(sort...)
+
+	// The sort field is needed to resume after the last item
+	if len(opts.Fields) > 0 {
+		selector := bson.M{field: 1}
+		for _, f := range opts.Fields {
+			selector[f] = 1
+		}
+		query = query.Select(selector)
+	}
+
+	// Fetch one more than a page to know whether there is another
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit + 1)
+	}
+	if err := query.All(result); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Total: -1}
+	items := reflect.ValueOf(result).Elem()
+	if opts.Limit > 0 && items.Len() > opts.Limit {
+		items.SetLen(opts.Limit)
+		if page.Next, err = cursorOf(items.Index(opts.Limit-1).Interface(), opts.Sort); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Count {
+		if page.Total, err = c.Find(opts.Query).Count(); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// cursorOf returns the position of item in a listing sorted by sort
+func cursorOf(item interface{}, sort string) (*Cursor, error) {
+	b, err := bson.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(b, doc); err != nil {
+		return nil, err
+	}
+	id, _ := doc["_id"].(bson.ObjectId)
+	field, _ := sortField(sort)
+	c := &Cursor{Sort: sort, ID: id}
+	if field != "_id" {
+		c.Value = doc[field]
+	}
+	return c, nil
+}
+
+// validateSort checks sort names one of fields, optionally descending
+func validateSort(sort string, fields ...string) error {
+	field, _ := sortField(sort)
+	for _, f := range fields {
+		if field == f {
+			return nil
+		}
+	}
+	return errors.NewValidationError("sort", fmt.Sprintf("one of %v, optionally prefixed with -", strings.Join(fields, ", ")))
+}
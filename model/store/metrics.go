@@ -0,0 +1,49 @@
+package store
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/briansan/ManageMeServer/trace"
+)
+
+var (
+	pingSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "manageme_store_ping_seconds",
+		Help: "Latency of the pings of mongo by NewMongoStore by result.",
+	}, []string{"result"})
+	copySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "manageme_store_session_copy_seconds",
+		Help: "Latency of the copies of the mongo session by NewMongoStore.",
+	})
+	methodSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "manageme_store_method_duration_seconds",
+		Help: "Latency of the methods of the store by method.",
+	}, []string{"method"})
+)
+
+// since is the seconds elapsed since start
+func since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// observe records the latency of method since start and its span, deferred
+//   first thing by the methods of the store
+func (m *MongoStore) observe(method string, start time.Time) {
+	methodSeconds.WithLabelValues(method).Observe(since(start))
+
+	// Only the calls of traced requests are traced, not background work
+	if m.ctx == nil || !trace.Traced(m.ctx) {
+		return
+	}
+	_, span := trace.StartAt(m.ctx, "store."+method, trace.KindClient, start)
+	span.SetAttributes(
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.name", databaseName),
+		attribute.String("db.operation", method),
+	)
+	span.End()
+}
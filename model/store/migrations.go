@@ -0,0 +1,309 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// SchemaVersion is the version of the documents this build reads and
+	//   writes, that of its last migration
+	SchemaVersion = 12
+
+	metaCollectionName = "meta"
+	schemaVersionID    = "schemaVersion"
+	migrationLockID    = "migrationLock"
+
+	// lockTTL is how long the migration lock is held before another
+	//   replica takes it over, in case its owner died while migrating
+	lockTTL = 10 * time.Minute
+	// lockRetry is how often a replica waiting for the lock checks it
+	lockRetry = time.Second
+)
+
+// Migration is a change of the database, applied once in the order of
+//   Version which is then recorded as the schema version
+type Migration struct {
+	Version     int
+	Description string
+
+	// check refuses to migrate a database that the migration would
+	//   break, before anything is changed
+	check func(db *mgo.Database) error
+	// indexes are ensured by collection before up runs
+	indexes map[string][]mgo.Index
+	// up changes the documents if needed, it must be safe to run again
+	//   as an interrupted migration is retried
+	up func(db *mgo.Database) error
+}
+
+// migrations are applied in order from version 1. A released migration
+//   is never changed, changes of the schema are appended
+var migrations = []*Migration{
+	{
+		Version:     1,
+		Description: "index users by unique username",
+		indexes: map[string][]mgo.Index{
+			usersCollectionName: {{Key: []string{"username"}, Unique: true}},
+		},
+		check: checkUsernames,
+	},
+	{
+		Version:     2,
+		Description: "index tasks by calendar uid",
+		indexes: map[string][]mgo.Index{
+			tasksCollectionName: {{Key: []string{"userID", "icalUID"}, Sparse: true}},
+		},
+	},
+	{
+		Version:     3,
+		Description: "index filters by owner and share",
+		indexes: map[string][]mgo.Index{
+			filtersCollectionName: {
+				{Key: []string{"ownerID"}},
+				{Key: []string{"sharedWith"}},
+			},
+		},
+	},
+	{
+		Version:     4,
+		Description: "index webhooks by owner and subscribed events",
+		indexes: map[string][]mgo.Index{
+			webhooksCollectionName: {
+				{Key: []string{"ownerID"}},
+				{Key: []string{"active", "events"}},
+			},
+		},
+	},
+	{
+		Version:     5,
+		Description: "index webhook deliveries",
+		indexes: map[string][]mgo.Index{
+			// The outbox is polled for due deliveries and the log listed
+			//   per webhook
+			deliveriesCollectionName: {
+				{Key: []string{"status", "nextAttempt"}},
+				{Key: []string{"webhookID", "_id"}},
+			},
+		},
+	},
+	{
+		Version:     6,
+		Description: "index the text of tasks for search",
+		indexes: map[string][]mgo.Index{
+			tasksCollectionName: {{Key: []string{"$text:title", "$text:description"}}},
+		},
+	},
+	{
+		Version:     7,
+		Description: "index users by feed token",
+		indexes: map[string][]mgo.Index{
+			usersCollectionName: {{Key: []string{"feedToken"}, Sparse: true}},
+		},
+	},
+	{
+		Version:     8,
+		Description: "create the capped events collection",
+		up:          ensureEventCollection,
+	},
+	{
+		Version:     9,
+		Description: "convert preferredHours to workingHours",
+		up:          migratePreferredHours,
+	},
+	{
+		Version:     10,
+		Description: "index tasks by user and time range, and users by email",
+		indexes: map[string][]mgo.Index{
+			tasksCollectionName: {{Key: []string{"userID", "start", "finish"}}},
+			usersCollectionName: {{Key: []string{"email"}}},
+		},
+	},
+	{
+		Version:     11,
+		Description: "index events by sequence, numbering those emitted from now on",
+		indexes: map[string][]mgo.Index{
+			eventsCollectionName: {{Key: []string{"seq"}}},
+		},
+	},
+	{
+		Version:     12,
+		Description: "expire stream tickets",
+		indexes: map[string][]mgo.Index{
+			// Tickets are removed a second after they expire
+			ticketsCollectionName: {{Key: []string{"expiresAt"}, ExpireAfter: time.Second}},
+		},
+	},
+}
+
+// apply checks db, ensures the indexes of m then runs it on db
+func (m *Migration) apply(db *mgo.Database) error {
+	if m.check != nil {
+		if err := m.check(db); err != nil {
+			return err
+		}
+	}
+	for name, list := range m.indexes {
+		for _, index := range list {
+			if err := db.C(name).EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+	}
+	if m.up == nil {
+		return nil
+	}
+	return m.up(db)
+}
+
+// checkUsernames fails listing the usernames shared by several users, as
+//   the unique index can't be built until all but one are renamed
+func checkUsernames(db *mgo.Database) error {
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$username", "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+	dups := []struct {
+		Username string `bson:"_id"`
+		Count    int    `bson:"count"`
+	}{}
+	if err := db.C(usersCollectionName).Pipe(pipeline).All(&dups); err != nil {
+		return err
+	}
+	if len(dups) == 0 {
+		return nil
+	}
+	list := make([]string, len(dups))
+	for i, dup := range dups {
+		list[i] = fmt.Sprintf("%q (%d users)", dup.Username, dup.Count)
+	}
+	return fmt.Errorf("usernames are duplicated, rename all but one user of each: %s", strings.Join(list, ", "))
+}
+
+// pending returns the migrations of a database of the schema version
+func pending(version int) []*Migration {
+	list := []*Migration{}
+	for _, m := range migrations {
+		if m.Version > version {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// schemaVersion returns the version recorded in db, 0 if never migrated
+func schemaVersion(db *mgo.Database) (int, error) {
+	doc := struct {
+		Version int `bson:"version"`
+	}{}
+	err := db.C(metaCollectionName).FindId(schemaVersionID).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	return doc.Version, err
+}
+
+// setSchemaVersion records version unless db has a later one, migrated by
+//   a newer build
+func setSchemaVersion(db *mgo.Database, version int) error {
+	_, err := db.C(metaCollectionName).UpsertId(schemaVersionID, bson.M{"$max": bson.M{"version": version}})
+	return err
+}
+
+// lock takes the migration lock of db, waiting while another replica
+//   holds it. It returns the owner to unlock with
+func lock(db *mgo.Database) (string, error) {
+	c := db.C(metaCollectionName)
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d:%s", host, os.Getpid(), bson.NewObjectId().Hex())
+	for waited := false; ; waited = true {
+		now := time.Now()
+		err := c.Insert(bson.M{"_id": migrationLockID, "owner": owner, "expires": now.Add(lockTTL)})
+		if err == nil {
+			return owner, nil
+		} else if !mgo.IsDup(err) {
+			return "", err
+		}
+
+		// Take over the lock of a replica that died
+		held := bson.M{"owner": owner, "expires": now.Add(lockTTL)}
+		err = c.Update(bson.M{"_id": migrationLockID, "expires": bson.M{"$lt": now}}, bson.M{"$set": held})
+		if err == nil {
+			return owner, nil
+		} else if err != mgo.ErrNotFound {
+			return "", err
+		}
+		if !waited {
+			logger.Info("waiting for the migrations of another replica")
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// unlock releases the migration lock of db held by owner
+func unlock(db *mgo.Database, owner string) {
+	err := db.C(metaCollectionName).Remove(bson.M{"_id": migrationLockID, "owner": owner})
+	if err != nil && err != mgo.ErrNotFound {
+		logger.Warn("migration unlock failed", "err", err)
+	}
+}
+
+// migrate applies the pending migrations of db holding the migration
+//   lock, then ensures every index in case one was dropped. If dryRun, the
+//   pending migrations are returned and db is left as is
+func migrate(db *mgo.Database, dryRun bool) ([]*Migration, error) {
+	if dryRun {
+		version, err := schemaVersion(db)
+		if err != nil {
+			return nil, err
+		}
+		return pending(version), nil
+	}
+
+	owner, err := lock(db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(db, owner)
+
+	// Read once locked, as another replica may have just migrated
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	applied := []*Migration{}
+	for _, m := range pending(version) {
+		logger.Info("migrating", "version", m.Version, "description", m.Description)
+		if err := m.apply(db); err != nil {
+			return applied, fmt.Errorf("migration %d: %v", m.Version, err)
+		}
+		if err := setSchemaVersion(db, m.Version); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, ensureIndexes(db)
+}
+
+// GetSchemaVersion returns the version of the documents of the database,
+//   0 if never recorded
+func (m *MongoStore) GetSchemaVersion() (int, error) {
+	defer m.observe("GetSchemaVersion", time.Now())
+	version, err := schemaVersion(m.GetDatabase())
+	return version, storeError(err)
+}
+
+// Migrate applies the pending migrations of the database, as done when
+//   the server connects unless disabled. If dryRun, they are returned
+//   without being applied
+func (m *MongoStore) Migrate(dryRun bool) ([]*Migration, error) {
+	defer m.observe("Migrate", time.Now())
+	applied, err := migrate(m.GetDatabase(), dryRun)
+	return applied, storeError(err)
+}
@@ -1,79 +1,146 @@
 package store
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/mgutz/logxi/v1"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"gopkg.in/mgo.v2"
+
+	"github.com/briansan/ManageMeServer/config"
+	"github.com/briansan/ManageMeServer/trace"
 )
 
 const (
-	envMongoAuth    = "MANAGEME_MONGO_AUTH"
-	envMongoHost    = "MANAGEME_MONGO_HOST"
-	envDatabaseName = "MANAGEME_MONGO_DATABASE"
+	dialTimeout = 10 * time.Second
+
+	// minReconnect and maxReconnect bound the backoff between redials
+	minReconnect = time.Second
+	maxReconnect = 30 * time.Second
 )
 
 var (
 	logger = log.New("store")
 
-	mongoAuth, mongoHost, databaseName string
+	mongoAuth, mongoHost, databaseName, overlapPolicy string
+
+	// complianceTolerance is the seconds a day's total may be off its
+	//   working hours and still be within them
+	complianceTolerance int
 
-	defMongoAuth    = "mdbmanageme:manageme"
-	defMongoHost    = "localhost:27017"
-	defDatabaseName = "manageme"
+	// autoMigrate tells whether the pending migrations are applied on
+	//   connecting
+	autoMigrate bool
 
-	mongo *mgo.Session
+	// mongo is the session stores copy, replaced when redialed
+	mongoMu sync.RWMutex
+	mongo   *mgo.Session
+
+	// lost wakes Reconnect when mongo is found unreachable
+	lost = make(chan struct{}, 1)
 )
 
 type MongoStore struct {
 	s *mgo.Session
+	// ctx is the context of the request the store serves, parent of the
+	//   spans of its methods
+	ctx context.Context
 }
 
 func getMongoURL() string {
+	if len(mongoAuth) == 0 {
+		return fmt.Sprintf("mongodb://%v/%v", mongoHost, databaseName)
+	}
 	return fmt.Sprintf("mongodb://%v@%v/%v", mongoAuth, mongoHost, databaseName)
 }
 
-// InitMongoSession resets the mongo session pointer with updated connection info
-func InitMongoSession() error {
-	// To avoid a socket leak
-	if mongo != nil {
-		CleanupMongoSession()
+// setSession replaces the mongo session by s, closing the former one
+func setSession(s *mgo.Session) {
+	mongoMu.Lock()
+	old := mongo
+	mongo = s
+	mongoMu.Unlock()
+	if old != nil {
+		old.Close()
 	}
+}
 
-	// Reset connection params
-	warn := func(env, def string) {
-		logger.Warn(fmt.Sprintf("env.%s not defined, defaulting to %v", env, def))
-	}
-	if mongoAuth = os.Getenv(envMongoAuth); len(mongoAuth) == 0 {
-		warn(envMongoAuth, defMongoAuth)
-		mongoAuth = defMongoAuth
+// connect dials mongo
+func connect() (*mgo.Session, error) {
+	return mgo.DialWithTimeout(getMongoURL(), dialTimeout)
+}
+
+// setup migrates db if migrations run on connecting, else warns if its
+//   schema is behind
+func setup(db *mgo.Database) error {
+	if autoMigrate {
+		_, err := migrate(db, false)
+		return err
 	}
-	if mongoHost = os.Getenv(envMongoHost); len(mongoHost) == 0 {
-		warn(envMongoHost, defMongoHost)
-		mongoHost = defMongoHost
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
 	}
-	if databaseName = os.Getenv(envDatabaseName); len(databaseName) == 0 {
-		warn(envDatabaseName, defDatabaseName)
-		databaseName = defDatabaseName
+	if version < SchemaVersion {
+		logger.Warn("schema is behind, run manageme migrate", "version", version, "expected", SchemaVersion)
 	}
+	return nil
+}
+
+// configure sets the connection info of cfg
+func configure(cfg *config.Store) {
+	mongoAuth = cfg.Auth
+	mongoHost = cfg.Host
+	databaseName = cfg.Database
+	overlapPolicy = cfg.OverlapPolicy
+	complianceTolerance = cfg.ComplianceTolerance
+	autoMigrate = cfg.Migrate
+}
 
-	// Establish new session
+// InitMongoSession resets the mongo session pointer with the connection
+//   info of cfg, which must be valid
+func InitMongoSession(cfg *config.Store) error {
+	// To avoid a socket leak
+	CleanupMongoSession()
+	configure(cfg)
+
+	// Establish new session, set up once rather than on every redial
 	logger.Debug("init mongo", "host", mongoHost, "url", getMongoURL())
-	var err error
-	if mongo, err = mgo.Dial(getMongoURL()); err != nil {
+	s, err := connect()
+	if err != nil {
 		return err
 	}
+	if err := setup(s.DB(databaseName)); err != nil {
+		s.Close()
+		return err
+	}
+	setSession(s)
+	return nil
+}
 
-	// Ensure indicies
-	ensureUserIndex()
+// ConnectMongoSession resets the mongo session like InitMongoSession,
+//   leaving the database as is to inspect or migrate it
+func ConnectMongoSession(cfg *config.Store) error {
+	CleanupMongoSession()
+	configure(cfg)
 
+	s, err := connect()
+	if err != nil {
+		return err
+	}
+	setSession(s)
 	return nil
 }
 
 // CleanupMongoSession closes the current session and sets the pointer to nil
 func CleanupMongoSession() {
+	mongoMu.Lock()
+	defer mongoMu.Unlock()
 	if mongo == nil {
 		return
 	}
@@ -83,8 +150,66 @@ func CleanupMongoSession() {
 	mongo = nil
 }
 
+// recovered tells whether the current session reaches mongo
+func recovered() bool {
+	mongoMu.RLock()
+	if mongo == nil {
+		mongoMu.RUnlock()
+		return false
+	}
+	s := mongo.Copy()
+	mongoMu.RUnlock()
+	defer s.Close()
+	return s.Ping() == nil
+}
+
+// unavailable asks Reconnect to redial mongo
+func unavailable() {
+	select {
+	case lost <- struct{}{}:
+	default:
+	}
+}
+
+// Reconnect redials mongo with backoff whenever a store finds it
+//   unreachable, until stop is closed
+func Reconnect(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-lost:
+		}
+
+		retry := minReconnect
+		for {
+			// The session may have recovered by itself
+			if recovered() {
+				break
+			}
+			s, err := connect()
+			if err == nil {
+				setSession(s)
+				logger.Info("reconnected to mongo", "host", mongoHost)
+				break
+			}
+			logger.Warn("mongo reconnection failed", "err", err, "retry", retry)
+			select {
+			case <-stop:
+				return
+			case <-time.After(retry):
+			}
+			if retry *= 2; retry > maxReconnect {
+				retry = maxReconnect
+			}
+		}
+	}
+}
+
 // Nuke destroys the database if it is in a test environment
 func Nuke() error {
+	mongoMu.RLock()
+	defer mongoMu.RUnlock()
 	if testing := os.Getenv("TESTING"); testing == "true" && mongo != nil {
 		return mongo.DB(databaseName).DropDatabase()
 	}
@@ -92,12 +217,45 @@ func Nuke() error {
 }
 
 // NewMongoStore returns an instance of the store with a copied mongo session
-// error is 500 if mongo ping fails
+// error is ErrUnavailable if mongo ping fails
 func NewMongoStore() (*MongoStore, error) {
-	if err := mongo.Ping(); err != nil {
-		return nil, err
+	return NewMongoStoreContext(context.Background())
+}
+
+// NewMongoStoreContext returns a store like NewMongoStore, tracing its
+//   methods as children of the span of ctx if any
+func NewMongoStoreContext(ctx context.Context) (*MongoStore, error) {
+	// The span of an untraced ctx does nothing
+	span := oteltrace.SpanFromContext(ctx)
+	if trace.Traced(ctx) {
+		_, span = trace.Start(ctx, "store.NewMongoStore", trace.KindClient)
+		defer span.End()
+		span.SetAttributes(attribute.String("db.system", "mongodb"))
+	}
+
+	// The session is copied before pinging, as it is closed once replaced
+	start := time.Now()
+	mongoMu.RLock()
+	if mongo == nil {
+		mongoMu.RUnlock()
+		unavailable()
+		trace.SetError(span, ErrUnavailable)
+		return nil, ErrUnavailable
+	}
+	s := mongo.Copy()
+	mongoMu.RUnlock()
+	copySeconds.Observe(since(start))
+
+	start = time.Now()
+	if err := s.Ping(); err != nil {
+		pingSeconds.WithLabelValues("error").Observe(since(start))
+		s.Close()
+		unavailable()
+		trace.SetError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
 	}
-	return &MongoStore{s: mongo.Copy()}, nil
+	pingSeconds.WithLabelValues("ok").Observe(since(start))
+	return &MongoStore{s: s, ctx: ctx}, nil
 }
 
 // Cleanup closes the mongo session of this store object
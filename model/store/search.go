@@ -0,0 +1,81 @@
+package store
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/search"
+)
+
+var (
+	// durationOps maps the comparisons of a duration term to mongo
+	durationOps = map[string]string{"=": "$eq", "<": "$lt", "<=": "$lte", ">": "$gt", ">=": "$gte"}
+	// negatedOps maps comparisons to their opposite
+	negatedOps = map[string]string{"$eq": "$ne", "$lt": "$gte", "$lte": "$gt", "$gt": "$lte", "$gte": "$lt"}
+)
+
+// containsText matches value anywhere in field ignoring case
+func containsText(field, value string) bson.M {
+	return bson.M{field: bson.RegEx{Pattern: regexp.QuoteMeta(value), Options: "i"}}
+}
+
+// NewTaskSearchQuery compiles q into a mongo query, userIDs maps the
+//   usernames of q's user terms to their ids. Words and phrases are looked
+//   up in the text index of the title and description, each quoted so that
+//   all must appear. The index can't exclude documents, so negated ones
+//   must not appear in the title or description ignoring case
+func NewTaskSearchQuery(q *search.Query, userIDs map[string]bson.ObjectId) bson.M {
+	clauses := []bson.M{}
+	texts := []string{}
+	for _, t := range q.Terms {
+		var clause bson.M
+		switch t := t.(type) {
+		case *search.Text:
+			if !t.Negated() {
+				texts = append(texts, `"`+strings.Replace(t.Value, `"`, ``, -1)+`"`)
+				continue
+			}
+			clause = bson.M{"$or": []bson.M{containsText("title", t.Value), containsText("description", t.Value)}}
+		case *search.Match:
+			clause = containsText(t.Field, t.Value)
+		case *search.Tag:
+			clause = bson.M{"tags": t.Name}
+		case *search.User:
+			clause = bson.M{"userID": userIDs[t.Username]}
+		case *search.Duration:
+			// Negating the comparison keeps $expr out of $nor
+			op := durationOps[t.Op]
+			if t.Negated() {
+				op = negatedOps[op]
+			}
+			clauses = append(clauses, bson.M{"$expr": bson.M{
+				op: []interface{}{bson.M{"$subtract": []string{"$finish", "$start"}}, int(t.Value.Seconds())},
+			}})
+			continue
+		case *search.Date:
+			from, to := int(t.From.Unix()), int(t.To.Unix())
+			switch t.Op {
+			case "before":
+				clause = bson.M{"start": bson.M{"$lt": from}}
+			case "after":
+				clause = bson.M{"start": bson.M{"$gte": to}}
+			case "on":
+				clause = bson.M{"start": bson.M{"$gte": from, "$lt": to}}
+			}
+		}
+		if t.Negated() {
+			clause = bson.M{"$nor": []bson.M{clause}}
+		}
+		clauses = append(clauses, clause)
+	}
+
+	if len(texts) > 0 {
+		clauses = append(clauses, bson.M{"$text": bson.M{"$search": strings.Join(texts, " ")}})
+	}
+	if len(clauses) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": clauses}
+}
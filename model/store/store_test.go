@@ -1,13 +1,25 @@
 package store
 
 import (
-	"os"
+	"bytes"
+	goerrors "errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 
+	"github.com/briansan/ManageMeServer/backup"
+	"github.com/briansan/ManageMeServer/config"
+	"github.com/briansan/ManageMeServer/errors"
 	"github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/search"
 )
 
 type StoreTestSuite struct {
@@ -17,15 +29,17 @@ type StoreTestSuite struct {
 
 func (suite *StoreTestSuite) SetupTest() {
 	// Use test database and reestablish session
-	os.Setenv(envDatabaseName, "test")
-	InitMongoSession()
+	cfg, err := config.Load([]string{"-mode", "api", "-store.database", "test", "-api.secret", "test_secret"})
+	suite.Require().NoError(err)
+	InitMongoSession(&cfg.Store)
 
-	var err error
 	suite.store, err = NewMongoStore()
 	suite.Nil(err)
 
 	suite.store.GetUsersCollection().RemoveAll(nil)
 	suite.store.GetTasksCollection().RemoveAll(nil)
+	suite.store.GetWebhooksCollection().RemoveAll(nil)
+	suite.store.GetDeliveriesCollection().RemoveAll(nil)
 }
 
 func TestExampleTestSuite(t *testing.T) {
@@ -65,6 +79,12 @@ func (suite *StoreTestSuite) Test001_User() {
 	suite.Equal(username, user.Username)
 	suite.Equal(email, user.Email)
 
+	// Test GetUsersByIDs
+	found, err := suite.store.GetUsersByIDs([]bson.ObjectId{user.ID, bson.NewObjectId()})
+	suite.Nil(err)
+	suite.Equal(1, len(found))
+	suite.Equal(username, found[0].Username)
+
 	// Test GetUserByPassword
 	user, err = suite.store.GetUserByCreds(username, pw)
 	suite.Nil(err)
@@ -99,7 +119,7 @@ func (suite *StoreTestSuite) Test001_User() {
 	suite.True(mgo.IsDup(err))
 
 	// Test GetAllUsers
-	users, err := suite.store.GetAllUsers()
+	users, _, err := suite.store.GetAllUsers(nil)
 	suite.Nil(err)
 	suite.Equal(len(users), 2)
 
@@ -114,7 +134,7 @@ func (suite *StoreTestSuite) Test001_User() {
 // Test002_Admin asserts proper admin insertion
 func (suite *StoreTestSuite) Test002_Admin() {
 	// Create admin
-	err := suite.store.AdminExistsOrCreate("test_secret")
+	err := suite.store.AdminExistsOrCreate("boss", "bk@breadtech.com", "test_secret")
 	suite.Nil(err)
 
 	// Fetch admin
@@ -169,56 +189,58 @@ func (suite *StoreTestSuite) Test003_Task() {
 	// Test GetAllTasksByUserID
 	q, err := NewTaskQueryFromParams(user.ID.Hex(), "", "", "")
 	suite.NoError(err)
-	tasks1, err := suite.store.GetAllTasks(q)
+	tasks1, _, err := suite.store.GetAllTasks(&ListOptions{Query: q})
 	suite.NoError(err)
 	suite.Equal(1, len(tasks1))
 
 	t := tasks1[0]
-	getTask, err := suite.store.GetTask(newTaskQueryByID(t.ID.Hex()))
+	q, err = newTaskQueryByID(t.ID.Hex())
+	suite.NoError(err)
+	getTask, err := suite.store.GetTask(q)
 	suite.NoError(err)
 	suite.Equal(t, getTask)
 
 	// Test GetAllTasksByTimeRange
 	q, err = NewTaskQueryFromParams("", "", "1", "50")
 	suite.NoError(err)
-	tasks2, err := suite.store.GetAllTasks(q)
+	tasks2, _, err := suite.store.GetAllTasks(&ListOptions{Query: q})
 	suite.NoError(err)
 	suite.Equal(0, len(tasks2))
 
 	q, err = NewTaskQueryFromParams("", "", "1", "100")
 	suite.NoError(err)
-	tasks2, err = suite.store.GetAllTasks(q)
+	tasks2, _, err = suite.store.GetAllTasks(&ListOptions{Query: q})
 	suite.NoError(err)
 	suite.Equal(1, len(tasks2))
 
 	q, err = NewTaskQueryFromParams("", "", "95", "105")
 	suite.NoError(err)
-	tasks2, err = suite.store.GetAllTasks(q)
+	tasks2, _, err = suite.store.GetAllTasks(&ListOptions{Query: q})
 	suite.NoError(err)
 	suite.Equal(1, len(tasks2))
 
 	q, err = NewTaskQueryFromParams("", "", "900", "1000")
 	suite.NoError(err)
-	tasks2, err = suite.store.GetAllTasks(q)
+	tasks2, _, err = suite.store.GetAllTasks(&ListOptions{Query: q})
 	suite.NoError(err)
 	suite.Equal(1, len(tasks2))
 
 	q, err = NewTaskQueryFromParams("", "", "1001", "1002")
 	suite.NoError(err)
-	tasks2, err = suite.store.GetAllTasks(q)
+	tasks2, _, err = suite.store.GetAllTasks(&ListOptions{Query: q})
 	suite.NoError(err)
 	suite.Equal(0, len(tasks2))
 
 	// Test GetAllTasksByUserIDTimeRange
 	q, err = NewTaskQueryFromParams(user.ID.Hex(), "", "1", "50")
 	suite.NoError(err)
-	tasks3, err := suite.store.GetAllTasks(q)
+	tasks3, _, err := suite.store.GetAllTasks(&ListOptions{Query: q})
 	suite.NoError(err)
 	suite.Equal(0, len(tasks3))
 
 	q, err = NewTaskQueryFromParams(user.ID.Hex(), "", "100", "101")
 	suite.NoError(err)
-	tasks3, err = suite.store.GetAllTasks(q)
+	tasks3, _, err = suite.store.GetAllTasks(&ListOptions{Query: q})
 	suite.NoError(err)
 	suite.Equal(1, len(tasks3))
 
@@ -250,12 +272,37 @@ func (suite *StoreTestSuite) Test003_Task() {
 	suite.Equal(*newTr.Start, *newT.TimeRange.Start)
 	suite.Equal(*newTr.Finish, *newT.TimeRange.Finish)
 
+	// A patch finishing the task before it starts is invalid
+	early := *newTr.Start - 1
+	_, err = suite.store.UpdateTask(t.ID.Hex(), &schema.TaskPatch{TimeRange: schema.TimeRange{Finish: &early}})
+	suite.Equal(http.StatusBadRequest, errors.ProblemOf(err).Status)
+
+	// A task is only updated at the revision it was read at
+	_, err = suite.store.UpdateTaskRevision(t.ID.Hex(), newT.Revision-1, &patchTask)
+	suite.Equal(ErrModified, err)
+	newT, err = suite.store.UpdateTaskRevision(t.ID.Hex(), newT.Revision, &patchTask)
+	suite.NoError(err)
+
 	// Test DeleteTask
 	delT, err := suite.store.DeleteTask(newT.ID.Hex())
 	suite.Nil(err)
 	suite.NotNil(user)
 	suite.Equal(delT, newT)
 
+	// Test CreateTasks reports the tasks of a failed batch once another is inserted
+	bulk := []*schema.Task{}
+	for i := 0; i <= insertBatchSize; i++ {
+		bulk = append(bulk, &schema.Task{TimeRange: *schema.NewTimeRange(i*60, i*60+30), UserID: &user.ID})
+	}
+	bulk[insertBatchSize].Description = strings.Repeat("x", 17<<20)
+	failed, err := suite.store.CreateTasks(bulk, false)
+	suite.Nil(err)
+	suite.Len(failed, 1)
+	suite.NotNil(failed[insertBatchSize])
+	n, _ := suite.store.GetTasksCollection().Find(bson.M{"userID": user.ID}).Count()
+	suite.Equal(insertBatchSize, n)
+	suite.store.GetTasksCollection().RemoveAll(bson.M{"userID": user.ID})
+
 	// Test DeleteUser
 	user, err = suite.store.DeleteUser(id)
 	suite.Nil(err)
@@ -263,3 +310,529 @@ func (suite *StoreTestSuite) Test003_Task() {
 	suite.Equal(username, user.Username)
 	suite.Equal(email, user.Email)
 }
+
+// Test004_Overlap asserts overlap policies are applied to tasks
+func (suite *StoreTestSuite) Test004_Overlap() {
+	username := "foo"
+	email := "bar"
+	pw := "baz"
+	policy := schema.OverlapWarn
+
+	newUser := &schema.User{
+		Username:      &username,
+		Email:         &email,
+		Password:      &pw,
+		OverlapPolicy: &policy,
+	}
+	err := suite.store.CreateUser(newUser)
+	suite.Nil(err)
+
+	// First task has nothing to overlap with
+	task1 := schema.Task{
+		TimeRange: *schema.NewTimeRange(100, 200),
+		UserID:    &newUser.ID,
+		Title:     "foo",
+	}
+	err = suite.store.CreateTask(&task1)
+	suite.NoError(err)
+	suite.Nil(task1.Overlaps)
+
+	// Second task is created with a warning
+	task2 := schema.Task{
+		TimeRange: *schema.NewTimeRange(150, 250),
+		UserID:    &newUser.ID,
+		Title:     "bar",
+	}
+	err = suite.store.CreateTask(&task2)
+	suite.NoError(err)
+	suite.Equal([]bson.ObjectId{task1.ID}, task2.Overlaps)
+
+	// Moving it out of the way clears the warning
+	patch := schema.TaskPatch{TimeRange: *schema.NewTimeRange(300, 400)}
+	t, err := suite.store.UpdateTask(task2.ID.Hex(), &patch)
+	suite.NoError(err)
+	suite.Nil(t.Overlaps)
+
+	// Reject policy refuses to move it back
+	policy = schema.OverlapReject
+	_, err = suite.store.UpdateUser(newUser.ID.Hex(), &schema.User{OverlapPolicy: &policy})
+	suite.NoError(err)
+
+	patch = schema.TaskPatch{TimeRange: *schema.NewTimeRange(150, 250)}
+	t, err = suite.store.UpdateTask(task2.ID.Hex(), &patch)
+	suite.Nil(t)
+	overlap, ok := err.(*errors.OverlapError)
+	suite.True(ok)
+	suite.Equal([]string{task1.ID.Hex()}, overlap.TaskIDs)
+
+	// Allow policy lets it through
+	policy = schema.OverlapAllow
+	_, err = suite.store.UpdateUser(newUser.ID.Hex(), &schema.User{OverlapPolicy: &policy})
+	suite.NoError(err)
+
+	t, err = suite.store.UpdateTask(task2.ID.Hex(), &patch)
+	suite.NoError(err)
+	suite.Nil(t.Overlaps)
+
+	// Reject policy is refused while tasks overlap
+	policy = schema.OverlapReject
+	_, err = suite.store.UpdateUser(newUser.ID.Hex(), &schema.User{OverlapPolicy: &policy})
+	overlap, ok = err.(*errors.OverlapError)
+	suite.True(ok)
+	suite.Equal([]string{task1.ID.Hex(), task2.ID.Hex()}, overlap.TaskIDs)
+
+	// Warn policy reports overlaps on edits of other fields too
+	policy = schema.OverlapWarn
+	_, err = suite.store.UpdateUser(newUser.ID.Hex(), &schema.User{OverlapPolicy: &policy})
+	suite.NoError(err)
+	title := "baz"
+	t, err = suite.store.UpdateTask(task2.ID.Hex(), &schema.TaskPatch{Title: &title})
+	suite.NoError(err)
+	suite.Equal([]bson.ObjectId{task1.ID}, t.Overlaps)
+
+	// Tasks back to back don't overlap
+	patch = schema.TaskPatch{TimeRange: *schema.NewTimeRange(200, 300)}
+	_, err = suite.store.UpdateTask(task2.ID.Hex(), &patch)
+	suite.NoError(err)
+	policy = schema.OverlapReject
+	_, err = suite.store.UpdateUser(newUser.ID.Hex(), &schema.User{OverlapPolicy: &policy})
+	suite.NoError(err)
+	task3 := schema.Task{
+		TimeRange: *schema.NewTimeRange(300, 400),
+		UserID:    &newUser.ID,
+		Title:     "qux",
+	}
+	suite.NoError(suite.store.CreateTask(&task3))
+
+	// Rows of an import can't overlap each other either
+	for _, dryRun := range []bool{true, false} {
+		bulk := []*schema.Task{}
+		for _, r := range [][2]int{{500, 600}, {550, 650}, {600, 700}} {
+			bulk = append(bulk, &schema.Task{TimeRange: *schema.NewTimeRange(r[0], r[1]), UserID: &newUser.ID, Title: "bulk"})
+		}
+		failed, err := suite.store.CreateTasks(bulk, dryRun)
+		suite.NoError(err)
+		suite.Equal(1, len(failed))
+		overlap, ok = failed[1].(*errors.OverlapError)
+		suite.True(ok)
+		suite.Equal([]string{bulk[0].ID.Hex()}, overlap.TaskIDs)
+	}
+}
+
+// Test005_List asserts cursor pagination is stable across equal sort values
+func (suite *StoreTestSuite) Test005_List() {
+	userID := bson.NewObjectId()
+	for i, title := range []string{"c", "a", "b", "a", "d"} {
+		task := schema.Task{
+			TimeRange: *schema.NewTimeRange(100*(i+1), 100*(i+1)+50),
+			UserID:    &userID,
+			Title:     title,
+		}
+		suite.NoError(suite.store.CreateTask(&task))
+	}
+
+	// Walk the pages sorted by title descending
+	opts := &ListOptions{Sort: "-title", Limit: 2, Count: true, Fields: []string{"start"}}
+	titles := []string{}
+	pages := 0
+	for {
+		tasks, page, err := suite.store.GetAllTasks(opts)
+		suite.NoError(err)
+		suite.Equal(5, page.Total)
+		for _, t := range tasks {
+			titles = append(titles, t.Title)
+			suite.Empty(t.Description)
+		}
+		pages++
+		if page.Next == nil {
+			break
+		}
+
+		// The cursor survives a round trip
+		opts.Cursor, err = ParseCursor(page.Next.String())
+		suite.NoError(err)
+	}
+	suite.Equal([]string{"d", "c", "b", "a", "a"}, titles)
+	suite.Equal(3, pages)
+
+	// Unknown sort fields and mismatched cursors are rejected
+	_, _, err := suite.store.GetAllTasks(&ListOptions{Sort: "description"})
+	_, ok := err.(*errors.ValidationError)
+	suite.True(ok)
+	_, _, err = suite.store.GetAllTasks(&ListOptions{Sort: "start", Cursor: opts.Cursor})
+	_, ok = err.(*errors.ValidationError)
+	suite.True(ok)
+
+	// No limit lists everything
+	tasks, page, err := suite.store.GetAllTasks(nil)
+	suite.NoError(err)
+	suite.Equal(5, len(tasks))
+	suite.Nil(page.Next)
+	suite.Equal(-1, page.Total)
+}
+
+// Test006_Search asserts every term of a search must match
+func (suite *StoreTestSuite) Test006_Search() {
+	alice, bob := bson.NewObjectId(), bson.NewObjectId()
+	for _, task := range []schema.Task{
+		{TimeRange: *schema.NewTimeRange(1000, 1000+3*3600), UserID: &alice, Title: "Design review", Tags: []string{"client-x"}},
+		{TimeRange: *schema.NewTimeRange(1000, 1000+3600), UserID: &alice, Title: "Design review", Description: "short"},
+		{TimeRange: *schema.NewTimeRange(1000, 1000+3*3600), UserID: &bob, Title: "review of design", Tags: []string{"client-x"}},
+		{TimeRange: *schema.NewTimeRange(1000, 1000+3*3600), UserID: &alice, Title: "Planning", Tags: []string{"client-x"}},
+	} {
+		task := task
+		suite.NoError(suite.store.CreateTask(&task))
+	}
+
+	titles := func(s string) []string {
+		q, err := search.Parse(s, time.UTC)
+		suite.NoError(err)
+		tasks, _, err := suite.store.GetAllTasks(&ListOptions{
+			Query: NewTaskSearchQuery(q, map[string]bson.ObjectId{"alice": alice}),
+			Sort:  "title",
+		})
+		suite.NoError(err)
+		result := []string{}
+		for _, t := range tasks {
+			result = append(result, t.Title)
+		}
+		return result
+	}
+
+	suite.Equal([]string{"Design review"}, titles(`title:"design review" tag:client-x duration>2h user:alice before:1970-01-02`))
+	suite.Equal([]string{"Design review", "Design review", "review of design"}, titles(`design review`))
+	suite.Equal([]string{"Design review", "Design review"}, titles(`"design review"`))
+	suite.Equal([]string{"Design review"}, titles(`review -short user:alice`))
+	suite.Equal([]string{"Planning"}, titles(`-review`))
+	// Words are looked up in the text index, whole
+	suite.Equal([]string{"Design review", "review of design"}, titles(`REVIEW tag:client-x`))
+	suite.Equal([]string{}, titles(`rev`))
+	suite.Equal([]string{}, titles(`of`))
+	suite.Equal([]string{"Design review", "Design review", "Planning"}, titles(`-of`))
+	suite.Equal([]string{"Design review"}, titles(`-duration>2h`))
+	suite.Equal([]string{}, titles(`after:1970-01-01`))
+}
+
+// Test007_Webhooks asserts changes queue deliveries for subscribed webhooks
+func (suite *StoreTestSuite) Test007_Webhooks() {
+	alice, bob := bson.NewObjectId(), bson.NewObjectId()
+	all := &schema.Webhook{URL: "https://example.com/all", Events: schema.Events, Active: true}
+	own := &schema.Webhook{URL: "https://example.com/own", Events: []string{schema.EventTaskUpdated}, UserID: &alice, Active: true}
+	off := &schema.Webhook{URL: "https://example.com/off", Events: schema.Events}
+	for _, w := range []*schema.Webhook{all, own, off} {
+		suite.NoError(suite.store.CreateWebhook(w))
+	}
+
+	// Created for every user, updated only for alice's own
+	task := &schema.Task{TimeRange: *schema.NewTimeRange(1000, 2000), UserID: &alice, Title: "foo"}
+	suite.NoError(suite.store.CreateTask(task))
+	title := "bar"
+	_, err := suite.store.UpdateTask(task.ID.Hex(), &schema.TaskPatch{Title: &title})
+	suite.NoError(err)
+	suite.NoError(suite.store.CreateTask(&schema.Task{TimeRange: *schema.NewTimeRange(1000, 2000), UserID: &bob, Title: "baz"}))
+
+	deliveries, _, err := suite.store.GetDeliveries(all.ID.Hex(), &ListOptions{Sort: "_id"})
+	suite.NoError(err)
+	suite.Equal(3, len(deliveries))
+	suite.Equal(schema.EventTaskCreated, deliveries[0].Event)
+	suite.Equal(schema.EventTaskUpdated, deliveries[1].Event)
+	suite.Contains(deliveries[1].Payload, `"title":"bar"`)
+	deliveries, _, err = suite.store.GetDeliveries(own.ID.Hex(), nil)
+	suite.NoError(err)
+	suite.Equal(1, len(deliveries))
+	deliveries, _, err = suite.store.GetDeliveries(off.ID.Hex(), nil)
+	suite.NoError(err)
+	suite.Equal(0, len(deliveries))
+
+	// Claimed deliveries aren't due again until their lease is up
+	claimed := map[bson.ObjectId]bool{}
+	for i := 0; i < 4; i++ {
+		d, err := suite.store.ClaimDelivery(time.Minute)
+		suite.NoError(err)
+		claimed[d.ID] = true
+	}
+	suite.Equal(4, len(claimed))
+	_, err = suite.store.ClaimDelivery(time.Minute)
+	suite.Equal(ErrNotFound, err)
+
+	// Attempts are logged
+	deliveries, _, _ = suite.store.GetDeliveries(own.ID.Hex(), nil)
+	attempt := &schema.DeliveryAttempt{At: 1000, StatusCode: 500, Error: "500 Internal Server Error"}
+	suite.NoError(suite.store.RecordAttempt(deliveries[0].ID, attempt, schema.DeliveryPending, 1030))
+	suite.NoError(suite.store.RecordAttempt(deliveries[0].ID, &schema.DeliveryAttempt{At: 1030, StatusCode: 200}, schema.DeliveryDelivered, 0))
+	got, err := suite.store.GetDelivery(own.ID.Hex(), deliveries[0].ID.Hex())
+	suite.NoError(err)
+	suite.Equal(schema.DeliveryDelivered, got.Status)
+	suite.Equal(2, len(got.Attempts))
+
+	// Deleting a user removes the webhooks about them
+	suite.NoError(suite.store.DeleteWebhooksForUser(alice.Hex()))
+	_, err = suite.store.GetWebhook(own.ID.Hex())
+	suite.Equal(ErrNotFound, err)
+	_, err = suite.store.GetWebhook(all.ID.Hex())
+	suite.NoError(err)
+}
+
+// Test008_Events asserts changes are numbered and can be replayed or tailed
+func (suite *StoreTestSuite) Test008_Events() {
+	db := suite.store.GetDatabase()
+	after, err := lastEventSeq(db)
+	suite.NoError(err)
+
+	// An event numbered before others may be recorded after them, as by
+	//   another replica
+	seq, err := nextEventSeq(db, 1)
+	suite.NoError(err)
+	late := &schema.Event{ID: bson.NewObjectId(), Seq: seq, Type: schema.EventUserCreated}
+
+	alice := bson.NewObjectId()
+	task := &schema.Task{TimeRange: *schema.NewTimeRange(1000, 2000), UserID: &alice, Title: "foo"}
+	suite.NoError(suite.store.CreateTask(task))
+	_, err = suite.store.DeleteTask(task.ID.Hex())
+	suite.NoError(err)
+	suite.NoError(suite.store.GetEventsCollection().Insert(late))
+
+	// Replays are in order of sequence
+	events, err := suite.store.GetEventsAfter(after, 10)
+	suite.NoError(err)
+	suite.Equal(3, len(events))
+	suite.Equal(late.ID, events[0].ID)
+	suite.Equal(after+2, events[1].Seq)
+	suite.Equal(schema.EventTaskCreated, events[1].Type)
+	suite.Equal(alice, events[1].UserID)
+	suite.Contains(events[1].Data, `"title":"foo"`)
+	suite.Equal(after+3, events[2].Seq)
+	suite.Equal(schema.EventTaskDeleted, events[2].Type)
+
+	// Tailing picks up from after in order of insertion until stopped
+	stop := make(chan struct{})
+	tailed := []*schema.Event{}
+	err = suite.store.TailEvents(after, stop, func(ev *schema.Event) {
+		tailed = append(tailed, ev)
+		if len(tailed) == 3 {
+			close(stop)
+		}
+	})
+	suite.NoError(err)
+	suite.Equal([]*schema.Event{events[1], events[2], events[0]}, tailed)
+}
+
+// Test009_Reconnect asserts the schema version is recorded and a lost
+//   session is redialed
+func (suite *StoreTestSuite) Test009_Reconnect() {
+	version, err := suite.store.GetSchemaVersion()
+	suite.NoError(err)
+	suite.Equal(SchemaVersion, version)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go Reconnect(stop)
+
+	setSession(nil)
+	_, err = NewMongoStore()
+	suite.True(goerrors.Is(err, ErrUnavailable))
+
+	deadline := time.Now().Add(10 * time.Second)
+	for err != nil && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		var db *MongoStore
+		if db, err = NewMongoStore(); err == nil {
+			db.Cleanup()
+		}
+	}
+	suite.NoError(err)
+}
+
+// Test010_Migrate asserts the migrations of a database left behind are
+//   listed by a dry run, refused while usernames are duplicated, then
+//   applied taking over a stale lock
+func (suite *StoreTestSuite) Test010_Migrate() {
+	missing, err := suite.store.MissingIndexes()
+	suite.NoError(err)
+	suite.Empty(missing)
+
+	meta := suite.store.GetDatabase().C(metaCollectionName)
+	suite.NoError(suite.store.GetUsersCollection().DropIndexName("username_1"))
+	_, err = meta.RemoveAll(nil)
+	suite.NoError(err)
+
+	pending, err := suite.store.Migrate(true)
+	suite.NoError(err)
+	suite.Equal(migrations, pending)
+
+	// The unique index of usernames waits for the duplicates to be renamed
+	users := suite.store.GetUsersCollection()
+	dups := []interface{}{bson.M{"username": "twin"}, bson.M{"username": "twin"}}
+	suite.NoError(users.Insert(dups...))
+	_, err = suite.store.Migrate(false)
+	suite.EqualError(err, `migration 1: usernames are duplicated, rename all but one user of each: "twin" (2 users)`)
+	_, err = users.RemoveAll(bson.M{"username": "twin"})
+	suite.NoError(err)
+	suite.NoError(meta.Insert(bson.M{"_id": migrationLockID, "owner": "dead", "expires": time.Now().Add(-time.Minute)}))
+
+	missing, err = suite.store.MissingIndexes()
+	suite.NoError(err)
+	suite.Equal([]string{"users(username)"}, missing)
+
+	applied, err := suite.store.Migrate(false)
+	suite.NoError(err)
+	suite.Equal(migrations, applied)
+	missing, err = suite.store.MissingIndexes()
+	suite.NoError(err)
+	suite.Empty(missing)
+	version, err := suite.store.GetSchemaVersion()
+	suite.NoError(err)
+	suite.Equal(SchemaVersion, version)
+	n, err := meta.FindId(migrationLockID).Count()
+	suite.NoError(err)
+	suite.Zero(n)
+
+	// A dropped index is restored even if no migration is pending
+	suite.NoError(suite.store.GetTasksCollection().DropIndex("userID", "start", "finish"))
+	applied, err = suite.store.Migrate(false)
+	suite.NoError(err)
+	suite.Empty(applied)
+	missing, err = suite.store.MissingIndexes()
+	suite.NoError(err)
+	suite.Empty(missing)
+}
+
+// Test011_Backup asserts an archive restores the users with their
+//   passwords and their tasks, merged or replacing the database
+func (suite *StoreTestSuite) Test011_Backup() {
+	username, email, pw, role := "foo", "bar", "baz", schema.RoleUser
+	user := &schema.User{Username: &username, Email: &email, Password: &pw, Role: &role}
+	suite.NoError(suite.store.CreateUser(user))
+	task := &schema.Task{TimeRange: *schema.NewTimeRange(1000, 2000), UserID: &user.ID, Title: "qux"}
+	suite.NoError(suite.store.CreateTask(task))
+
+	buf := &bytes.Buffer{}
+	manifest, err := suite.store.Backup(buf)
+	suite.NoError(err)
+	suite.Equal(SchemaVersion, manifest.SchemaVersion)
+	suite.Equal(1, manifest.Collection(tasksCollectionName).Count)
+	suite.Zero(manifest.Collection(tasksCollectionName).Orphans)
+	a, err := backup.Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	suite.Require().NoError(err)
+
+	// Everything is in the database already
+	results, err := suite.store.Restore(a, RestoreMerge, false)
+	suite.NoError(err)
+	suite.Equal(&RestoreResult{Collection: usersCollectionName, Skipped: 1}, results[0])
+	suite.Equal(&RestoreResult{Collection: tasksCollectionName, Skipped: 1}, results[1])
+
+	// Another foo can't be merged
+	other := "foo"
+	suite.NoError(suite.store.GetUsersCollection().RemoveId(user.ID))
+	suite.NoError(suite.store.CreateUser(&schema.User{Username: &other, Email: &email, Password: &pw, Role: &role}))
+	_, err = suite.store.Restore(a, RestoreMerge, true)
+	suite.True(goerrors.Is(err, backup.ErrIncompatible))
+
+	// Replacing keeps the ids and the password hashes
+	results, err = suite.store.Restore(a, RestoreReplace, true)
+	suite.NoError(err)
+	suite.Equal(1, results[0].Restored)
+	results, err = suite.store.Restore(a, RestoreReplace, false)
+	suite.NoError(err)
+	suite.Equal(&RestoreResult{Collection: tasksCollectionName, Restored: 1}, results[1])
+	restored, err := suite.store.GetUserByCreds("foo", "baz")
+	suite.NoError(err)
+	suite.Equal(user.ID, restored.ID)
+	n, err := suite.store.GetTasksCollection().FindId(task.ID).Count()
+	suite.NoError(err)
+	suite.Equal(1, n)
+
+	// The collections written are renamed over those of the database,
+	//   indexed
+	names, err := suite.store.GetDatabase().CollectionNames()
+	suite.NoError(err)
+	for _, name := range names {
+		suite.False(strings.HasPrefix(name, restorePrefix), name)
+	}
+	missing, err := suite.store.MissingIndexes()
+	suite.NoError(err)
+	suite.Empty(missing)
+
+	// The tasks of a deleted user are orphans, of the backup and the restore
+	_, err = suite.store.DeleteUser(user.ID.Hex())
+	suite.NoError(err)
+	buf.Reset()
+	manifest, err = suite.store.Backup(buf)
+	suite.NoError(err)
+	suite.Equal(1, manifest.Collection(tasksCollectionName).Orphans)
+	a, err = backup.Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	suite.Require().NoError(err)
+	results, err = suite.store.Restore(a, RestoreReplace, true)
+	suite.NoError(err)
+	suite.Equal(&RestoreResult{Collection: tasksCollectionName, Restored: 1, Orphans: 1}, results[1])
+}
+
+// TestErrors checks mongo errors are converted to the errors of the store
+//   and malformed ids are rejected up front, it doesn't need mongo
+func TestErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(storeError(nil))
+	assert.Equal(ErrNotFound, storeError(mgo.ErrNotFound))
+	assert.Equal(ErrConflict, storeError(&mgo.LastError{Code: 11000, Err: "E11000 duplicate key"}))
+	assert.True(goerrors.Is(storeError(io.EOF), ErrUnavailable))
+	// Unreachable errors wake Reconnect
+	assert.Len(lost, 1)
+	<-lost
+	assert.True(goerrors.Is(storeError(&net.OpError{Op: "dial", Err: goerrors.New("refused")}), ErrUnavailable))
+	<-lost
+	// Failures of mgo aren't typed, they are told by their message
+	assert.True(goerrors.Is(storeError(goerrors.New("no reachable servers")), ErrUnavailable))
+	<-lost
+	other := &mgo.QueryError{Code: 2, Message: "unknown operator"}
+	assert.Equal(other, storeError(other))
+	unknown := goerrors.New("unknown")
+	assert.Equal(unknown, storeError(unknown))
+	assert.Len(lost, 0)
+	invalid := errors.NewValidationError("title", "string")
+	assert.Equal(invalid, storeError(invalid))
+	assert.True(goerrors.Is(errors.NewConflictError("user", "username", "foo"), ErrConflict))
+
+	// Store errors are described by their status
+	assert.Equal(http.StatusNotFound, errors.ProblemOf(ErrNotFound).Status)
+	assert.Equal(errors.CodeInvalidID, errors.ProblemOf(ErrInvalidID).Code)
+	p := errors.ProblemOf(storeError(io.EOF))
+	assert.Equal(http.StatusServiceUnavailable, p.Status)
+	assert.Equal("database unavailable", p.Detail)
+	<-lost
+
+	// Malformed ids never reach mongo
+	m := &MongoStore{}
+	_, err := m.GetUserByID("nope")
+	assert.Equal(ErrInvalidID, err)
+	_, err = m.DeleteTask("nope")
+	assert.Equal(ErrInvalidID, err)
+	_, err = m.GetFilter("nope")
+	assert.Equal(ErrInvalidID, err)
+	_, err = m.GetDelivery(bson.NewObjectId().Hex(), "nope")
+	assert.Equal(ErrInvalidID, err)
+	_, err = NewTaskQueryFromParams("", "nope", "", "")
+	assert.Equal(ErrInvalidID, err)
+}
+
+// TestMigrations checks the migrations are numbered in order up to
+//   SchemaVersion, it doesn't need mongo
+func TestMigrations(t *testing.T) {
+	assert := assert.New(t)
+
+	for i, m := range migrations {
+		assert.Equal(i+1, m.Version, m.Description)
+		assert.NotEmpty(m.Description)
+	}
+	assert.Equal(SchemaVersion, migrations[len(migrations)-1].Version)
+
+	assert.Len(pending(0), len(migrations))
+	assert.Equal(migrations[1:], pending(1))
+	assert.Empty(pending(SchemaVersion))
+
+	keys := []string{}
+	for _, index := range indexes()[tasksCollectionName] {
+		keys = append(keys, indexKey(index))
+	}
+	assert.Contains(keys, "userID,start,finish")
+}
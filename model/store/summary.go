@@ -0,0 +1,89 @@
+package store
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+// GetDailyTotals sums the duration of tasks matching q per user and per
+// calendar day in loc, attributing each task to the day it starts on
+func (m *MongoStore) GetDailyTotals(q bson.M, loc *time.Location) ([]*schema.DayTotal, error) {
+	defer m.observe("GetDailyTotals", time.Now())
+	epoch := time.Unix(0, 0)
+	pipeline := []bson.M{
+		{"$match": q},
+		{"$project": bson.M{
+			"userID":   1,
+			"duration": bson.M{"$subtract": []interface{}{"$finish", "$start"}},
+			"date": bson.M{"$dateToString": bson.M{
+				"format":   "%Y-%m-%d",
+				"date":     bson.M{"$add": []interface{}{epoch, bson.M{"$multiply": []interface{}{"$start", 1000}}}},
+				"timezone": loc.String(),
+			}},
+		}},
+		{"$group": bson.M{
+			"_id":     bson.M{"userID": "$userID", "date": "$date"},
+			"total":   bson.M{"$sum": "$duration"},
+			"taskIDs": bson.M{"$push": "$_id"},
+		}},
+		{"$project": bson.M{
+			"_id":     0,
+			"userID":  "$_id.userID",
+			"date":    "$_id.date",
+			"total":   1,
+			"taskIDs": 1,
+		}},
+		{"$sort": bson.M{"date": 1}},
+	}
+
+	totals := []*schema.DayTotal{}
+	if err := m.GetTasksCollection().Pipe(pipeline).All(&totals); err != nil {
+		return nil, storeError(err)
+	}
+	return totals, nil
+}
+
+// GetUserSummaries summarizes the days between the unix timestamps from and to
+// for each user, in tz if specified, else in each user's own time zone.
+// Only the tasks matching filter are counted if it isn't nil
+func (m *MongoStore) GetUserSummaries(users []*schema.UserSecure, from, to int, tz string, filter bson.M) ([]*schema.UserSummary, error) {
+	defer m.observe("GetUserSummaries", time.Now())
+	// Group users by time zone so that each zone takes a single aggregation
+	locs := map[string]*time.Location{}
+	userIDs := map[string][]bson.ObjectId{}
+	for _, u := range users {
+		loc, err := schema.SummaryLocation(u, tz)
+		if err != nil {
+			return nil, err
+		}
+		locs[loc.String()] = loc
+		userIDs[loc.String()] = append(userIDs[loc.String()], u.ID)
+	}
+
+	totals := []*schema.DayTotal{}
+	for name, loc := range locs {
+		q := bson.M{
+			"userID": bson.M{"$in": userIDs[name]},
+			"finish": bson.M{"$gte": from},
+			"start":  bson.M{"$lte": to},
+		}
+		if filter != nil {
+			q = bson.M{"$and": []bson.M{q, filter}}
+		}
+		t, err := m.GetDailyTotals(q, loc)
+		if err != nil {
+			return nil, err
+		}
+		totals = append(totals, t...)
+	}
+
+	summaries := make([]*schema.UserSummary, len(users))
+	for i, u := range users {
+		loc, _ := schema.SummaryLocation(u, tz)
+		summaries[i] = schema.NewUserSummary(u, totals, from, to, loc, complianceTolerance)
+	}
+	return summaries, nil
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -13,25 +14,45 @@ import (
 
 const (
 	tasksCollectionName = "tasks"
+
+	// insertBatchSize is the number of tasks inserted at once by CreateTasks
+	insertBatchSize = 500
+
+	// calendarTagField is the field of users holding the tag of the
+	//   calendar of their tasks
+	calendarTagField = "calendarTag"
 )
 
-func newTaskQueryByID(id string) bson.M {
-	return bson.M{"_id": bson.ObjectIdHex(id)}
+func newTaskQueryByID(id string) (bson.M, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"_id": oid}, nil
 }
 
 // NewTaskQueryFromParams constructs a mongo query from string inputs
-//   error can be safely ignored if from and to are passed a empty strings
+//   error is ErrInvalidID for malformed ids, or a validation error of from
+//   and to, which can't fail if passed empty strings
 func NewTaskQueryFromParams(userID, taskID, from, to string) (bson.M, error) {
 	q := bson.M{}
 
 	// userID
 	if len(userID) > 0 {
-		q["userID"] = bson.ObjectIdHex(userID)
+		id, err := objectID(userID)
+		if err != nil {
+			return nil, err
+		}
+		q["userID"] = id
 	}
 
 	// taskID
 	if len(taskID) > 0 {
-		q["_id"] = bson.ObjectIdHex(taskID)
+		id, err := objectID(taskID)
+		if err != nil {
+			return nil, err
+		}
+		q["_id"] = id
 	}
 
 	// from
@@ -54,84 +75,499 @@ func NewTaskQueryFromParams(userID, taskID, from, to string) (bson.M, error) {
 	return q, nil
 }
 
+// newTaskOverlapQuery matches the other tasks of task's user whose time range
+//   intersects task's, tasks that only touch end to start don't overlap
+func newTaskOverlapQuery(task *schema.Task) bson.M {
+	q := bson.M{
+		"userID": *task.UserID,
+		"finish": bson.M{"$gt": *task.Start},
+		"start":  bson.M{"$lt": *task.Finish},
+	}
+	if len(task.ID) > 0 {
+		q["_id"] = bson.M{"$ne": task.ID}
+	}
+	return q
+}
+
+// getOverlapPolicy returns the overlap policy of the given user
+//   falling back to the server wide default
+func (m *MongoStore) getOverlapPolicy(userID bson.ObjectId) (string, error) {
+	user, err := m.GetUserByID(userID.Hex())
+	if err != nil && err != ErrNotFound {
+		return "", err
+	}
+	if user != nil && len(user.OverlapPolicy) > 0 {
+		return user.OverlapPolicy, nil
+	}
+	return overlapPolicy, nil
+}
+
+// checkOverlaps applies the overlap policy of task's user
+//   error is an OverlapError if policy is reject, else overlapping
+//   task ids are recorded on task if policy is warn
+func (m *MongoStore) checkOverlaps(task *schema.Task) error {
+	if task.Start == nil || task.Finish == nil {
+		return nil
+	}
+
+	policy, err := m.getOverlapPolicy(*task.UserID)
+	if err != nil {
+		return err
+	}
+	return m.applyOverlapPolicy(task, policy)
+}
+
+// applyOverlapPolicy is checkOverlaps with the policy already looked up
+func (m *MongoStore) applyOverlapPolicy(task *schema.Task, policy string) error {
+	if policy == schema.OverlapAllow {
+		return nil
+	}
+
+	// Fetch the ids of overlapping tasks
+	overlaps := []*schema.Task{}
+	err := m.GetTasksCollection().Find(newTaskOverlapQuery(task)).Select(bson.M{"_id": 1}).All(&overlaps)
+	if err != nil {
+		return storeError(err)
+	}
+	if len(overlaps) == 0 {
+		return nil
+	}
+
+	ids := make([]bson.ObjectId, len(overlaps))
+	hexes := make([]string, len(overlaps))
+	for i, t := range overlaps {
+		ids[i] = t.ID
+		hexes[i] = t.ID.Hex()
+	}
+	if policy == schema.OverlapReject {
+		return errors.NewOverlapError(hexes)
+	}
+	task.Overlaps = ids
+	return nil
+}
+
+// findOverlaps returns the ids of the tasks of userID that overlap
+//   any other of theirs, sweeping them in order of start
+func (m *MongoStore) findOverlaps(userID bson.ObjectId) ([]string, error) {
+	tasks := []*schema.Task{}
+	err := m.GetTasksCollection().Find(bson.M{"userID": userID, "start": bson.M{"$ne": nil}, "finish": bson.M{"$ne": nil}}).
+		Select(bson.M{"_id": 1, "start": 1, "finish": 1}).Sort("start").All(&tasks)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	hexes := []string{}
+	seen := map[bson.ObjectId]bool{}
+	add := func(t *schema.Task) {
+		if !seen[t.ID] {
+			seen[t.ID] = true
+			hexes = append(hexes, t.ID.Hex())
+		}
+	}
+	// latest is the task finishing last among those started so far
+	var latest *schema.Task
+	for _, t := range tasks {
+		if latest != nil && *t.Start < *latest.Finish {
+			add(latest)
+			add(t)
+		}
+		if latest == nil || *t.Finish > *latest.Finish {
+			latest = t
+		}
+	}
+	return hexes, nil
+}
+
+// applyBatchOverlapPolicy applies policy to task against the tasks of
+//   its user accepted before it in an import, which may not be in db yet
+//   error is an OverlapError if policy is reject, else overlapping task
+//   ids are added to those recorded on task if policy is warn
+func applyBatchOverlapPolicy(task *schema.Task, policy string, accepted []*schema.Task) error {
+	if policy == schema.OverlapAllow || task.Start == nil || task.Finish == nil {
+		return nil
+	}
+
+	hexes := []string{}
+	for _, t := range accepted {
+		if *t.Start < *task.Finish && *t.Finish > *task.Start {
+			hexes = append(hexes, t.ID.Hex())
+			if policy == schema.OverlapWarn && !containsID(task.Overlaps, t.ID) {
+				task.Overlaps = append(task.Overlaps, t.ID)
+			}
+		}
+	}
+	if policy == schema.OverlapReject && len(hexes) > 0 {
+		return errors.NewOverlapError(hexes)
+	}
+	return nil
+}
+
+func containsID(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
 // GetTasksCollection returns an mgo instance to the tasks collection
 func (m *MongoStore) GetTasksCollection() *mgo.Collection {
 	return m.GetDatabase().C(tasksCollectionName)
 }
 
 // CreateTask inserts task object into db
-// error is 500 if mongo fails, 409 if user exists, else nil
+// error is 500 if mongo fails, 400 without userID, 409 if task overlaps and policy is reject, else nil
 func (m *MongoStore) CreateTask(task *schema.Task) error {
+	defer m.observe("CreateTask", time.Now())
 	// UserID is a required field
 	if task.UserID == nil {
-		return fmt.Errorf("task must contain userID")
+		return errors.NewValidationError("userID", "object id")
+	}
+
+	// Apply overlap policy
+	if err := m.checkOverlaps(task); err != nil {
+		return err
 	}
 	// Try to insert and return error
 	task.ID = bson.NewObjectId()
+	task.Revision = 1
 	if err := m.GetTasksCollection().Insert(task); err != nil {
-		return err
+		return storeError(err)
 	}
+	m.touchCalendar(*task.UserID)
+	m.emit(schema.EventTaskCreated, *task.UserID, task)
 	return nil
 }
 
-// GetAllTasks retrieves all tasks for option userID
-func (m *MongoStore) GetAllTasks(q bson.M) ([]*schema.Task, error) {
+// CreateTasks inserts tasks into db in batches applying each user's
+// overlap policy, nothing is inserted if dryRun. Tasks are checked against
+// the tasks already in db and against the tasks before them accepted
+// the import isn't atomic: once a task may have been inserted, failures are
+//   recorded by index rather than returned, so that the tasks missing from
+//   failed are the ones inserted and only the failed ones need a retry
+// the events of each batch are emitted together and the calendar of each
+//   user is tagged once
+// returns the errors of the tasks that weren't inserted by index,
+// error is 500 if mongo fails before any task is inserted, else nil
+func (m *MongoStore) CreateTasks(tasks []*schema.Task, dryRun bool) (map[int]error, error) {
+	defer m.observe("CreateTasks", time.Now())
+	failed := map[int]error{}
+	policies := map[bson.ObjectId]string{}
+	// accepted are the tasks of the import inserted or to be, by user
+	accepted := map[bson.ObjectId][]*schema.Task{}
+	batch := []*schema.Task{}
+	rows := []int{}
+
+	// partial is set once tasks may have been inserted, fail then records
+	//   the error of a task instead of returning it
+	partial := false
+	fail := func(i int, err error) error {
+		if !partial {
+			return err
+		}
+		failed[i] = err
+		return nil
+	}
+
+	// touched are the users whose calendars changed, tagged once the
+	//   import is done
+	touched := map[bson.ObjectId]bool{}
+	defer func() {
+		for userID := range touched {
+			m.touchCalendar(userID)
+		}
+	}()
+
+	flush := func() error {
+		defer func() {
+			batch, rows = batch[:0], rows[:0]
+		}()
+		if len(batch) == 0 || dryRun {
+			return nil
+		}
+		docs := make([]interface{}, len(batch))
+		ids := make([]bson.ObjectId, len(batch))
+		for j, task := range batch {
+			docs[j], ids[j] = task, task.ID
+		}
+		c := m.GetTasksCollection()
+		inserted := map[bson.ObjectId]bool{}
+		err := c.Insert(docs...)
+		if err == nil {
+			for _, id := range ids {
+				inserted[id] = true
+			}
+		} else {
+			// An insert stops at its first failure, the tasks before it
+			//   are inserted
+			found := []struct {
+				ID bson.ObjectId `bson:"_id"`
+			}{}
+			if ferr := c.Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"_id": 1}).All(&found); ferr != nil {
+				partial = true
+				for j, row := range rows {
+					failed[row] = fmt.Errorf("%v, the task may have been inserted", storeError(err))
+					touched[*batch[j].UserID] = true
+				}
+				return nil
+			}
+			for _, f := range found {
+				inserted[f.ID] = true
+			}
+		}
+
+		emissions := []emission{}
+		for _, task := range batch {
+			if inserted[task.ID] {
+				partial = true
+				touched[*task.UserID] = true
+				emissions = append(emissions, emission{*task.UserID, task})
+			}
+		}
+		m.emitAll(schema.EventTaskCreated, emissions)
+		if err != nil {
+			for j, task := range batch {
+				if inserted[task.ID] {
+					continue
+				}
+				if ferr := fail(rows[j], storeError(err)); ferr != nil {
+					return ferr
+				}
+			}
+		}
+		return nil
+	}
+
+	for i, task := range tasks {
+		if task.UserID == nil {
+			failed[i] = errors.NewValidationError("userID", "object id")
+			continue
+		}
+
+		// Apply overlap policy
+		policy, ok := policies[*task.UserID]
+		if !ok {
+			var err error
+			if policy, err = m.getOverlapPolicy(*task.UserID); err != nil {
+				if ferr := fail(i, err); ferr != nil {
+					return nil, ferr
+				}
+				continue
+			}
+			policies[*task.UserID] = policy
+		}
+		if err := m.applyOverlapPolicy(task, policy); err != nil {
+			if _, ok := err.(*errors.OverlapError); !ok {
+				if ferr := fail(i, err); ferr != nil {
+					return nil, ferr
+				}
+				continue
+			}
+			failed[i] = err
+			continue
+		}
+		if err := applyBatchOverlapPolicy(task, policy, accepted[*task.UserID]); err != nil {
+			failed[i] = err
+			continue
+		}
+
+		task.ID = bson.NewObjectId()
+		task.Revision = 1
+		accepted[*task.UserID] = append(accepted[*task.UserID], task)
+		batch = append(batch, task)
+		rows = append(rows, i)
+		if len(batch) == insertBatchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return failed, nil
+}
+
+// GetAllTasks retrieves the tasks listed by opts
+//   sortable by start, finish or title
+func (m *MongoStore) GetAllTasks(opts *ListOptions) ([]*schema.Task, *Page, error) {
+	defer m.observe("GetAllTasks", time.Now())
+	if opts != nil && len(opts.Sort) > 0 {
+		if err := validateSort(opts.Sort, "_id", "start", "finish", "title"); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Fetch the tasks
 	tasks := []*schema.Task{}
-	err := m.GetTasksCollection().Find(q).All(&tasks)
+	page, err := list(m.GetTasksCollection(), opts, &tasks)
 	if err != nil {
-		return nil, err
+		return nil, nil, storeError(err)
 	}
 
-	return tasks, nil
+	return tasks, page, nil
+}
+
+// GetTaskByICalUID looks up the task of userID imported from the calendar event uid
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) GetTaskByICalUID(userID bson.ObjectId, uid string) (*schema.Task, error) {
+	defer m.observe("GetTaskByICalUID", time.Now())
+	return m.GetTask(bson.M{"userID": userID, "icalUID": uid})
+}
+
+// touchCalendar stores a new tag for the calendar of userID as its tasks
+//   changed. The change is already saved, so failures are logged rather
+//   than returned
+func (m *MongoStore) touchCalendar(userID bson.ObjectId) {
+	err := m.GetUsersCollection().UpdateId(userID, bson.M{"$set": bson.M{calendarTagField: bson.NewObjectId().Hex()}})
+	if err != nil && err != mgo.ErrNotFound {
+		logger.Warn("calendar tag update failed", "userID", userID.Hex(), "err", err)
+	}
+}
+
+// GetCalendarTag returns a tag that changes whenever any task of userID
+//   is created, updated or deleted, stored along with the user so that
+//   it is read without the tasks
+// error is 500 if mongo fails, 404 if the user isn't found, else nil
+func (m *MongoStore) GetCalendarTag(userID bson.ObjectId) (string, error) {
+	defer m.observe("GetCalendarTag", time.Now())
+	doc := bson.M{}
+	err := m.GetUsersCollection().FindId(userID).Select(bson.M{calendarTagField: 1}).One(&doc)
+	if err != nil {
+		return "", storeError(err)
+	}
+
+	// Users whose tasks didn't change since tags were stored have none
+	if tag, ok := doc[calendarTagField].(string); ok {
+		return tag, nil
+	}
+	return userID.Hex(), nil
+}
+
+// IterTasks returns an iterator over the tasks matching q ordered by start
+//   the caller is responsible for closing the iterator
+func (m *MongoStore) IterTasks(q bson.M) *mgo.Iter {
+	return m.GetTasksCollection().Find(q).Sort("start").Iter()
 }
 
 // GetTask looks up task in db with given query for entire object
-// error is 500 if mongo fails, else nil
+// error is 500 if mongo fails, ErrNotFound if no task matches, else nil
 func (m *MongoStore) GetTask(q bson.M) (*schema.Task, error) {
+	defer m.observe("GetTask", time.Now())
 	task := schema.Task{}
 	err := m.GetTasksCollection().Find(q).One(&task)
 	if err != nil {
-		return nil, err
+		return nil, storeError(err)
 	}
 	return &task, nil
 }
 
-// UpdateTask ...
+// UpdateTask applies taskPatch to the task with given taskID
+// the overlap policy is applied on every update, so overlaps left since
+//   the task was last checked, e.g. after its owner's policy changed, are reported too
+// error is 500 if mongo fails, 400 if the patched time range is invalid, 409 if the task
+//   overlaps and policy is reject, ErrModified if it was updated meanwhile, else nil
 func (m *MongoStore) UpdateTask(taskID string, taskPatch *schema.TaskPatch) (*schema.Task, error) {
-	// Try to update the user
-	q := newTaskQueryByID(taskID)
+	defer m.observe("UpdateTask", time.Now())
+	return m.updateTask(taskID, nil, taskPatch)
+}
+
+// UpdateTaskRevision applies taskPatch like UpdateTask if the task is still
+//   at revision, as read by the caller
+// error is ErrModified if it isn't, else as UpdateTask
+func (m *MongoStore) UpdateTaskRevision(taskID string, revision int, taskPatch *schema.TaskPatch) (*schema.Task, error) {
+	defer m.observe("UpdateTaskRevision", time.Now())
+	return m.updateTask(taskID, &revision, taskPatch)
+}
+
+// updateTask applies taskPatch to the task of taskID at revision if any.
+//   The task is only updated at the revision it was checked for overlaps
+//   at, so that concurrent updates can't both pass the check
+func (m *MongoStore) updateTask(taskID string, revision *int, taskPatch *schema.TaskPatch) (*schema.Task, error) {
+	q, err := newTaskQueryByID(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply overlap policy to the task as patched
+	current, err := m.GetTask(q)
+	if err != nil {
+		return nil, err
+	}
+	if revision != nil && current.Revision != *revision {
+		return nil, ErrModified
+	}
+	if taskPatch.Start != nil {
+		current.Start = taskPatch.Start
+	}
+	if taskPatch.Finish != nil {
+		current.Finish = taskPatch.Finish
+	}
+	// The patch may move one end past the other
+	if err = current.TimeRange.Validate(); err != nil {
+		return nil, err
+	}
+	if err = m.checkOverlaps(current); err != nil {
+		return nil, err
+	}
+	overlaps := current.Overlaps
+
+	// Tasks saved before revisions were recorded have none
+	q["revision"] = current.Revision
+	if current.Revision == 0 {
+		q["revision"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+
+	// Try to update the task
 	changeInfo := mgo.Change{
-		Update:    bson.M{"$set": taskPatch},
+		Update:    bson.M{"$set": taskPatch, "$inc": bson.M{"revision": 1}},
 		Upsert:    false,
 		ReturnNew: true,
 	}
 	task := schema.Task{}
-	_, err := m.GetTasksCollection().Find(q).Apply(changeInfo, &task)
-	if err != nil {
-		return nil, err
+	_, err = m.GetTasksCollection().Find(q).Apply(changeInfo, &task)
+	if err == mgo.ErrNotFound {
+		return nil, ErrModified
+	} else if err != nil {
+		return nil, storeError(err)
 	}
+	task.Overlaps = overlaps
+	m.touchCalendar(*task.UserID)
+	m.emit(schema.EventTaskUpdated, *task.UserID, &task)
 	return &task, nil
 }
 
 // DeleteTask removes task from db with given taskID
 // error is 500 if mongo fails, else nil
 func (m *MongoStore) DeleteTask(taskID string) (*schema.Task, error) {
-	task, err := m.GetTask(newTaskQueryByID(taskID))
+	defer m.observe("DeleteTask", time.Now())
+	q, err := newTaskQueryByID(taskID)
+	if err != nil {
+		return nil, err
+	}
+	task, err := m.GetTask(q)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = m.GetTasksCollection().Remove(newTaskQueryByID(taskID)); err != nil {
-		return task, err
+	if err = m.GetTasksCollection().Remove(q); err != nil {
+		return task, storeError(err)
 	}
 
+	m.touchCalendar(*task.UserID)
+	m.emit(schema.EventTaskDeleted, *task.UserID, task)
 	return task, nil
 }
 
 // DeleteTasks removes tasks for given userID
 // error is 500 if mongo fails, else nil
 func (m *MongoStore) DeleteTasksForUser(userID string) error {
-	_, err := m.GetTasksCollection().RemoveAll(bson.M{"userID": bson.ObjectIdHex(userID)})
-	return err
+	defer m.observe("DeleteTasksForUser", time.Now())
+	id, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	_, err = m.GetTasksCollection().RemoveAll(bson.M{"userID": id})
+	return storeError(err)
 }
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	ticketsCollectionName = "tickets"
+
+	// ticketBytes is the entropy of stream tickets
+	ticketBytes = 32
+	// TicketDuration is how long a stream ticket may be redeemed
+	TicketDuration = 30 * time.Second
+)
+
+// ticket is a stored stream ticket, identified by the hash of the ticket
+type ticket struct {
+	ID        string        `bson:"_id"`
+	UserID    bson.ObjectId `bson:"userID"`
+	ExpiresAt time.Time     `bson:"expiresAt"`
+}
+
+// GetTicketsCollection returns an mgo instance to the tickets collection
+func (m *MongoStore) GetTicketsCollection() *mgo.Collection {
+	return m.GetDatabase().C(ticketsCollectionName)
+}
+
+// NewStreamTicket generates a random ticket authenticating a single stream
+// of the user within TicketDuration, only its hash is stored
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) NewStreamTicket(userID bson.ObjectId) (string, error) {
+	defer m.observe("NewStreamTicket", time.Now())
+	b := make([]byte, ticketBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	t := &ticket{ID: hash(token), UserID: userID, ExpiresAt: time.Now().Add(TicketDuration)}
+	if err := m.GetTicketsCollection().Insert(t); err != nil {
+		return "", storeError(err)
+	}
+	return token, nil
+}
+
+// RedeemStreamTicket removes the ticket, returning the id of its user. Any
+// replica may redeem it, but only once
+// error is 500 if mongo fails, 404 if unknown, expired or redeemed, else nil
+func (m *MongoStore) RedeemStreamTicket(token string) (bson.ObjectId, error) {
+	defer m.observe("RedeemStreamTicket", time.Now())
+	t := ticket{}
+	q := bson.M{"_id": hash(token), "expiresAt": bson.M{"$gt": time.Now()}}
+	if _, err := m.GetTicketsCollection().Find(q).Apply(mgo.Change{Remove: true}, &t); err != nil {
+		return "", storeError(err)
+	}
+	return t.UserID, nil
+}
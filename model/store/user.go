@@ -3,6 +3,7 @@ package store
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"time"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -15,30 +16,42 @@ const (
 	usersCollectionName = "users"
 )
 
-var (
-	adminUsername = "boss"
-	adminEmail    = "bk@breadtech.com"
-)
-
 func hash(s string) string {
 	hash := sha256.Sum256([]byte(s))
 	return base64.StdEncoding.EncodeToString(hash[:])
 }
 
-func ensureUserIndex() {
-	c := mongo.DB(databaseName).C(usersCollectionName)
-	if err := c.EnsureIndex(mgo.Index{
-		Key:      []string{"username"},
-		Unique:   true,
-		DropDups: true,
-	}); err != nil {
-		panic(err)
+// migratePreferredHours converts the legacy preferredHours field
+// of every user into the equivalent workingHours schedule
+func migratePreferredHours(db *mgo.Database) error {
+	c := db.C(usersCollectionName)
+
+	legacy := struct {
+		ID             bson.ObjectId     `bson:"_id"`
+		PreferredHours *schema.TimeRange `bson:"preferredHours"`
+	}{}
+	iter := c.Find(bson.M{"preferredHours": bson.M{"$exists": true}}).Iter()
+	for iter.Next(&legacy) {
+		update := bson.M{"$unset": bson.M{"preferredHours": ""}}
+		if legacy.PreferredHours != nil {
+			update["$set"] = bson.M{"workingHours": schema.NewWorkingHoursFromTimeRange(legacy.PreferredHours)}
+		}
+		if err := c.UpdateId(legacy.ID, update); err != nil {
+			iter.Close()
+			return err
+		}
+		logger.Info("migrated preferredHours to workingHours", "user", legacy.ID.Hex())
+		legacy.PreferredHours = nil
 	}
-
+	return iter.Close()
 }
 
-func newUserQueryByID(id string) bson.M {
-	return bson.M{"_id": bson.ObjectIdHex(id)}
+func newUserQueryByID(id string) (bson.M, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"_id": oid}, nil
 }
 
 func newUserQueryByUsername(username string) bson.M {
@@ -61,8 +74,9 @@ func (m *MongoStore) GetUsersCollection() *mgo.Collection {
 // CreateUser inserts user object into db
 // error is 500 if mongo fails, 409 if user exists, else nil
 func (m *MongoStore) CreateUser(user *schema.User) error {
+	defer m.observe("CreateUser", time.Now())
 	uname := *user.Username
-	if user, err := m.GetUserByUsername(uname); err != nil && err != mgo.ErrNotFound {
+	if user, err := m.GetUserByUsername(uname); err != nil && err != ErrNotFound {
 		return err
 	} else if user != nil {
 		return errors.NewConflictError("user", "username", uname)
@@ -75,56 +89,94 @@ func (m *MongoStore) CreateUser(user *schema.User) error {
 	// Try to insert and return error
 	user.ID = bson.NewObjectId()
 	if err := m.GetUsersCollection().Insert(user); err != nil {
-		return err
+		if mgo.IsDup(err) {
+			return errors.NewConflictError("user", "username", uname)
+		}
+		return storeError(err)
+	}
+	if created, err := m.GetUserByID(user.ID.Hex()); err == nil {
+		m.emit(schema.EventUserCreated, created.ID, created)
 	}
 	return nil
 }
 
-// GetAllUsers retrieves all users
-func (m *MongoStore) GetAllUsers() ([]*schema.UserSecure, error) {
+// GetAllUsers retrieves the users listed by opts
+//   sortable by username or email
+func (m *MongoStore) GetAllUsers(opts *ListOptions) ([]*schema.UserSecure, *Page, error) {
+	defer m.observe("GetAllUsers", time.Now())
+	if opts != nil && len(opts.Sort) > 0 {
+		if err := validateSort(opts.Sort, "_id", "username", "email"); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	users := []*schema.UserSecure{}
-	err := m.GetUsersCollection().Find(nil).All(&users)
+	page, err := list(m.GetUsersCollection(), opts, &users)
 	if err != nil {
-		return nil, err
+		return nil, nil, storeError(err)
 	}
 
-	return users, nil
+	return users, page, nil
 }
 
 // GetUser looks up user in db with given query for entire object (excpet password)
-// error is 500 if mongo fails, else nil
+// error is 500 if mongo fails, ErrNotFound if no user matches, else nil
 func (m *MongoStore) GetUser(q bson.M) (*schema.UserSecure, error) {
+	defer m.observe("GetUser", time.Now())
 	user := schema.UserSecure{}
 	err := m.GetUsersCollection().Find(q).One(&user)
 	if err != nil {
-		return nil, err
+		return nil, storeError(err)
 	}
 	return &user, nil
 }
 
 // GetUserByID looks up user with given object id
 func (m *MongoStore) GetUserByID(id string) (*schema.UserSecure, error) {
-	return m.GetUser(newUserQueryByID(id))
+	defer m.observe("GetUserByID", time.Now())
+	q, err := newUserQueryByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetUser(q)
 }
 
 // GetUserByUsername looks up user with given username
 func (m *MongoStore) GetUserByUsername(username string) (*schema.UserSecure, error) {
+	defer m.observe("GetUserByUsername", time.Now())
 	return m.GetUser(newUserQueryByUsername(username))
 }
 
 // GetUserByCreds looks up user with given username, password
 func (m *MongoStore) GetUserByCreds(user, pw string) (*schema.UserSecure, error) {
+	defer m.observe("GetUserByCreds", time.Now())
 	return m.GetUser(newUserQueryByCreds(user, pw))
 }
 
 // GetUserByEmail looks up user with given email
 func (m *MongoStore) GetUserByEmail(email string) (*schema.UserSecure, error) {
+	defer m.observe("GetUserByEmail", time.Now())
 	return m.GetUser(newUserQueryByEmail(email))
 }
 
+// GetUsersByIDs looks up the users with the given object ids at once,
+//   ids of no user are left out
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) GetUsersByIDs(ids []bson.ObjectId) ([]*schema.UserSecure, error) {
+	defer m.observe("GetUsersByIDs", time.Now())
+	users := []*schema.UserSecure{}
+	q := bson.M{"_id": bson.M{"$in": ids}}
+	if err := m.GetUsersCollection().Find(q).All(&users); err != nil {
+		return nil, storeError(err)
+	}
+	return users, nil
+}
+
 // UpdateUser ...
+// error is 409 if the patch sets the reject overlap policy while tasks of the user overlap
 // TODO check for username exists and email exists
 func (m *MongoStore) UpdateUser(userID string, user *schema.User) (*schema.UserSecure, error) {
+	defer m.observe("UpdateUser", time.Now())
 	// Hash the password if provided
 	if user.Password != nil {
 		h := hash(*user.Password)
@@ -132,16 +184,30 @@ func (m *MongoStore) UpdateUser(userID string, user *schema.User) (*schema.UserS
 	}
 
 	// Try to update the user
-	q := newUserQueryByID(userID)
+	q, err := newUserQueryByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse the reject policy while tasks of the user already overlap
+	if user.OverlapPolicy != nil && *user.OverlapPolicy == schema.OverlapReject {
+		overlaps, err := m.findOverlaps(q["_id"].(bson.ObjectId))
+		if err != nil {
+			return nil, err
+		}
+		if len(overlaps) > 0 {
+			return nil, errors.NewOverlapError(overlaps)
+		}
+	}
+
 	changeInfo := mgo.Change{
 		Update:    bson.M{"$set": user},
 		Upsert:    false,
 		ReturnNew: true,
 	}
 	safeUser := schema.UserSecure{}
-	_, err := m.GetUsersCollection().Find(q).Apply(changeInfo, &safeUser)
-	if err != nil {
-		return nil, err
+	if _, err = m.GetUsersCollection().Find(q).Apply(changeInfo, &safeUser); err != nil {
+		return nil, storeError(err)
 	}
 	return &safeUser, nil
 }
@@ -149,24 +215,27 @@ func (m *MongoStore) UpdateUser(userID string, user *schema.User) (*schema.UserS
 // DeleteUser removes user from db with given username
 // error is 500 if mongo fails, else nil
 func (m *MongoStore) DeleteUser(userID string) (*schema.UserSecure, error) {
+	defer m.observe("DeleteUser", time.Now())
 	user, err := m.GetUserByID(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = m.GetUsersCollection().Remove(newUserQueryByID(userID)); err != nil {
-		return user, err
+	if err = m.GetUsersCollection().RemoveId(user.ID); err != nil {
+		return user, storeError(err)
 	}
 
+	m.emit(schema.EventUserDeleted, user.ID, user)
 	return user, nil
 }
 
-// AdminExistsOrCreate checks for existence of admin account
-// and creates one with given key if it doesn't exist
-func (m *MongoStore) AdminExistsOrCreate(secret string) error {
+// AdminExistsOrCreate checks for existence of the admin account username
+// and creates one with email and password secret if it doesn't exist
+func (m *MongoStore) AdminExistsOrCreate(username, email, secret string) error {
+	defer m.observe("AdminExistsOrCreate", time.Now())
 	// Try to fetch admin user
-	user, err := m.GetUserByUsername(adminUsername)
-	if err != nil && err != mgo.ErrNotFound {
+	user, err := m.GetUserByUsername(username)
+	if err != nil && err != ErrNotFound {
 		return err
 	}
 
@@ -177,9 +246,9 @@ func (m *MongoStore) AdminExistsOrCreate(secret string) error {
 
 	// Create admin
 	return m.CreateUser(&schema.User{
-		Username: &adminUsername,
+		Username: &username,
 		Password: &secret,
-		Email:    &adminEmail,
+		Email:    &email,
 		Role:     &schema.RoleAdmin,
 	})
 }
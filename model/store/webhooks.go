@@ -0,0 +1,255 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+const (
+	webhooksCollectionName   = "webhooks"
+	deliveriesCollectionName = "deliveries"
+)
+
+// GetWebhooksCollection returns an mgo instance to the webhooks collection
+func (m *MongoStore) GetWebhooksCollection() *mgo.Collection {
+	return m.GetDatabase().C(webhooksCollectionName)
+}
+
+// GetDeliveriesCollection returns an mgo instance to the deliveries collection
+func (m *MongoStore) GetDeliveriesCollection() *mgo.Collection {
+	return m.GetDatabase().C(deliveriesCollectionName)
+}
+
+// CreateWebhook inserts webhook object into db
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) CreateWebhook(webhook *schema.Webhook) error {
+	defer m.observe("CreateWebhook", time.Now())
+	webhook.ID = bson.NewObjectId()
+	return storeError(m.GetWebhooksCollection().Insert(webhook))
+}
+
+// GetWebhook looks up the webhook with given id
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) GetWebhook(webhookID string) (*schema.Webhook, error) {
+	defer m.observe("GetWebhook", time.Now())
+	id, err := objectID(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	webhook := schema.Webhook{}
+	if err := m.GetWebhooksCollection().FindId(id).One(&webhook); err != nil {
+		return nil, storeError(err)
+	}
+	return &webhook, nil
+}
+
+// GetWebhooksForUser retrieves the webhooks owned by userID
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) GetWebhooksForUser(userID bson.ObjectId) ([]*schema.Webhook, error) {
+	defer m.observe("GetWebhooksForUser", time.Now())
+	webhooks := []*schema.Webhook{}
+	if err := m.GetWebhooksCollection().Find(bson.M{"ownerID": userID}).Sort("_id").All(&webhooks); err != nil {
+		return nil, storeError(err)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the webhook with the same id
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) UpdateWebhook(webhook *schema.Webhook) error {
+	defer m.observe("UpdateWebhook", time.Now())
+	return storeError(m.GetWebhooksCollection().UpdateId(webhook.ID, webhook))
+}
+
+// DeleteWebhook removes the webhook with given id along with its deliveries
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) DeleteWebhook(webhookID string) error {
+	defer m.observe("DeleteWebhook", time.Now())
+	id, err := objectID(webhookID)
+	if err != nil {
+		return err
+	}
+	if err := m.GetWebhooksCollection().RemoveId(id); err != nil {
+		return storeError(err)
+	}
+	_, err = m.GetDeliveriesCollection().RemoveAll(bson.M{"webhookID": id})
+	return storeError(err)
+}
+
+// DeleteWebhooksForUser removes the webhooks owned by or scoped to userID
+//   along with their deliveries
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) DeleteWebhooksForUser(userID string) error {
+	defer m.observe("DeleteWebhooksForUser", time.Now())
+	id, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	webhooks := []*schema.Webhook{}
+	q := bson.M{"$or": []bson.M{{"ownerID": id}, {"userID": id}}}
+	if err := m.GetWebhooksCollection().Find(q).Select(bson.M{"_id": 1}).All(&webhooks); err != nil {
+		return storeError(err)
+	}
+	ids := []bson.ObjectId{}
+	for _, w := range webhooks {
+		ids = append(ids, w.ID)
+	}
+	if _, err := m.GetWebhooksCollection().RemoveAll(bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return storeError(err)
+	}
+	_, err = m.GetDeliveriesCollection().RemoveAll(bson.M{"webhookID": bson.M{"$in": ids}})
+	return storeError(err)
+}
+
+// queueDeliveries queues the events of a kind in the outbox of every
+//   active webhook subscribed to them
+func (m *MongoStore) queueDeliveries(event string, events []*schema.Event) error {
+	userIDs := []bson.ObjectId{}
+	seen := map[bson.ObjectId]bool{}
+	for _, ev := range events {
+		if !seen[ev.UserID] {
+			seen[ev.UserID] = true
+			userIDs = append(userIDs, ev.UserID)
+		}
+	}
+	q := bson.M{
+		"active": true,
+		"events": event,
+		"$or":    []bson.M{{"userID": nil}, {"userID": bson.M{"$in": userIDs}}},
+	}
+	webhooks := []*schema.Webhook{}
+	if err := m.GetWebhooksCollection().Find(q).All(&webhooks); err != nil {
+		return storeError(err)
+	}
+
+	deliveries := []interface{}{}
+	for _, ev := range events {
+		for _, w := range webhooks {
+			if w.UserID != nil && *w.UserID != ev.UserID {
+				continue
+			}
+			d := &schema.Delivery{
+				ID:          bson.NewObjectId(),
+				WebhookID:   w.ID,
+				Event:       ev.Type,
+				Status:      schema.DeliveryPending,
+				NextAttempt: ev.CreatedAt,
+				Attempts:    []*schema.DeliveryAttempt{},
+				CreatedAt:   ev.CreatedAt,
+			}
+			payload, err := json.Marshal(map[string]interface{}{
+				"id":        d.ID,
+				"event":     ev.Type,
+				"createdAt": ev.CreatedAt,
+				"data":      json.RawMessage(ev.Data),
+			})
+			if err != nil {
+				return err
+			}
+			d.Payload = string(payload)
+			deliveries = append(deliveries, d)
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return storeError(m.GetDeliveriesCollection().Insert(deliveries...))
+}
+
+// GetDeliveries retrieves the delivery log of webhookID listed by opts
+//   newest first unless sorted by createdAt
+func (m *MongoStore) GetDeliveries(webhookID string, opts *ListOptions) ([]*schema.Delivery, *Page, error) {
+	defer m.observe("GetDeliveries", time.Now())
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	if len(opts.Sort) == 0 {
+		opts.Sort = "-_id"
+	} else if err := validateSort(opts.Sort, "_id", "createdAt"); err != nil {
+		return nil, nil, err
+	}
+	id, err := objectID(webhookID)
+	if err != nil {
+		return nil, nil, err
+	}
+	q := bson.M{"webhookID": id}
+	if opts.Query != nil {
+		q = bson.M{"$and": []bson.M{q, opts.Query}}
+	}
+	opts.Query = q
+
+	deliveries := []*schema.Delivery{}
+	page, err := list(m.GetDeliveriesCollection(), opts, &deliveries)
+	if err != nil {
+		return nil, nil, storeError(err)
+	}
+	return deliveries, page, nil
+}
+
+// GetDelivery looks up the delivery of webhookID with given id
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) GetDelivery(webhookID, deliveryID string) (*schema.Delivery, error) {
+	defer m.observe("GetDelivery", time.Now())
+	id, err := objectID(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	hookID, err := objectID(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	d := schema.Delivery{}
+	q := bson.M{"_id": id, "webhookID": hookID}
+	if err := m.GetDeliveriesCollection().Find(q).One(&d); err != nil {
+		return nil, storeError(err)
+	}
+	return &d, nil
+}
+
+// ClaimDelivery takes the pending delivery due the earliest off the outbox
+//   for lease, after which it is due again unless its attempt is recorded
+// error is 500 if mongo fails, 404 if none is due, else nil
+func (m *MongoStore) ClaimDelivery(lease time.Duration) (*schema.Delivery, error) {
+	defer m.observe("ClaimDelivery", time.Now())
+	now := time.Now()
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"nextAttempt": int(now.Add(lease).Unix())}},
+		ReturnNew: true,
+	}
+	d := schema.Delivery{}
+	q := bson.M{"status": schema.DeliveryPending, "nextAttempt": bson.M{"$lte": int(now.Unix())}}
+	if _, err := m.GetDeliveriesCollection().Find(q).Sort("nextAttempt").Apply(change, &d); err != nil {
+		return nil, storeError(err)
+	}
+	return &d, nil
+}
+
+// RecordAttempt logs attempt of delivery and moves it to status,
+//   due again at nextAttempt if still pending
+// error is 500 if mongo fails, else nil
+func (m *MongoStore) RecordAttempt(deliveryID bson.ObjectId, attempt *schema.DeliveryAttempt, status string, nextAttempt int) error {
+	defer m.observe("RecordAttempt", time.Now())
+	set := bson.M{"status": status, "nextAttempt": nextAttempt}
+	if status != schema.DeliveryPending {
+		set["nextAttempt"] = 0
+	}
+	return storeError(m.GetDeliveriesCollection().UpdateId(deliveryID, bson.M{
+		"$set":  set,
+		"$push": bson.M{"attempts": attempt},
+	}))
+}
+
+// Redeliver queues a delivery again to be attempted right away
+// error is 500 if mongo fails, 404 if not found, else nil
+func (m *MongoStore) Redeliver(deliveryID bson.ObjectId) error {
+	defer m.observe("Redeliver", time.Now())
+	return storeError(m.GetDeliveriesCollection().UpdateId(deliveryID, bson.M{"$set": bson.M{
+		"status":      schema.DeliveryPending,
+		"nextAttempt": int(time.Now().Unix()),
+	}}))
+}
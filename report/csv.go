@@ -0,0 +1,47 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (c *csvWriter) Begin(title string) error {
+	return c.w.Write([]string{"date", "start", "finish", "duration", "user", "title", "description"})
+}
+
+func (c *csvWriter) Row(r *Row) error {
+	return c.w.Write([]string{
+		r.Date, r.Start, r.Finish, strconv.Itoa(r.Duration),
+		r.User, r.Title, r.Description,
+	})
+}
+
+// DayTotal writes a total line and flushes the day to the client
+func (c *csvWriter) DayTotal(date string, total int) error {
+	if err := c.w.Write([]string{date, "", "", strconv.Itoa(total), "", "total", ""}); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) End(total int) error {
+	if err := c.w.Write([]string{"", "", "", strconv.Itoa(total), "", "total", ""}); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,44 @@
+package report
+
+import (
+	"fmt"
+	"html"
+	"io"
+)
+
+type htmlWriter struct {
+	w io.Writer
+}
+
+func newHTMLWriter(w io.Writer) *htmlWriter {
+	return &htmlWriter{w: w}
+}
+
+func (h *htmlWriter) ContentType() string {
+	return "text/html; charset=utf-8"
+}
+
+func (h *htmlWriter) Begin(title string) error {
+	_, err := fmt.Fprintf(h.w, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<table>\n"+
+		"<tr><th>Date</th><th>Start</th><th>Finish</th><th>Duration</th><th>User</th><th>Title</th><th>Description</th></tr>\n",
+		html.EscapeString(title), html.EscapeString(title))
+	return err
+}
+
+func (h *htmlWriter) Row(r *Row) error {
+	_, err := fmt.Fprintf(h.w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+		r.Date, r.Start, r.Finish, FormatDuration(r.Duration),
+		html.EscapeString(r.User), html.EscapeString(r.Title), html.EscapeString(r.Description))
+	return err
+}
+
+func (h *htmlWriter) DayTotal(date string, total int) error {
+	_, err := fmt.Fprintf(h.w, "<tr><th>%s</th><th></th><th></th><th>%s</th><th colspan=\"3\">Total</th></tr>\n",
+		date, FormatDuration(total))
+	return err
+}
+
+func (h *htmlWriter) End(total int) error {
+	_, err := fmt.Fprintf(h.w, "</table>\n<p><b>Total Time: </b>%s</p>\n</body>\n</html>\n", FormatDuration(total))
+	return err
+}
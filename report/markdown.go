@@ -0,0 +1,44 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\n", " ", "\r", "")
+
+type markdownWriter struct {
+	w io.Writer
+}
+
+func newMarkdownWriter(w io.Writer) *markdownWriter {
+	return &markdownWriter{w: w}
+}
+
+func (m *markdownWriter) ContentType() string {
+	return "text/markdown; charset=utf-8"
+}
+
+func (m *markdownWriter) Begin(title string) error {
+	_, err := fmt.Fprintf(m.w, "# %s\n\n| Date | Start | Finish | Duration | User | Title | Description |\n|---|---|---|---|---|---|---|\n",
+		markdownEscaper.Replace(title))
+	return err
+}
+
+func (m *markdownWriter) Row(r *Row) error {
+	_, err := fmt.Fprintf(m.w, "| %s | %s | %s | %s | %s | %s | %s |\n",
+		r.Date, r.Start, r.Finish, FormatDuration(r.Duration),
+		markdownEscaper.Replace(r.User), markdownEscaper.Replace(r.Title), markdownEscaper.Replace(r.Description))
+	return err
+}
+
+func (m *markdownWriter) DayTotal(date string, total int) error {
+	_, err := fmt.Fprintf(m.w, "| **%s** | | | **%s** | | **Total** | |\n", date, FormatDuration(total))
+	return err
+}
+
+func (m *markdownWriter) End(total int) error {
+	_, err := fmt.Fprintf(m.w, "\n**Total Time:** %s\n", FormatDuration(total))
+	return err
+}
@@ -0,0 +1,142 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	pdfPageWidth   = 595 // A4 in points
+	pdfPageHeight  = 842
+	pdfMargin      = 40
+	pdfFontSize    = 8
+	pdfLeading     = 11
+	pdfLinesOnPage = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+
+	// Objects 1 through 3 are allocated up front, pages follow
+	pdfCatalogObj = 1
+	pdfPagesObj   = 2
+	pdfFontObj    = 3
+)
+
+var pdfEscaper = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+
+// pdfWriter renders a timesheet as a plain PDF in a monospace font.
+// Pages are written out as soon as they fill up and the page tree
+// is written last, so only a single page is held in memory
+type pdfWriter struct {
+	w       io.Writer
+	err     error
+	n       int
+	offsets map[int]int
+	nextObj int
+	pages   []int
+	lines   []string
+}
+
+func newPDFWriter(w io.Writer) *pdfWriter {
+	return &pdfWriter{w: w, offsets: map[int]int{}, nextObj: pdfFontObj + 1}
+}
+
+func (p *pdfWriter) ContentType() string {
+	return "application/pdf"
+}
+
+func (p *pdfWriter) write(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.n += n
+	p.err = err
+}
+
+func (p *pdfWriter) object(num int, body string) {
+	p.offsets[num] = p.n
+	p.write("%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+func (p *pdfWriter) line(format string, args ...interface{}) error {
+	p.lines = append(p.lines, fmt.Sprintf(format, args...))
+	if len(p.lines) >= pdfLinesOnPage {
+		p.flushPage()
+	}
+	return p.err
+}
+
+// flushPage writes the buffered lines as a content stream and page object
+func (p *pdfWriter) flushPage() {
+	content := &bytes.Buffer{}
+	fmt.Fprintf(content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin)
+	for _, l := range p.lines {
+		fmt.Fprintf(content, "(%s) '\n", pdfEscaper.Replace(latin1(l)))
+	}
+	content.WriteString("ET")
+
+	contentObj, pageObj := p.nextObj, p.nextObj+1
+	p.nextObj += 2
+	p.object(contentObj, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	p.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+		pdfPagesObj, pdfPageWidth, pdfPageHeight, pdfFontObj, contentObj))
+	p.pages = append(p.pages, pageObj)
+	p.lines = nil
+}
+
+func (p *pdfWriter) Begin(title string) error {
+	p.write("%%PDF-1.4\n")
+	p.object(pdfCatalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pdfPagesObj))
+	p.object(pdfFontObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	p.line("%s", title)
+	p.line("")
+	return p.line("%-10s %-5s %-6s %8s  %-12s %s", "Date", "Start", "Finish", "Duration", "User", "Title / Description")
+}
+
+func (p *pdfWriter) Row(r *Row) error {
+	text := r.Title
+	if len(r.Description) > 0 {
+		text += ": " + r.Description
+	}
+	return p.line("%-10s %-5s %-6s %8s  %-12.12s %.60s",
+		r.Date, r.Start, r.Finish, FormatDuration(r.Duration), r.User, text)
+}
+
+func (p *pdfWriter) DayTotal(date string, total int) error {
+	p.line("%-10s %-5s %-6s %8s  Total", date, "", "", FormatDuration(total))
+	return p.line("")
+}
+
+func (p *pdfWriter) End(total int) error {
+	p.line("Total Time: %s", FormatDuration(total))
+	p.flushPage()
+
+	// Page tree
+	kids := make([]string, len(p.pages))
+	for i, page := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", page)
+	}
+	p.object(pdfPagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+
+	// Cross reference table and trailer
+	xref := p.n
+	p.write("xref\n0 %d\n0000000000 65535 f \n", p.nextObj)
+	for num := 1; num < p.nextObj; num++ {
+		p.write("%010d 00000 n \n", p.offsets[num])
+	}
+	p.write("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", p.nextObj, pdfCatalogObj, xref)
+	return p.err
+}
+
+// latin1 encodes s as single bytes, replacing the runes
+// WinAnsiEncoding can't display
+func latin1(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff || r < 0x20 {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
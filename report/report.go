@@ -0,0 +1,114 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+const (
+	dateLayout  = "2006-01-02"
+	clockLayout = "15:04"
+)
+
+// Formats lists the supported report formats
+var Formats = []string{"csv", "html", "pdf", "md"}
+
+// Row is a single task line of a timesheet
+type Row struct {
+	Date        string
+	Start       string
+	Finish      string
+	Duration    int
+	User        string
+	Title       string
+	Description string
+}
+
+// Writer renders a timesheet as it is streamed. Begin is called once,
+// followed by the rows of each day and that day's DayTotal, and End
+// is called last with the grand total
+type Writer interface {
+	ContentType() string
+	Begin(title string) error
+	Row(r *Row) error
+	DayTotal(date string, total int) error
+	End(total int) error
+}
+
+// NewWriter returns a Writer for format writing to w
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case "csv":
+		return newCSVWriter(w), nil
+	case "html":
+		return newHTMLWriter(w), nil
+	case "pdf":
+		return newPDFWriter(w), nil
+	case "md":
+		return newMarkdownWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown report format %v", format)
+}
+
+// Timesheet streams the tasks returned by next, which must be ordered
+// by start, to w grouped by the day they start on in loc
+//   usernames maps user ids to the names displayed in the report
+func Timesheet(w Writer, title string, next func(*schema.Task) bool, usernames map[string]string, loc *time.Location) error {
+	if err := w.Begin(title); err != nil {
+		return err
+	}
+
+	day, dayTotal, total := "", 0, 0
+	for {
+		task := &schema.Task{}
+		if !next(task) {
+			break
+		}
+
+		start := time.Unix(int64(*task.Start), 0).In(loc)
+		finish := time.Unix(int64(*task.Finish), 0).In(loc)
+
+		// Close the previous day
+		date := start.Format(dateLayout)
+		if date != day {
+			if len(day) > 0 {
+				if err := w.DayTotal(day, dayTotal); err != nil {
+					return err
+				}
+			}
+			day, dayTotal = date, 0
+		}
+
+		row := &Row{
+			Date:        date,
+			Start:       start.Format(clockLayout),
+			Finish:      finish.Format(clockLayout),
+			Duration:    *task.Finish - *task.Start,
+			Title:       task.Title,
+			Description: task.Description,
+		}
+		if task.UserID != nil {
+			row.User = usernames[task.UserID.Hex()]
+		}
+		if err := w.Row(row); err != nil {
+			return err
+		}
+		dayTotal += row.Duration
+		total += row.Duration
+	}
+
+	if len(day) > 0 {
+		if err := w.DayTotal(day, dayTotal); err != nil {
+			return err
+		}
+	}
+	return w.End(total)
+}
+
+// FormatDuration renders seconds as hours and minutes
+func FormatDuration(seconds int) string {
+	return fmt.Sprintf("%dh%02dm", seconds/3600, (seconds%3600)/60)
+}
@@ -0,0 +1,97 @@
+package report
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+func newTasks() []*schema.Task {
+	userID := bson.ObjectIdHex("5a0000000000000000000001")
+	day1 := 1768208400 // 2026-01-12T09:00:00Z
+	day2 := day1 + 24*3600
+	return []*schema.Task{
+		{TimeRange: *schema.NewTimeRange(day1, day1+3600), UserID: &userID, Title: "foo", Description: "bar"},
+		{TimeRange: *schema.NewTimeRange(day1+7200, day1+9000), UserID: &userID, Title: "a|b", Description: "<c>"},
+		{TimeRange: *schema.NewTimeRange(day2, day2+600), UserID: &userID, Title: "baz"},
+	}
+}
+
+func timesheet(t *testing.T, format string) string {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(format, buf)
+	assert.Nil(t, err)
+
+	tasks := newTasks()
+	i := 0
+	next := func(task *schema.Task) bool {
+		if i == len(tasks) {
+			return false
+		}
+		*task = *tasks[i]
+		i++
+		return true
+	}
+	err = Timesheet(w, "Timesheet", next, map[string]string{"5a0000000000000000000001": "alice"}, time.UTC)
+	assert.Nil(t, err)
+	return buf.String()
+}
+
+func Test001_Unknown(t *testing.T) {
+	_, err := NewWriter("doc", &bytes.Buffer{})
+	assert.Equal(t, "unknown report format doc", err.Error())
+}
+
+func Test002_CSV(t *testing.T) {
+	assert.Equal(t, `date,start,finish,duration,user,title,description
+2026-01-12,09:00,10:00,3600,alice,foo,bar
+2026-01-12,11:00,11:30,1800,alice,a|b,<c>
+2026-01-12,,,5400,,total,
+2026-01-13,09:00,09:10,600,alice,baz,
+2026-01-13,,,600,,total,
+,,,6000,,total,
+`, timesheet(t, "csv"))
+}
+
+func Test003_HTML(t *testing.T) {
+	out := timesheet(t, "html")
+	assert.Contains(t, out, "<td>a|b</td><td>&lt;c&gt;</td>")
+	assert.Contains(t, out, "<tr><th>2026-01-12</th><th></th><th></th><th>1h30m</th>")
+	assert.Contains(t, out, "<p><b>Total Time: </b>1h40m</p>")
+}
+
+func Test004_Markdown(t *testing.T) {
+	out := timesheet(t, "md")
+	assert.Contains(t, out, "| 2026-01-12 | 11:00 | 11:30 | 0h30m | alice | a\\|b | <c> |\n")
+	assert.Contains(t, out, "| **2026-01-13** | | | **0h10m** | | **Total** | |\n")
+	assert.Contains(t, out, "**Total Time:** 1h40m")
+}
+
+func Test005_PDF(t *testing.T) {
+	out := timesheet(t, "pdf")
+	assert.True(t, strings.HasPrefix(out, "%PDF-1.4\n"))
+	assert.True(t, strings.HasSuffix(out, "%%EOF\n"))
+	assert.Contains(t, out, "(2026-01-12                 1h30m  Total) '")
+	assert.Contains(t, out, "/Count 1")
+
+	// Every xref entry points at the start of its object
+	xref := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(out, -1)
+	assert.Equal(t, 5, len(xref))
+	for i, entry := range xref {
+		offset, _ := strconv.Atoi(entry[1])
+		assert.True(t, strings.HasPrefix(out[offset:], strconv.Itoa(i+1)+" 0 obj\n"))
+	}
+
+	// startxref points at the xref table
+	start := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(out)
+	offset, _ := strconv.Atoi(start[1])
+	assert.True(t, strings.HasPrefix(out[offset:], "xref\n"))
+}
@@ -0,0 +1,12 @@
+// Package pb is the generated client and server of the ManageMe gRPC
+// service of manageme.proto. Other Go services call ManageMe with
+//
+//	conn, err := grpc.NewClient("localhost:8890", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	client := pb.NewManageMeClient(conn)
+//	ctx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+session)
+//	tasks, err := client.ListTasks(ctx, &pb.ListTasksRequest{})
+//
+// It is regenerated with protoc, protoc-gen-go and protoc-gen-go-grpc
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative manageme.proto
@@ -0,0 +1,252 @@
+// Package search parses the task search language, a list of terms that
+// must all match, such as
+//
+//	title:"design review" tag:client-x duration>2h user:alice before:2026-01-01
+//
+// Bare words and quoted phrases match the title or description, and any
+// term can be negated with a leading -
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const dateLayout = "2006-01-02"
+
+// Fields lists the fields terms can be qualified with
+var Fields = []string{"title", "description", "tag", "user", "duration", "before", "after", "on"}
+
+// Query is the parsed form of a search, its terms must all match
+type Query struct {
+	Terms []Term
+}
+
+// Term is a single condition of a query
+type Term interface {
+	// Pos is the column the term starts at, from 1
+	Pos() int
+	// Negated reports whether the term must not match
+	Negated() bool
+}
+
+type term struct {
+	At  int
+	Not bool
+}
+
+func (t *term) Pos() int      { return t.At }
+func (t *term) Negated() bool { return t.Not }
+
+// Text matches words or a phrase in the title or description
+type Text struct {
+	term
+	Value  string
+	Phrase bool
+}
+
+// Match matches a substring of the title or description, ignoring case
+type Match struct {
+	term
+	Field string
+	Value string
+}
+
+// Tag matches tasks tagged with Name
+type Tag struct {
+	term
+	Name string
+}
+
+// User matches the tasks of the user with Username
+type User struct {
+	term
+	Username string
+}
+
+// Duration compares the length of tasks to Value with Op,
+// one of =, <, <=, > or >=
+type Duration struct {
+	term
+	Op    string
+	Value time.Duration
+}
+
+// Date matches tasks starting before, after or on the day
+// from From up to To
+type Date struct {
+	term
+	Op       string
+	From, To time.Time
+}
+
+// ParseError reports where a search couldn't be parsed
+type ParseError struct {
+	// Pos is the column of the error, from 1
+	Pos     int
+	Message string
+}
+
+func (err ParseError) Error() string {
+	return fmt.Sprintf("at %d: %v", err.Pos, err.Message)
+}
+
+// parser scans the runes of a search
+type parser struct {
+	s   []rune
+	i   int
+	loc *time.Location
+}
+
+func (p *parser) errorf(at int, format string, args ...interface{}) *ParseError {
+	return &ParseError{Pos: at + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) skipSpace() {
+	for p.i < len(p.s) && unicode.IsSpace(p.s[p.i]) {
+		p.i++
+	}
+}
+
+// quoted reads a phrase in double quotes, with \" and \\ escaped
+func (p *parser) quoted() (string, error) {
+	start := p.i
+	p.i++
+	b := strings.Builder{}
+	for p.i < len(p.s) {
+		r := p.s[p.i]
+		p.i++
+		switch {
+		case r == '\\' && p.i < len(p.s):
+			b.WriteRune(p.s[p.i])
+			p.i++
+		case r == '"':
+			return b.String(), nil
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return "", p.errorf(start, "unterminated quote")
+}
+
+// word reads up to the next space
+func (p *parser) word() string {
+	start := p.i
+	for p.i < len(p.s) && !unicode.IsSpace(p.s[p.i]) {
+		p.i++
+	}
+	return string(p.s[start:p.i])
+}
+
+// Parse parses a search, dates are days in loc
+func Parse(s string, loc *time.Location) (*Query, error) {
+	p := &parser{s: []rune(s), loc: loc}
+	q := &Query{Terms: []Term{}}
+	for {
+		p.skipSpace()
+		if p.i == len(p.s) {
+			return q, nil
+		}
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		q.Terms = append(q.Terms, t)
+	}
+}
+
+func (p *parser) term() (Term, error) {
+	base := term{At: p.i + 1}
+	if p.s[p.i] == '-' && p.i+1 < len(p.s) && !unicode.IsSpace(p.s[p.i+1]) {
+		base.Not = true
+		p.i++
+	}
+
+	// Phrase
+	if p.s[p.i] == '"' {
+		v, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return &Text{term: base, Value: v, Phrase: true}, nil
+	}
+
+	// A field is a name followed by an operator
+	start := p.i
+	for p.i < len(p.s) && (unicode.IsLetter(p.s[p.i]) || p.s[p.i] == '_') {
+		p.i++
+	}
+	name := strings.ToLower(string(p.s[start:p.i]))
+	op := ""
+	for p.i < len(p.s) && strings.ContainsRune(":<>=", p.s[p.i]) && len(op) < 2 {
+		op += string(p.s[p.i])
+		p.i++
+	}
+	if len(name) == 0 || len(op) == 0 {
+		p.i = start
+		w := p.word()
+		return &Text{term: base, Value: w}, nil
+	}
+
+	// Value
+	at := p.i
+	var value string
+	if p.i < len(p.s) && p.s[p.i] == '"' {
+		v, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		value = v
+	} else {
+		value = p.word()
+	}
+	if len(value) == 0 {
+		return nil, p.errorf(at, "expected a value after %v%v", name, op)
+	}
+	return p.field(base, start, name, op, at, value)
+}
+
+// field builds the term for name op value
+func (p *parser) field(base term, start int, name, op string, at int, value string) (Term, error) {
+	if name != "duration" && op != ":" {
+		return nil, p.errorf(start+len([]rune(name)), "expected : after %v", name)
+	}
+
+	switch name {
+	case "title", "description":
+		return &Match{term: base, Field: name, Value: value}, nil
+	case "tag":
+		return &Tag{term: base, Name: value}, nil
+	case "user":
+		return &User{term: base, Username: value}, nil
+	case "duration":
+		if op == ":" {
+			op = "="
+		}
+		if !isComparison(op) {
+			return nil, p.errorf(start+len([]rune(name)), "expected one of :, =, <, <=, > or >= after duration")
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, p.errorf(at, "expected a duration such as 90m or 1h30m")
+		}
+		return &Duration{term: base, Op: op, Value: d}, nil
+	case "before", "after", "on":
+		day, err := time.ParseInLocation(dateLayout, value, p.loc)
+		if err != nil {
+			return nil, p.errorf(at, "expected a date as YYYY-MM-DD")
+		}
+		return &Date{term: base, Op: name, From: day, To: day.AddDate(0, 0, 1)}, nil
+	}
+	return nil, p.errorf(start, "unknown field %v, expected one of %v", name, strings.Join(Fields, ", "))
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test001_Parse(t *testing.T) {
+	q, err := Parse(`title:"design review" tag:client-x duration>2h user:alice before:2026-01-01 -draft "q1 plan"`, time.UTC)
+	assert.Nil(t, err)
+	assert.Equal(t, []Term{
+		&Match{term: term{At: 1}, Field: "title", Value: "design review"},
+		&Tag{term: term{At: 23}, Name: "client-x"},
+		&Duration{term: term{At: 36}, Op: ">", Value: 2 * time.Hour},
+		&User{term: term{At: 48}, Username: "alice"},
+		&Date{term: term{At: 59}, Op: "before",
+			From: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)},
+		&Text{term: term{At: 77, Not: true}, Value: "draft"},
+		&Text{term: term{At: 84}, Value: "q1 plan", Phrase: true},
+	}, q.Terms)
+
+	q, err = Parse(`  -tag:"a b"  duration:90m  Title:x\"y  client-x `, time.UTC)
+	assert.Nil(t, err)
+	assert.Equal(t, []Term{
+		&Tag{term: term{At: 3, Not: true}, Name: "a b"},
+		&Duration{term: term{At: 15}, Op: "=", Value: 90 * time.Minute},
+		&Match{term: term{At: 29}, Field: "title", Value: `x\"y`},
+		&Text{term: term{At: 41}, Value: "client-x"},
+	}, q.Terms)
+
+	q, err = Parse("", time.UTC)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(q.Terms))
+}
+
+func Test002_Errors(t *testing.T) {
+	for s, msg := range map[string]string{
+		`title:"design`:           `at 7: unterminated quote`,
+		`foo title:`:              `at 11: expected a value after title:`,
+		`colour:red`:              `at 1: unknown field colour, expected one of title, description, tag, user, duration, before, after, on`,
+		`tag>x`:                   `at 4: expected : after tag`,
+		`duration>=two`:           `at 11: expected a duration such as 90m or 1h30m`,
+		`duration<>1h`:            `at 9: expected one of :, =, <, <=, > or >= after duration`,
+		`ok -after:Jan`:           `at 11: expected a date as YYYY-MM-DD`,
+		`é "ü" before:2026-13-01`: `at 14: expected a date as YYYY-MM-DD`,
+	} {
+		_, err := Parse(s, time.UTC)
+		if assert.NotNil(t, err, s) {
+			assert.Equal(t, msg, err.Error(), s)
+		}
+	}
+}
+
+func Test003_Dates(t *testing.T) {
+	loc, _ := time.LoadLocation("America/New_York")
+	q, err := Parse("on:2026-03-08", loc)
+	assert.Nil(t, err)
+
+	// The day DST starts is 23 hours long
+	d := q.Terms[0].(*Date)
+	assert.Equal(t, 23*time.Hour, d.To.Sub(d.From))
+}
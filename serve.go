@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/briansan/ManageMeServer/api"
+	"github.com/briansan/ManageMeServer/config"
+	"github.com/briansan/ManageMeServer/model/store"
+	"github.com/briansan/ManageMeServer/trace"
+	"github.com/briansan/ManageMeServer/webhook"
+	"github.com/briansan/ManageMeServer/www"
+)
+
+var serveCommand = &command{
+	name:    "serve",
+	summary: "serve the api, the webapp or both by the mode of the config",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 0); err != nil {
+				return err
+			}
+			if err := cfg.Validate(); err != nil {
+				return usageError{fmt.Errorf("invalid config: %v", err)}
+			}
+			if cfg.Mode == config.ModeWWW {
+				return runWWW(cfg)
+			}
+			return runAPI(cfg)
+		}
+	},
+}
+
+// server is a listener that shuts down gracefully
+type server interface {
+	Shutdown(ctx context.Context) error
+}
+
+// grpcServer shuts down a gRPC server gracefully, stopping it at once
+//   when ctx is done first
+type grpcServer struct {
+	*grpc.Server
+}
+
+func (s grpcServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
+
+// serve runs start in the background, sending its error to errs unless
+//   it is the one of a server shut down
+func serve(errs chan<- error, start func() error) {
+	go func() {
+		if err := start(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+}
+
+// wait blocks until a signal asks to stop or a server fails, returning
+//   the error of the server
+func wait(errs <-chan error) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case sig := <-signals:
+		logger.Info("shutting down", "signal", sig)
+		return nil
+	case err := <-errs:
+		logger.Error("server failed, shutting down", "err", err)
+		return err
+	}
+}
+
+// shutdown shuts down servers, giving the requests in flight timeout
+//   seconds to finish
+func shutdown(timeout int, servers ...server) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				logger.Warn("shutdown interrupted", "err", err)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+// runAPI serves the api, with the webapp in all mode, until stopped. Then
+//   it stops its workers and closes the mongo session
+func runAPI(cfg *config.Config) error {
+	if err := store.InitMongoSession(&cfg.Store); err != nil {
+		return err
+	}
+	defer store.CleanupMongoSession()
+	if err := trace.Init(&cfg.Trace); err != nil {
+		return err
+	}
+	defer trace.Shutdown()
+
+	// Workers run until the servers are shut down, the networks being
+	//   validated with the config
+	webhookNetworks, _ := cfg.API.WebhookNetworks()
+	stop := make(chan struct{})
+	var workers sync.WaitGroup
+	for _, run := range []func(<-chan struct{}){
+		store.Reconnect,
+		webhook.NewDispatcher(webhookNetworks).Run,
+	} {
+		workers.Add(1)
+		go func(run func(<-chan struct{})) {
+			defer workers.Done()
+			run(stop)
+		}(run)
+	}
+	defer workers.Wait()
+	defer close(stop)
+
+	e, err := api.New(&cfg.API)
+	if err != nil {
+		return err
+	}
+	// The webapp calls the api of its own origin
+	if cfg.Mode == config.ModeAll {
+		if err := www.Mount(e, cfg.WWW.AssetsDir, ""); err != nil {
+			return err
+		}
+	}
+	rpcServer := api.NewRPCServer()
+	metricsServer := api.NewMetricsServer(&cfg.API)
+
+	// gRPC is served on its own port, once api.New has set up sessions.
+	//   Metrics are served apart, so they aren't public with the api
+	errs := make(chan error, 3)
+	serve(errs, func() error { return e.Start(cfg.API.Addr()) })
+	serve(errs, func() error {
+		l, err := net.Listen("tcp", cfg.API.GRPCAddr())
+		if err != nil {
+			return err
+		}
+		return rpcServer.Serve(l)
+	})
+	serve(errs, func() error { return metricsServer.Start(cfg.API.MetricsAddr()) })
+
+	err = wait(errs)
+
+	// Event streams would hold up the shutdown of the api
+	api.Drain()
+	shutdown(cfg.ShutdownTimeout, e.Server, grpcServer{rpcServer}, metricsServer.Server)
+	return err
+}
+
+// runWWW serves the webapp until stopped
+func runWWW(cfg *config.Config) error {
+	e, err := www.New(&cfg.WWW)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, 1)
+	serve(errs, func() error { return e.Start(cfg.WWW.Addr()) })
+	err = wait(errs)
+	shutdown(cfg.ShutdownTimeout, e.Server)
+	return err
+}
@@ -0,0 +1,152 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mgutz/logxi/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/briansan/ManageMeServer/config"
+)
+
+const (
+	// queueSize is how many ended spans can wait for export before new
+	//   ones are dropped
+	queueSize = 2048
+
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+
+	// shutdownTimeout bounds the export of the spans left on shutdown
+	shutdownTimeout = 10 * time.Second
+
+	// serviceName names the server in the resource of its spans
+	serviceName = "manageme"
+	// scopeName names the instrumentation of the spans
+	scopeName = "github.com/briansan/ManageMeServer/trace"
+)
+
+var (
+	logger = log.New("trace")
+
+	mu       sync.RWMutex
+	provider *sdktrace.TracerProvider
+	closer   io.Closer
+
+	disabled = noop.NewTracerProvider().Tracer(scopeName)
+)
+
+func init() {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("failed to export spans", "err", err)
+	}))
+}
+
+// tracer returns the tracer of the spans, one doing nothing while tracing
+//   is disabled
+func tracer() oteltrace.Tracer {
+	mu.RLock()
+	defer mu.RUnlock()
+	if provider == nil {
+		return disabled
+	}
+	return provider.Tracer(scopeName)
+}
+
+// Enabled tells whether spans are recorded
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return provider != nil
+}
+
+// SetExporter records spans and exports them to exp in batches, replacing
+//   any exporter set before. A nil exp disables tracing
+func SetExporter(exp sdktrace.SpanExporter) {
+	Shutdown()
+	if exp == nil {
+		return
+	}
+
+	p := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp,
+			sdktrace.WithMaxQueueSize(queueSize),
+			sdktrace.WithMaxExportBatchSize(batchSize),
+			sdktrace.WithBatchTimeout(flushInterval),
+		),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	mu.Lock()
+	provider = p
+	mu.Unlock()
+}
+
+// Init sets the exporter configured by cfg
+func Init(cfg *config.Trace) error {
+	switch cfg.Exporter {
+	case config.ExporterNone:
+		SetExporter(nil)
+	case config.ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return err
+		}
+		SetExporter(exp)
+	case config.ExporterFile:
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return err
+		}
+		SetExporter(exp)
+		mu.Lock()
+		closer = f
+		mu.Unlock()
+	case config.ExporterOTLP:
+		exp, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		if err != nil {
+			return err
+		}
+		SetExporter(exp)
+	default:
+		return fmt.Errorf("unknown trace exporter %s", cfg.Exporter)
+	}
+	return nil
+}
+
+// Shutdown exports the spans ended so far and disables tracing
+func Shutdown() error {
+	mu.Lock()
+	p, c := provider, closer
+	provider, closer = nil, nil
+	mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := p.Shutdown(ctx)
+	if c != nil {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
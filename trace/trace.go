@@ -0,0 +1,64 @@
+// Package trace records the spans of requests and the store calls they
+// make with OpenTelemetry, propagated by W3C traceparent headers, and
+// exports them in batches to stdout, a file or a collector over OTLP
+package trace
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// HeaderTraceparent carries the span context of a request, see
+//   https://www.w3.org/TR/trace-context/
+const HeaderTraceparent = "traceparent"
+
+// Kind is the role of a span in a trace
+type Kind = oteltrace.SpanKind
+
+// Kinds of spans
+const (
+	KindInternal = oteltrace.SpanKindInternal
+	KindServer   = oteltrace.SpanKindServer
+	KindClient   = oteltrace.SpanKindClient
+)
+
+// propagator reads and writes traceparent headers
+var propagator = propagation.TraceContext{}
+
+// Extract returns ctx continuing the trace of the traceparent header of h,
+//   ctx itself if there is none or it isn't valid
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// Traced tells whether ctx has a span, of the process or remote, whose
+//   trace its own spans continue
+func Traced(ctx context.Context) bool {
+	return oteltrace.SpanContextFromContext(ctx).IsValid()
+}
+
+// Start starts a span named name as a child of the span of ctx, returning
+//   the context of the new span
+func Start(ctx context.Context, name string, kind Kind) (context.Context, oteltrace.Span) {
+	return tracer().Start(ctx, name, oteltrace.WithSpanKind(kind))
+}
+
+// StartAt starts a span like Start, as of start. The span does nothing
+//   while tracing is disabled
+func StartAt(ctx context.Context, name string, kind Kind, start time.Time) (context.Context, oteltrace.Span) {
+	return tracer().Start(ctx, name, oteltrace.WithSpanKind(kind), oteltrace.WithTimestamp(start))
+}
+
+// SetError records that the operation of span failed with err, if not nil
+func SetError(span oteltrace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,216 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/briansan/ManageMeServer/config"
+)
+
+// recorder keeps the spans it exports past the shutdown of tracing, which
+//   would reset them
+type recorder struct {
+	*tracetest.InMemoryExporter
+}
+
+func (recorder) Shutdown(context.Context) error {
+	return nil
+}
+
+func Test001_Traceparent(t *testing.T) {
+	h := http.Header{}
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := Extract(context.Background(), h)
+	assert.True(t, Traced(ctx))
+	sc := oteltrace.SpanContextFromContext(ctx)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID().String())
+	assert.True(t, sc.IsSampled())
+	assert.True(t, sc.IsRemote())
+
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	sc = oteltrace.SpanContextFromContext(Extract(context.Background(), h))
+	assert.True(t, sc.IsValid())
+	assert.False(t, sc.IsSampled())
+
+	// Later versions may add fields
+	h.Set(HeaderTraceparent, "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-foo")
+	assert.True(t, Traced(Extract(context.Background(), h)))
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-foo",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	}
+	for _, v := range invalid {
+		h.Set(HeaderTraceparent, v)
+		assert.False(t, Traced(Extract(context.Background(), h)), v)
+	}
+}
+
+func Test002_Spans(t *testing.T) {
+	// Spans do nothing while disabled
+	SetExporter(nil)
+	assert.False(t, Enabled())
+	ctx, span := Start(context.Background(), "foo", KindInternal)
+	assert.False(t, span.IsRecording())
+	assert.False(t, Traced(ctx))
+	SetError(span, fmt.Errorf("foo"))
+	span.End()
+
+	r := recorder{tracetest.NewInMemoryExporter()}
+	SetExporter(r)
+	assert.True(t, Enabled())
+	h := http.Header{}
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	remote := oteltrace.SpanContextFromContext(Extract(context.Background(), h))
+	ctx, parent := Start(Extract(context.Background(), h), "parent", KindServer)
+	_, child := Start(ctx, "child", KindClient)
+	SetError(child, fmt.Errorf("failed"))
+	SetError(child, nil)
+	child.End()
+	parent.End()
+
+	// Unsampled traces are propagated but not exported
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, span = Start(Extract(context.Background(), h), "unsampled", KindServer)
+	assert.True(t, Traced(ctx))
+	assert.False(t, span.SpanContext().IsSampled())
+	span.End()
+
+	// Spans without a parent start a trace
+	_, root := Start(context.Background(), "root", KindInternal)
+	root.End()
+	assert.NoError(t, Shutdown())
+	assert.False(t, Enabled())
+
+	spans := r.GetSpans()
+	if assert.Len(t, spans, 3) {
+		assert.Equal(t, "child", spans[0].Name)
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		assert.Equal(t, "failed", spans[0].Status.Description)
+		assert.Len(t, spans[0].Events, 1)
+		assert.Equal(t, remote.TraceID(), spans[0].SpanContext.TraceID())
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent.SpanID())
+		assert.Equal(t, "parent", spans[1].Name)
+		assert.Equal(t, remote.SpanID(), spans[1].Parent.SpanID())
+		assert.NotEqual(t, remote.TraceID(), spans[2].SpanContext.TraceID())
+		assert.False(t, spans[2].Parent.IsValid())
+		name, _ := spans[2].Resource.Set().Value("service.name")
+		assert.Equal(t, "manageme", name.AsString())
+	}
+}
+
+// fileSpan is the part of a span written by the file exporter a test reads
+type fileSpan struct {
+	Name               string
+	SpanKind           Kind
+	SpanContext        struct{ TraceID, SpanID string }
+	Parent             struct{ TraceID, SpanID string }
+	StartTime, EndTime time.Time
+	Attributes         []struct {
+		Key   string
+		Value struct{ Value interface{} }
+	}
+}
+
+func Test003_File(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "spans.json")
+	assert.NoError(t, Init(&config.Trace{Exporter: config.ExporterFile, File: name}))
+	ctx, parent := Start(context.Background(), "parent", KindServer)
+	_, child := StartAt(ctx, "child", KindClient, time.Now().Add(-time.Second))
+	child.SetAttributes(attribute.String("db.operation", "GetUserByID"))
+	child.End()
+	parent.End()
+	assert.NoError(t, Shutdown())
+
+	b, err := ioutil.ReadFile(name)
+	assert.NoError(t, err)
+	spans := []fileSpan{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		span := fileSpan{}
+		assert.NoError(t, dec.Decode(&span))
+		spans = append(spans, span)
+	}
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "child", spans[0].Name)
+		assert.Equal(t, KindClient, spans[0].SpanKind)
+		assert.Equal(t, parent.SpanContext().SpanID().String(), spans[0].Parent.SpanID)
+		assert.Equal(t, spans[1].SpanContext.TraceID, spans[0].SpanContext.TraceID)
+		assert.True(t, spans[0].EndTime.Sub(spans[0].StartTime) >= time.Second)
+		if assert.Len(t, spans[0].Attributes, 1) {
+			assert.Equal(t, "db.operation", spans[0].Attributes[0].Key)
+			assert.Equal(t, "GetUserByID", spans[0].Attributes[0].Value.Value)
+		}
+		assert.Equal(t, "parent", spans[1].Name)
+		assert.Equal(t, "0000000000000000", spans[1].Parent.SpanID)
+	}
+
+	assert.Error(t, Init(&config.Trace{Exporter: config.ExporterFile, File: t.TempDir()}))
+}
+
+func Test004_OTLP(t *testing.T) {
+	requests := make(chan *coltracepb.ExportTraceServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		b, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		req := &coltracepb.ExportTraceServiceRequest{}
+		assert.NoError(t, proto.Unmarshal(b, req))
+		requests <- req
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer server.Close()
+
+	assert.NoError(t, Init(&config.Trace{Exporter: config.ExporterOTLP, Endpoint: server.URL + "/v1/traces"}))
+	_, span := Start(context.Background(), "GET /api/users/:id", KindServer)
+	span.SetAttributes(attribute.Int("http.status_code", 500), attribute.String("http.route", "/api/users/:id"))
+	span.SetStatus(codes.Error, "Internal Server Error")
+	span.End()
+	assert.NoError(t, Shutdown())
+
+	req := <-requests
+	if !assert.Len(t, req.ResourceSpans, 1) {
+		return
+	}
+	rs := req.ResourceSpans[0]
+	if assert.Len(t, rs.Resource.Attributes, 1) {
+		assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+		assert.Equal(t, "manageme", rs.Resource.Attributes[0].Value.GetStringValue())
+	}
+	if !assert.Len(t, rs.ScopeSpans, 1) || !assert.Len(t, rs.ScopeSpans[0].Spans, 1) {
+		return
+	}
+	assert.Equal(t, "github.com/briansan/ManageMeServer/trace", rs.ScopeSpans[0].Scope.Name)
+	got := rs.ScopeSpans[0].Spans[0]
+	assert.Equal(t, "GET /api/users/:id", got.Name)
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, got.Kind)
+	assert.Equal(t, span.SpanContext().TraceID().String(), fmt.Sprintf("%x", got.TraceId))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, got.Status.Code)
+	assert.Equal(t, "Internal Server Error", got.Status.Message)
+	assert.Len(t, got.Attributes, 2)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/config"
+	"github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// randomPassword returns a password to hand out to a user
+func randomPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// parseRole returns the role called name
+func parseRole(name string) (int, error) {
+	role, ok := schema.RoleNames[name]
+	if !ok {
+		return 0, usageError{fmt.Errorf("unknown role %q, expected user, manager or admin", name)}
+	}
+	return role, nil
+}
+
+var userCreateCommand = &command{
+	name:    "user create",
+	summary: "create a user, printing its password if generated",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		username := fs.String("username", "", "username of the user")
+		email := fs.String("email", "", "email of the user")
+		password := fs.String("password", "", "password of the user, generated if empty")
+		roleName := fs.String("role", "user", "role of the user: user, manager or admin")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 0); err != nil {
+				return err
+			}
+			role, err := parseRole(*roleName)
+			if err != nil {
+				return err
+			}
+			generated := len(*password) == 0
+			if generated {
+				if *password, err = randomPassword(); err != nil {
+					return err
+				}
+			}
+			u := &schema.User{Username: username, Email: email, Password: password, Role: &role}
+			if err := u.Validate(); err != nil {
+				return usageError{err}
+			}
+
+			return withStore(cfg, store.InitMongoSession, func(db *store.MongoStore) error {
+				if err := db.CreateUser(u); err != nil {
+					return err
+				}
+				fmt.Fprintf(stdout, "created %s %s as %s\n", *roleName, *username, u.ID.Hex())
+				if generated {
+					fmt.Fprintf(stdout, "password: %s\n", *password)
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var userSetRoleCommand = &command{
+	name:    "user set-role",
+	args:    "<username> <role>",
+	summary: "give a user the role user, manager or admin",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 2); err != nil {
+				return err
+			}
+			role, err := parseRole(args[1])
+			if err != nil {
+				return err
+			}
+
+			return withStore(cfg, store.InitMongoSession, func(db *store.MongoStore) error {
+				user, err := db.GetUserByUsername(args[0])
+				if err != nil {
+					return fmt.Errorf("user %s: %v", args[0], err)
+				}
+				if _, err := db.UpdateUser(user.ID.Hex(), &schema.User{Role: &role}); err != nil {
+					return err
+				}
+				fmt.Fprintf(stdout, "%s is now %s, was %s\n", user.Username, args[1], schema.RoleName(user.Role))
+				return nil
+			})
+		}
+	},
+}
+
+var userResetPasswordCommand = &command{
+	name:    "user reset-password",
+	args:    "<username>",
+	summary: "set the password of a user, printing it if generated",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		password := fs.String("password", "", "new password, generated if empty")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 1); err != nil {
+				return err
+			}
+			generated := len(*password) == 0
+			if generated {
+				var err error
+				if *password, err = randomPassword(); err != nil {
+					return err
+				}
+			}
+
+			return withStore(cfg, store.InitMongoSession, func(db *store.MongoStore) error {
+				user, err := db.GetUserByUsername(args[0])
+				if err != nil {
+					return fmt.Errorf("user %s: %v", args[0], err)
+				}
+				if _, err := db.UpdateUser(user.ID.Hex(), &schema.User{Password: password}); err != nil {
+					return err
+				}
+				fmt.Fprintf(stdout, "password of %s reset\n", user.Username)
+				if generated {
+					fmt.Fprintf(stdout, "password: %s\n", *password)
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var userListCommand = &command{
+	name:    "user list",
+	summary: "list the users by username",
+	define: func(fs *flag.FlagSet) func(*config.Config, []string) error {
+		roleName := fs.String("role", "", "only list the users of this role")
+		return func(cfg *config.Config, args []string) error {
+			if err := expectArgs(args, 0); err != nil {
+				return err
+			}
+			opts := &store.ListOptions{Sort: "username"}
+			if len(*roleName) > 0 {
+				role, err := parseRole(*roleName)
+				if err != nil {
+					return err
+				}
+				opts.Query = bson.M{"role": role}
+			}
+
+			return withStore(cfg, store.InitMongoSession, func(db *store.MongoStore) error {
+				users, _, err := db.GetAllUsers(opts)
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tROLE")
+				for _, u := range users {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.ID.Hex(), u.Username, u.Email, schema.RoleName(u.Role))
+				}
+				return w.Flush()
+			})
+		}
+	},
+}
@@ -0,0 +1,246 @@
+// Package webhook posts the deliveries queued in the store's outbox to
+// their webhooks, signing each with the webhook's secret and retrying
+// failures with exponential backoff
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/mgutz/logxi/v1"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+	"github.com/briansan/ManageMeServer/model/store"
+)
+
+// Headers of a delivery
+const (
+	HeaderEvent     = "X-ManageMe-Event"
+	HeaderDelivery  = "X-ManageMe-Delivery"
+	HeaderTimestamp = "X-ManageMe-Timestamp"
+	HeaderSignature = "X-ManageMe-Signature"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is posted before it fails
+	MaxAttempts = 10
+
+	firstBackoff = 30 * time.Second
+	maxBackoff   = 6 * time.Hour
+)
+
+var logger = log.New("webhook")
+
+// Sign returns the signature of payload sent at timestamp, the hex HMAC-SHA256
+// of the timestamp, a dot and the payload keyed with secret
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature matches payload sent at timestamp, as
+// receivers should before trusting a delivery
+func Verify(secret string, timestamp int64, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, payload)), []byte(signature))
+}
+
+// Backoff returns how long to wait after the given failed attempt, from 1,
+// doubling from 30 seconds up to 6 hours
+func Backoff(attempt int) time.Duration {
+	d := firstBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// Deliver posts d to w once, any 2xx response is a success
+func Deliver(client *http.Client, w *schema.Webhook, d *schema.Delivery) *schema.DeliveryAttempt {
+	start := time.Now()
+	attempt := &schema.DeliveryAttempt{At: int(start.Unix())}
+	defer func() {
+		attempt.Duration = int(time.Since(start) / time.Millisecond)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ManageMe-Webhook")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, d.ID.Hex())
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(start.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(w.Secret, start.Unix(), []byte(d.Payload)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	resp.Body.Close()
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = resp.Status
+	}
+	return attempt
+}
+
+// forbidden are the networks webhooks can't post to beside loopback,
+//   link-local, private, unspecified and multicast addresses: this network
+//   and the shared address space of carriers, where clouds serve metadata
+var forbidden = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+// Reachable reports whether webhooks may post to ip, a public address
+//   unless in one of the networks of allow
+func Reachable(ip net.IP, allow []*net.IPNet) bool {
+	for _, network := range allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range forbidden {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClient returns the client posting deliveries. It only connects to
+//   the addresses Reachable with allow, checked once a host is resolved
+//   so that no name or redirect leads to the services of the network.
+//   Proxies aren't used, as they would connect on its behalf
+func NewClient(allow []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !Reachable(ip, allow) {
+				return fmt.Errorf("%s is not a public address", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// Outcome returns the status of a delivery after its nth attempt,
+// and when it is due again if it is still pending
+func Outcome(n int, attempt *schema.DeliveryAttempt) (string, int) {
+	if len(attempt.Error) == 0 {
+		return schema.DeliveryDelivered, 0
+	}
+	if n >= MaxAttempts {
+		return schema.DeliveryFailed, 0
+	}
+	return schema.DeliveryPending, attempt.At + int(Backoff(n)/time.Second)
+}
+
+// Dispatcher polls the outbox for due deliveries
+type Dispatcher struct {
+	Client *http.Client
+	// Interval between polls of the outbox
+	Interval time.Duration
+	// Lease is how long a claimed delivery has to be attempted
+	//   before another dispatcher may claim it
+	Lease time.Duration
+}
+
+// NewDispatcher returns a dispatcher polling every 5 seconds, posting to
+//   the private networks of allow besides public addresses
+func NewDispatcher(allow []*net.IPNet) *Dispatcher {
+	return &Dispatcher{
+		Client:   NewClient(allow),
+		Interval: 5 * time.Second,
+		Lease:    time.Minute,
+	}
+}
+
+// Run dispatches due deliveries until stop is closed
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.drain(); err != nil {
+			logger.Warn("webhook dispatch failed", "err", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain attempts every delivery that is due
+func (d *Dispatcher) drain() error {
+	db, err := store.NewMongoStore()
+	if err != nil {
+		return err
+	}
+	defer db.Cleanup()
+
+	for {
+		delivery, err := db.ClaimDelivery(d.Lease)
+		if err == store.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var attempt *schema.DeliveryAttempt
+		w, err := db.GetWebhook(delivery.WebhookID.Hex())
+		switch {
+		case err == store.ErrNotFound:
+			attempt = &schema.DeliveryAttempt{At: int(time.Now().Unix()), Error: "webhook deleted"}
+		case err != nil:
+			return err
+		case !w.Active:
+			attempt = &schema.DeliveryAttempt{At: int(time.Now().Unix()), Error: "webhook inactive"}
+		default:
+			attempt = Deliver(d.Client, w, delivery)
+		}
+
+		// Only deliveries to an active webhook are retried
+		status, next := schema.DeliveryFailed, 0
+		if w != nil && w.Active {
+			status, next = Outcome(len(delivery.Attempts)+1, attempt)
+		}
+		if err := db.RecordAttempt(delivery.ID, attempt, status, next); err != nil {
+			return err
+		}
+	}
+}
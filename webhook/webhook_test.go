@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/briansan/ManageMeServer/model/schema"
+)
+
+func Test001_Sign(t *testing.T) {
+	payload := []byte(`{"event":"task.created"}`)
+	sig := Sign("secret", 1767225600, payload)
+	assert.Equal(t, "sha256=", sig[:7])
+	assert.Equal(t, 7+64, len(sig))
+
+	assert.True(t, Verify("secret", 1767225600, payload, sig))
+	assert.False(t, Verify("other", 1767225600, payload, sig))
+	assert.False(t, Verify("secret", 1767225601, payload, sig))
+	assert.False(t, Verify("secret", 1767225600, []byte(`{}`), sig))
+}
+
+func Test002_Backoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1))
+	assert.Equal(t, time.Minute, Backoff(2))
+	assert.Equal(t, 8*time.Minute, Backoff(5))
+	assert.Equal(t, 6*time.Hour, Backoff(20))
+
+	// Failures are retried until the last attempt
+	failed := &schema.DeliveryAttempt{At: 100, StatusCode: 500, Error: "500 Internal Server Error"}
+	status, next := Outcome(1, failed)
+	assert.Equal(t, schema.DeliveryPending, status)
+	assert.Equal(t, 130, next)
+	status, _ = Outcome(MaxAttempts, failed)
+	assert.Equal(t, schema.DeliveryFailed, status)
+	status, _ = Outcome(1, &schema.DeliveryAttempt{At: 100, StatusCode: 204})
+	assert.Equal(t, schema.DeliveryDelivered, status)
+}
+
+func Test003_Deliver(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	code := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	w := &schema.Webhook{URL: srv.URL, Secret: "secret"}
+	d := &schema.Delivery{ID: bson.NewObjectId(), Event: schema.EventTaskCreated, Payload: `{"event":"task.created"}`}
+
+	attempt := Deliver(srv.Client(), w, d)
+	assert.Equal(t, http.StatusNoContent, attempt.StatusCode)
+	assert.Equal(t, "", attempt.Error)
+	assert.Equal(t, d.Payload, string(body))
+	assert.Equal(t, schema.EventTaskCreated, got.Header.Get(HeaderEvent))
+	assert.Equal(t, d.ID.Hex(), got.Header.Get(HeaderDelivery))
+
+	// The receiver can verify the signature
+	ts, err := strconv.ParseInt(got.Header.Get(HeaderTimestamp), 10, 64)
+	assert.Nil(t, err)
+	assert.True(t, Verify("secret", ts, body, got.Header.Get(HeaderSignature)))
+
+	// Non 2xx responses fail
+	code = http.StatusGone
+	attempt = Deliver(srv.Client(), w, d)
+	assert.Equal(t, http.StatusGone, attempt.StatusCode)
+	assert.Equal(t, "410 Gone", attempt.Error)
+
+	// So do unreachable urls
+	srv.Close()
+	attempt = Deliver(srv.Client(), w, d)
+	assert.Equal(t, 0, attempt.StatusCode)
+	assert.NotEqual(t, "", attempt.Error)
+}
+
+func Test004_Reachable(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.1.0.0/16")
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"169.254.169.254":  false,
+		"10.0.0.1":         false,
+		"10.1.2.3":         true,
+		"192.168.1.1":      false,
+		"172.16.0.1":       false,
+		"100.100.100.200":  false,
+		"0.0.0.0":          false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		assert.Equal(t, want, Reachable(net.ParseIP(addr), []*net.IPNet{lan}), addr)
+	}
+
+	// The client refuses the loopback of the test server unless allowed
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	w := &schema.Webhook{URL: srv.URL, Secret: "secret"}
+	d := &schema.Delivery{ID: bson.NewObjectId(), Event: schema.EventTaskCreated, Payload: `{}`}
+	attempt := Deliver(NewClient(nil), w, d)
+	assert.Equal(t, 0, attempt.StatusCode)
+	assert.Contains(t, attempt.Error, "127.0.0.1 is not a public address")
+
+	w.URL = strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	attempt = Deliver(NewClient(nil), w, d)
+	assert.Contains(t, attempt.Error, "is not a public address")
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	attempt = Deliver(NewClient([]*net.IPNet{loopback}), w, d)
+	assert.Equal(t, http.StatusOK, attempt.StatusCode)
+}
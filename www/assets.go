@@ -0,0 +1,205 @@
+package www
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// client is the webapp, its files and their precompressed variants but not
+//   the directory of its tests, served from www.assetsDir
+//
+//go:embed client/*.*
+var client embed.FS
+
+// encodings are the content codings of precompressed files by their
+//   extension, in order of preference
+var encodings = []struct {
+	name, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// refPattern matches the attributes of html referring to other files
+var refPattern = regexp.MustCompile(`(src|href)="([^"]+)"`)
+
+// Assets returns the webapp code embedded in the binary
+func Assets() fs.FS {
+	sub, err := fs.Sub(client, "client")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// asset is a file of the webapp
+type asset struct {
+	name string
+	body []byte
+	// version is the hash of body, busting the caches of references to it
+	version string
+	// encoded are the bodies of precompressed variants by content coding
+	encoded map[string][]byte
+}
+
+// assets are the files of the webapp by path
+type assets map[string]*asset
+
+// version hashes b
+func version(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:6])
+}
+
+// variantOf returns the name of the file name would be a precompressed
+//   variant of, by its extension
+func variantOf(name string) (string, bool) {
+	for _, enc := range encodings {
+		if strings.HasSuffix(name, enc.ext) {
+			return strings.TrimSuffix(name, enc.ext), true
+		}
+	}
+	return "", false
+}
+
+// loadAssets reads the files of fsys, rendering html pages so they refer
+//   to the versions of the other files and to the api at apiHost. Files
+//   ending with .br or .gz are the precompressed variants of the others
+func loadAssets(fsys fs.FS, apiHost string) (assets, error) {
+	files := map[string][]byte{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, name)
+		files[name] = b
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a := assets{}
+	for name, b := range files {
+		if original, ok := variantOf(name); ok {
+			if _, ok := files[original]; ok {
+				continue
+			}
+		}
+		f := &asset{name: name, body: b, version: version(b), encoded: map[string][]byte{}}
+		for _, enc := range encodings {
+			if v, ok := files[name+enc.ext]; ok {
+				f.encoded[enc.name] = v
+			}
+		}
+		a[name] = f
+	}
+
+	// Pages are rendered once every version is known, their precompressed
+	//   variants would be stale
+	for _, f := range a {
+		if path.Ext(f.name) != ".html" {
+			continue
+		}
+		f.body = a.render(f.name, f.body, apiHost)
+		f.version = version(f.body)
+		var buf bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		w.Write(f.body)
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		f.encoded = map[string][]byte{"gzip": buf.Bytes()}
+	}
+	return a, nil
+}
+
+// render versions the references of the page name to the files of a and
+//   injects the url of the api as the manageme-api meta
+func (a assets) render(name string, page []byte, apiHost string) []byte {
+	page = refPattern.ReplaceAllFunc(page, func(m []byte) []byte {
+		sub := refPattern.FindSubmatch(m)
+		ref := string(sub[2])
+		if strings.ContainsAny(ref, ":?#") || strings.HasPrefix(ref, "//") {
+			return m
+		}
+		target := path.Join(path.Dir(name), ref)
+		if strings.HasPrefix(ref, "/") {
+			target = strings.TrimPrefix(ref, "/")
+		}
+		f, ok := a[target]
+		if !ok {
+			return m
+		}
+		return []byte(fmt.Sprintf(`%s="%s?v=%s"`, sub[1], ref, f.version))
+	})
+	meta := fmt.Sprintf("<meta name=\"manageme-api\" content=\"%s\">\n</head>", html.EscapeString(apiHost))
+	return bytes.Replace(page, []byte("</head>"), []byte(meta), 1)
+}
+
+// accepts tells whether the Accept-Encoding header h accepts the content
+//   coding enc
+func accepts(h, enc string) bool {
+	for _, part := range strings.Split(h, ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != enc {
+			continue
+		}
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serve responds with the file of the path, or the index.html of its
+//   directory. Versioned references are cached for good, the others are
+//   revalidated by their ETag
+func (a assets) serve(c echo.Context) error {
+	name := strings.TrimPrefix(path.Clean(c.Request().URL.Path), "/")
+	f, ok := a[name]
+	if !ok {
+		f, ok = a[path.Join(name, "index.html")]
+	}
+	if !ok {
+		return echo.ErrNotFound
+	}
+
+	h := c.Response().Header()
+	if c.QueryParam("v") == f.version {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Add("Vary", "Accept-Encoding")
+
+	body, etag := f.body, f.version
+	for _, enc := range encodings {
+		if b, ok := f.encoded[enc.name]; ok && accepts(c.Request().Header.Get("Accept-Encoding"), enc.name) {
+			body, etag = b, f.version+"-"+enc.name
+			h.Set(echo.HeaderContentEncoding, enc.name)
+			break
+		}
+	}
+	h.Set("ETag", strconv.Quote(etag))
+	http.ServeContent(c.Response(), c.Request(), f.name, time.Time{}, bytes.NewReader(body))
+	return nil
+}
@@ -288,6 +288,33 @@ var TasksListController = {
     }, alertError);
   },
 
+  // inWorkingHours reports whether the task intersects the working hours
+  //   of the day it starts on in the time zone of the schedule, a dated
+  //   exception replacing the weekly intervals of that day
+  inWorkingHours: function(task, wh) {
+    if (!wh || !wh.weekly) {
+      return false;
+    }
+    var start = zonedClock(task.start, wh.timeZone);
+    var finish = zonedClock(task.finish, wh.timeZone);
+    var intervals = wh.weekly[start.weekday] || [];
+    var exceptions = wh.exceptions || [];
+    for (var i = 0; i < exceptions.length; i++) {
+      if (exceptions[i].date == start.date) {
+        intervals = exceptions[i].intervals || [];
+      }
+    }
+    // a task ending on a later day runs until the end of the day it starts on
+    var finishMinutes = (finish.date == start.date) ? finish.minutes : 24*60;
+    for (var i = 0; i < intervals.length; i++) {
+      if ((start.minutes < clock2minutes(intervals[i].finish)) &&
+          (finishMinutes > clock2minutes(intervals[i].start))) {
+        return true;
+      }
+    }
+    return false;
+  },
+
   loadView: function(tasks) {
     if (TasksListController.all) {
       ManageMeAPI.getMappedUsers(function(users) {
@@ -296,11 +323,7 @@ var TasksListController = {
           var user = users[task.userID];
           if (!user) continue;
           tasks[i].user = user.username;
-          var userHrs = user.preferredHours;
-          if (!userHrs) continue;
-          var start = task.start % 86400;
-          var finish = task.finish % 86400;
-          tasks[i].conflict = (start < userHrs.finish) && (finish > userHrs.start);
+          tasks[i].conflict = TasksListController.inWorkingHours(task, user.workingHours);
         }
         TasksListView.init(
           tasks,
@@ -317,11 +340,7 @@ var TasksListController = {
       for (var i = 0; i < tasks.length; i++) {
         var task = tasks[i]
         tasks[i].user = AuthController.getUser().username;
-        var userHrs = AuthController.getUser().preferredHours;
-        if (!userHrs) continue;
-        var start = task.start % 86400;
-        var finish = task.finish % 86400;
-        tasks[i].conflict = (start < userHrs.finish) && (finish > userHrs.start);
+        tasks[i].conflict = TasksListController.inWorkingHours(task, AuthController.getUser().workingHours);
       }
       TasksListView.init(
         tasks,
@@ -1,21 +1,35 @@
 "use strict";
 
-var baseURL = "http://localhost:8888";
-
 function log(msg) {
   console.log(msg);
 }
 
+// getAPIURL reads the url of the api the server injects in the page, empty
+// when the api is served along with the webapp
 function getAPIURL() {
-  $.ajax({url: "/api"}).done(function(url) {
-    log("using api url: "+url);
-    baseURL = url;
-  }).fail(function(resp) {
-    alert("failed to get api url");
-  });
+  var meta = document.querySelector('meta[name="manageme-api"]');
+  if (!meta) {
+    return "http://localhost:8888";
+  }
+  log("using api url: "+meta.content);
+  return meta.content;
+}
+
+var baseURL = getAPIURL();
+
+// randomHex returns n random bytes in hex
+function randomHex(n) {
+  var bytes = new Uint8Array(n);
+  window.crypto.getRandomValues(bytes);
+  return Array.prototype.map.call(bytes, function(b) {
+    return ("0" + b.toString(16)).slice(-2);
+  }).join("");
 }
 
-getAPIURL();
+// traceparent starts a sampled W3C trace for a request to the api
+function traceparent() {
+  return "00-" + randomHex(16) + "-" + randomHex(8) + "-01";
+}
 
 function request(method, path, data, successHandler, failureHandler) {
   var req = {
@@ -23,7 +37,8 @@ function request(method, path, data, successHandler, failureHandler) {
     method: method,
     data: JSON.stringify(data),
     headers: {
-      "Content-type": "application/json; charset=utf-8"
+      "Content-type": "application/json; charset=utf-8",
+      "traceparent": traceparent()
     }
   }
   if (localStorage.jwt) {
@@ -38,6 +53,7 @@ var ManageMeAPI = {
       url: baseURL + "/api/login",
       beforeSend: function (xhr) {
         xhr.setRequestHeader ("Authorization", "Basic " + btoa(username + ":" + password));
+        xhr.setRequestHeader ("traceparent", traceparent());
       }
     }).done(successHandler).fail(failureHandler);
   },
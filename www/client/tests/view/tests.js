@@ -154,16 +154,16 @@ QUnit.test("RegisterView", function(assert) {
   $("#registerPreferredHoursStart").val("");
   $("#registerPreferredHoursFinish").val("");
   user = RegisterView.user();
-  assert.equal(null, user.preferredHours);
+  assert.equal(null, user.workingHours);
 
   $("#registerPreferredHoursStart").val("10:00");
   user = RegisterView.user();
-  assert.equal(null, user.preferredHours);
+  assert.equal(null, user.workingHours);
 
   $("#registerPreferredHoursFinish").val("11:00");
   user = RegisterView.user();
-  assert.equal(36000, user.preferredHours.start);
-  assert.equal(39600, user.preferredHours.finish);
+  assert.equal("10:00", user.workingHours.weekly.monday[0].start);
+  assert.equal("11:00", user.workingHours.weekly.monday[0].finish);
 });
 
 QUnit.test("MenuView", function(assert) {
@@ -177,9 +177,9 @@ QUnit.test("ProfileView", function(assert) {
   var user = {
     username: "foo",
     email: "foo@bar.baz",
-    preferredHours: {
-      start: 3600,
-      finish: 36000
+    workingHours: {
+      timeZone: "UTC",
+      weekly: {monday: [{start: "01:00", finish: "10:00"}]}
     }
   };
 
@@ -235,21 +235,29 @@ QUnit.test("ProfileView", function(assert) {
   var profileUser = ProfileView.user();
   assert.equal(user.username, profileUser.username);
   assert.equal(user.email, profileUser.email);
-  assert.equal(36000, profileUser.preferredHours.start);
-  assert.equal(39600, profileUser.preferredHours.finish);
+  assert.equal("10:00", profileUser.workingHours.weekly.monday[0].start);
+  assert.equal("11:00", profileUser.workingHours.weekly.monday[0].finish);
+  assert.equal("UTC", profileUser.workingHours.timeZone);
+  assert.equal(undefined, profileUser.workingHours.weekly.tuesday);
+
+  // Test user unchanged workingHours are left out
+  $("#profilePreferredHoursStart").val("01:00");
+  $("#profilePreferredHoursFinish").val("10:00");
+  profileUser = ProfileView.user();
+  assert.equal(undefined, profileUser.workingHours);
 
-  // Test user no preferredHours
+  // Test user no workingHours
   $("#profilePreferredHoursStart").val("");
   $("#profilePreferredHoursFinish").val("");
   profileUser = ProfileView.user();
-  assert.equal(undefined, profileUser.preferredHours);
+  assert.equal(undefined, profileUser.workingHours);
 
   $("#profilePreferredHoursStart").val("10:00");
   profileUser = ProfileView.user();
-  assert.equal(undefined, profileUser.preferredHours);
+  assert.equal(undefined, profileUser.workingHours);
   
   $("#profilePreferredHoursFinish").val("11:00");
   profileUser = ProfileView.user();
-  assert.equal(36000, profileUser.preferredHours.start);
-  assert.equal(39600, profileUser.preferredHours.finish);
+  assert.equal("10:00", profileUser.workingHours.weekly.monday[0].start);
+  assert.equal("11:00", profileUser.workingHours.weekly.monday[0].finish);
 });
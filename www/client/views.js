@@ -15,6 +15,103 @@ function unix2time(t) {
   return unix2dt(t).substr(-5);
 };
 
+var weekdays = ["sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"];
+
+// clocks2workingHours builds a workingHours schedule that applies
+//   the HH:mm start and finish times to every day of the week
+function clocks2workingHours(start, finish) {
+  var weekly = {};
+  for (var i = 0; i < weekdays.length; i++) {
+    weekly[weekdays[i]] = [{start: start, finish: finish}];
+  }
+  var tz = "UTC";
+  if (window.Intl) {
+    tz = Intl.DateTimeFormat().resolvedOptions().timeZone || tz;
+  }
+  return {timeZone: tz, weekly: weekly};
+};
+
+// mergeWorkingHours moves the intervals of the schedule wh equal to the one
+//   shown by workingHours2clocks to the HH:mm start and finish times, keeping
+//   the days off, the other intervals, the time zone and the exceptions.
+//   It returns null if the times are unchanged, and a schedule of every day
+//   if wh has no interval
+function mergeWorkingHours(wh, start, finish) {
+  var clocks = workingHours2clocks(wh);
+  if (clocks == null) {
+    return clocks2workingHours(start, finish);
+  }
+  if (clocks.start == start && clocks.finish == finish) {
+    return null;
+  }
+  var weekly = {};
+  for (var day in wh.weekly) {
+    weekly[day] = [];
+    var intervals = wh.weekly[day] || [];
+    for (var i = 0; i < intervals.length; i++) {
+      if (intervals[i].start == clocks.start && intervals[i].finish == clocks.finish) {
+        weekly[day].push({start: start, finish: finish});
+      } else {
+        weekly[day].push(intervals[i]);
+      }
+    }
+  }
+  var merged = {timeZone: wh.timeZone, weekly: weekly};
+  if (wh.exceptions) {
+    merged.exceptions = wh.exceptions;
+  }
+  return merged;
+};
+
+// zonedClock returns the YYYY-MM-DD date, weekday and minutes since midnight
+//   of the unix timestamp t in the IANA time zone tz, in UTC if the browser
+//   cannot resolve it
+function zonedClock(t, tz) {
+  var date = new Date(t*1000);
+  var parts = {};
+  try {
+    new Intl.DateTimeFormat("en-US", {
+      timeZone: tz || "UTC", hourCycle: "h23", weekday: "long",
+      year: "numeric", month: "2-digit", day: "2-digit", hour: "2-digit", minute: "2-digit"
+    }).formatToParts(date).forEach(function(p) { parts[p.type] = p.value; });
+  } catch (e) {
+    parts = {
+      year: String(date.getUTCFullYear()),
+      month: ("0" + (date.getUTCMonth()+1)).substr(-2),
+      day: ("0" + date.getUTCDate()).substr(-2),
+      weekday: weekdays[date.getUTCDay()],
+      hour: String(date.getUTCHours()),
+      minute: String(date.getUTCMinutes())
+    };
+  }
+  return {
+    date: parts.year + "-" + parts.month + "-" + parts.day,
+    weekday: parts.weekday.toLowerCase(),
+    minutes: (parseInt(parts.hour, 10) % 24)*60 + parseInt(parts.minute, 10)
+  };
+};
+
+// clock2minutes converts HH:mm into minutes since midnight, 24:00 being 1440
+function clock2minutes(c) {
+  var hm = c.split(":");
+  return parseInt(hm[0], 10)*60 + parseInt(hm[1], 10);
+};
+
+// workingHours2clocks returns the first interval of the weekly schedule
+//   as {start, finish} HH:mm times, or null if there is none
+function workingHours2clocks(wh) {
+  if (!wh || !wh.weekly) {
+    return null;
+  }
+  for (var i = 0; i < weekdays.length; i++) {
+    var intervals = wh.weekly[weekdays[i]];
+    if (intervals && intervals.length) {
+      return intervals[0];
+    }
+  }
+  return null;
+};
+
 function unix2str(t) {
   var date = new Date(t*1000);
   return date.toUTCString().slice(0, -7);
@@ -226,7 +323,7 @@ var RegisterView = {
     if (finish.length == 0) {
       return user;
     }
-    user.preferredHours = {start: time2unix(start), finish: time2unix(finish)};
+    user.workingHours = clocks2workingHours(start, finish);
     return user;
   }
 }
@@ -294,11 +391,15 @@ var ProfileView = {
       $("#profileRole").val(roleIntToStr[user.role]);
     }
 
-    if (user.preferredHours != null) {
-      $("#profilePreferredHoursStart").val(unix2time(user.preferredHours.start));
-      $("#profilePreferredHoursFinish").val(unix2time(user.preferredHours.finish));
+    var clocks = workingHours2clocks(user.workingHours);
+    if (clocks != null) {
+      $("#profilePreferredHoursStart").val(clocks.start);
+      $("#profilePreferredHoursFinish").val(clocks.finish);
     }
 
+    // the schedule is kept to merge the edited hours into it
+    ProfileView.workingHours = user.workingHours;
+
     $("#profileCancel").click(cancelHandler);
     $("#profileSubmit").click(updateHandler);
     $("#profileDelete").click(deleteHandler);
@@ -352,7 +453,10 @@ var ProfileView = {
     if (finish.length == 0) {
       return user;
     }
-    user.preferredHours = {start: time2unix(start), finish: time2unix(finish)};
+    var workingHours = mergeWorkingHours(ProfileView.workingHours, start, finish);
+    if (workingHours != null) {
+      user.workingHours = workingHours;
+    }
     return user;
   }
 };
@@ -365,15 +469,16 @@ var UsersListView = {
   },
 
   newItem: function(user) {
+    var clocks = workingHours2clocks(user.workingHours);
     var el = '<tr id="'+user.id+'">' +
       '<td>'+user.id+'</td>' +
       '<td>'+user.username+'</td>' +
       '<td>'+user.email+'</td>' +
       '<td>' +
-      (user.preferredHours ? unix2time(user.preferredHours.start) : "None") +
+      (clocks ? clocks.start : "None") +
       '</td>' +
       '<td>' +
-      (user.preferredHours ? unix2time(user.preferredHours.finish) : "None") +
+      (clocks ? clocks.finish : "None") +
       '</td></tr>'
     return el;
   },
@@ -6,37 +6,42 @@ import (
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
-)
 
-const (
-	envAssetsDir = "MANAGEME_ASSETS_DIR"
-	envAPIHost   = "MANAGEME_API_HOST"
+	"github.com/briansan/ManageMeServer/config"
 )
 
-func New() *echo.Echo {
+// New returns the webapp server configured by cfg
+func New(cfg *config.WWW) (*echo.Echo, error) {
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.RemoveTrailingSlash())
 	e.Use(middleware.Recover())
 
-	// Allow assets filepath to be configurable
-	assetsDir := os.Getenv(envAssetsDir)
-	if len(assetsDir) == 0 {
-		assetsDir = "www/client"
+	// Probes of orchestrators, the webapp is ready as soon as it serves
+	probe := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 	}
+	e.GET("/healthz", probe)
+	e.GET("/readyz", probe)
 
-	// Get the API host env var
-	apiHost := os.Getenv(envAPIHost)
-	if len(apiHost) == 0 {
-		apiHost = "http://localhost:8888"
+	if err := Mount(e, cfg.AssetsDir, cfg.APIHost); err != nil {
+		return nil, err
 	}
+	return e, nil
+}
 
-	// Set up route to report the api url
-	e.GET("/api", func(c echo.Context) error {
-		return c.String(http.StatusOK, apiHost)
-	})
-
-	// Serve that folder and default index.html
-	e.Use(middleware.Static(assetsDir))
-	return e
+// Mount serves the webapp on e, from dir if set else embedded, telling it
+//   the api is at apiHost. An empty apiHost is the origin of the webapp
+func Mount(e *echo.Echo, dir, apiHost string) error {
+	fsys := Assets()
+	if len(dir) > 0 {
+		fsys = os.DirFS(dir)
+	}
+	a, err := loadAssets(fsys, apiHost)
+	if err != nil {
+		return err
+	}
+	e.GET("/*", a.serve)
+	e.HEAD("/*", a.serve)
+	return nil
 }
@@ -0,0 +1,86 @@
+package www
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssets(t *testing.T) {
+	assert := assert.New(t)
+
+	fsys := fstest.MapFS{
+		"index.html":       {Data: []byte(`<head></head><script src="app.js"></script><a href="https://example.com">`)},
+		"app.js":           {Data: []byte("main();")},
+		"app.js.br":        {Data: []byte("br")},
+		"app.js.gz":        {Data: []byte("gz")},
+		"index.html.gz":    {Data: []byte("stale")},
+		"tests/index.html": {Data: []byte(`<head></head><script src="/app.js"></script><script src="../missing.js"></script>`)},
+		"archive.gz":       {Data: []byte("archive")},
+		"tests/shared.js":  {Data: []byte("shared();")},
+	}
+	a, err := loadAssets(fsys, `http://localhost:8888/?"`)
+	if !assert.NoError(err) {
+		return
+	}
+	e := echo.New()
+	e.GET("/*", a.serve)
+	get := func(path, encoding string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Pages refer to versions of the files and to the api
+	version := a["app.js"].version
+	rec := get("/", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("text/html; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal("no-cache", rec.Header().Get("Cache-Control"))
+	assert.Equal(`<head><meta name="manageme-api" content="http://localhost:8888/?&#34;">
+</head><script src="app.js?v=`+version+`"></script><a href="https://example.com">`, rec.Body.String())
+	assert.Contains(get("/tests", "").Body.String(), `<script src="/app.js?v=`+version+`"></script><script src="../missing.js"></script>`)
+
+	// Pages are compressed once rendered
+	rec = get("/index.html", "gzip")
+	assert.Equal("gzip", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.NotEqual("stale", rec.Body.String())
+
+	// Versioned files are cached for good, with their preferred encoding
+	rec = get("/app.js?v="+version, "gzip, deflate, br")
+	assert.Equal("public, max-age=31536000, immutable", rec.Header().Get("Cache-Control"))
+	assert.Equal("br", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal("Accept-Encoding", rec.Header().Get("Vary"))
+	assert.Equal("br", rec.Body.String())
+	assert.Equal("gz", get("/app.js", "br;q=0, gzip").Body.String())
+	rec = get("/app.js?v=old", "identity")
+	assert.Equal("no-cache", rec.Header().Get("Cache-Control"))
+	assert.Empty(rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal("main();", rec.Body.String())
+
+	// Unchanged files aren't sent again
+	etag := rec.Header().Get("ETag")
+	assert.Equal(http.StatusNotModified, get("/app.js", "", "If-None-Match", etag).Code)
+
+	// Variants are only served as encodings of their file
+	assert.Equal(http.StatusNotFound, get("/app.js.gz", "").Code)
+	assert.Equal("archive", get("/archive.gz", "").Body.String())
+	assert.Equal(http.StatusNotFound, get("/missing.js", "").Code)
+
+	// The client is embedded without its tests
+	_, err = loadAssets(Assets(), "")
+	assert.NoError(err)
+	_, err = fs.Stat(Assets(), "tests")
+	assert.True(errors.Is(err, fs.ErrNotExist))
+}